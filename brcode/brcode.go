@@ -0,0 +1,269 @@
+// Package brcode monta e interpreta o payload PIX no padrão BR Code (EMV-MPM) sem depender do provedor:
+// o texto "copia e cola" e o conteúdo das imagens de QR Code impressas.
+package brcode
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+
+	"BACK_SORTE_GO/money"
+)
+
+// Identificadores dos campos EMV usados pelo PIX
+const (
+	idFormato             = "00"
+	idIniciacao           = "01"
+	idContaRecebedor      = "26"
+	idCategoria           = "52"
+	idMoeda               = "53"
+	idValor               = "54"
+	idPais                = "58"
+	idNomeRecebedor       = "59"
+	idCidade              = "60"
+	idCEP                 = "61"
+	idDadosAdicionais     = "62"
+	idCRC                 = "63"
+	idContaGUI            = "00"
+	idContaChave          = "01"
+	idContaInfo           = "02"
+	idContaURL            = "25"
+	idAdicionalTxid       = "05"
+	gui                   = "br.gov.bcb.pix"
+	iniciacaoEstatico     = "11"
+	iniciacaoDinamico     = "12"
+	txidNaoInformado      = "***"
+	tamanhoMaxNome        = 25
+	tamanhoMaxCidade      = 15
+	tamanhoMaxTxid        = 25
+	tamanhoMaxCampo       = 99
+	tamanhoMaxChave       = 77
+	moedaReal             = "986"
+	paisBrasil            = "BR"
+	categoriaNaoInformada = "0000"
+)
+
+var (
+	ErrPayloadInvalido = errors.New("payload BR Code inválido")
+	ErrCRCInvalido     = errors.New("CRC do BR Code não confere")
+	ErrNaoPix          = errors.New("BR Code não é de PIX")
+
+	txidValido = regexp.MustCompile(`^[a-zA-Z0-9]{1,25}$`)
+)
+
+// Pix são os dados de um BR Code PIX. Com Chave o código é estático (pagamento na chave, valor opcional);
+// com URL é dinâmico e o pagador busca a cobrança no location do provedor.
+type Pix struct {
+	Chave         string         `json:"chave,omitempty"`
+	URL           string         `json:"url,omitempty"`
+	InfoAdicional string         `json:"info_adicional,omitempty"`
+	Valor         money.Centavos `json:"valor"` // zero: valor escolhido pelo pagador
+	NomeRecebedor string         `json:"nome_recebedor"`
+	Cidade        string         `json:"cidade"`
+	CEP           string         `json:"cep,omitempty"`
+	Txid          string         `json:"txid,omitempty"`
+	Unico         bool           `json:"unico"` // ponto de iniciação 12: o código só pode ser pago uma vez
+}
+
+// Dinamico indica se o código aponta para uma cobrança no provedor
+func (p Pix) Dinamico() bool {
+	return p.URL != ""
+}
+
+// Payload monta o texto EMV do BR Code, com o CRC16 no final
+func (p Pix) Payload() (string, error) {
+	if (p.Chave == "") == (p.URL == "") {
+		return "", fmt.Errorf("%w: informe a chave (estático) ou a URL (dinâmico)", ErrPayloadInvalido)
+	}
+	if p.Valor < 0 {
+		return "", fmt.Errorf("%w: valor negativo", ErrPayloadInvalido)
+	}
+
+	nome := normalizar(p.NomeRecebedor, tamanhoMaxNome)
+	cidade := normalizar(p.Cidade, tamanhoMaxCidade)
+	if nome == "" || cidade == "" {
+		return "", fmt.Errorf("%w: nome do recebedor e cidade são obrigatórios", ErrPayloadInvalido)
+	}
+
+	conta := campo(idContaGUI, gui)
+	if p.Chave != "" {
+		if len(p.Chave) > tamanhoMaxChave {
+			return "", fmt.Errorf("%w: chave PIX muito longa", ErrPayloadInvalido)
+		}
+		conta += campo(idContaChave, p.Chave)
+	}
+	if info := normalizar(p.InfoAdicional, tamanhoMaxCampo); info != "" {
+		conta += campo(idContaInfo, info)
+	}
+	if p.URL != "" {
+		conta += campo(idContaURL, strings.TrimPrefix(strings.TrimPrefix(p.URL, "https://"), "http://"))
+	}
+	if len(conta) > tamanhoMaxCampo {
+		return "", fmt.Errorf("%w: chave, informação adicional e URL excedem %d caracteres", ErrPayloadInvalido, tamanhoMaxCampo)
+	}
+
+	txid := p.Txid
+	if txid == "" {
+		txid = txidNaoInformado
+	} else if !txidValido.MatchString(txid) {
+		return "", fmt.Errorf("%w: txid deve ter de 1 a %d letras ou números", ErrPayloadInvalido, tamanhoMaxTxid)
+	}
+
+	var b strings.Builder
+	b.WriteString(campo(idFormato, "01"))
+	if p.Unico || p.Dinamico() {
+		b.WriteString(campo(idIniciacao, iniciacaoDinamico))
+	} else {
+		b.WriteString(campo(idIniciacao, iniciacaoEstatico))
+	}
+	b.WriteString(campo(idContaRecebedor, conta))
+	b.WriteString(campo(idCategoria, categoriaNaoInformada))
+	b.WriteString(campo(idMoeda, moedaReal))
+	if p.Valor > 0 {
+		b.WriteString(campo(idValor, p.Valor.String()))
+	}
+	b.WriteString(campo(idPais, paisBrasil))
+	b.WriteString(campo(idNomeRecebedor, nome))
+	b.WriteString(campo(idCidade, cidade))
+	if cep := somenteDigitos(p.CEP); cep != "" {
+		b.WriteString(campo(idCEP, cep))
+	}
+	b.WriteString(campo(idDadosAdicionais, campo(idAdicionalTxid, txid)))
+	b.WriteString(idCRC + "04")
+	b.WriteString(CRC16(b.String()))
+	return b.String(), nil
+}
+
+// Parse interpreta um payload PIX, conferindo o CRC16
+func Parse(payload string) (*Pix, error) {
+	payload = strings.TrimSpace(payload)
+	if len(payload) < 8 || payload[len(payload)-8:len(payload)-4] != idCRC+"04" {
+		return nil, fmt.Errorf("%w: CRC ausente", ErrPayloadInvalido)
+	}
+	if !strings.EqualFold(payload[len(payload)-4:], CRC16(payload[:len(payload)-4])) {
+		return nil, ErrCRCInvalido
+	}
+
+	campos, err := lerCampos(payload[:len(payload)-8])
+	if err != nil {
+		return nil, err
+	}
+	if campos[idFormato] != "01" {
+		return nil, fmt.Errorf("%w: formato %q não suportado", ErrPayloadInvalido, campos[idFormato])
+	}
+	if moeda, ok := campos[idMoeda]; ok && moeda != moedaReal {
+		return nil, fmt.Errorf("%w: moeda %q não suportada", ErrPayloadInvalido, moeda)
+	}
+
+	contaRaw, ok := campos[idContaRecebedor]
+	if !ok {
+		return nil, ErrNaoPix
+	}
+	conta, err := lerCampos(contaRaw)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(conta[idContaGUI], gui) {
+		return nil, ErrNaoPix
+	}
+
+	p := &Pix{
+		Chave:         conta[idContaChave],
+		InfoAdicional: conta[idContaInfo],
+		NomeRecebedor: campos[idNomeRecebedor],
+		Cidade:        campos[idCidade],
+		CEP:           campos[idCEP],
+		Unico:         campos[idIniciacao] == iniciacaoDinamico,
+	}
+	if url := conta[idContaURL]; url != "" {
+		p.URL = "https://" + url
+	}
+	if p.Chave == "" && p.URL == "" {
+		return nil, fmt.Errorf("%w: sem chave nem URL", ErrPayloadInvalido)
+	}
+	if valor, ok := campos[idValor]; ok {
+		if p.Valor, err = money.Parse(valor); err != nil || p.Valor < 0 {
+			return nil, fmt.Errorf("%w: valor %q", ErrPayloadInvalido, valor)
+		}
+	}
+	if adicionaisRaw, ok := campos[idDadosAdicionais]; ok {
+		adicionais, err := lerCampos(adicionaisRaw)
+		if err != nil {
+			return nil, err
+		}
+		if txid := adicionais[idAdicionalTxid]; txid != txidNaoInformado {
+			p.Txid = txid
+		}
+	}
+	return p, nil
+}
+
+// CRC16 calcula o CRC16-CCITT (polinômio 0x1021, valor inicial 0xFFFF) em 4 dígitos hexadecimais maiúsculos
+func CRC16(dados string) string {
+	crc := uint16(0xFFFF)
+	for i := 0; i < len(dados); i++ {
+		crc ^= uint16(dados[i]) << 8
+		for bit := 0; bit < 8; bit++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return fmt.Sprintf("%04X", crc)
+}
+
+func campo(id, valor string) string {
+	return fmt.Sprintf("%s%02d%s", id, len(valor), valor)
+}
+
+// lerCampos separa os campos ID+tamanho+valor de um nível do payload
+func lerCampos(dados string) (map[string]string, error) {
+	campos := map[string]string{}
+	for i := 0; i < len(dados); {
+		if i+4 > len(dados) {
+			return nil, fmt.Errorf("%w: campo truncado na posição %d", ErrPayloadInvalido, i)
+		}
+		id := dados[i : i+2]
+		tamanho, err := strconv.Atoi(dados[i+2 : i+4])
+		if err != nil || i+4+tamanho > len(dados) {
+			return nil, fmt.Errorf("%w: tamanho inválido no campo %s", ErrPayloadInvalido, id)
+		}
+		campos[id] = dados[i+4 : i+4+tamanho]
+		i += 4 + tamanho
+	}
+	return campos, nil
+}
+
+// normalizar remove acentos e caracteres fora do ASCII imprimível, que vários aplicativos bancários rejeitam
+func normalizar(s string, max int) string {
+	t := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	s, _, _ = transform.String(t, s)
+	s = strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7E {
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(s))
+	if len(s) > max {
+		s = strings.TrimSpace(s[:max])
+	}
+	return s
+}
+
+func somenteDigitos(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < '0' || r > '9' {
+			return -1
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,147 @@
+package brcode
+
+import (
+	"errors"
+	"testing"
+)
+
+// Exemplo do Manual de Padrões para Iniciação do PIX (BCB): código estático, sem valor, com CRC 1D3D
+const exemploBCB = "00020126580014br.gov.bcb.pix0136123e4567-e12b-12d1-a456-426655440000" +
+	"5204000053039865802BR5913Fulano de Tal6008BRASILIA62070503***63041D3D"
+
+func TestCRC16(t *testing.T) {
+	if got := CRC16(exemploBCB[:len(exemploBCB)-4]); got != "1D3D" {
+		t.Errorf("CRC16(exemplo BCB) = %s, esperado 1D3D", got)
+	}
+}
+
+func TestParseExemploBCB(t *testing.T) {
+	p, err := Parse(exemploBCB)
+	if err != nil {
+		t.Fatalf("Parse(exemplo BCB): %v", err)
+	}
+	esperado := Pix{
+		Chave:         "123e4567-e12b-12d1-a456-426655440000",
+		NomeRecebedor: "Fulano de Tal",
+		Cidade:        "BRASILIA",
+	}
+	if *p != esperado {
+		t.Errorf("Parse(exemplo BCB) = %+v, esperado %+v", *p, esperado)
+	}
+	if p.Dinamico() {
+		t.Error("exemplo BCB interpretado como dinâmico")
+	}
+}
+
+func TestPayloadExemploBCB(t *testing.T) {
+	// O exemplo do BCB omite o ponto de iniciação (campo 01); fora ele, o payload gerado é o mesmo
+	p := Pix{
+		Chave:         "123e4567-e12b-12d1-a456-426655440000",
+		NomeRecebedor: "Fulano de Tal",
+		Cidade:        "BRASILIA",
+	}
+	payload, err := p.Payload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	semIniciacao := exemploBCB[:6] + "010211" + exemploBCB[6:len(exemploBCB)-4]
+	esperado := semIniciacao + CRC16(semIniciacao)
+	if payload != esperado {
+		t.Errorf("Payload() = %s\nesperado   %s", payload, esperado)
+	}
+}
+
+func TestPayloadParseIdaEVolta(t *testing.T) {
+	casos := []Pix{
+		{Chave: "doacoes@exemplo.org", Valor: 1050, NomeRecebedor: "Campanha Solidaria", Cidade: "SAO PAULO", Txid: "CAMPANHA123"},
+		{Chave: "+5511999998888", InfoAdicional: "Doacao", NomeRecebedor: "Instituto", Cidade: "RECIFE", CEP: "50000000"},
+		{Chave: "11222333000181", Valor: 100, NomeRecebedor: "Associacao", Cidade: "CURITIBA", Unico: true},
+		{URL: "https://pix.exemplo.com/qr/v2/9d36b84f", NomeRecebedor: "Campanha", Cidade: "BRASILIA", Unico: true},
+	}
+	for _, c := range casos {
+		payload, err := c.Payload()
+		if err != nil {
+			t.Errorf("Payload(%+v): %v", c, err)
+			continue
+		}
+		p, err := Parse(payload)
+		if err != nil {
+			t.Errorf("Parse(%s): %v", payload, err)
+			continue
+		}
+		if *p != c {
+			t.Errorf("Parse(Payload()) = %+v, esperado %+v", *p, c)
+		}
+	}
+}
+
+func TestPayloadNormaliza(t *testing.T) {
+	p := Pix{Chave: "chave", NomeRecebedor: "Associação Beneficente São José do Norte", Cidade: "São João del-Rei"}
+	payload, err := p.Payload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lido, err := Parse(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lido.NomeRecebedor != "Associacao Beneficente Sa" || lido.Cidade != "Sao Joao del-Re" {
+		t.Errorf("nome %q e cidade %q: esperados sem acento e truncados", lido.NomeRecebedor, lido.Cidade)
+	}
+}
+
+func TestPayloadInvalido(t *testing.T) {
+	casos := []struct {
+		nome string
+		pix  Pix
+	}{
+		{"sem chave nem URL", Pix{NomeRecebedor: "Nome", Cidade: "Cidade"}},
+		{"chave e URL", Pix{Chave: "chave", URL: "https://pix.exemplo.com/qr", NomeRecebedor: "Nome", Cidade: "Cidade"}},
+		{"valor negativo", Pix{Chave: "chave", Valor: -1, NomeRecebedor: "Nome", Cidade: "Cidade"}},
+		{"sem nome", Pix{Chave: "chave", Cidade: "Cidade"}},
+		{"sem cidade", Pix{Chave: "chave", NomeRecebedor: "Nome"}},
+		{"txid com símbolo", Pix{Chave: "chave", NomeRecebedor: "Nome", Cidade: "Cidade", Txid: "abc-123"}},
+		{"txid longo", Pix{Chave: "chave", NomeRecebedor: "Nome", Cidade: "Cidade", Txid: "abcdefghijklmnopqrstuvwxyz"}},
+	}
+	for _, c := range casos {
+		if _, err := c.pix.Payload(); !errors.Is(err, ErrPayloadInvalido) {
+			t.Errorf("%s: Payload() erro = %v, esperado ErrPayloadInvalido", c.nome, err)
+		}
+	}
+}
+
+func TestParseInvalido(t *testing.T) {
+	// Troca um caractere do nome mantendo o CRC original
+	adulterado := exemploBCB[:len(exemploBCB)-30] + "X" + exemploBCB[len(exemploBCB)-29:]
+	// Remove o campo 26 e recalcula o CRC: payload válido, mas sem conta PIX
+	semConta := "0002015204000053039865802BR5913Fulano de Tal6008BRASILIA62070503***6304"
+	semConta += CRC16(semConta)
+	// Tamanho do campo 59 maior que o restante do payload, com CRC correto
+	truncadoComCRC := "00020126580014br.gov.bcb.pix0136123e4567-e12b-12d1-a456-4266554400005999Fulano6304"
+	truncadoComCRC += CRC16(truncadoComCRC)
+
+	casos := []struct {
+		nome    string
+		payload string
+		erro    error
+	}{
+		{"vazio", "", ErrPayloadInvalido},
+		{"truncado", exemploBCB[:len(exemploBCB)-10], ErrPayloadInvalido},
+		{"sem CRC", exemploBCB[:len(exemploBCB)-8], ErrPayloadInvalido},
+		{"CRC errado", exemploBCB[:len(exemploBCB)-4] + "1D3E", ErrCRCInvalido},
+		{"conteúdo adulterado", adulterado, ErrCRCInvalido},
+		{"campo truncado", truncadoComCRC, ErrPayloadInvalido},
+		{"sem conta PIX", semConta, ErrNaoPix},
+	}
+	for _, c := range casos {
+		if _, err := Parse(c.payload); !errors.Is(err, c.erro) {
+			t.Errorf("%s: Parse erro = %v, esperado %v", c.nome, err, c.erro)
+		}
+	}
+}
+
+func TestParseCRCMinusculo(t *testing.T) {
+	if _, err := Parse(exemploBCB[:len(exemploBCB)-4] + "1d3d"); err != nil {
+		t.Errorf("Parse com CRC em minúsculas: %v", err)
+	}
+}
@@ -0,0 +1,52 @@
+package brcode
+
+import (
+	"fmt"
+	"strings"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// TamanhoPadrao é o lado, em pixels, da imagem PNG quando nenhum tamanho é informado
+const TamanhoPadrao = 512
+
+// PNG desenha o QR Code do payload em uma imagem quadrada de lado tamanho (pixels)
+func PNG(payload string, tamanho int) ([]byte, error) {
+	if tamanho <= 0 {
+		tamanho = TamanhoPadrao
+	}
+	qr, err := qrcode.New(payload, qrcode.Medium)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao gerar QR Code: %w", err)
+	}
+	return qr.PNG(tamanho)
+}
+
+// SVG desenha o QR Code do payload em SVG, um módulo por unidade, para impressão em qualquer escala
+func SVG(payload string) ([]byte, error) {
+	qr, err := qrcode.New(payload, qrcode.Medium)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao gerar QR Code: %w", err)
+	}
+	modulos := qr.Bitmap() // inclui a margem (quiet zone)
+	lado := len(modulos)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, lado, lado)
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, lado, lado)
+	for y, linha := range modulos {
+		for x := 0; x < len(linha); x++ {
+			if !linha[x] {
+				continue
+			}
+			// Agrupa módulos escuros consecutivos da linha em um único retângulo
+			inicio := x
+			for x+1 < len(linha) && linha[x+1] {
+				x++
+			}
+			fmt.Fprintf(&b, "M%d %dh%dv1h-%dz", inicio, y, x-inicio+1, x-inicio+1)
+		}
+	}
+	b.WriteString(`"/></svg>`)
+	return []byte(b.String()), nil
+}
@@ -0,0 +1,41 @@
+// ledgercheck verifica a consistência do razão contábil (core.ledger_*) e termina com código 1 se encontrar problemas.
+//
+//	go run ./cmd/ledgercheck
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"BACK_SORTE_GO/config"
+	"BACK_SORTE_GO/database"
+	"BACK_SORTE_GO/ledger"
+)
+
+func main() {
+	config.LoadEnv()
+
+	db, err := database.Connect()
+	if err != nil {
+		log.Fatalf("Erro ao conectar ao banco de dados: %v", err)
+	}
+	defer db.Close()
+
+	inconsistencias, err := ledger.Verificar(db)
+	if err != nil {
+		log.Fatalf("Erro ao verificar o razão: %v", err)
+	}
+
+	if len(inconsistencias) == 0 {
+		fmt.Println("Razão consistente")
+		return
+	}
+
+	for _, i := range inconsistencias {
+		fmt.Printf("[%s] %s\n", i.Regra, i.Detalhe)
+	}
+	fmt.Printf("%d inconsistência(s) encontrada(s)\n", len(inconsistencias))
+	db.Close()
+	os.Exit(1)
+}
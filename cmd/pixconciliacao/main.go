@@ -0,0 +1,71 @@
+// pixconciliacao concilia as cobranças PIX com a listagem do provedor no período informado, corrige as divergências
+// seguras e termina com código 1 se restarem divergências para análise.
+//
+//	go run ./cmd/pixconciliacao -inicio 2025-01-01 -fim 2025-01-31
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"BACK_SORTE_GO/config"
+	"BACK_SORTE_GO/database"
+	"BACK_SORTE_GO/handlers"
+	"BACK_SORTE_GO/payment"
+)
+
+func main() {
+	inicioFlag := flag.String("inicio", "", "data inicial (AAAA-MM-DD), padrão: ontem")
+	fimFlag := flag.String("fim", "", "data final inclusiva (AAAA-MM-DD), padrão: hoje")
+	flag.Parse()
+
+	agora := time.Now()
+	hoje := time.Date(agora.Year(), agora.Month(), agora.Day(), 0, 0, 0, 0, time.Local)
+	inicio, fim := hoje.AddDate(0, 0, -1), hoje
+	var err error
+	if *inicioFlag != "" {
+		if inicio, err = time.ParseInLocation("2006-01-02", *inicioFlag, time.Local); err != nil {
+			log.Fatalf("Data inicial inválida: %v", err)
+		}
+	}
+	if *fimFlag != "" {
+		if fim, err = time.ParseInLocation("2006-01-02", *fimFlag, time.Local); err != nil {
+			log.Fatalf("Data final inválida: %v", err)
+		}
+	}
+	fim = fim.AddDate(0, 0, 1).Add(-time.Second)
+	if !fim.After(inicio) {
+		log.Fatal("A data final deve ser igual ou posterior à inicial")
+	}
+
+	config.LoadEnv()
+
+	db, err := database.Connect()
+	if err != nil {
+		log.Fatalf("Erro ao conectar ao banco de dados: %v", err)
+	}
+	defer db.Close()
+
+	c, err := handlers.ConciliarPix(db, payment.NewProvider(), inicio, fim, handlers.ConciliacaoOrigemComando)
+	if err != nil {
+		log.Fatalf("Erro na conciliação: %v", err)
+	}
+
+	for _, item := range c.Itens {
+		situacao := "PENDENTE"
+		if item.Corrigido {
+			situacao = "CORRIGIDO"
+		}
+		fmt.Printf("[%s] %s %s: %s\n", situacao, item.Tipo, item.Txid, item.Detalhe)
+	}
+	fmt.Printf("Conciliação %s: %d cobrança(s) no provedor, %d local(is), %d corrigida(s), %d divergência(s)\n",
+		c.ID, c.CobrancasProvedor, c.CobrancasLocais, c.Corrigidas, c.Divergencias)
+
+	if c.Divergencias > 0 {
+		db.Close()
+		os.Exit(1)
+	}
+}
@@ -91,4 +91,153 @@ func GetJwtSecret() string {
 
 func GetawsBucketNameImgDoacao() string {
 	return os.Getenv("AWS_BUCKET_NAME_IMG_DOACAO")
-}
\ No newline at end of file
+}
+
+// GetAppURL retorna a URL pública do frontend, usada nos links enviados por e-mail
+func GetAppURL() string {
+	return os.Getenv("APP_URL")
+}
+
+// GetMailDriver retorna o tipo de envio de e-mail (smtp, file ou log)
+func GetMailDriver() string {
+	return os.Getenv("MAIL_DRIVER")
+}
+
+func GetMailFrom() string {
+	return os.Getenv("MAIL_FROM")
+}
+
+func GetMailFileDir() string {
+	return os.Getenv("MAIL_FILE_DIR")
+}
+
+func GetSmtpHost() string {
+	return os.Getenv("SMTP_HOST")
+}
+
+func GetSmtpPort() string {
+	return os.Getenv("SMTP_PORT")
+}
+
+func GetSmtpUser() string {
+	return os.Getenv("SMTP_USER")
+}
+
+func GetSmtpPassword() string {
+	return os.Getenv("SMTP_PASSWORD")
+}
+
+// GetAdminEmail retorna o e-mail do usuário que recebe ROLE_ADMIN ao executar as migrações
+func GetAdminEmail() string {
+	return os.Getenv("ADMIN_EMAIL")
+}
+
+// GetTotpIssuer retorna o nome exibido no aplicativo autenticador (2FA)
+func GetTotpIssuer() string {
+	return os.Getenv("TOTP_ISSUER")
+}
+
+// GetPixWebhookURL retorna a URL pública de /pix/webhook registrada na EfiPay.
+// Quando definida, a confirmação dos pagamentos vem pelo webhook e não por consulta periódica.
+func GetPixWebhookURL() string {
+	return os.Getenv("PIX_WEBHOOK_URL")
+}
+
+// GetPixWebhookSecret retorna o segredo usado no HMAC incluído na URL do webhook
+func GetPixWebhookSecret() string {
+	return os.Getenv("PIX_WEBHOOK_SECRET")
+}
+
+// GetPixWebhookTLSAddr retorna o endereço do listener TLS com mTLS para o webhook (ex.: :8443)
+func GetPixWebhookTLSAddr() string {
+	return os.Getenv("PIX_WEBHOOK_TLS_ADDR")
+}
+
+func GetPixWebhookTLSCert() string {
+	return os.Getenv("PIX_WEBHOOK_TLS_CERT")
+}
+
+func GetPixWebhookTLSKey() string {
+	return os.Getenv("PIX_WEBHOOK_TLS_KEY")
+}
+
+// GetPixWebhookClientCA retorna o caminho da cadeia de certificados da EfiPay usada para validar o mTLS do webhook
+func GetPixWebhookClientCA() string {
+	return os.Getenv("PIX_WEBHOOK_CLIENT_CA")
+}
+
+// GetPixProvider retorna o provedor PIX: "efipay" (padrão) ou "fake"
+func GetPixProvider() string {
+	return os.Getenv("PIX_PROVIDER")
+}
+
+// GetPixFakeAutoPay define se o provedor fake conclui a cobrança já na primeira consulta (padrão "true")
+func GetPixFakeAutoPay() string {
+	return os.Getenv("PIX_FAKE_AUTOPAY")
+}
+
+// GetJobWorkers retorna o número de workers da fila de jobs (JOB_WORKERS, padrão 4)
+func GetJobWorkers() int {
+	workers, err := strconv.Atoi(os.Getenv("JOB_WORKERS"))
+	if err != nil || workers < 1 {
+		return 4
+	}
+	return workers
+}
+
+// GetPixChavePagador retorna a chave PIX da plataforma usada como pagador nos saques
+func GetPixChavePagador() string {
+	return os.Getenv("PIX_CHAVE_PAGADOR")
+}
+
+// GetSaqueAprovacaoAutomatica define se os saques são enviados sem aprovação de um operador (padrão "false")
+func GetSaqueAprovacaoAutomatica() string {
+	return os.Getenv("SAQUE_APROVACAO_AUTOMATICA")
+}
+
+// GetPixChaveRecebedor retorna a chave PIX da plataforma impressa nos QR Codes estáticos (padrão: PIX_CHAVE_PAGADOR)
+func GetPixChaveRecebedor() string {
+	if chave := os.Getenv("PIX_CHAVE_RECEBEDOR"); chave != "" {
+		return chave
+	}
+	return GetPixChavePagador()
+}
+
+// GetPixRecebedorNome retorna o nome do recebedor gravado no BR Code (até 25 caracteres)
+func GetPixRecebedorNome() string {
+	return os.Getenv("PIX_RECEBEDOR_NOME")
+}
+
+// GetPixRecebedorCidade retorna a cidade do recebedor gravada no BR Code (até 15 caracteres)
+func GetPixRecebedorCidade() string {
+	return os.Getenv("PIX_RECEBEDOR_CIDADE")
+}
+
+// GetPagamentoNotificacaoURL retorna a URL pública base das notificações dos provedores de cartão. O nome do
+// provedor é acrescentado ao final (ex.: https://api.exemplo.com/pagamento/notificacao/efipay).
+func GetPagamentoNotificacaoURL() string {
+	return os.Getenv("PAGAMENTO_NOTIFICATION_URL")
+}
+
+// GetTrustedProxies retorna os IPs ou redes (CIDR) dos proxies reversos cujo X-Forwarded-For é confiável,
+// separados por vírgula (TRUSTED_PROXIES). Vazio: X-Forwarded-For é ignorado e vale o IP da conexão.
+func GetTrustedProxies() string {
+	return os.Getenv("TRUSTED_PROXIES")
+}
+
+// GetApiClientID e GetApiClientSecret definem o cliente da API cadastrado no boot (API_CLIENT_ID e
+// API_CLIENT_SECRET), usado pelos frontends no Basic do /login. Numa instalação nova é o único jeito de ter um
+// cliente ativo; trocar o segredo e reiniciar rotaciona a credencial.
+func GetApiClientID() string {
+	return os.Getenv("API_CLIENT_ID")
+}
+
+func GetApiClientSecret() string {
+	return os.Getenv("API_CLIENT_SECRET")
+}
+
+// GetApiLegacyClient habilita o cliente legado da API com a credencial pública API_NAME_ACCESS (padrão "false").
+// Só deve ficar "true" enquanto houver frontends antigos que ainda enviam essa credencial.
+func GetApiLegacyClient() string {
+	return os.Getenv("API_LEGACY_CLIENT")
+}
@@ -0,0 +1,162 @@
+package cpf
+
+import (
+	"errors"
+	"strings"
+)
+
+// Tipos de documento aceitos
+const (
+	TipoCPF  = "CPF"
+	TipoCNPJ = "CNPJ"
+)
+
+var (
+	ErrCPFInvalido       = errors.New("CPF inválido")
+	ErrCNPJInvalido      = errors.New("CNPJ inválido")
+	ErrDocumentoInvalido = errors.New("documento inválido, informe um CPF ou CNPJ")
+)
+
+// Normalize remove pontuação e espaços, mantendo apenas os dígitos
+func Normalize(doc string) string {
+	var b strings.Builder
+	for _, r := range doc {
+		if r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func digits(doc string) []int {
+	d := make([]int, len(doc))
+	for i, r := range doc {
+		d[i] = int(r - '0')
+	}
+	return d
+}
+
+func allEqual(d []int) bool {
+	for _, v := range d[1:] {
+		if v != d[0] {
+			return false
+		}
+	}
+	return true
+}
+
+// checkDigit calcula o dígito verificador módulo 11 com os pesos informados
+func checkDigit(d []int, weights []int) int {
+	sum := 0
+	for i, w := range weights {
+		sum += d[i] * w
+	}
+	rest := sum % 11
+	if rest < 2 {
+		return 0
+	}
+	return 11 - rest
+}
+
+// IsValid valida os dígitos verificadores de um CPF, com ou sem pontuação
+func IsValid(doc string) bool {
+	n := Normalize(doc)
+	if len(n) != 11 {
+		return false
+	}
+	d := digits(n)
+	if allEqual(d) {
+		return false
+	}
+
+	if checkDigit(d, []int{10, 9, 8, 7, 6, 5, 4, 3, 2}) != d[9] {
+		return false
+	}
+	return checkDigit(d, []int{11, 10, 9, 8, 7, 6, 5, 4, 3, 2}) == d[10]
+}
+
+// IsValidCNPJ valida os dígitos verificadores de um CNPJ, com ou sem pontuação
+func IsValidCNPJ(doc string) bool {
+	n := Normalize(doc)
+	if len(n) != 14 {
+		return false
+	}
+	d := digits(n)
+	if allEqual(d) {
+		return false
+	}
+
+	if checkDigit(d, []int{5, 4, 3, 2, 9, 8, 7, 6, 5, 4, 3, 2}) != d[12] {
+		return false
+	}
+	return checkDigit(d, []int{6, 5, 4, 3, 2, 9, 8, 7, 6, 5, 4, 3, 2}) == d[13]
+}
+
+// Parse valida um CPF e retorna os 11 dígitos normalizados
+func Parse(doc string) (string, error) {
+	if !IsValid(doc) {
+		return "", ErrCPFInvalido
+	}
+	return Normalize(doc), nil
+}
+
+// ParseCNPJ valida um CNPJ e retorna os 14 dígitos normalizados
+func ParseCNPJ(doc string) (string, error) {
+	if !IsValidCNPJ(doc) {
+		return "", ErrCNPJInvalido
+	}
+	return Normalize(doc), nil
+}
+
+// ParseDocumento aceita CPF (pessoa física) ou CNPJ (organizações) e retorna o documento normalizado e o seu tipo
+func ParseDocumento(doc string) (string, string, error) {
+	n := Normalize(doc)
+	switch len(n) {
+	case 11:
+		if IsValid(n) {
+			return n, TipoCPF, nil
+		}
+		return "", "", ErrCPFInvalido
+	case 14:
+		if IsValidCNPJ(n) {
+			return n, TipoCNPJ, nil
+		}
+		return "", "", ErrCNPJInvalido
+	}
+	return "", "", ErrDocumentoInvalido
+}
+
+// Format formata um CPF como 000.000.000-00. Valores que não têm 11 dígitos são devolvidos sem alteração.
+func Format(doc string) string {
+	n := Normalize(doc)
+	if len(n) != 11 {
+		return doc
+	}
+	return n[0:3] + "." + n[3:6] + "." + n[6:9] + "-" + n[9:11]
+}
+
+// FormatCNPJ formata um CNPJ como 00.000.000/0000-00. Valores que não têm 14 dígitos são devolvidos sem alteração.
+func FormatCNPJ(doc string) string {
+	n := Normalize(doc)
+	if len(n) != 14 {
+		return doc
+	}
+	return n[0:2] + "." + n[2:5] + "." + n[5:8] + "/" + n[8:12] + "-" + n[12:14]
+}
+
+// FormatDocumento formata CPF ou CNPJ de acordo com a quantidade de dígitos
+func FormatDocumento(doc string) string {
+	if len(Normalize(doc)) == 14 {
+		return FormatCNPJ(doc)
+	}
+	return Format(doc)
+}
+
+// Mask oculta os dígitos centrais do CPF para exibição pública (ex.: ***.456.789-**)
+func Mask(doc string) string {
+	n := Normalize(doc)
+	if len(n) != 11 {
+		return doc
+	}
+	return "***." + n[3:6] + "." + n[6:9] + "-**"
+}
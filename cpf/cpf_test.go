@@ -0,0 +1,89 @@
+package cpf
+
+import "testing"
+
+func TestIsValid(t *testing.T) {
+	casos := []struct {
+		doc    string
+		valido bool
+	}{
+		{"529.982.247-25", true},
+		{"52998224725", true},
+		{"111.444.777-35", true},
+		{"123.456.789-09", true},
+		{"529.982.247-24", false},
+		{"529.982.247-15", false},
+		{"111.111.111-11", false},
+		{"000.000.000-00", false},
+		{"5299822472", false},
+		{"529982247250", false},
+		{"", false},
+		{"abc.def.ghi-jk", false},
+	}
+	for _, c := range casos {
+		if got := IsValid(c.doc); got != c.valido {
+			t.Errorf("IsValid(%q) = %v, esperado %v", c.doc, got, c.valido)
+		}
+	}
+}
+
+func TestIsValidCNPJ(t *testing.T) {
+	casos := []struct {
+		doc    string
+		valido bool
+	}{
+		{"11.222.333/0001-81", true},
+		{"11222333000181", true},
+		{"11.444.777/0001-61", true},
+		{"11.222.333/0001-80", false},
+		{"11.222.333/0001-91", false},
+		{"00.000.000/0000-00", false},
+		{"11.111.111/1111-11", false},
+		{"1122233300018", false},
+		{"", false},
+	}
+	for _, c := range casos {
+		if got := IsValidCNPJ(c.doc); got != c.valido {
+			t.Errorf("IsValidCNPJ(%q) = %v, esperado %v", c.doc, got, c.valido)
+		}
+	}
+}
+
+func TestParseDocumento(t *testing.T) {
+	casos := []struct {
+		doc  string
+		norm string
+		tipo string
+		err  error
+	}{
+		{"529.982.247-25", "52998224725", TipoCPF, nil},
+		{" 111.444.777-35 ", "11144477735", TipoCPF, nil},
+		{"11.222.333/0001-81", "11222333000181", TipoCNPJ, nil},
+		{"529.982.247-24", "", "", ErrCPFInvalido},
+		{"11.222.333/0001-80", "", "", ErrCNPJInvalido},
+		{"123", "", "", ErrDocumentoInvalido},
+		{"", "", "", ErrDocumentoInvalido},
+	}
+	for _, c := range casos {
+		norm, tipo, err := ParseDocumento(c.doc)
+		if norm != c.norm || tipo != c.tipo || err != c.err {
+			t.Errorf("ParseDocumento(%q) = %q, %q, %v; esperado %q, %q, %v", c.doc, norm, tipo, err, c.norm, c.tipo, c.err)
+		}
+	}
+}
+
+func TestFormatEMask(t *testing.T) {
+	if got := FormatDocumento("52998224725"); got != "529.982.247-25" {
+		t.Errorf("FormatDocumento(CPF) = %q", got)
+	}
+	if got := FormatDocumento("11222333000181"); got != "11.222.333/0001-81" {
+		t.Errorf("FormatDocumento(CNPJ) = %q", got)
+	}
+	if got := Mask("529.982.247-25"); got != "***.982.247-**" {
+		t.Errorf("Mask(CPF) = %q", got)
+	}
+	// CNPJ é público e não é mascarado
+	if got := Mask("11222333000181"); got != "11222333000181" {
+		t.Errorf("Mask(CNPJ) = %q", got)
+	}
+}
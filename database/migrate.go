@@ -3,6 +3,12 @@ package database
 import (
 	"database/sql"
 	"fmt"
+	"log"
+
+	"BACK_SORTE_GO/config"
+	"BACK_SORTE_GO/cpf"
+
+	"golang.org/x/crypto/bcrypt"
 )
 
 func RunMigrations(db *sql.DB) error {
@@ -45,10 +51,12 @@ func RunMigrations(db *sql.DB) error {
 		);`,
 
 		// Inserção da role ROLE_OPERATOR
-		`INSERT INTO core.role (role_name) VALUES ('ROLE_OPERATOR');`,
+		`INSERT INTO core.role (role_name)
+			SELECT 'ROLE_OPERATOR' WHERE NOT EXISTS (SELECT 1 FROM core.role WHERE role_name = 'ROLE_OPERATOR');`,
 
 		// Inserção da role ROLE_ADMIN
-		`INSERT INTO core.role (role_name) VALUES ('ROLE_ADMIN');`,
+		`INSERT INTO core.role (role_name)
+			SELECT 'ROLE_ADMIN' WHERE NOT EXISTS (SELECT 1 FROM core.role WHERE role_name = 'ROLE_ADMIN');`,
 
 		// Tabela user_role
 		`CREATE TABLE IF NOT EXISTS core.user_role (
@@ -71,7 +79,7 @@ func RunMigrations(db *sql.DB) error {
 			id UUID PRIMARY KEY,
 			id_user UUID REFERENCES core.user(id),
 			name VARCHAR(255) NOT NULL,
-			valor DOUBLE PRECISION NOT NULL,
+			valor NUMERIC(12,2) NOT NULL,
 			active BOOLEAN DEFAULT true,
 			dell BOOLEAN DEFAULT false,
 			closed BOOLEAN DEFAULT false,
@@ -94,7 +102,7 @@ func RunMigrations(db *sql.DB) error {
 			id UUID PRIMARY KEY,
 			id_doacao UUID REFERENCES core.doacao(id),
 			qrcode VARCHAR(255),
-			valor DOUBLE PRECISION NOT NULL,
+			valor NUMERIC(12,2) NOT NULL,
 			active BOOLEAN DEFAULT true,
 			dell BOOLEAN DEFAULT false,
 			date_start TIMESTAMP,
@@ -146,7 +154,7 @@ func RunMigrations(db *sql.DB) error {
 		`CREATE TABLE IF NOT EXISTS core.saque_details (
 			id UUID PRIMARY KEY,
 			id_saque_conta UUID REFERENCES core.saque_conta(id),
-			valor DOUBLE PRECISION NOT NULL,
+			valor NUMERIC(12,2) NOT NULL,
 			realizado BOOLEAN DEFAULT false,
 			error VARCHAR(255),
 			date_create TIMESTAMP DEFAULT now(),
@@ -286,6 +294,550 @@ func RunMigrations(db *sql.DB) error {
 			data_create TIMESTAMP WITHOUT TIME ZONE DEFAULT now()
 		);`,
 
+		// Sessões de login (família de refresh tokens)
+		`CREATE TABLE IF NOT EXISTS core.user_session (
+			id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+			id_user UUID NOT NULL REFERENCES core.user(id),
+			revoked BOOLEAN NOT NULL DEFAULT false,
+			revoked_motivo VARCHAR(100),
+			revoked_at TIMESTAMP,
+			expires_at TIMESTAMP NOT NULL,
+			date_create TIMESTAMP DEFAULT now()
+		);`,
+
+		`CREATE INDEX IF NOT EXISTS idx_user_session_id_user ON core.user_session (id_user);`,
+
+		// Refresh tokens (apenas o hash é persistido)
+		`CREATE TABLE IF NOT EXISTS core.refresh_token (
+			id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+			id_session UUID NOT NULL REFERENCES core.user_session(id) ON DELETE CASCADE,
+			id_user UUID NOT NULL REFERENCES core.user(id),
+			token_hash VARCHAR(64) UNIQUE NOT NULL,
+			used BOOLEAN NOT NULL DEFAULT false,
+			used_at TIMESTAMP,
+			expires_at TIMESTAMP NOT NULL,
+			date_create TIMESTAMP DEFAULT now()
+		);`,
+
+		// Tokens de recuperação de senha (apenas o hash é persistido)
+		`CREATE TABLE IF NOT EXISTS core.password_reset (
+			id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+			id_user UUID NOT NULL REFERENCES core.user(id),
+			token_hash VARCHAR(64) UNIQUE NOT NULL,
+			expires_at TIMESTAMP NOT NULL,
+			used_at TIMESTAMP,
+			ip VARCHAR(100),
+			date_create TIMESTAMP DEFAULT now()
+		);`,
+
+		// Tokens de verificação de e-mail (apenas o hash é persistido)
+		`CREATE TABLE IF NOT EXISTS core.email_verification (
+			id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+			id_user UUID NOT NULL REFERENCES core.user(id),
+			email VARCHAR(255) NOT NULL,
+			token_hash VARCHAR(64) UNIQUE NOT NULL,
+			expires_at TIMESTAMP NOT NULL,
+			used_at TIMESTAMP,
+			date_create TIMESTAMP DEFAULT now()
+		);`,
+
+		// Auditoria de login
+		`ALTER TABLE core.user_login ADD COLUMN IF NOT EXISTS ip VARCHAR(100);`,
+		`ALTER TABLE core.user_login ADD COLUMN IF NOT EXISTS user_agent VARCHAR(500);`,
+		`ALTER TABLE core.user_login ADD COLUMN IF NOT EXISTS motivo VARCHAR(50);`,
+		`CREATE INDEX IF NOT EXISTS idx_user_login_email_date ON core.user_login (email, date);`,
+		`CREATE INDEX IF NOT EXISTS idx_user_login_ip_date ON core.user_login (ip, date);`,
+		`CREATE INDEX IF NOT EXISTS idx_user_login_id_user_date ON core.user_login (id_user, date);`,
+
+		// Autenticação em duas etapas (TOTP - RFC 6238)
+		`CREATE TABLE IF NOT EXISTS core.user_totp (
+			id_user UUID PRIMARY KEY REFERENCES core.user(id),
+			secret VARCHAR(64) NOT NULL,
+			enabled BOOLEAN NOT NULL DEFAULT false,
+			last_step BIGINT NOT NULL DEFAULT 0,
+			date_enabled TIMESTAMP,
+			date_create TIMESTAMP DEFAULT now()
+		);`,
+
+		// Códigos de recuperação do 2FA (apenas o hash é persistido)
+		`CREATE TABLE IF NOT EXISTS core.user_recovery_code (
+			id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+			id_user UUID NOT NULL REFERENCES core.user(id),
+			code_hash VARCHAR(64) NOT NULL,
+			used_at TIMESTAMP,
+			date_create TIMESTAMP DEFAULT now()
+		);`,
+
+		`CREATE INDEX IF NOT EXISTS idx_user_recovery_code_id_user ON core.user_recovery_code (id_user);`,
+
+		// Momento da última confirmação do 2FA na sessão (step-up)
+		`ALTER TABLE core.user_session ADD COLUMN IF NOT EXISTS step_up_at TIMESTAMP;`,
+
+		// Clientes da API autorizados a usar /login (web, mobile, parceiros); apenas o hash do secret é persistido
+		`CREATE TABLE IF NOT EXISTS core.api_client (
+			id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+			client_id VARCHAR(100) UNIQUE NOT NULL,
+			name VARCHAR(150) NOT NULL,
+			secret_hash VARCHAR(255) NOT NULL,
+			grant_types TEXT[] NOT NULL DEFAULT '{}',
+			allowed_origins TEXT[] NOT NULL DEFAULT '{}',
+			active BOOLEAN NOT NULL DEFAULT true,
+			last_used_at TIMESTAMP,
+			date_create TIMESTAMP DEFAULT now(),
+			date_update TIMESTAMP
+		);`,
+
+		`ALTER TABLE core.user_session ADD COLUMN IF NOT EXISTS id_api_client UUID REFERENCES core.api_client(id);`,
+
+		// LGPD: registro das solicitações do titular (exportação e exclusão)
+		`CREATE TABLE IF NOT EXISTS core.lgpd_request (
+			id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+			id_user UUID NOT NULL REFERENCES core.user(id),
+			tipo VARCHAR(20) NOT NULL,
+			ip VARCHAR(100),
+			date_create TIMESTAMP DEFAULT now()
+		);`,
+
+		`ALTER TABLE core.user ADD COLUMN IF NOT EXISTS date_anonymized TIMESTAMP;`,
+
+		// Notificações recebidas pelo webhook PIX (uma por endToEndId)
+		`CREATE TABLE IF NOT EXISTS core.pix_webhook_event (
+			id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+			end_to_end_id VARCHAR(100) UNIQUE NOT NULL,
+			txid VARCHAR(255),
+			valor NUMERIC(10,2),
+			horario TIMESTAMP,
+			payload JSONB NOT NULL,
+			processado BOOLEAN NOT NULL DEFAULT false,
+			erro VARCHAR(255),
+			date_create TIMESTAMP DEFAULT now()
+		);`,
+
+		`ALTER TABLE core.pix_qrcode_status ADD COLUMN IF NOT EXISTS end_to_end_id VARCHAR(100);`,
+		`CREATE INDEX IF NOT EXISTS idx_pix_qrcode_status_id_pix ON core.pix_qrcode_status (id_pix);`,
+
+		// Fila de jobs persistente (consulta de status de pagamento etc.)
+		`CREATE TABLE IF NOT EXISTS core.job (
+			id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+			tipo VARCHAR(50) NOT NULL,
+			chave VARCHAR(255) NOT NULL,
+			payload JSONB NOT NULL DEFAULT '{}',
+			status VARCHAR(20) NOT NULL DEFAULT 'PENDING',
+			attempts INT NOT NULL DEFAULT 0,
+			max_attempts INT NOT NULL DEFAULT 10,
+			next_run_at TIMESTAMP NOT NULL DEFAULT now(),
+			last_error TEXT,
+			locked_at TIMESTAMP,
+			locked_by VARCHAR(255),
+			date_create TIMESTAMP DEFAULT now(),
+			date_update TIMESTAMP
+		);`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS uq_job_tipo_chave_ativo ON core.job (tipo, chave) WHERE status IN ('PENDING', 'RUNNING');`,
+		`CREATE INDEX IF NOT EXISTS idx_job_pending ON core.job (next_run_at) WHERE status = 'PENDING';`,
+
+		// Liquidação dos pagamentos PIX: uma linha por txid garante que o crédito na doação é feito uma única vez
+		`CREATE TABLE IF NOT EXISTS core.pix_liquidacao (
+			id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+			txid VARCHAR(255) UNIQUE NOT NULL,
+			id_pix_qrcode UUID NOT NULL REFERENCES core.pix_qrcode(id) ON DELETE CASCADE,
+			id_doacao UUID NOT NULL,
+			end_to_end_id VARCHAR(100),
+			valor_bruto NUMERIC(12,2) NOT NULL,
+			taxa NUMERIC(12,2) NOT NULL,
+			valor_liquido NUMERIC(12,2) NOT NULL,
+			origem VARCHAR(20) NOT NULL,
+			date_create TIMESTAMP DEFAULT now()
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_pix_liquidacao_id_doacao ON core.pix_liquidacao (id_doacao);`,
+		// Cobranças CONCLUIDA de antes da tabela não são liquidadas aqui: a consulta antiga era simulada e o
+		// status não prova o pagamento. O job PIX_LEGADO confere cada uma no provedor (handlers.EnqueuePixLegadoJobs).
+
+		// Razão contábil em partidas dobradas (valores em centavos; débito positivo, crédito negativo)
+		`CREATE TABLE IF NOT EXISTS core.ledger_account (
+			id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+			codigo VARCHAR(100) UNIQUE NOT NULL,
+			tipo VARCHAR(30) NOT NULL,
+			natureza CHAR(1) NOT NULL CHECK (natureza IN ('D', 'C')),
+			id_doacao UUID,
+			date_create TIMESTAMP DEFAULT now()
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_ledger_account_id_doacao ON core.ledger_account (id_doacao);`,
+		`CREATE TABLE IF NOT EXISTS core.ledger_transaction (
+			id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+			tipo VARCHAR(30) NOT NULL,
+			referencia VARCHAR(255) NOT NULL,
+			id_doacao UUID,
+			descricao VARCHAR(255),
+			date_create TIMESTAMP DEFAULT now(),
+			UNIQUE (tipo, referencia)
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_ledger_transaction_id_doacao ON core.ledger_transaction (id_doacao);`,
+		`CREATE TABLE IF NOT EXISTS core.ledger_entry (
+			id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+			id_transaction UUID NOT NULL REFERENCES core.ledger_transaction(id),
+			id_account UUID NOT NULL REFERENCES core.ledger_account(id),
+			valor BIGINT NOT NULL CHECK (valor <> 0),
+			date_create TIMESTAMP DEFAULT now()
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_ledger_entry_id_transaction ON core.ledger_entry (id_transaction);`,
+		`CREATE INDEX IF NOT EXISTS idx_ledger_entry_id_account ON core.ledger_entry (id_account);`,
+
+		// Lançamentos só podem ser inseridos; correções são feitas com novas transações
+		`CREATE OR REPLACE FUNCTION core.ledger_somente_insercao() RETURNS trigger AS $$
+		BEGIN
+			RAISE EXCEPTION 'lançamentos contábeis não podem ser alterados ou removidos';
+		END;
+		$$ LANGUAGE plpgsql;`,
+		`DROP TRIGGER IF EXISTS trg_ledger_transaction_somente_insercao ON core.ledger_transaction;`,
+		`CREATE TRIGGER trg_ledger_transaction_somente_insercao BEFORE UPDATE OR DELETE ON core.ledger_transaction
+			FOR EACH ROW EXECUTE FUNCTION core.ledger_somente_insercao();`,
+		`DROP TRIGGER IF EXISTS trg_ledger_entry_somente_insercao ON core.ledger_entry;`,
+		`CREATE TRIGGER trg_ledger_entry_somente_insercao BEFORE UPDATE OR DELETE ON core.ledger_entry
+			FOR EACH ROW EXECUTE FUNCTION core.ledger_somente_insercao();`,
+
+		// A soma dos lançamentos de cada transação é conferida no commit
+		`CREATE OR REPLACE FUNCTION core.ledger_verifica_balanco() RETURNS trigger AS $$
+		BEGIN
+			IF (SELECT COALESCE(SUM(valor), 0) FROM core.ledger_entry WHERE id_transaction = NEW.id_transaction) <> 0 THEN
+				RAISE EXCEPTION 'transação contábil % não está balanceada', NEW.id_transaction;
+			END IF;
+			RETURN NULL;
+		END;
+		$$ LANGUAGE plpgsql;`,
+		`DROP TRIGGER IF EXISTS trg_ledger_entry_balanco ON core.ledger_entry;`,
+		`CREATE CONSTRAINT TRIGGER trg_ledger_entry_balanco AFTER INSERT ON core.ledger_entry
+			DEFERRABLE INITIALLY DEFERRED
+			FOR EACH ROW EXECUTE FUNCTION core.ledger_verifica_balanco();`,
+
+		`CREATE OR REPLACE VIEW core.ledger_saldo AS
+			SELECT la.id, la.codigo, la.tipo, la.id_doacao,
+				CASE la.natureza WHEN 'D' THEN COALESCE(SUM(le.valor), 0) ELSE -COALESCE(SUM(le.valor), 0) END AS saldo
+			FROM core.ledger_account la
+			LEFT JOIN core.ledger_entry le ON le.id_account = la.id
+			GROUP BY la.id;`,
+
+		`INSERT INTO core.ledger_account (codigo, tipo, natureza) VALUES
+			('PROVEDOR', 'PROVEDOR', 'D'),
+			('TAXA_PLATAFORMA', 'TAXA_PLATAFORMA', 'C'),
+			('SAQUE_TRANSITO', 'SAQUE_TRANSITO', 'C')
+		ON CONFLICT (codigo) DO NOTHING;`,
+
+		// Saldos existentes: lança as liquidações e as transferências já feitas
+		`INSERT INTO core.ledger_account (codigo, tipo, natureza, id_doacao)
+			SELECT DISTINCT 'CAMPANHA:' || x.id_doacao::TEXT, 'CAMPANHA', 'C', x.id_doacao
+			FROM (
+				SELECT id_doacao FROM core.pix_liquidacao
+				UNION
+				SELECT id_doacao FROM core.doacao_pagamentos WHERE valor_tranferido > 0
+			) x
+		ON CONFLICT (codigo) DO NOTHING;`,
+		`WITH t AS (
+			INSERT INTO core.ledger_transaction (tipo, referencia, id_doacao, descricao, date_create)
+				SELECT 'PIX_RECEBIDO', l.txid, l.id_doacao, 'PIX recebido', l.date_create
+				FROM core.pix_liquidacao l
+			ON CONFLICT (tipo, referencia) DO NOTHING
+			RETURNING id, referencia
+		)
+		INSERT INTO core.ledger_entry (id_transaction, id_account, valor)
+			SELECT t.id, a.id, e.valor
+			FROM t
+			JOIN core.pix_liquidacao l ON l.txid = t.referencia
+			CROSS JOIN LATERAL (VALUES
+				('PROVEDOR', round(l.valor_bruto * 100)::BIGINT),
+				('CAMPANHA:' || l.id_doacao::TEXT, -round(l.valor_bruto * 100)::BIGINT)
+			) AS e(codigo, valor)
+			JOIN core.ledger_account a ON a.codigo = e.codigo;`,
+		`WITH t AS (
+			INSERT INTO core.ledger_transaction (tipo, referencia, id_doacao, descricao, date_create)
+				SELECT 'TAXA', l.txid, l.id_doacao, 'Taxa da plataforma', l.date_create
+				FROM core.pix_liquidacao l
+				WHERE l.taxa > 0
+			ON CONFLICT (tipo, referencia) DO NOTHING
+			RETURNING id, referencia
+		)
+		INSERT INTO core.ledger_entry (id_transaction, id_account, valor)
+			SELECT t.id, a.id, e.valor
+			FROM t
+			JOIN core.pix_liquidacao l ON l.txid = t.referencia
+			CROSS JOIN LATERAL (VALUES
+				('CAMPANHA:' || l.id_doacao::TEXT, round(l.taxa * 100)::BIGINT),
+				('TAXA_PLATAFORMA', -round(l.taxa * 100)::BIGINT)
+			) AS e(codigo, valor)
+			JOIN core.ledger_account a ON a.codigo = e.codigo;`,
+		`WITH s AS (
+			SELECT id_doacao, SUM(valor_tranferido) AS valor, MAX(data_tranferido) AS data_tranferido
+			FROM core.doacao_pagamentos
+			WHERE valor_tranferido > 0
+			GROUP BY id_doacao
+		), t AS (
+			INSERT INTO core.ledger_transaction (tipo, referencia, id_doacao, descricao, date_create)
+				SELECT 'SAQUE_CONCLUIDO', 'MIGRACAO:' || s.id_doacao::TEXT, s.id_doacao, 'Transferências anteriores ao razão', COALESCE(s.data_tranferido, now())
+				FROM s
+			ON CONFLICT (tipo, referencia) DO NOTHING
+			RETURNING id, id_doacao
+		)
+		INSERT INTO core.ledger_entry (id_transaction, id_account, valor)
+			SELECT t.id, a.id, e.valor
+			FROM t
+			JOIN s ON s.id_doacao = t.id_doacao
+			CROSS JOIN LATERAL (VALUES
+				('CAMPANHA:' || s.id_doacao::TEXT, round(s.valor * 100)::BIGINT),
+				('PROVEDOR', -round(s.valor * 100)::BIGINT)
+			) AS e(codigo, valor)
+			JOIN core.ledger_account a ON a.codigo = e.codigo;`,
+
+		// Regras de taxa da plataforma (percentual, valor fixo, mínimo; por área da campanha e plano do dono)
+		`CREATE TABLE IF NOT EXISTS core.taxa_regra (
+			id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+			nome VARCHAR(255) NOT NULL,
+			percentual NUMERIC(5,2) NOT NULL DEFAULT 0 CHECK (percentual >= 0 AND percentual <= 100),
+			valor_fixo NUMERIC(12,2) NOT NULL DEFAULT 0 CHECK (valor_fixo >= 0),
+			valor_minimo NUMERIC(12,2) NOT NULL DEFAULT 0 CHECK (valor_minimo >= 0),
+			area VARCHAR(255),
+			nivel VARCHAR(100),
+			prioridade INT NOT NULL DEFAULT 0,
+			vigencia_inicio TIMESTAMP,
+			vigencia_fim TIMESTAMP,
+			ativo BOOLEAN NOT NULL DEFAULT true,
+			date_create TIMESTAMP DEFAULT now(),
+			date_update TIMESTAMP
+		);`,
+		`INSERT INTO core.taxa_regra (nome, percentual)
+			SELECT 'Taxa padrão', 10 WHERE NOT EXISTS (SELECT 1 FROM core.taxa_regra);`,
+		`ALTER TABLE core.pix_liquidacao ADD COLUMN IF NOT EXISTS id_taxa_regra UUID;`,
+		`ALTER TABLE core.pix_liquidacao ADD COLUMN IF NOT EXISTS taxa_percentual NUMERIC(5,2);`,
+		`ALTER TABLE core.pix_liquidacao ADD COLUMN IF NOT EXISTS taxa_valor_fixo NUMERIC(12,2);`,
+		`ALTER TABLE core.pix_liquidacao ADD COLUMN IF NOT EXISTS taxa_valor_minimo NUMERIC(12,2);`,
+
+		// Valores monetários em NUMERIC (bancos criados antes usavam DOUBLE PRECISION)
+		`DO $$
+		DECLARE
+			c RECORD;
+		BEGIN
+			FOR c IN
+				SELECT table_name FROM information_schema.columns
+				WHERE table_schema = 'core' AND column_name = 'valor' AND data_type = 'double precision'
+				  AND table_name IN ('doacao', 'doacao_qrcode', 'saque_details')
+			LOOP
+				EXECUTE format('ALTER TABLE core.%I ALTER COLUMN valor TYPE NUMERIC(12,2) USING round(valor::NUMERIC, 2)', c.table_name);
+			END LOOP;
+		END
+		$$;`,
+
+		// Saques: envio por PIX para a chave da conta de saque, com aprovação do operador
+		`ALTER TABLE core.saque_details ADD COLUMN IF NOT EXISTS id_doacao UUID;`,
+		`ALTER TABLE core.saque_details ADD COLUMN IF NOT EXISTS status VARCHAR(20) NOT NULL DEFAULT 'SOLICITADO';`,
+		`ALTER TABLE core.saque_details ADD COLUMN IF NOT EXISTS chave_destino VARCHAR(255);`,
+		`ALTER TABLE core.saque_details ADD COLUMN IF NOT EXISTS id_envio VARCHAR(35);`,
+		`ALTER TABLE core.saque_details ADD COLUMN IF NOT EXISTS end_to_end_id VARCHAR(100);`,
+		`ALTER TABLE core.saque_details ADD COLUMN IF NOT EXISTS aprovado_por UUID;`,
+		`ALTER TABLE core.saque_details ADD COLUMN IF NOT EXISTS data_aprovacao TIMESTAMP;`,
+		`ALTER TABLE core.saque_details ADD COLUMN IF NOT EXISTS data_envio TIMESTAMP;`,
+		`ALTER TABLE core.saque_details ADD COLUMN IF NOT EXISTS data_confirmacao TIMESTAMP;`,
+		`ALTER TABLE core.saque_details ADD COLUMN IF NOT EXISTS comprovante JSONB;`,
+		`CREATE INDEX IF NOT EXISTS idx_saque_details_id_doacao ON core.saque_details (id_doacao);`,
+		`CREATE INDEX IF NOT EXISTS idx_saque_details_status ON core.saque_details (status);`,
+
+		// Devoluções de PIX aos doadores (parciais ou totais), com o estorno proporcional da taxa
+		`ALTER TABLE core.pix_qrcode ADD COLUMN IF NOT EXISTS email VARCHAR(255);`,
+		`CREATE TABLE IF NOT EXISTS core.pix_devolucao (
+			id UUID PRIMARY KEY,
+			id_devolucao VARCHAR(35) UNIQUE NOT NULL,
+			txid VARCHAR(255) NOT NULL REFERENCES core.pix_liquidacao(txid),
+			id_pix_qrcode UUID NOT NULL REFERENCES core.pix_qrcode(id) ON DELETE CASCADE,
+			id_doacao UUID NOT NULL,
+			end_to_end_id VARCHAR(100) NOT NULL,
+			valor NUMERIC(12,2) NOT NULL CHECK (valor > 0),
+			taxa_estornada NUMERIC(12,2) NOT NULL DEFAULT 0,
+			motivo VARCHAR(255),
+			status VARCHAR(30) NOT NULL,
+			rtr_id VARCHAR(100),
+			resposta JSONB,
+			error VARCHAR(255),
+			solicitado_por UUID,
+			date_create TIMESTAMP DEFAULT now(),
+			date_update TIMESTAMP
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_pix_devolucao_txid ON core.pix_devolucao (txid);`,
+		`CREATE INDEX IF NOT EXISTS idx_pix_devolucao_id_doacao ON core.pix_devolucao (id_doacao);`,
+
+		// Conciliação das cobranças com a listagem da EfiPay: execuções e divergências para os operadores
+		`CREATE TABLE IF NOT EXISTS core.pix_conciliacao (
+			id UUID PRIMARY KEY,
+			inicio TIMESTAMP NOT NULL,
+			fim TIMESTAMP NOT NULL,
+			origem VARCHAR(20) NOT NULL,
+			cobrancas_provedor INT NOT NULL DEFAULT 0,
+			cobrancas_locais INT NOT NULL DEFAULT 0,
+			corrigidas INT NOT NULL DEFAULT 0,
+			divergencias INT NOT NULL DEFAULT 0,
+			erro TEXT,
+			date_create TIMESTAMP DEFAULT now(),
+			date_fim TIMESTAMP
+		);`,
+		`CREATE TABLE IF NOT EXISTS core.pix_conciliacao_item (
+			id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+			id_conciliacao UUID NOT NULL REFERENCES core.pix_conciliacao(id) ON DELETE CASCADE,
+			txid VARCHAR(255) NOT NULL,
+			tipo VARCHAR(50) NOT NULL,
+			corrigido BOOLEAN NOT NULL DEFAULT false,
+			status_local VARCHAR(50),
+			status_provedor VARCHAR(50),
+			valor_local NUMERIC(12,2),
+			valor_provedor NUMERIC(12,2),
+			pago_local TIMESTAMP,
+			pago_provedor TIMESTAMP,
+			detalhe TEXT,
+			date_create TIMESTAMP DEFAULT now()
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_pix_conciliacao_item_id_conciliacao ON core.pix_conciliacao_item (id_conciliacao);`,
+		`CREATE INDEX IF NOT EXISTS idx_pix_conciliacao_item_txid ON core.pix_conciliacao_item (txid);`,
+
+		// QR Code estático da campanha (BR Code gerado localmente): payload completo, chave e txid impressos
+		`DO $$
+		BEGIN
+			IF EXISTS (
+				SELECT 1 FROM information_schema.columns
+				WHERE table_schema = 'core' AND table_name = 'doacao_qrcode' AND column_name = 'qrcode' AND data_type <> 'text'
+			) THEN
+				ALTER TABLE core.doacao_qrcode ALTER COLUMN qrcode TYPE TEXT;
+			END IF;
+		END
+		$$;`,
+		`ALTER TABLE core.doacao_qrcode ADD COLUMN IF NOT EXISTS chave VARCHAR(77);`,
+		`ALTER TABLE core.doacao_qrcode ADD COLUMN IF NOT EXISTS txid VARCHAR(25);`,
+		`ALTER TABLE core.doacao_qrcode ADD COLUMN IF NOT EXISTS criado_por UUID;`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_doacao_qrcode_txid ON core.doacao_qrcode (txid);`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_doacao_qrcode_ativo ON core.doacao_qrcode (id_doacao) WHERE active AND NOT dell;`,
+
+		// Cobranças com vencimento (cobv): promessas de doação e faturas de plano (conta_nivel_pagamento),
+		// com multa, juros e desconto calculados pelo PSP e o valor efetivamente pago
+		`ALTER TABLE core.pix_qrcode_status ALTER COLUMN id_pix_qrcode DROP NOT NULL;`,
+		`ALTER TABLE core.pix_qrcode_status ADD COLUMN IF NOT EXISTS id_conta_nivel_pagamento UUID REFERENCES core.conta_nivel_pagamento(id) ON DELETE CASCADE;`,
+		`ALTER TABLE core.pix_qrcode_status ADD COLUMN IF NOT EXISTS data_vencimento DATE;`,
+		`ALTER TABLE core.pix_qrcode_status ADD COLUMN IF NOT EXISTS validade_apos_vencimento INT;`,
+		`ALTER TABLE core.pix_qrcode_status ADD COLUMN IF NOT EXISTS encargos JSONB;`,
+		`ALTER TABLE core.pix_qrcode_status ADD COLUMN IF NOT EXISTS valor_pago NUMERIC(12,2);`,
+		`CREATE INDEX IF NOT EXISTS idx_pix_qrcode_status_id_conta_nivel_pagamento ON core.pix_qrcode_status (id_conta_nivel_pagamento);`,
+		`ALTER TABLE core.conta_nivel_pagamento ADD COLUMN IF NOT EXISTS nivel VARCHAR(100);`,
+		`ALTER TABLE core.conta_nivel_pagamento ADD COLUMN IF NOT EXISTS valor_pago NUMERIC(12,2);`,
+		`ALTER TABLE core.conta_nivel_pagamento ADD COLUMN IF NOT EXISTS end_to_end_id VARCHAR(100);`,
+		`ALTER TABLE core.conta_nivel_pagamento ADD COLUMN IF NOT EXISTS criado_por UUID;`,
+		`CREATE INDEX IF NOT EXISTS idx_conta_nivel_pagamento_txid ON core.conta_nivel_pagamento (txid);`,
+
+		// Doações mensais: assinatura do doador numa campanha e os ciclos cobrados por cobv a cada mês
+		`CREATE TABLE IF NOT EXISTS core.doacao_recorrente (
+			id UUID PRIMARY KEY,
+			id_doacao UUID NOT NULL REFERENCES core.doacao(id),
+			valor NUMERIC(12,2) NOT NULL CHECK (valor > 0),
+			dia SMALLINT NOT NULL CHECK (dia BETWEEN 1 AND 28),
+			nome VARCHAR(255) NOT NULL,
+			cpf VARCHAR(14) NOT NULL,
+			email VARCHAR(255) NOT NULL,
+			mensagem VARCHAR(255),
+			anonimo BOOLEAN NOT NULL DEFAULT false,
+			chave VARCHAR(77) NOT NULL,
+			status VARCHAR(20) NOT NULL,
+			proximo_ciclo DATE NOT NULL,
+			falhas_consecutivas INT NOT NULL DEFAULT 0,
+			date_create TIMESTAMP DEFAULT now(),
+			date_update TIMESTAMP DEFAULT now(),
+			date_cancelamento TIMESTAMP
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_doacao_recorrente_id_doacao ON core.doacao_recorrente (id_doacao);`,
+		`CREATE INDEX IF NOT EXISTS idx_doacao_recorrente_proximo_ciclo ON core.doacao_recorrente (proximo_ciclo) WHERE status = 'ATIVA';`,
+		`CREATE TABLE IF NOT EXISTS core.doacao_recorrente_ciclo (
+			id UUID PRIMARY KEY,
+			id_recorrente UUID NOT NULL REFERENCES core.doacao_recorrente(id) ON DELETE CASCADE,
+			competencia DATE NOT NULL,
+			status VARCHAR(20) NOT NULL,
+			txid VARCHAR(255),
+			id_pix_qrcode UUID REFERENCES core.pix_qrcode(id),
+			erro TEXT,
+			date_create TIMESTAMP DEFAULT now(),
+			date_update TIMESTAMP DEFAULT now(),
+			UNIQUE (id_recorrente, competencia)
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_doacao_recorrente_ciclo_txid ON core.doacao_recorrente_ciclo (txid);`,
+
+		// Pagamentos com cartão e carteiras digitais: a doação continua em pix_qrcode (registro único usado nas
+		// mensagens e totais da campanha) e a cobrança no provedor fica em pagamento_cartao, com o histórico de status
+		`ALTER TABLE core.pix_qrcode ADD COLUMN IF NOT EXISTS metodo_pagamento VARCHAR(20) NOT NULL DEFAULT 'PIX';`,
+		`CREATE TABLE IF NOT EXISTS core.pagamento_cartao (
+			id UUID PRIMARY KEY,
+			id_pix_qrcode UUID NOT NULL REFERENCES core.pix_qrcode(id) ON DELETE CASCADE,
+			id_doacao UUID NOT NULL,
+			metodo VARCHAR(20) NOT NULL,
+			provedor VARCHAR(30) NOT NULL,
+			id_cobranca VARCHAR(100),
+			status VARCHAR(20) NOT NULL,
+			status_provedor VARCHAR(50),
+			valor NUMERIC(12,2) NOT NULL,
+			parcelas INT NOT NULL DEFAULT 1,
+			taxa NUMERIC(12,2),
+			valor_liquido NUMERIC(12,2),
+			id_taxa_regra UUID,
+			taxa_percentual NUMERIC(5,2),
+			taxa_valor_fixo NUMERIC(12,2),
+			taxa_valor_minimo NUMERIC(12,2),
+			motivo TEXT,
+			autorizado_em TIMESTAMP,
+			capturado_em TIMESTAMP,
+			contestado_em TIMESTAMP,
+			estornado_em TIMESTAMP,
+			date_create TIMESTAMP DEFAULT now(),
+			date_update TIMESTAMP DEFAULT now()
+		);`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_pagamento_cartao_cobranca ON core.pagamento_cartao (provedor, id_cobranca);`,
+		`CREATE INDEX IF NOT EXISTS idx_pagamento_cartao_id_doacao ON core.pagamento_cartao (id_doacao);`,
+		`CREATE TABLE IF NOT EXISTS core.pagamento_cartao_evento (
+			id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+			id_pagamento UUID NOT NULL REFERENCES core.pagamento_cartao(id) ON DELETE CASCADE,
+			status_anterior VARCHAR(20),
+			status VARCHAR(20) NOT NULL,
+			status_provedor VARCHAR(50),
+			origem VARCHAR(20) NOT NULL,
+			date_create TIMESTAMP DEFAULT now()
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_pagamento_cartao_evento_id_pagamento ON core.pagamento_cartao_evento (id_pagamento);`,
+
+		// Idempotency-Key: resposta original das rotas de criação, devolvida nas repetições da mesma requisição
+		`CREATE TABLE IF NOT EXISTS core.idempotency_key (
+			id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+			escopo VARCHAR(300) NOT NULL,
+			chave VARCHAR(255) NOT NULL,
+			fingerprint VARCHAR(64) NOT NULL,
+			status VARCHAR(20) NOT NULL,
+			status_code INT,
+			content_type VARCHAR(100),
+			resposta BYTEA,
+			date_create TIMESTAMP DEFAULT now(),
+			date_update TIMESTAMP DEFAULT now(),
+			expira_em TIMESTAMP NOT NULL,
+			UNIQUE (escopo, chave)
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_idempotency_key_expira_em ON core.idempotency_key (expira_em);`,
+
+		// Contestação de pagamento com cartão: valor retido no razão até a decisão do chargeback
+		`ALTER TABLE core.pagamento_cartao ADD COLUMN IF NOT EXISTS contestacoes INT NOT NULL DEFAULT 0;`,
+		`ALTER TABLE core.pagamento_cartao ADD COLUMN IF NOT EXISTS contestacao_retida BOOLEAN NOT NULL DEFAULT false;`,
+		`INSERT INTO core.ledger_account (codigo, tipo, natureza) VALUES ('CONTESTACAO_CARTAO', 'CONTESTACAO_CARTAO', 'C')
+			ON CONFLICT (codigo) DO NOTHING;`,
+
+		// Pedidos de redefinição de senha por IP, inclusive para e-mails não cadastrados (limite por IP)
+		`CREATE TABLE IF NOT EXISTS core.password_reset_request (
+			id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+			ip VARCHAR(100) NOT NULL,
+			date_create TIMESTAMP DEFAULT now()
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_password_reset_request_ip ON core.password_reset_request (ip, date_create);`,
+		`CREATE INDEX IF NOT EXISTS idx_password_reset_id_user ON core.password_reset (id_user, date_create);`,
+
+		// Normaliza CPF/CNPJ já gravados para somente dígitos
+		`UPDATE core.user SET cpf = regexp_replace(cpf, '\D', '', 'g') WHERE cpf ~ '\D';`,
+		`UPDATE core.saque_conta SET cpf = regexp_replace(cpf, '\D', '', 'g') WHERE cpf ~ '\D';`,
+		`UPDATE core.pix_qrcode SET cpf = regexp_replace(cpf, '\D', '', 'g') WHERE cpf ~ '\D';`,
+
 	}
 
 	for _, query := range queries {
@@ -294,5 +846,229 @@ func RunMigrations(db *sql.DB) error {
 		}
 	}
 
+	if err := backfillCpfValid(db); err != nil {
+		return err
+	}
+	if err := seedAdmin(db); err != nil {
+		return err
+	}
+	if err := seedApiClient(db); err != nil {
+		return err
+	}
+	if err := seedLegacyApiClient(db); err != nil {
+		return err
+	}
+	return exigirApiClientAtivo(db)
+}
+
+// seedAdmin concede ROLE_ADMIN ao usuário de ADMIN_EMAIL, permitindo criar o primeiro administrador
+func seedAdmin(db *sql.DB) error {
+	email := config.GetAdminEmail()
+	if email == "" {
+		return nil
+	}
+
+	_, err := db.Exec(`
+		INSERT INTO core.user_role (id_user, id_role)
+		SELECT u.id, r.id
+		FROM core.user u, (SELECT MIN(id) AS id FROM core.role WHERE role_name = 'ROLE_ADMIN') r
+		WHERE u.email = $1 AND r.id IS NOT NULL
+		ON CONFLICT DO NOTHING
+	`, email)
+	if err != nil {
+		return fmt.Errorf("erro ao conceder ROLE_ADMIN para %s: %v", email, err)
+	}
+	return nil
+}
+
+// backfillCpfValid marca cpf_valid nos usuários cujo documento normalizado passa na validação dos dígitos
+// verificadores. Cadastros novos já gravam cpf_valid; aqui só são revistos os que continuam como false.
+func backfillCpfValid(db *sql.DB) error {
+	rows, err := db.Query(`
+		SELECT DISTINCT u.id, u.cpf
+		FROM core.user u
+		JOIN core.user_details ud ON ud.id_user = u.id
+		WHERE COALESCE(ud.cpf_valid, false) = false AND u.cpf <> '' AND u.dell = false
+	`)
+	if err != nil {
+		return fmt.Errorf("erro ao buscar documentos não validados: %v", err)
+	}
+	var validos []string
+	for rows.Next() {
+		var id, documento string
+		if err := rows.Scan(&id, &documento); err != nil {
+			rows.Close()
+			return fmt.Errorf("erro ao ler documento: %v", err)
+		}
+		if _, _, err := cpf.ParseDocumento(documento); err == nil {
+			validos = append(validos, id)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("erro ao ler documentos: %v", err)
+	}
+
+	for _, id := range validos {
+		if _, err := db.Exec(`UPDATE core.user_details SET cpf_valid = true, date_update = now() WHERE id_user = $1`, id); err != nil {
+			return fmt.Errorf("erro ao marcar cpf_valid: %v", err)
+		}
+	}
+	if len(validos) > 0 {
+		log.Println("Documentos validados em user_details.cpf_valid:", len(validos))
+	}
+	return nil
+}
+
+// Tamanho mínimo de API_CLIENT_SECRET; os segredos gerados em /admin/apiClients têm 43 caracteres
+const apiClientSecretMinLen = 32
+
+// seedApiClient cadastra o cliente da API de API_CLIENT_ID/API_CLIENT_SECRET. Se o cliente já existe com outro
+// segredo, o hash é atualizado: a variável de ambiente é a fonte da credencial.
+func seedApiClient(db *sql.DB) error {
+	clientID, secret := config.GetApiClientID(), config.GetApiClientSecret()
+	if clientID == "" && secret == "" {
+		return nil
+	}
+	if clientID == "" || secret == "" {
+		return fmt.Errorf("API_CLIENT_ID e API_CLIENT_SECRET devem ser definidos juntos")
+	}
+	if len(secret) < apiClientSecretMinLen {
+		return fmt.Errorf("API_CLIENT_SECRET deve ter pelo menos %d caracteres", apiClientSecretMinLen)
+	}
+	if clientID == legacyClientID {
+		return fmt.Errorf("API_CLIENT_ID não pode ser o cliente legado %s", legacyClientID)
+	}
+
+	var hashAtual string
+	err := db.QueryRow(`SELECT secret_hash FROM core.api_client WHERE client_id = $1`, clientID).Scan(&hashAtual)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("erro ao buscar cliente da API %s: %v", clientID, err)
+	}
+	if err == nil && bcrypt.CompareHashAndPassword([]byte(hashAtual), []byte(secret)) == nil {
+		return nil
+	}
+
+	hash, errHash := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.DefaultCost)
+	if errHash != nil {
+		return fmt.Errorf("erro ao gerar hash do cliente da API: %v", errHash)
+	}
+	if err == sql.ErrNoRows {
+		_, err = db.Exec(`
+			INSERT INTO core.api_client (client_id, name, secret_hash, grant_types, allowed_origins, active, date_create)
+			VALUES ($1, $1, $2, '{password,refresh_token,totp}', '{}', true, now())
+			ON CONFLICT (client_id) DO NOTHING
+		`, clientID, string(hash))
+		if err != nil {
+			return fmt.Errorf("erro ao cadastrar cliente da API %s: %v", clientID, err)
+		}
+		log.Println("Cliente da API cadastrado a partir de API_CLIENT_ID:", clientID)
+		return nil
+	}
+
+	_, err = db.Exec(`UPDATE core.api_client SET secret_hash = $2, date_update = now() WHERE client_id = $1`, clientID, string(hash))
+	if err != nil {
+		return fmt.Errorf("erro ao atualizar segredo do cliente da API %s: %v", clientID, err)
+	}
+	log.Println("Segredo do cliente da API atualizado a partir de API_CLIENT_SECRET:", clientID)
+	return nil
+}
+
+// exigirApiClientAtivo impede o boot sem nenhum cliente ativo: o /login exige um, e ninguém conseguiria entrar
+// para cadastrar outro em /admin/apiClients
+func exigirApiClientAtivo(db *sql.DB) error {
+	var existe bool
+	if err := db.QueryRow(`SELECT EXISTS(SELECT 1 FROM core.api_client WHERE active = true)`).Scan(&existe); err != nil {
+		return fmt.Errorf("erro ao verificar clientes da API: %v", err)
+	}
+	if !existe {
+		return fmt.Errorf("nenhum cliente ativo em core.api_client: defina API_CLIENT_ID e API_CLIENT_SECRET " +
+			"(ou API_LEGACY_CLIENT=true durante a migração dos frontends antigos)")
+	}
+	return nil
+}
+
+// Credencial que era fixa no código do LoginHandler (Basic QVBJX05BTUVfQUNDRVNT...). Como é pública, só é
+// cadastrada com API_LEGACY_CLIENT=true, para não quebrar frontends antigos durante a migração.
+//
+// Para sair dela: cadastre um cliente próprio em POST /admin/apiClients e atualize os frontends; depois remova
+// API_LEGACY_CLIENT (o cliente legado é desativado no próximo boot) ou troque o segredo em
+// POST /admin/apiClients/{id}/rotateSecret, o que também o deixa de ser a credencial pública.
+const (
+	legacyClientID     = "API_NAME_ACCESS"
+	legacyClientSecret = "API_SECRET_ACCESS"
+)
+
+// seedLegacyApiClient cadastra o cliente legado quando habilitado e ainda não existe nenhum cliente da API.
+// Sem a opção, um cliente legado ativo com o segredo público é desativado se houver outro cliente ativo, e o
+// boot falha se ele for o único; com ela, cada boot registra um aviso.
+func seedLegacyApiClient(db *sql.DB) error {
+	habilitado := config.GetApiLegacyClient() == "true"
+
+	var hashAtual string
+	var ativo bool
+	err := db.QueryRow(`
+		SELECT secret_hash, active FROM core.api_client WHERE client_id = $1
+	`, legacyClientID).Scan(&hashAtual, &ativo)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("erro ao buscar cliente legado: %v", err)
+	}
+	// Segredo já trocado: o cliente deixou de usar a credencial pública e é tratado como qualquer outro
+	segredoPublico := err == nil && bcrypt.CompareHashAndPassword([]byte(hashAtual), []byte(legacyClientSecret)) == nil
+
+	if !habilitado {
+		if segredoPublico && ativo {
+			// Numa atualização em que o legado é o único cliente ativo, desativá-lo tiraria o acesso de todos
+			var outroAtivo bool
+			if err := db.QueryRow(`
+				SELECT EXISTS(SELECT 1 FROM core.api_client WHERE active = true AND client_id <> $1)
+			`, legacyClientID).Scan(&outroAtivo); err != nil {
+				return fmt.Errorf("erro ao verificar clientes da API: %v", err)
+			}
+			if !outroAtivo {
+				return fmt.Errorf("o cliente legado da API (API_NAME_ACCESS) é o único ativo: defina API_CLIENT_ID e " +
+					"API_CLIENT_SECRET para os frontends, ou API_LEGACY_CLIENT=true para mantê-lo por enquanto")
+			}
+			if _, err := db.Exec(`UPDATE core.api_client SET active = false WHERE client_id = $1`, legacyClientID); err != nil {
+				return fmt.Errorf("erro ao desativar cliente legado: %v", err)
+			}
+			log.Println("Cliente legado da API (API_NAME_ACCESS) desativado: API_LEGACY_CLIENT não está habilitado")
+		}
+		return nil
+	}
+
+	if err == sql.ErrNoRows {
+		// O cliente de API_CLIENT_ID, cadastrado antes neste mesmo boot, não conta
+		var exists bool
+		if err := db.QueryRow(`
+			SELECT EXISTS(SELECT 1 FROM core.api_client WHERE client_id <> $1)
+		`, config.GetApiClientID()).Scan(&exists); err != nil {
+			return fmt.Errorf("erro ao verificar clientes da API: %v", err)
+		}
+		if exists {
+			return nil
+		}
+
+		hash, err := bcrypt.GenerateFromPassword([]byte(legacyClientSecret), bcrypt.DefaultCost)
+		if err != nil {
+			return fmt.Errorf("erro ao gerar hash do cliente legado: %v", err)
+		}
+
+		_, err = db.Exec(`
+			INSERT INTO core.api_client (client_id, name, secret_hash, grant_types, allowed_origins, active, date_create)
+			VALUES ($1, 'Cliente legado', $2, '{password,refresh_token,totp}', '{}', true, now())
+			ON CONFLICT (client_id) DO NOTHING
+		`, legacyClientID, string(hash))
+		if err != nil {
+			return fmt.Errorf("erro ao cadastrar cliente legado: %v", err)
+		}
+		segredoPublico, ativo = true, true
+	}
+
+	if segredoPublico && ativo {
+		log.Println("AVISO: cliente legado da API ativo com a credencial pública API_NAME_ACCESS/API_SECRET_ACCESS. " +
+			"Migre os frontends para um cliente próprio e remova API_LEGACY_CLIENT ou troque o segredo em " +
+			"POST /admin/apiClients/{id}/rotateSecret")
+	}
 	return nil
 }
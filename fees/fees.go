@@ -0,0 +1,81 @@
+package fees
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"time"
+
+	"BACK_SORTE_GO/ledger"
+	"BACK_SORTE_GO/money"
+)
+
+// Percentual usado quando nenhuma regra de core.taxa_regra se aplica
+const PercentualPadrao = 10.0
+
+// Aplicada é a taxa calculada para um pagamento, com os parâmetros da regra usada. É gravada junto do
+// pagamento para que relatórios antigos não mudem quando as regras mudarem.
+type Aplicada struct {
+	IDRegra     string         `json:"id_regra,omitempty"`
+	Percentual  float64        `json:"percentual"`
+	ValorFixo   money.Centavos `json:"valor_fixo"`
+	ValorMinimo money.Centavos `json:"valor_minimo"`
+	Taxa        money.Centavos `json:"taxa"`
+}
+
+// Calcula aplica percentual, valor fixo e mínimo ao valor. A taxa nunca passa do valor.
+func (a Aplicada) Calcula(valor money.Centavos) money.Centavos {
+	taxa := money.Centavos(math.Round(float64(valor)*a.Percentual/100)) + a.ValorFixo
+	if taxa < a.ValorMinimo {
+		taxa = a.ValorMinimo
+	}
+	if taxa > valor {
+		taxa = valor
+	}
+	if taxa < 0 {
+		taxa = 0
+	}
+	return taxa
+}
+
+// Calcular escolhe a regra vigente em "em" para a campanha e calcula a taxa sobre o valor.
+// Entre as regras ativas que se aplicam (área da campanha e plano do dono, ou genéricas), vence a de maior
+// prioridade, depois a mais específica e por fim a mais recente.
+func Calcular(q ledger.Querier, idDoacao string, valor money.Centavos, em time.Time) (Aplicada, error) {
+	var a Aplicada
+	var idRegra sql.NullString
+
+	err := q.QueryRow(`
+		WITH campanha AS (
+			SELECT dd.area,
+				(SELECT cn.nivel FROM core.conta_nivel cn
+				 WHERE cn.id_user = d.id_user AND cn.ativo = true
+				 ORDER BY cn.data_update DESC LIMIT 1) AS nivel
+			FROM core.doacao d
+			LEFT JOIN core.doacao_details dd ON dd.id_doacao = d.id
+			WHERE d.id = $1
+			LIMIT 1
+		)
+		SELECT tr.id, tr.percentual, tr.valor_fixo, tr.valor_minimo
+		FROM core.taxa_regra tr
+		LEFT JOIN campanha c ON true
+		WHERE tr.ativo = true
+		  AND (tr.vigencia_inicio IS NULL OR tr.vigencia_inicio <= $2)
+		  AND (tr.vigencia_fim IS NULL OR tr.vigencia_fim > $2)
+		  AND (tr.area IS NULL OR tr.area = c.area)
+		  AND (tr.nivel IS NULL OR tr.nivel = c.nivel)
+		ORDER BY tr.prioridade DESC,
+			(tr.area IS NOT NULL)::INT + (tr.nivel IS NOT NULL)::INT DESC,
+			tr.date_create DESC
+		LIMIT 1
+	`, idDoacao, em).Scan(&idRegra, &a.Percentual, &a.ValorFixo, &a.ValorMinimo)
+	if err == sql.ErrNoRows {
+		a.Percentual = PercentualPadrao
+	} else if err != nil {
+		return a, fmt.Errorf("erro ao buscar regra de taxa: %w", err)
+	}
+
+	a.IDRegra = idRegra.String
+	a.Taxa = a.Calcula(valor)
+	return a, nil
+}
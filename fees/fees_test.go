@@ -0,0 +1,35 @@
+package fees
+
+import (
+	"testing"
+
+	"BACK_SORTE_GO/money"
+)
+
+func TestCalcula(t *testing.T) {
+	casos := []struct {
+		nome  string
+		regra Aplicada
+		valor money.Centavos
+		taxa  money.Centavos
+	}{
+		{"percentual", Aplicada{Percentual: 10}, 10000, 1000},
+		{"percentual arredondado", Aplicada{Percentual: 3.99}, 1050, 42},
+		{"percentual meio centavo", Aplicada{Percentual: 2.5}, 1010, 25},
+		{"percentual zero", Aplicada{}, 10000, 0},
+		{"valor fixo", Aplicada{ValorFixo: 150}, 10000, 150},
+		{"percentual e valor fixo", Aplicada{Percentual: 5, ValorFixo: 100}, 10000, 600},
+		{"mínimo aplicado", Aplicada{Percentual: 1, ValorMinimo: 200}, 5000, 200},
+		{"mínimo não aplicado", Aplicada{Percentual: 10, ValorMinimo: 200}, 5000, 500},
+		{"limitada ao valor pelo fixo", Aplicada{ValorFixo: 500}, 300, 300},
+		{"limitada ao valor pelo mínimo", Aplicada{Percentual: 10, ValorMinimo: 1000}, 800, 800},
+		{"percentual acima de 100", Aplicada{Percentual: 150}, 1000, 1000},
+		{"valor zero", Aplicada{Percentual: 10, ValorFixo: 100}, 0, 0},
+		{"fixo negativo", Aplicada{Percentual: 1, ValorFixo: -500}, 1000, 0},
+	}
+	for _, c := range casos {
+		if got := c.regra.Calcula(c.valor); got != c.taxa {
+			t.Errorf("%s: Calcula(%s) = %s, esperado %s", c.nome, c.valor, got, c.taxa)
+		}
+	}
+}
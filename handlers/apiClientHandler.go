@@ -0,0 +1,301 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"BACK_SORTE_GO/models"
+	"BACK_SORTE_GO/utils"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Grant types aceitos pelo /login
+var apiClientGrantTypes = []string{"password", "refresh_token", "totp"}
+
+var (
+	errClienteInvalido    = errors.New("cliente da API inválido")
+	errGrantNaoPermitido  = errors.New("grant_type não permitido para este cliente")
+	errOrigemNaoPermitida = errors.New("origem não permitida para este cliente")
+)
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// validateGrantTypes confere se todos os grant types informados são suportados
+func validateGrantTypes(grantTypes []string) error {
+	if len(grantTypes) == 0 {
+		return errors.New("informe ao menos um grant_type")
+	}
+	for _, g := range grantTypes {
+		if !containsString(apiClientGrantTypes, g) {
+			return errors.New("grant_type não suportado: " + g)
+		}
+	}
+	return nil
+}
+
+// authenticateApiClient valida as credenciais Basic do cliente, o grant_type solicitado e a origem da requisição.
+// Clientes sem origens cadastradas (mobile, parceiros servidor a servidor) aceitam qualquer origem.
+func authenticateApiClient(db *sql.DB, r *http.Request, grantType string) (models.ApiClient, error) {
+	var client models.ApiClient
+
+	clientID, secret, ok := r.BasicAuth()
+	if !ok || clientID == "" || secret == "" {
+		return client, errClienteInvalido
+	}
+
+	var secretHash string
+	err := db.QueryRow(`
+		SELECT id, client_id, name, secret_hash, grant_types, allowed_origins, active
+		FROM core.api_client
+		WHERE client_id = $1
+	`, clientID).Scan(
+		&client.ID, &client.ClientID, &client.Name, &secretHash,
+		pq.Array(&client.GrantTypes), pq.Array(&client.AllowedOrigins), &client.Active,
+	)
+	if err == sql.ErrNoRows {
+		return client, errClienteInvalido
+	}
+	if err != nil {
+		return client, err
+	}
+
+	if !client.Active || bcrypt.CompareHashAndPassword([]byte(secretHash), []byte(secret)) != nil {
+		return client, errClienteInvalido
+	}
+	if !containsString(client.GrantTypes, grantType) {
+		return client, errGrantNaoPermitido
+	}
+	if origin := r.Header.Get("Origin"); origin != "" && len(client.AllowedOrigins) > 0 && !containsString(client.AllowedOrigins, origin) {
+		return client, errOrigemNaoPermitida
+	}
+
+	if _, err := db.Exec(`UPDATE core.api_client SET last_used_at = now() WHERE id = $1`, client.ID); err != nil {
+		log.Println("Erro ao atualizar último uso do cliente da API:", err)
+	}
+
+	return client, nil
+}
+
+// writeApiClientError responde a falha de authenticateApiClient
+func writeApiClientError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errClienteInvalido):
+		w.Header().Set("WWW-Authenticate", `Basic realm="login"`)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+	case errors.Is(err, errGrantNaoPermitido):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, errOrigemNaoPermitida):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	default:
+		http.Error(w, "Erro ao validar cliente da API: "+err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// hashClientSecret gera um novo secret e o seu hash bcrypt
+func hashClientSecret() (string, string, error) {
+	secret, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.DefaultCost)
+	if err != nil {
+		return "", "", err
+	}
+	return secret, string(hash), nil
+}
+
+// ApiClientListHandler lista os clientes da API cadastrados
+func ApiClientListHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rows, err := db.Query(`
+			SELECT id, client_id, name, grant_types, allowed_origins, active, last_used_at, date_create, date_update
+			FROM core.api_client
+			ORDER BY date_create
+		`)
+		if err != nil {
+			http.Error(w, "Erro ao buscar clientes da API: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		clients := []models.ApiClient{}
+		for rows.Next() {
+			var c models.ApiClient
+			if err := rows.Scan(
+				&c.ID, &c.ClientID, &c.Name, pq.Array(&c.GrantTypes), pq.Array(&c.AllowedOrigins),
+				&c.Active, &c.LastUsedAt, &c.DateCreate, &c.DateUpdate,
+			); err != nil {
+				http.Error(w, "Erro ao ler clientes da API: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			clients = append(clients, c)
+		}
+
+		jsonResponse(w, http.StatusOK, clients)
+	}
+}
+
+// ApiClientCreateHandler cadastra um cliente da API. O secret é exibido apenas nesta resposta.
+func ApiClientCreateHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ClientID       string   `json:"client_id"`
+			Name           string   `json:"name"`
+			GrantTypes     []string `json:"grant_types"`
+			AllowedOrigins []string `json:"allowed_origins"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Erro ao processar o JSON", http.StatusBadRequest)
+			return
+		}
+
+		req.Name = strings.TrimSpace(req.Name)
+		if req.Name == "" {
+			http.Error(w, "Nome do cliente é obrigatório", http.StatusBadRequest)
+			return
+		}
+		if err := validateGrantTypes(req.GrantTypes); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.AllowedOrigins == nil {
+			req.AllowedOrigins = []string{}
+		}
+		if req.ClientID == "" {
+			req.ClientID = uuid.NewString()
+		}
+
+		secret, hash, err := hashClientSecret()
+		if err != nil {
+			http.Error(w, "Erro ao gerar secret: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		id := uuid.NewString()
+		_, err = db.Exec(`
+			INSERT INTO core.api_client (id, client_id, name, secret_hash, grant_types, allowed_origins, active, date_create)
+			VALUES ($1, $2, $3, $4, $5, $6, true, now())
+		`, id, req.ClientID, req.Name, hash, pq.Array(req.GrantTypes), pq.Array(req.AllowedOrigins))
+		if err != nil {
+			if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+				http.Error(w, "client_id já cadastrado", http.StatusConflict)
+				return
+			}
+			http.Error(w, "Erro ao cadastrar cliente da API: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		jsonResponse(w, http.StatusCreated, map[string]interface{}{
+			"id":              id,
+			"client_id":       req.ClientID,
+			"client_secret":   secret,
+			"name":            req.Name,
+			"grant_types":     req.GrantTypes,
+			"allowed_origins": req.AllowedOrigins,
+		})
+	}
+}
+
+// ApiClientUpdateHandler altera nome, grant types, origens ou ativa/desativa o cliente
+func ApiClientUpdateHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+
+		var req struct {
+			Name           *string   `json:"name"`
+			GrantTypes     *[]string `json:"grant_types"`
+			AllowedOrigins *[]string `json:"allowed_origins"`
+			Active         *bool     `json:"active"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Erro ao processar o JSON", http.StatusBadRequest)
+			return
+		}
+
+		var grantTypes, allowedOrigins interface{}
+		if req.GrantTypes != nil {
+			if err := validateGrantTypes(*req.GrantTypes); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			grantTypes = pq.Array(*req.GrantTypes)
+		}
+		if req.AllowedOrigins != nil {
+			origins := *req.AllowedOrigins
+			if origins == nil {
+				origins = []string{}
+			}
+			allowedOrigins = pq.Array(origins)
+		}
+
+		res, err := db.Exec(`
+			UPDATE core.api_client SET
+				name = COALESCE($2, name),
+				grant_types = COALESCE($3::TEXT[], grant_types),
+				allowed_origins = COALESCE($4::TEXT[], allowed_origins),
+				active = COALESCE($5, active),
+				date_update = now()
+			WHERE id = $1
+		`, id, req.Name, grantTypes, allowedOrigins, req.Active)
+		if err != nil {
+			http.Error(w, "Erro ao atualizar cliente da API: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			http.Error(w, "Cliente da API não encontrado", http.StatusNotFound)
+			return
+		}
+
+		jsonResponse(w, http.StatusOK, map[string]string{
+			"message": "Cliente da API atualizado com sucesso",
+		})
+	}
+}
+
+// ApiClientRotateSecretHandler gera um novo secret para o cliente, invalidando o anterior imediatamente
+func ApiClientRotateSecretHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+
+		secret, hash, err := hashClientSecret()
+		if err != nil {
+			http.Error(w, "Erro ao gerar secret: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		var clientID string
+		err = db.QueryRow(`
+			UPDATE core.api_client SET secret_hash = $2, date_update = now()
+			WHERE id = $1
+			RETURNING client_id
+		`, id, hash).Scan(&clientID)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Cliente da API não encontrado", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Erro ao atualizar secret: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		jsonResponse(w, http.StatusOK, map[string]string{
+			"client_id":     clientID,
+			"client_secret": secret,
+		})
+	}
+}
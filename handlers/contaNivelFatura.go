@@ -0,0 +1,302 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"BACK_SORTE_GO/config"
+	"BACK_SORTE_GO/cpf"
+	"BACK_SORTE_GO/ledger"
+	"BACK_SORTE_GO/middleware"
+	"BACK_SORTE_GO/money"
+	"BACK_SORTE_GO/payment"
+
+	"github.com/google/uuid"
+)
+
+// Status da fatura de plano em core.conta_nivel_pagamento
+const (
+	FaturaPendente = "PENDENTE"
+	FaturaPaga     = "PAGO"
+	FaturaVencida  = "VENCIDO"
+)
+
+// Fatura é uma fatura de plano (core.conta_nivel_pagamento) cobrada por PIX com vencimento
+type Fatura struct {
+	ID                     string            `json:"id"`
+	IDUser                 string            `json:"id_user"`
+	Nivel                  *string           `json:"nivel,omitempty"`
+	Referente              *string           `json:"referente,omitempty"`
+	Valor                  money.Centavos    `json:"valor"`
+	ValorPago              *money.Centavos   `json:"valor_pago,omitempty"`
+	Status                 *string           `json:"status,omitempty"`
+	Pago                   bool              `json:"pago"`
+	PagoData               *time.Time        `json:"pago_data,omitempty"`
+	Txid                   *string           `json:"txid,omitempty"`
+	PixCopiaECola          *string           `json:"pix_copia_e_cola,omitempty"`
+	DataVencimento         *time.Time        `json:"data_vencimento,omitempty"`
+	ValidadeAposVencimento *int              `json:"validade_apos_vencimento,omitempty"`
+	Encargos               *payment.Encargos `json:"encargos,omitempty"`
+	DataCreate             time.Time         `json:"data_create"`
+}
+
+const faturaSelect = `
+	SELECT f.id, f.id_user, f.nivel, f.referente, COALESCE(f.valor, 0), f.valor_pago, f.status, COALESCE(f.pago, false),
+		f.pago_data, f.txid, f.pixCopiaECola, pqs.data_vencimento, pqs.validade_apos_vencimento, pqs.encargos, f.data_create
+	FROM core.conta_nivel_pagamento f
+	LEFT JOIN core.pix_qrcode_status pqs ON pqs.id_conta_nivel_pagamento = f.id
+`
+
+func scanFaturas(rows *sql.Rows) ([]Fatura, error) {
+	faturas := []Fatura{}
+	for rows.Next() {
+		var f Fatura
+		var encargos []byte
+		err := rows.Scan(&f.ID, &f.IDUser, &f.Nivel, &f.Referente, &f.Valor, &f.ValorPago, &f.Status, &f.Pago,
+			&f.PagoData, &f.Txid, &f.PixCopiaECola, &f.DataVencimento, &f.ValidadeAposVencimento, &encargos, &f.DataCreate)
+		if err != nil {
+			return nil, err
+		}
+		if encargos != nil {
+			f.Encargos = &payment.Encargos{}
+			if err := json.Unmarshal(encargos, f.Encargos); err != nil {
+				return nil, err
+			}
+		}
+		faturas = append(faturas, f)
+	}
+	return faturas, rows.Err()
+}
+
+type faturaRequest struct {
+	IDUser    string         `json:"id_user"`
+	Nivel     string         `json:"nivel"`
+	Valor     money.Centavos `json:"valor"`
+	Referente string         `json:"referente"`
+	CobrancaVencimentoRequest
+}
+
+// ContaNivelFaturaHandler emite a fatura de plano de um usuário como cobrança PIX com vencimento (operador).
+// O pagamento ativa o nível em core.conta_nivel; sem pagamento até o fim da validade a fatura vence.
+func ContaNivelFaturaHandler(db *sql.DB, provider payment.PixProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := middleware.GetPrincipal(r)
+		if !ok {
+			http.Error(w, "Usuário não autenticado", http.StatusUnauthorized)
+			return
+		}
+
+		var req faturaRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Erro ao decodificar JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		req.Nivel = strings.ToUpper(strings.TrimSpace(req.Nivel))
+		if req.IDUser == "" || req.Nivel == "" || req.Valor <= 0 || !req.ComVencimento() {
+			http.Error(w, "id_user, nivel, valor e vencimento são obrigatórios", http.StatusBadRequest)
+			return
+		}
+
+		chave := config.GetPixChaveRecebedor()
+		if chave == "" {
+			http.Error(w, "Chave PIX do recebedor não configurada", http.StatusServiceUnavailable)
+			return
+		}
+
+		var nome, documento string
+		err := db.QueryRow(`
+			SELECT name, COALESCE(cpf, '') FROM core.user WHERE id = $1 AND dell = false
+		`, req.IDUser).Scan(&nome, &documento)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Usuário não encontrado", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Erro ao buscar usuário: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		documento, tipoDocumento, err := cpf.ParseDocumento(documento)
+		if err != nil {
+			http.Error(w, "Documento do usuário inválido para a cobrança: "+err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+
+		solicitacao := "Fatura do plano " + req.Nivel
+		if req.Referente != "" {
+			solicitacao += " - " + req.Referente
+		}
+		dueReq, err := req.dueChargeRequest(req.Valor, chave, nome, documento, tipoDocumento, solicitacao)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		charge, err := provider.CreateDueCharge(dueReq)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Erro ao criar cobrança PIX com vencimento: %v", err), http.StatusInternalServerError)
+			return
+		}
+		pixCopiaECola := cobrancaPixCopiaECola(charge)
+
+		tx, err := db.Begin()
+		if err != nil {
+			http.Error(w, "Erro ao iniciar transação: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		idFatura := uuid.NewString()
+		_, err = tx.Exec(`
+			INSERT INTO core.conta_nivel_pagamento (
+				id, id_user, pago_data, pago, valor, status, data_create, referente, valido,
+				txid, pg_status, cpf, chave, pixCopiaECola, expiracao, nivel, criado_por
+			) VALUES (
+				$1, $2, NULL, false, $3, $4, now(), NULLIF($5, ''), true,
+				$6, $7, $8, $9, $10, NULL, $11, $12
+			)
+		`, idFatura, req.IDUser, req.Valor, FaturaPendente, req.Referente,
+			charge.Txid, charge.Status, documento, chave, pixCopiaECola, req.Nivel, principal.UserID)
+		if err != nil {
+			http.Error(w, "Erro ao salvar fatura: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if err := salvarStatusCobranca(tx, "", idFatura, chave, charge, pixCopiaECola, req.Encargos); err != nil {
+			http.Error(w, "Erro ao salvar pix_qrcode_status: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := enqueuePixStatusJob(tx, charge.Txid, charge.LimitePagamento()); err != nil {
+			http.Error(w, "Erro ao agendar verificação do pagamento: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			http.Error(w, "Erro ao confirmar transação: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		jsonResponse(w, http.StatusCreated, map[string]interface{}{
+			"id":               idFatura,
+			"txid":             charge.Txid,
+			"status":           FaturaPendente,
+			"valor":            req.Valor,
+			"data_vencimento":  dueReq.DataVencimento.Format("2006-01-02"),
+			"pagavel_ate":      charge.LimitePagamento(),
+			"pix_copia_e_cola": pixCopiaECola,
+			"location":         charge.Location,
+		})
+	}
+}
+
+// UserFaturaListHandler lista as faturas de plano do usuário autenticado, com o copia e cola das pendentes
+func UserFaturaListHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := middleware.GetPrincipal(r)
+		if !ok {
+			http.Error(w, "Usuário não autenticado", http.StatusUnauthorized)
+			return
+		}
+
+		rows, err := db.Query(faturaSelect+`
+			WHERE f.id_user = $1 AND f.txid IS NOT NULL
+			ORDER BY f.data_create DESC
+		`, principal.UserID)
+		if err != nil {
+			http.Error(w, "Erro ao buscar faturas: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		faturas, err := scanFaturas(rows)
+		if err != nil {
+			http.Error(w, "Erro ao ler faturas: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		jsonResponse(w, http.StatusOK, faturas)
+	}
+}
+
+var errFaturaNaoEncontrada = errors.New("fatura não encontrada")
+
+// liquidarFatura conclui a cobrança da fatura, ativa o nível do plano do usuário e lança a receita no razão
+// numa única transação. A fatura só é paga uma vez (pago = false na atualização), então webhook e consulta de
+// status repetidos não lançam de novo. Retorna true quando esta chamada registrou o pagamento.
+func liquidarFatura(db *sql.DB, idFatura, txid, endToEndID string, valorPago money.Centavos, origem string) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var idUser, nivel string
+	var valor money.Centavos
+	err = tx.QueryRow(`
+		SELECT id_user, COALESCE(nivel, ''), COALESCE(valor, 0)
+		FROM core.conta_nivel_pagamento
+		WHERE id = $1
+		FOR UPDATE
+	`, idFatura).Scan(&idUser, &nivel, &valor)
+	if err == sql.ErrNoRows {
+		return false, errFaturaNaoEncontrada
+	}
+	if err != nil {
+		return false, err
+	}
+	if valorPago > 0 {
+		valor = valorPago
+	}
+
+	res, err := tx.Exec(`
+		UPDATE core.conta_nivel_pagamento
+		SET pago = true, pago_data = now(), status = $2, pg_status = 'CONCLUIDA', valido = true,
+			valor_pago = $3, end_to_end_id = NULLIF($4, '')
+		WHERE id = $1 AND COALESCE(pago, false) = false
+	`, idFatura, FaturaPaga, valor, endToEndID)
+	if err != nil {
+		return false, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		fmt.Println("Fatura de plano já paga para id:", txid)
+		return false, nil
+	}
+
+	log.Printf("Fatura de plano %s paga (%s), origem %s\n", idFatura, txid, origem)
+
+	_, err = tx.Exec(`
+		UPDATE core.pix_qrcode_status
+		SET status = 'CONCLUIDA', buscar = false, finalizado = true,
+			data_pago = COALESCE(data_pago, now()),
+			end_to_end_id = COALESCE(NULLIF($2, ''), end_to_end_id),
+			valor_pago = $3
+		WHERE id_conta_nivel_pagamento = $1
+	`, idFatura, endToEndID, valor)
+	if err != nil {
+		return false, fmt.Errorf("erro ao atualizar pix_qrcode_status: %w", err)
+	}
+
+	if nivel != "" {
+		_, err = tx.Exec(`
+			UPDATE core.conta_nivel
+			SET nivel = $2, ativo = true, status = 'ATIVO', data_pagamento = now(), tipo_pagamento = 'PIX', data_update = now()
+			WHERE id_user = $1
+		`, idUser, nivel)
+		if err != nil {
+			return false, fmt.Errorf("erro ao ativar o plano: %w", err)
+		}
+	}
+
+	if _, err := ledger.Lancar(tx, ledger.PlanoRecebido(idFatura, valor)); err != nil {
+		return false, fmt.Errorf("erro ao lançar fatura de plano: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,298 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"BACK_SORTE_GO/brcode"
+	"BACK_SORTE_GO/config"
+	"BACK_SORTE_GO/middleware"
+	"BACK_SORTE_GO/money"
+	"BACK_SORTE_GO/payment"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// tipoPagamentoQRCodeEstatico marca em pix_qrcode_status os PIX recebidos pelo QR Code estático da campanha,
+// que não têm cobrança no provedor (id_pix = endToEndId)
+const tipoPagamentoQRCodeEstatico = "QR_ESTATICO"
+
+// Tamanho máximo aceito para a imagem PNG, em pixels
+const qrCodeTamanhoMaximo = 2048
+
+var errQRCodeSemRecebedor = errors.New("chave PIX, nome e cidade do recebedor não configurados")
+
+// QRCodeEstatico é o QR Code impresso da campanha (core.doacao_qrcode), sem valor definido
+type QRCodeEstatico struct {
+	ID         string         `json:"id"`
+	IDDoacao   string         `json:"id_doacao"`
+	Payload    string         `json:"payload"`
+	Chave      string         `json:"chave"`
+	Txid       string         `json:"txid"`
+	Valor      money.Centavos `json:"valor"`
+	DateCreate time.Time      `json:"date_create"`
+}
+
+// qrCodeEstaticoTxid gera o txid impresso no QR Code (até 25 caracteres alfanuméricos)
+func qrCodeEstaticoTxid() string {
+	return "QR" + strings.ReplaceAll(uuid.NewString(), "-", "")[:23]
+}
+
+// rowQueryer é atendido por *sql.DB e *sql.Tx
+type rowQueryer interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func buscarQRCodeEstatico(q rowQueryer, idDoacao string) (*QRCodeEstatico, error) {
+	var c QRCodeEstatico
+	err := q.QueryRow(`
+		SELECT id, id_doacao, qrcode, chave, txid, valor, date_create
+		FROM core.doacao_qrcode
+		WHERE id_doacao = $1 AND active = true AND dell = false AND txid IS NOT NULL
+	`, idDoacao).Scan(&c.ID, &c.IDDoacao, &c.Payload, &c.Chave, &c.Txid, &c.Valor, &c.DateCreate)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// emitirQRCodeEstatico gera o BR Code estático da campanha na chave da plataforma e o grava em doacao_qrcode.
+// O valor fica em aberto: o doador escolhe quanto pagar; o txid identifica a campanha no recebimento.
+func emitirQRCodeEstatico(tx *sql.Tx, idDoacao, nomeCampanha, criadoPor string) (*QRCodeEstatico, error) {
+	chave, nome, cidade := config.GetPixChaveRecebedor(), config.GetPixRecebedorNome(), config.GetPixRecebedorCidade()
+	if chave == "" || nome == "" || cidade == "" {
+		return nil, errQRCodeSemRecebedor
+	}
+
+	c := &QRCodeEstatico{
+		ID:       uuid.NewString(),
+		IDDoacao: idDoacao,
+		Chave:    chave,
+		Txid:     qrCodeEstaticoTxid(),
+	}
+	payload, err := brcode.Pix{
+		Chave:         chave,
+		InfoAdicional: nomeCampanha,
+		NomeRecebedor: nome,
+		Cidade:        cidade,
+		Txid:          c.Txid,
+	}.Payload()
+	if errors.Is(err, brcode.ErrPayloadInvalido) && nomeCampanha != "" {
+		// Nome da campanha longo demais para caber junto com a chave: o QR Code sai sem ele
+		payload, err = brcode.Pix{Chave: chave, NomeRecebedor: nome, Cidade: cidade, Txid: c.Txid}.Payload()
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.Payload = payload
+
+	err = tx.QueryRow(`
+		INSERT INTO core.doacao_qrcode (id, id_doacao, qrcode, valor, chave, txid, criado_por, active, dell, date_start, date_create, date_update)
+		VALUES ($1, $2, $3, 0, $4, $5, NULLIF($6, '')::UUID, true, false, now(), now(), now())
+		RETURNING date_create
+	`, c.ID, idDoacao, c.Payload, c.Chave, c.Txid, criadoPor).Scan(&c.DateCreate)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// DonationQRCodeEstaticoHandler emite o QR Code estático da campanha para cartazes (dono da campanha ou operador).
+// Se já houver um ativo ele é devolvido; com ?rotacionar=true o atual é desativado e um novo é emitido.
+func DonationQRCodeEstaticoHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := middleware.GetPrincipal(r)
+		if !ok {
+			http.Error(w, "Usuário não autenticado", http.StatusUnauthorized)
+			return
+		}
+		idDoacao := mux.Vars(r)["id"]
+		rotacionar := r.URL.Query().Get("rotacionar") == "true"
+
+		tx, err := db.Begin()
+		if err != nil {
+			http.Error(w, "Erro ao iniciar transação: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		var idUser, nome string
+		var ativa bool
+		err = tx.QueryRow(`
+			SELECT id_user, name, active AND NOT closed
+			FROM core.doacao
+			WHERE id = $1 AND dell = false
+			FOR UPDATE
+		`, idDoacao).Scan(&idUser, &nome, &ativa)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Doação não encontrada", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Erro ao buscar doação: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if idUser != principal.UserID && !principal.HasRole(middleware.RoleOperator) {
+			http.Error(w, "Você não tem permissão para gerar o QR Code desta doação", http.StatusForbidden)
+			return
+		}
+		if !ativa {
+			http.Error(w, "Doação encerrada ou inativa", http.StatusConflict)
+			return
+		}
+
+		atual, err := buscarQRCodeEstatico(tx, idDoacao)
+		if err != nil {
+			http.Error(w, "Erro ao buscar QR Code: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if atual != nil && !rotacionar {
+			jsonResponse(w, http.StatusOK, atual)
+			return
+		}
+		if atual != nil {
+			_, err = tx.Exec(`UPDATE core.doacao_qrcode SET active = false, date_update = now() WHERE id = $1`, atual.ID)
+			if err != nil {
+				http.Error(w, "Erro ao desativar QR Code: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		c, err := emitirQRCodeEstatico(tx, idDoacao, nome, principal.UserID)
+		if err == errQRCodeSemRecebedor {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Erro ao gerar QR Code: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			http.Error(w, "Erro ao confirmar transação: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		jsonResponse(w, http.StatusCreated, c)
+	}
+}
+
+// DonationQRCodeHandler devolve o QR Code estático ativo da campanha: JSON (padrão), ?formato=png (&tamanho=)
+// ou ?formato=svg para impressão
+func DonationQRCodeHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c, err := buscarQRCodeEstatico(db, mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Erro ao buscar QR Code: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if c == nil {
+			http.Error(w, "QR Code não encontrado", http.StatusNotFound)
+			return
+		}
+
+		switch r.URL.Query().Get("formato") {
+		case "", "json":
+			jsonResponse(w, http.StatusOK, c)
+		case "png":
+			tamanho := brcode.TamanhoPadrao
+			if t := r.URL.Query().Get("tamanho"); t != "" {
+				if tamanho, err = strconv.Atoi(t); err != nil || tamanho < 64 || tamanho > qrCodeTamanhoMaximo {
+					http.Error(w, fmt.Sprintf("Tamanho deve estar entre 64 e %d", qrCodeTamanhoMaximo), http.StatusBadRequest)
+					return
+				}
+			}
+			img, err := brcode.PNG(c.Payload, tamanho)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(img)
+		case "svg":
+			img, err := brcode.SVG(c.Payload)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "image/svg+xml")
+			w.Write(img)
+		default:
+			http.Error(w, "Formato deve ser json, png ou svg", http.StatusBadRequest)
+		}
+	}
+}
+
+// receberPixQRCodeEstatico registra o PIX pago pelo QR Code estático de uma campanha como doação anônima e o
+// liquida pelo endToEndId. Retorna false quando o txid não é de um QR Code estático.
+func receberPixQRCodeEstatico(db *sql.DB, item payment.ReceivedPix, valor money.Centavos) (bool, error) {
+	var idDoacao string
+	err := db.QueryRow(`SELECT id_doacao FROM core.doacao_qrcode WHERE txid = $1`, item.Txid).Scan(&idDoacao)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if valor <= 0 {
+		return true, marcarEventoWebhook(db, item.EndToEndID, fmt.Sprintf("valor recebido %q inválido", item.Valor))
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return true, err
+	}
+	defer tx.Rollback()
+
+	// Reprocessamento de uma notificação que falhou depois de registrar o pagamento
+	var existe bool
+	err = tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM core.pix_qrcode_status WHERE id_pix = $1)`, item.EndToEndID).Scan(&existe)
+	if err != nil {
+		return true, err
+	}
+	if !existe {
+		criacao := time.Now().UTC()
+		if t, err := time.Parse(time.RFC3339, item.Horario); err == nil {
+			criacao = t.UTC()
+		}
+		mensagem := item.InfoPagador
+		if len([]rune(mensagem)) > 255 {
+			mensagem = string([]rune(mensagem)[:255])
+		}
+
+		idPixQRCode := uuid.NewString()
+		_, err = tx.Exec(`
+			INSERT INTO core.pix_qrcode (id, id_doacao, valor, cpf, nome, mensagem, anonimo, visivel, data_criacao)
+			VALUES ($1, $2, $3, '', 'Doação por QR Code', NULLIF($4, ''), true, false, now())
+		`, idPixQRCode, idDoacao, valor, mensagem)
+		if err != nil {
+			return true, fmt.Errorf("erro ao salvar pix_qrcode: %w", err)
+		}
+		_, err = tx.Exec(`
+			INSERT INTO core.pix_qrcode_status (
+				id, id_pix_qrcode, data_criacao, expiracao, tipo_pagamento, chave, id_pix, status, buscar, finalizado, end_to_end_id
+			) VALUES ($1, $2, $3, 0, $4, NULLIF($5, ''), $6, 'ATIVA', false, false, $7)
+		`, uuid.NewString(), idPixQRCode, criacao, tipoPagamentoQRCodeEstatico, item.Chave, item.EndToEndID, item.EndToEndID)
+		if err != nil {
+			return true, fmt.Errorf("erro ao salvar pix_qrcode_status: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return true, err
+	}
+
+	log.Printf("PIX %s recebido pelo QR Code estático da doação %s\n", item.EndToEndID, idDoacao)
+	if _, err := liquidarPagamento(db, item.EndToEndID, item.EndToEndID, 0, liquidacaoOrigemWebhook); err != nil {
+		return true, err
+	}
+	return true, marcarEventoWebhook(db, item.EndToEndID, "")
+}
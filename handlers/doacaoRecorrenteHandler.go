@@ -0,0 +1,462 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	netmail "net/mail"
+	"strings"
+	"time"
+
+	"BACK_SORTE_GO/config"
+	"BACK_SORTE_GO/cpf"
+	"BACK_SORTE_GO/mail"
+	"BACK_SORTE_GO/money"
+	"BACK_SORTE_GO/payment"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// Status da doação mensal em core.doacao_recorrente
+const (
+	// Aguardando o doador confirmar pelo link enviado ao e-mail informado; nada é cobrado até lá
+	RecorrenciaPendente  = "PENDENTE"
+	RecorrenciaAtiva     = "ATIVA"
+	RecorrenciaPausada   = "PAUSADA"
+	RecorrenciaSuspensa  = "SUSPENSA"
+	RecorrenciaCancelada = "CANCELADA"
+	// Campanha encerrada ou removida: não há mais o que cobrar
+	RecorrenciaEncerrada = "ENCERRADA"
+)
+
+// Status do ciclo mensal em core.doacao_recorrente_ciclo
+const (
+	CicloGerado  = "GERADO"
+	CicloPago    = "PAGO"
+	CicloNaoPago = "NAO_PAGO"
+	CicloFalhou  = "FALHOU"
+	CicloPulado  = "PULADO"
+)
+
+// Dias do mês aceitos para o ciclo: até o dia 28 todo mês tem a data
+const (
+	cicloDiaMinimo = 1
+	cicloDiaMaximo = 28
+)
+
+var errLinkRecorrenciaInvalido = errors.New("link de gerenciamento inválido")
+
+// DoacaoRecorrente é a doação mensal de um doador para uma campanha, vista pelo link de gerenciamento
+type DoacaoRecorrente struct {
+	ID                 string            `json:"id"`
+	IDDoacao           string            `json:"id_doacao"`
+	Campanha           string            `json:"campanha"`
+	Valor              money.Centavos    `json:"valor"`
+	Dia                int               `json:"dia"`
+	Nome               string            `json:"nome"`
+	Email              string            `json:"email"`
+	Anonimo            bool              `json:"anonimo"`
+	Status             string            `json:"status"`
+	ProximoCiclo       time.Time         `json:"proximo_ciclo"`
+	FalhasConsecutivas int               `json:"falhas_consecutivas"`
+	DateCreate         time.Time         `json:"date_create"`
+	DateCancelamento   *time.Time        `json:"date_cancelamento,omitempty"`
+	Ciclos             []CicloRecorrente `json:"ciclos"`
+}
+
+// CicloRecorrente é a cobrança de um mês da doação mensal; o copia e cola só aparece enquanto está em aberto
+type CicloRecorrente struct {
+	Competencia   time.Time  `json:"competencia"`
+	Status        string     `json:"status"`
+	Txid          *string    `json:"txid,omitempty"`
+	PixCopiaECola *string    `json:"pix_copia_e_cola,omitempty"`
+	Vencimento    *time.Time `json:"data_vencimento,omitempty"`
+	DateCreate    time.Time  `json:"date_create"`
+}
+
+// recorrenciaToken assina o id da doação mensal para os links de gerenciamento enviados ao doador
+func recorrenciaToken(id string) string {
+	mac := hmac.New(sha256.New, []byte(config.GetJwtSecret()))
+	mac.Write([]byte("doacao-recorrente:" + id))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verificarRecorrenciaToken confere o token do link. Sem JWT_SECRET configurado nenhum link é aceito.
+func verificarRecorrenciaToken(id, token string) bool {
+	if config.GetJwtSecret() == "" || token == "" {
+		return false
+	}
+	return hmac.Equal([]byte(token), []byte(recorrenciaToken(id)))
+}
+
+// linkRecorrencia é a página onde o doador acompanha, pausa ou cancela a doação mensal
+func linkRecorrencia(id string) string {
+	return fmt.Sprintf("%s/recorrencia/%s?token=%s", config.GetAppURL(), id, recorrenciaToken(id))
+}
+
+// linkConfirmacaoRecorrencia é a página onde o doador confirma o e-mail e ativa a doação mensal
+func linkConfirmacaoRecorrencia(id string) string {
+	return fmt.Sprintf("%s/recorrencia/%s/confirmar?token=%s", config.GetAppURL(), id, recorrenciaToken(id))
+}
+
+// emailValido aceita só o endereço puro (sem nome de exibição), no formato de RFC 5322
+func emailValido(email string) bool {
+	addr, err := netmail.ParseAddress(email)
+	return err == nil && addr.Address == email && strings.Contains(email[strings.LastIndex(email, "@"):], ".")
+}
+
+// hojeBrasilia é a data de hoje no horário de Brasília, sem horário, como as datas lidas de colunas DATE
+func hojeBrasilia() time.Time {
+	agora := time.Now().In(payment.FusoBrasilia)
+	return time.Date(agora.Year(), agora.Month(), agora.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// proximaOcorrencia é a primeira data com o dia do ciclo a partir de hoje
+func proximaOcorrencia(dia int, hoje time.Time) time.Time {
+	data := time.Date(hoje.Year(), hoje.Month(), dia, 0, 0, 0, 0, time.UTC)
+	if data.Before(hoje) {
+		data = data.AddDate(0, 1, 0)
+	}
+	return data
+}
+
+// proximaCompetencia é a data do ciclo do mês seguinte
+func proximaCompetencia(competencia time.Time, dia int) time.Time {
+	return time.Date(competencia.Year(), competencia.Month()+1, dia, 0, 0, 0, 0, time.UTC)
+}
+
+func buscarRecorrencia(db *sql.DB, id string) (*DoacaoRecorrente, error) {
+	var d DoacaoRecorrente
+	err := db.QueryRow(`
+		SELECT r.id, r.id_doacao, d.name, r.valor, r.dia, r.nome, r.email, r.anonimo, r.status,
+			r.proximo_ciclo, r.falhas_consecutivas, r.date_create, r.date_cancelamento
+		FROM core.doacao_recorrente r
+		JOIN core.doacao d ON d.id = r.id_doacao
+		WHERE r.id = $1
+	`, id).Scan(&d.ID, &d.IDDoacao, &d.Campanha, &d.Valor, &d.Dia, &d.Nome, &d.Email, &d.Anonimo, &d.Status,
+		&d.ProximoCiclo, &d.FalhasConsecutivas, &d.DateCreate, &d.DateCancelamento)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT c.competencia, c.status, c.txid,
+			CASE WHEN c.status = $2 THEN pqs.pix_copia_e_cola END,
+			pqs.data_vencimento, c.date_create
+		FROM core.doacao_recorrente_ciclo c
+		LEFT JOIN core.pix_qrcode_status pqs ON pqs.id_pix = c.txid
+		WHERE c.id_recorrente = $1
+		ORDER BY c.competencia DESC
+	`, id, CicloGerado)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	d.Ciclos = []CicloRecorrente{}
+	for rows.Next() {
+		var c CicloRecorrente
+		if err := rows.Scan(&c.Competencia, &c.Status, &c.Txid, &c.PixCopiaECola, &c.Vencimento, &c.DateCreate); err != nil {
+			return nil, err
+		}
+		d.Ciclos = append(d.Ciclos, c)
+	}
+	return &d, rows.Err()
+}
+
+type doacaoRecorrenteRequest struct {
+	IDDoacao string         `json:"id"`
+	Valor    money.Centavos `json:"valor"`
+	Dia      int            `json:"dia"`
+	Nome     string         `json:"nome"`
+	CPF      string         `json:"cpf"`
+	Email    string         `json:"email"`
+	Mensagem string         `json:"mensagem"`
+	Anonimo  bool           `json:"anonimo"`
+}
+
+// DoacaoRecorrenteCreateHandler cadastra a doação mensal do doador numa campanha como pendente e envia ao e-mail
+// informado o link de confirmação; só depois da confirmação (DoacaoRecorrenteConfirmarHandler) as cobranças
+// começam, para que ninguém inscreva o e-mail de outra pessoa. Cada ciclo é uma cobrança com vencimento no dia
+// escolhido, enviada por e-mail com o link para pausar ou cancelar.
+func DoacaoRecorrenteCreateHandler(db *sql.DB, mailer mail.Sender) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req doacaoRecorrenteRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Erro ao decodificar JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		req.Nome = strings.TrimSpace(req.Nome)
+		req.Email = strings.TrimSpace(req.Email)
+		if req.IDDoacao == "" || req.Nome == "" || req.Email == "" || req.Valor <= 0 {
+			http.Error(w, "id, valor, nome e email são obrigatórios", http.StatusBadRequest)
+			return
+		}
+		if !emailValido(req.Email) {
+			http.Error(w, "E-mail inválido", http.StatusBadRequest)
+			return
+		}
+		if req.Dia < cicloDiaMinimo || req.Dia > cicloDiaMaximo {
+			http.Error(w, fmt.Sprintf("dia deve ser de %d a %d", cicloDiaMinimo, cicloDiaMaximo), http.StatusBadRequest)
+			return
+		}
+		documento, _, err := cpf.ParseDocumento(req.CPF)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		chave := config.GetPixChaveRecebedor()
+		if chave == "" {
+			http.Error(w, "Chave PIX do recebedor não configurada", http.StatusServiceUnavailable)
+			return
+		}
+
+		var campanha string
+		var ativa bool
+		err = db.QueryRow(`
+			SELECT name, active AND NOT closed FROM core.doacao WHERE id = $1 AND dell = false
+		`, req.IDDoacao).Scan(&campanha, &ativa)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Doação não encontrada", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Erro ao buscar doação: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !ativa {
+			http.Error(w, "Doação encerrada ou inativa", http.StatusConflict)
+			return
+		}
+
+		rec := recorrencia{
+			ID:           uuid.NewString(),
+			IDDoacao:     req.IDDoacao,
+			Campanha:     campanha,
+			Valor:        req.Valor,
+			Dia:          req.Dia,
+			Nome:         req.Nome,
+			CPF:          documento,
+			Email:        req.Email,
+			Mensagem:     req.Mensagem,
+			Anonimo:      req.Anonimo,
+			Chave:        chave,
+			ProximoCiclo: proximaOcorrencia(req.Dia, hojeBrasilia()),
+		}
+		_, err = db.Exec(`
+			INSERT INTO core.doacao_recorrente (
+				id, id_doacao, valor, dia, nome, cpf, email, mensagem, anonimo, chave,
+				status, proximo_ciclo, falhas_consecutivas, date_create, date_update
+			) VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, ''), $9, $10, $11, $12, 0, now(), now())
+		`, rec.ID, rec.IDDoacao, rec.Valor, rec.Dia, rec.Nome, rec.CPF, rec.Email, rec.Mensagem, rec.Anonimo, rec.Chave,
+			RecorrenciaPendente, rec.ProximoCiclo)
+		if err != nil {
+			http.Error(w, "Erro ao salvar doação mensal: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if err := notificarRecorrenciaConfirmacao(mailer, rec); err != nil {
+			http.Error(w, "Erro ao enviar e-mail de confirmação: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		jsonResponse(w, http.StatusCreated, map[string]interface{}{
+			"id":      rec.ID,
+			"status":  RecorrenciaPendente,
+			"valor":   rec.Valor,
+			"dia":     rec.Dia,
+			"message": "Enviamos um link de confirmação para o e-mail informado; a doação mensal começa após a confirmação",
+		})
+	}
+}
+
+// recorrenciaDoLink lê o id da rota e confere o token do link de gerenciamento
+func recorrenciaDoLink(r *http.Request) (string, error) {
+	id := mux.Vars(r)["id"]
+	if !verificarRecorrenciaToken(id, r.URL.Query().Get("token")) {
+		return "", errLinkRecorrenciaInvalido
+	}
+	return id, nil
+}
+
+// DoacaoRecorrenteHandler mostra a doação mensal e os ciclos ao doador, pelo link assinado
+func DoacaoRecorrenteHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := recorrenciaDoLink(r)
+		if err != nil {
+			http.Error(w, "Link de gerenciamento inválido", http.StatusForbidden)
+			return
+		}
+
+		d, err := buscarRecorrencia(db, id)
+		if err != nil {
+			http.Error(w, "Erro ao buscar doação mensal: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if d == nil {
+			http.Error(w, "Doação mensal não encontrada", http.StatusNotFound)
+			return
+		}
+		jsonResponse(w, http.StatusOK, d)
+	}
+}
+
+// DoacaoRecorrenteConfirmarHandler ativa a doação mensal pelo link enviado ao doador. O primeiro ciclo é
+// calculado a partir da confirmação e, se já estiver na janela de geração, a cobrança é criada na hora.
+func DoacaoRecorrenteConfirmarHandler(db *sql.DB, provider payment.PixProvider, mailer mail.Sender) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		alterarRecorrencia(db, w, r, []string{RecorrenciaPendente}, func(tx *sql.Tx, id string) error {
+			var dia int
+			if err := tx.QueryRow(`SELECT dia FROM core.doacao_recorrente WHERE id = $1`, id).Scan(&dia); err != nil {
+				return err
+			}
+			_, err := tx.Exec(`
+				UPDATE core.doacao_recorrente
+				SET status = $2, proximo_ciclo = $3, date_update = now()
+				WHERE id = $1
+			`, id, RecorrenciaAtiva, proximaOcorrencia(dia, hojeBrasilia()))
+			return err
+		}, func(id string) {
+			rec, err := carregarRecorrencia(db, id)
+			if err != nil {
+				log.Printf("Erro ao buscar a doação mensal %s confirmada: %v\n", id, err)
+				return
+			}
+			if cicloNaJanela(rec.ProximoCiclo, hojeBrasilia()) {
+				// Falha na geração fica registrada no ciclo e o job tenta de novo; a confirmação continua valendo
+				if _, err := gerarCicloRecorrente(db, provider, mailer, rec, hojeBrasilia()); err != nil {
+					log.Printf("Erro ao gerar o primeiro ciclo da doação mensal %s: %v\n", rec.ID, err)
+				}
+			} else if err := notificarRecorrenciaCriada(mailer, rec); err != nil {
+				log.Println("Erro ao enviar e-mail da doação mensal:", err)
+			}
+		})
+	}
+}
+
+// DoacaoRecorrentePausarHandler pausa a doação mensal: nenhum ciclo é gerado até o doador retomar
+func DoacaoRecorrentePausarHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		alterarRecorrencia(db, w, r, []string{RecorrenciaAtiva}, func(tx *sql.Tx, id string) error {
+			_, err := tx.Exec(`
+				UPDATE core.doacao_recorrente SET status = $2, date_update = now() WHERE id = $1
+			`, id, RecorrenciaPausada)
+			return err
+		}, nil)
+	}
+}
+
+// DoacaoRecorrenteRetomarHandler reativa a doação pausada ou suspensa. Os meses em pausa não são cobrados:
+// o próximo ciclo é a próxima data do dia escolhido, depois do último ciclo já gerado.
+func DoacaoRecorrenteRetomarHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		alterarRecorrencia(db, w, r, []string{RecorrenciaPausada, RecorrenciaSuspensa}, func(tx *sql.Tx, id string) error {
+			var dia int
+			var ultimo sql.NullTime
+			err := tx.QueryRow(`
+				SELECT r.dia, (SELECT MAX(c.competencia) FROM core.doacao_recorrente_ciclo c
+					WHERE c.id_recorrente = r.id AND c.status <> $2)
+				FROM core.doacao_recorrente r
+				WHERE r.id = $1
+			`, id, CicloFalhou).Scan(&dia, &ultimo)
+			if err != nil {
+				return err
+			}
+			proximo := proximaOcorrencia(dia, hojeBrasilia())
+			if ultimo.Valid && !proximo.After(ultimo.Time) {
+				proximo = proximaCompetencia(ultimo.Time, dia)
+			}
+			_, err = tx.Exec(`
+				UPDATE core.doacao_recorrente
+				SET status = $2, proximo_ciclo = $3, falhas_consecutivas = 0, date_update = now()
+				WHERE id = $1
+			`, id, RecorrenciaAtiva, proximo)
+			return err
+		}, nil)
+	}
+}
+
+// DoacaoRecorrenteCancelarHandler cancela a doação mensal. O ciclo já enviado continua podendo ser pago.
+func DoacaoRecorrenteCancelarHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		permitidos := []string{RecorrenciaPendente, RecorrenciaAtiva, RecorrenciaPausada, RecorrenciaSuspensa}
+		alterarRecorrencia(db, w, r, permitidos, func(tx *sql.Tx, id string) error {
+			_, err := tx.Exec(`
+				UPDATE core.doacao_recorrente
+				SET status = $2, date_cancelamento = now(), date_update = now()
+				WHERE id = $1
+			`, id, RecorrenciaCancelada)
+			return err
+		}, nil)
+	}
+}
+
+// alterarRecorrencia valida o link, trava a doação mensal, confere se o status atual permite a ação e
+// responde com a doação atualizada. depois, se informado, roda após o commit e antes da resposta.
+func alterarRecorrencia(db *sql.DB, w http.ResponseWriter, r *http.Request, permitidos []string, alterar func(tx *sql.Tx, id string) error, depois func(id string)) {
+	id, err := recorrenciaDoLink(r)
+	if err != nil {
+		http.Error(w, "Link de gerenciamento inválido", http.StatusForbidden)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Erro ao iniciar transação: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var status string
+	err = tx.QueryRow(`SELECT status FROM core.doacao_recorrente WHERE id = $1 FOR UPDATE`, id).Scan(&status)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Doação mensal não encontrada", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Erro ao buscar doação mensal: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	permitido := false
+	for _, s := range permitidos {
+		if s == status {
+			permitido = true
+			break
+		}
+	}
+	if !permitido {
+		http.Error(w, "Ação não permitida para doação mensal com status "+status, http.StatusConflict)
+		return
+	}
+
+	if err := alterar(tx, id); err != nil {
+		http.Error(w, "Erro ao atualizar doação mensal: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Erro ao confirmar transação: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if depois != nil {
+		depois(id)
+	}
+
+	d, err := buscarRecorrencia(db, id)
+	if err != nil {
+		http.Error(w, "Erro ao buscar doação mensal: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	jsonResponse(w, http.StatusOK, d)
+}
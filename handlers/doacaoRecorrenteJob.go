@@ -0,0 +1,424 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"BACK_SORTE_GO/cpf"
+	"BACK_SORTE_GO/jobs"
+	"BACK_SORTE_GO/mail"
+	"BACK_SORTE_GO/money"
+	"BACK_SORTE_GO/payment"
+
+	"github.com/google/uuid"
+)
+
+// JobDoacaoRecorrente atualiza os ciclos pagos ou vencidos e gera as cobranças das doações mensais
+const JobDoacaoRecorrente = "DOACAO_RECORRENTE"
+
+const (
+	recorrenciaIntervalo = 6 * time.Hour
+	// A cobrança do ciclo é criada e enviada alguns dias antes do vencimento
+	recorrenciaAntecedencia = 3
+	// Dias em que o ciclo ainda pode ser pago depois do vencimento
+	recorrenciaValidade = 5
+	// Ciclos seguidos sem pagamento que suspendem a doação mensal
+	recorrenciaFalhasMaximas = 3
+	// Cadastro não confirmado pelo doador nesse prazo é cancelado
+	recorrenciaPrazoConfirmacao = 7 * 24 * time.Hour
+)
+
+// recorrencia são os dados da doação mensal usados na geração do ciclo
+type recorrencia struct {
+	ID           string
+	IDDoacao     string
+	Campanha     string
+	Valor        money.Centavos
+	Dia          int
+	Nome         string
+	CPF          string
+	Email        string
+	Mensagem     string
+	Anonimo      bool
+	Chave        string
+	ProximoCiclo time.Time
+}
+
+// recorrenciaColunas e scanRecorrencia leem a doação mensal com o nome da campanha (FROM core.doacao_recorrente r
+// JOIN core.doacao d)
+const recorrenciaColunas = `r.id, r.id_doacao, d.name, r.valor, r.dia, r.nome, r.cpf, r.email, COALESCE(r.mensagem, ''),
+	r.anonimo, r.chave, r.proximo_ciclo`
+
+func scanRecorrencia(row interface{ Scan(...interface{}) error }) (recorrencia, error) {
+	var rec recorrencia
+	err := row.Scan(&rec.ID, &rec.IDDoacao, &rec.Campanha, &rec.Valor, &rec.Dia, &rec.Nome, &rec.CPF, &rec.Email,
+		&rec.Mensagem, &rec.Anonimo, &rec.Chave, &rec.ProximoCiclo)
+	return rec, err
+}
+
+// carregarRecorrencia busca a doação mensal para a geração de ciclo fora do job
+func carregarRecorrencia(db *sql.DB, id string) (recorrencia, error) {
+	return scanRecorrencia(db.QueryRow(`
+		SELECT `+recorrenciaColunas+`
+		FROM core.doacao_recorrente r
+		JOIN core.doacao d ON d.id = r.id_doacao
+		WHERE r.id = $1
+	`, id))
+}
+
+// cicloNaJanela indica se a cobrança do ciclo já deve ser gerada
+func cicloNaJanela(competencia, hoje time.Time) bool {
+	return !competencia.After(hoje.AddDate(0, 0, recorrenciaAntecedencia))
+}
+
+// EnqueueDoacaoRecorrenteJob agenda a geração dos ciclos, se ainda não houver uma pendente
+func EnqueueDoacaoRecorrenteJob(db *sql.DB) error {
+	_, err := jobs.Enqueue(db, JobDoacaoRecorrente, "ciclos", nil, time.Now().Add(time.Minute))
+	return err
+}
+
+// DoacaoRecorrenteJob fecha os ciclos cuja cobrança foi paga ou venceu, encerra as doações de campanhas
+// fechadas e gera as cobranças dos ciclos que entraram na janela. Erros de um ciclo não impedem os demais.
+func DoacaoRecorrenteJob(db *sql.DB, provider payment.PixProvider, mailer mail.Sender) jobs.Handler {
+	return func(ctx context.Context, job jobs.Job) (time.Duration, error) {
+		if err := atualizarCiclosRecorrentes(db, mailer); err != nil {
+			return 0, fmt.Errorf("erro ao atualizar ciclos das doações mensais: %w", err)
+		}
+
+		_, err := db.ExecContext(ctx, `
+			UPDATE core.doacao_recorrente r
+			SET status = $1, date_update = now()
+			FROM core.doacao d
+			WHERE d.id = r.id_doacao AND r.status IN ($2, $3, $4, $5)
+			  AND (d.dell OR d.closed OR NOT d.active)
+		`, RecorrenciaEncerrada, RecorrenciaAtiva, RecorrenciaPausada, RecorrenciaSuspensa, RecorrenciaPendente)
+		if err != nil {
+			return 0, fmt.Errorf("erro ao encerrar doações mensais: %w", err)
+		}
+
+		_, err = db.ExecContext(ctx, `
+			UPDATE core.doacao_recorrente
+			SET status = $1, date_cancelamento = now(), date_update = now()
+			WHERE status = $2 AND date_create < $3
+		`, RecorrenciaCancelada, RecorrenciaPendente, time.Now().Add(-recorrenciaPrazoConfirmacao))
+		if err != nil {
+			return 0, fmt.Errorf("erro ao cancelar doações mensais não confirmadas: %w", err)
+		}
+
+		hoje := hojeBrasilia()
+		rows, err := db.QueryContext(ctx, `
+			SELECT `+recorrenciaColunas+`
+			FROM core.doacao_recorrente r
+			JOIN core.doacao d ON d.id = r.id_doacao
+			WHERE r.status = $1 AND r.proximo_ciclo <= $2
+			ORDER BY r.proximo_ciclo
+		`, RecorrenciaAtiva, hoje.AddDate(0, 0, recorrenciaAntecedencia))
+		if err != nil {
+			return 0, err
+		}
+		var pendentes []recorrencia
+		for rows.Next() {
+			rec, err := scanRecorrencia(rows)
+			if err != nil {
+				rows.Close()
+				return 0, err
+			}
+			pendentes = append(pendentes, rec)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return 0, err
+		}
+
+		for _, rec := range pendentes {
+			if _, err := gerarCicloRecorrente(db, provider, mailer, rec, hoje); err != nil {
+				log.Printf("Erro ao gerar ciclo da doação mensal %s: %v\n", rec.ID, err)
+			}
+		}
+		return recorrenciaIntervalo, nil
+	}
+}
+
+// registrarCiclo grava o ciclo da competência. Um ciclo que falhou é substituído pela nova tentativa;
+// ciclos gerados, pagos ou não pagos não mudam.
+func registrarCiclo(q jobs.Execer, idRecorrente string, competencia time.Time, status, txid, idPixQRCode, erro string) error {
+	_, err := q.Exec(`
+		INSERT INTO core.doacao_recorrente_ciclo (id, id_recorrente, competencia, status, txid, id_pix_qrcode, erro, date_create, date_update)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, '')::UUID, NULLIF($7, ''), now(), now())
+		ON CONFLICT (id_recorrente, competencia) DO UPDATE
+		SET status = EXCLUDED.status, txid = EXCLUDED.txid, id_pix_qrcode = EXCLUDED.id_pix_qrcode,
+			erro = EXCLUDED.erro, date_update = now()
+		WHERE core.doacao_recorrente_ciclo.status = $8
+	`, uuid.NewString(), idRecorrente, competencia, status, txid, idPixQRCode, erro, CicloFalhou)
+	return err
+}
+
+// gerarCicloRecorrente cria a cobv do próximo ciclo, grava a doação em pix_qrcode como em /pix/create e envia
+// o copia e cola ao doador. Meses inteiros que passaram sem geração (serviço parado) ficam como pulados e não
+// são cobrados em atraso; um ciclo do mês corrente já atrasado vence hoje. Se a cobrança não puder ser criada,
+// o ciclo fica como falhou e a próxima execução tenta de novo. A doação mensal fica travada durante a geração,
+// então o cadastro e o job não cobram o mesmo ciclo duas vezes; retorna nil sem erro se não houver o que gerar.
+func gerarCicloRecorrente(db *sql.DB, provider payment.PixProvider, mailer mail.Sender, rec recorrencia, hoje time.Time) (*CicloRecorrente, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// NO KEY UPDATE deixa a chave estrangeira dos ciclos inseridos nesta transação verificar a linha travada
+	err = tx.QueryRow(`
+		SELECT proximo_ciclo FROM core.doacao_recorrente
+		WHERE id = $1 AND status = $2
+		FOR NO KEY UPDATE SKIP LOCKED
+	`, rec.ID, RecorrenciaAtiva).Scan(&rec.ProximoCiclo)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !cicloNaJanela(rec.ProximoCiclo, hoje) {
+		return nil, nil
+	}
+
+	competencia := rec.ProximoCiclo
+	for !proximaCompetencia(competencia, rec.Dia).After(hoje) {
+		if err := registrarCiclo(tx, rec.ID, competencia, CicloPulado, "", "", ""); err != nil {
+			return nil, err
+		}
+		competencia = proximaCompetencia(competencia, rec.Dia)
+	}
+	if !competencia.Equal(rec.ProximoCiclo) {
+		_, err := tx.Exec(`
+			UPDATE core.doacao_recorrente SET proximo_ciclo = $2, date_update = now() WHERE id = $1
+		`, rec.ID, competencia)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := tx.Exec(`SAVEPOINT ciclo`); err != nil {
+		return nil, err
+	}
+	// A falha desfaz só o que foi gravado do ciclo; os meses pulados e o registro da falha são confirmados
+	falhou := func(err error) (*CicloRecorrente, error) {
+		if _, errSavepoint := tx.Exec(`ROLLBACK TO SAVEPOINT ciclo`); errSavepoint != nil {
+			log.Println("Erro ao desfazer ciclo:", errSavepoint)
+			return nil, err
+		}
+		if errCiclo := registrarCiclo(tx, rec.ID, competencia, CicloFalhou, "", "", err.Error()); errCiclo != nil {
+			log.Println("Erro ao registrar falha do ciclo:", errCiclo)
+			return nil, err
+		}
+		if errCommit := tx.Commit(); errCommit != nil {
+			log.Println("Erro ao registrar falha do ciclo:", errCommit)
+		}
+		return nil, err
+	}
+
+	vencimento := competencia
+	if vencimento.Before(hoje) {
+		vencimento = hoje
+	}
+	validade := recorrenciaValidade
+	cobranca := CobrancaVencimentoRequest{
+		Vencimento:             vencimento.Format("2006-01-02"),
+		ValidadeAposVencimento: &validade,
+	}
+	documento, tipoDocumento, err := cpf.ParseDocumento(rec.CPF)
+	if err != nil {
+		return falhou(err)
+	}
+	dueReq, err := cobranca.dueChargeRequest(rec.Valor, rec.Chave, rec.Nome, documento, tipoDocumento, "doação mensal")
+	if err != nil {
+		return falhou(err)
+	}
+	charge, err := provider.CreateDueCharge(dueReq)
+	if err != nil {
+		return falhou(fmt.Errorf("erro ao criar cobrança PIX com vencimento: %w", err))
+	}
+	pixCopiaECola := cobrancaPixCopiaECola(charge)
+
+	idPixQRCode := uuid.NewString()
+	_, err = tx.Exec(`
+		INSERT INTO core.pix_qrcode
+		(id, id_doacao, valor, cpf, nome, mensagem, anonimo, visivel, email, data_criacao)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, false, $8, now())
+	`, idPixQRCode, rec.IDDoacao, rec.Valor, documento, rec.Nome, rec.Mensagem, rec.Anonimo, rec.Email)
+	if err != nil {
+		return falhou(fmt.Errorf("erro ao salvar pix_qrcode: %w", err))
+	}
+	if err := salvarStatusCobranca(tx, idPixQRCode, "", rec.Chave, charge, pixCopiaECola, cobranca.Encargos); err != nil {
+		return falhou(fmt.Errorf("erro ao salvar pix_qrcode_status: %w", err))
+	}
+	if err := enqueuePixStatusJob(tx, charge.Txid, charge.LimitePagamento()); err != nil {
+		return falhou(fmt.Errorf("erro ao agendar verificação do pagamento: %w", err))
+	}
+	if err := registrarCiclo(tx, rec.ID, competencia, CicloGerado, charge.Txid, idPixQRCode, ""); err != nil {
+		return falhou(err)
+	}
+	_, err = tx.Exec(`
+		UPDATE core.doacao_recorrente SET proximo_ciclo = $2, date_update = now() WHERE id = $1
+	`, rec.ID, proximaCompetencia(competencia, rec.Dia))
+	if err != nil {
+		return falhou(err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	ciclo := &CicloRecorrente{
+		Competencia:   competencia,
+		Status:        CicloGerado,
+		Txid:          &charge.Txid,
+		PixCopiaECola: &pixCopiaECola,
+		Vencimento:    &vencimento,
+		DateCreate:    time.Now(),
+	}
+	if err := notificarCicloRecorrente(mailer, rec, ciclo, charge.LimitePagamento()); err != nil {
+		log.Println("Erro ao enviar e-mail do ciclo da doação mensal:", err)
+	}
+	return ciclo, nil
+}
+
+type cicloEncerrado struct {
+	ID           string
+	IDRecorrente string
+	Pago         bool
+}
+
+// atualizarCiclosRecorrentes fecha os ciclos gerados cuja cobrança foi paga ou venceu. O pagamento zera as
+// falhas seguidas; após recorrenciaFalhasMaximas ciclos sem pagamento a doação mensal é suspensa e o doador
+// recebe o link para retomar.
+func atualizarCiclosRecorrentes(db *sql.DB, mailer mail.Sender) error {
+	rows, err := db.Query(`
+		SELECT c.id, c.id_recorrente, pqs.status = 'CONCLUIDA'
+		FROM core.doacao_recorrente_ciclo c
+		JOIN core.pix_qrcode_status pqs ON pqs.id_pix = c.txid
+		WHERE c.status = $1 AND pqs.status IN ('CONCLUIDA', 'VENCIDO')
+		ORDER BY c.competencia
+	`, CicloGerado)
+	if err != nil {
+		return err
+	}
+	var encerrados []cicloEncerrado
+	for rows.Next() {
+		var c cicloEncerrado
+		if err := rows.Scan(&c.ID, &c.IDRecorrente, &c.Pago); err != nil {
+			rows.Close()
+			return err
+		}
+		encerrados = append(encerrados, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, c := range encerrados {
+		suspensa, err := encerrarCiclo(db, c)
+		if err != nil {
+			return err
+		}
+		if suspensa {
+			if err := notificarRecorrenciaSuspensa(db, mailer, c.IDRecorrente); err != nil {
+				log.Println("Erro ao enviar e-mail de suspensão da doação mensal:", err)
+			}
+		}
+	}
+	return nil
+}
+
+// encerrarCiclo marca o ciclo como pago ou não pago e retorna true quando a doação mensal foi suspensa
+func encerrarCiclo(db *sql.DB, c cicloEncerrado) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	status := CicloNaoPago
+	if c.Pago {
+		status = CicloPago
+	}
+	res, err := tx.Exec(`
+		UPDATE core.doacao_recorrente_ciclo SET status = $2, date_update = now() WHERE id = $1 AND status = $3
+	`, c.ID, status, CicloGerado)
+	if err != nil {
+		return false, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return false, nil
+	}
+
+	suspensa := false
+	if c.Pago {
+		_, err = tx.Exec(`
+			UPDATE core.doacao_recorrente SET falhas_consecutivas = 0, date_update = now() WHERE id = $1
+		`, c.IDRecorrente)
+	} else {
+		err = tx.QueryRow(`
+			UPDATE core.doacao_recorrente
+			SET falhas_consecutivas = falhas_consecutivas + 1,
+				status = CASE WHEN status = $2 AND falhas_consecutivas + 1 >= $3 THEN $4 ELSE status END,
+				date_update = now()
+			WHERE id = $1
+			RETURNING status = $4 AND falhas_consecutivas = $3
+		`, c.IDRecorrente, RecorrenciaAtiva, recorrenciaFalhasMaximas, RecorrenciaSuspensa).Scan(&suspensa)
+	}
+	if err != nil {
+		return false, err
+	}
+	return suspensa, tx.Commit()
+}
+
+// notificarRecorrenciaConfirmacao é o único e-mail enviado antes de o doador confirmar o endereço
+func notificarRecorrenciaConfirmacao(mailer mail.Sender, rec recorrencia) error {
+	return mailer.Send(mail.Message{
+		To:      rec.Email,
+		Subject: "Confirme sua doação mensal",
+		Body: fmt.Sprintf("Olá %s,\n\nRecebemos o cadastro de uma doação mensal de R$ %s para a campanha \"%s\" com este e-mail. Para ativá-la, confirme em até %d dias pelo link:\n\n%s\n\nSe você não fez esse cadastro, ignore este e-mail: nenhuma cobrança será enviada.",
+			rec.Nome, rec.Valor.Format(), rec.Campanha, int(recorrenciaPrazoConfirmacao.Hours()/24), linkConfirmacaoRecorrencia(rec.ID)),
+	})
+}
+
+func notificarRecorrenciaCriada(mailer mail.Sender, rec recorrencia) error {
+	return mailer.Send(mail.Message{
+		To:      rec.Email,
+		Subject: "Sua doação mensal foi cadastrada",
+		Body: fmt.Sprintf("Olá %s,\n\nSua doação mensal de R$ %s para a campanha \"%s\" foi cadastrada. A primeira cobrança PIX vence em %s e será enviada por e-mail alguns dias antes.\n\nPara acompanhar, pausar ou cancelar a doação, acesse:\n\n%s",
+			rec.Nome, rec.Valor.Format(), rec.Campanha, rec.ProximoCiclo.Format("02/01/2006"), linkRecorrencia(rec.ID)),
+	})
+}
+
+func notificarCicloRecorrente(mailer mail.Sender, rec recorrencia, ciclo *CicloRecorrente, limite time.Time) error {
+	return mailer.Send(mail.Message{
+		To:      rec.Email,
+		Subject: "Sua doação mensal para " + rec.Campanha,
+		Body: fmt.Sprintf("Olá %s,\n\nA cobrança PIX da sua doação mensal de R$ %s para a campanha \"%s\" vence em %s e pode ser paga até %s. Use o PIX copia e cola abaixo:\n\n%s\n\nPara acompanhar, pausar ou cancelar a doação, acesse:\n\n%s",
+			rec.Nome, rec.Valor.Format(), rec.Campanha, ciclo.Vencimento.Format("02/01/2006"),
+			limite.Add(-time.Second).In(payment.FusoBrasilia).Format("02/01/2006"), *ciclo.PixCopiaECola, linkRecorrencia(rec.ID)),
+	})
+}
+
+func notificarRecorrenciaSuspensa(db *sql.DB, mailer mail.Sender, id string) error {
+	var nome, email, campanha string
+	err := db.QueryRow(`
+		SELECT r.nome, r.email, d.name
+		FROM core.doacao_recorrente r
+		JOIN core.doacao d ON d.id = r.id_doacao
+		WHERE r.id = $1
+	`, id).Scan(&nome, &email, &campanha)
+	if err != nil {
+		return err
+	}
+	return mailer.Send(mail.Message{
+		To:      email,
+		Subject: "Sua doação mensal foi suspensa",
+		Body: fmt.Sprintf("Olá %s,\n\nSuspendemos sua doação mensal para a campanha \"%s\" porque as últimas %d cobranças não foram pagas. Nenhuma nova cobrança será enviada.\n\nSe quiser voltar a doar, retome a doação pelo link:\n\n%s",
+			nome, campanha, recorrenciaFalhasMaximas, linkRecorrencia(id)),
+	})
+}
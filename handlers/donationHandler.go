@@ -2,10 +2,16 @@ package handlers
 
 import (
 	"BACK_SORTE_GO/config"
+	"BACK_SORTE_GO/cpf"
+	"BACK_SORTE_GO/ledger"
+	"BACK_SORTE_GO/mail"
+	"BACK_SORTE_GO/middleware"
+	"BACK_SORTE_GO/money"
 	"BACK_SORTE_GO/utils"
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"log"
 	"math/rand"
 	"net/http"
 	"regexp"
@@ -14,7 +20,6 @@ import (
 	"time"
 	"unicode"
 
-	"github.com/golang-jwt/jwt/v4"
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	"golang.org/x/crypto/bcrypt"
@@ -22,28 +27,25 @@ import (
 	"golang.org/x/text/unicode/norm"
 )
 
-// Chave secreta para validação do token JWT (mesma usada no login)
-var jwtSecretKey1 = []byte("SUA_CHAVE_SECRETA")
-
 // Estrutura para a requisição de doação
 type DonationRequest struct {
-	IDUser string  `json:"id_user"`
-	Name   string  `json:"name"`
-	Valor  float64 `json:"valor"`
-	Texto  string  `json:"texto"`
-	Area   string  `json:"area"`
-	Img    string  `json:"img"`
+	IDUser string         `json:"id_user"`
+	Name   string         `json:"name"`
+	Valor  money.Centavos `json:"valor"`
+	Texto  string         `json:"texto"`
+	Area   string         `json:"area"`
+	Img    string         `json:"img"`
 }
 
 // Estrutura de resposta de listagem de doações
 type DonationResponse struct {
-	ID        string  `json:"id"`
-	Name      string  `json:"name"`
-	Valor     float64 `json:"valor"`
-	Texto     string  `json:"texto"`
-	Area      string  `json:"area"`
-	Img       string  `json:"img"`
-	DateStart string  `json:"date_start"`
+	ID        string         `json:"id"`
+	Name      string         `json:"name"`
+	Valor     money.Centavos `json:"valor"`
+	Texto     string         `json:"texto"`
+	Area      string         `json:"area"`
+	Img       string         `json:"img"`
+	DateStart string         `json:"date_start"`
 }
 
 // DonationListByIDUserHandler retorna doações de um usuário com paginação
@@ -79,7 +81,12 @@ func DonationListByIDUserHandler(db *sql.DB) http.HandlerFunc {
 				dd.texto, dd.img_caminho, dd.area,
 				dl.nome_link,
 
-				dp.valor_disponivel, dp.valor_tranferido, dp.data_tranferido,
+				(COALESCE(ls.saldo, 0) / 100.0)::NUMERIC(14,2),
+				(SELECT (COALESCE(SUM(le.valor), 0) / 100.0)::NUMERIC(14,2)
+					FROM core.ledger_transaction lt
+					JOIN core.ledger_entry le ON le.id_transaction = lt.id AND le.valor > 0
+					WHERE lt.id_doacao = d.id AND lt.tipo = 'SAQUE_CONCLUIDO'),
+				dp.data_tranferido,
 				dp.solicitado, dp.data_solicitado, dp.status,
 				dp.img, dp.pdf, dp.banco, dp.conta, dp.agencia, dp.digito, dp.pix, dp.data_update
 
@@ -87,6 +94,7 @@ func DonationListByIDUserHandler(db *sql.DB) http.HandlerFunc {
 			JOIN core.doacao_details dd ON d.id = dd.id_doacao
 			LEFT JOIN core.doacao_link dl ON d.id = dl.id_doacao
 			LEFT JOIN core.doacao_pagamentos dp ON d.id = dp.id_doacao
+			LEFT JOIN core.ledger_saldo ls ON ls.id_doacao = d.id AND ls.tipo = 'CAMPANHA'
 			WHERE d.id_user = $1 AND d.dell = false
 			ORDER BY d.date_create DESC
 			LIMIT $2 OFFSET $3
@@ -103,12 +111,12 @@ func DonationListByIDUserHandler(db *sql.DB) http.HandlerFunc {
 		for rows.Next() {
 			var (
 				id, name, texto, img, area string
-				valor                      float64
+				valor                      money.Centavos
 				dateCreate, dateStart      time.Time
 				active, dell, closed       bool
 				nomeLink                   sql.NullString
 
-				valorDisponivel, valorTransferido sql.NullFloat64
+				valorDisponivel, valorTransferido money.Centavos
 				dataTransferido, dataSolicitado   sql.NullTime
 				solicitado                        sql.NullBool
 				status, imgComp, pdfComp          sql.NullString
@@ -145,11 +153,11 @@ func DonationListByIDUserHandler(db *sql.DB) http.HandlerFunc {
 				"nome_link":   nomeLink.String,
 			}
 
-			// Adicionar pagamentos se existirem
-			if valorDisponivel.Valid || valorTransferido.Valid {
+			// Adicionar pagamentos se existirem (os valores vêm do razão, o restante de doacao_pagamentos)
+			if dataUpdate.Valid {
 				donation["pagamento"] = map[string]interface{}{
-					"valor_disponivel": valorDisponivel.Float64,
-					"valor_tranferido": valorTransferido.Float64,
+					"valor_disponivel": valorDisponivel,
+					"valor_tranferido": valorTransferido,
 					"data_tranferido":  dataTransferido.Time,
 					"solicitado":       solicitado.Bool,
 					"data_solicitado":  dataSolicitado.Time,
@@ -201,33 +209,17 @@ func DonationDellHandler(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		// Extrair token JWT do cabeçalho
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			http.Error(w, "Token não fornecido", http.StatusUnauthorized)
-			return
-		}
-
-		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-		claims := jwt.MapClaims{}
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-			return jwtSecretKey1, nil
-		})
-		if err != nil || !token.Valid {
-			http.Error(w, "Token inválido", http.StatusUnauthorized)
-			return
-		}
-
-		// Obter o ID do usuário do token
-		userID := claims["sub"]
-		if userID == nil {
-			http.Error(w, "Token sem id_user", http.StatusUnauthorized)
+		// Obter o ID do usuário autenticado
+		principal, ok := middleware.GetPrincipal(r)
+		if !ok {
+			http.Error(w, "Usuário não autenticado", http.StatusUnauthorized)
 			return
 		}
+		userID := principal.UserID
 
 		// Verificar se a doação pertence ao usuário
 		var dbUserID string
-		err = db.QueryRow(`SELECT id_user FROM core.doacao WHERE id = $1`, donationID).Scan(&dbUserID)
+		err := db.QueryRow(`SELECT id_user FROM core.doacao WHERE id = $1`, donationID).Scan(&dbUserID)
 		if err != nil {
 			http.Error(w, "Doação não encontrada", http.StatusNotFound)
 			return
@@ -321,15 +313,15 @@ func DonationByLinkHandler(db *sql.DB) http.HandlerFunc {
 
 		// Buscar dados da doação
 		var doacao struct {
-			ID      string  `json:"id"`
-			IDUser  string  `json:"id_user"`
-			Name    string  `json:"name"`
-			Valor   float64 `json:"valor"`
-			Active  bool    `json:"active"`
-			Dell    bool    `json:"dell"`
-			Closed  bool    `json:"closed"`
-			Start   string  `json:"date_start"`
-			Created string  `json:"date_create"`
+			ID      string         `json:"id"`
+			IDUser  string         `json:"id_user"`
+			Name    string         `json:"name"`
+			Valor   money.Centavos `json:"valor"`
+			Active  bool           `json:"active"`
+			Dell    bool           `json:"dell"`
+			Closed  bool           `json:"closed"`
+			Start   string         `json:"date_start"`
+			Created string         `json:"date_create"`
 		}
 		err = db.QueryRow(`
 			SELECT id, id_user, name, valor, active, dell, closed, date_start, date_create
@@ -346,25 +338,14 @@ func DonationByLinkHandler(db *sql.DB) http.HandlerFunc {
 
 		// Validação se doação está fechada
 		if doacao.Closed {
-			authHeader := r.Header.Get("Authorization")
-			if authHeader == "" {
+			principal, ok := middleware.GetPrincipal(r)
+			if !ok {
 				http.Error(w, "Doação fechada. Acesso não autorizado", http.StatusUnauthorized)
 				return
 			}
 
-			tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-			claims := jwt.MapClaims{}
-			token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-				return jwtSecretKey1, nil
-			})
-			if err != nil || !token.Valid {
-				http.Error(w, "Token inválido", http.StatusUnauthorized)
-				return
-			}
-
 			// Comparar ID do token com ID do dono da doação
-			idFromToken, ok := claims["sub"].(string)
-			if !ok || idFromToken == "" || idFromToken != doacao.IDUser {
+			if principal.UserID != doacao.IDUser {
 				http.Error(w, "Você não tem permissão para acessar esta doação fechada", http.StatusForbidden)
 				return
 			}
@@ -410,13 +391,15 @@ func DonationByLinkHandler(db *sql.DB) http.HandlerFunc {
 
 // Estrutura do retorno completo
 type DonationMessageFull struct {
-	ID          string    `json:"id"`
-	Valor       string    `json:"valor"`
-	CPF         string    `json:"cpf"`
-	Nome        string    `json:"nome"`
-	Mensagem    string    `json:"mensagem"`
-	Anonimo     bool      `json:"anonimo"`
-	DataCriacao time.Time `json:"data_criacao"`
+	ID       string         `json:"id"`
+	Valor    money.Centavos `json:"valor"`
+	CPF      string         `json:"cpf"`
+	Nome     string         `json:"nome"`
+	Mensagem string         `json:"mensagem"`
+	Anonimo  bool           `json:"anonimo"`
+	// PIX, CARTAO, PAYPAL ou GOOGLE_PAY
+	MetodoPagamento string    `json:"metodo_pagamento"`
+	DataCriacao     time.Time `json:"data_criacao"`
 }
 
 // DonationMensagesHandler retorna mensagens com paginação
@@ -447,7 +430,7 @@ func DonationMensagesHandler(db *sql.DB) http.HandlerFunc {
 
 		// Consulta com paginação
 		rows, err := db.Query(`
-			SELECT id, valor, cpf, nome, mensagem, anonimo, data_criacao
+			SELECT id, valor, cpf, nome, mensagem, anonimo, metodo_pagamento, data_criacao
 			FROM core.pix_qrcode
 			WHERE id_doacao = $1 AND visivel = TRUE
 			ORDER BY data_criacao DESC
@@ -469,11 +452,14 @@ func DonationMensagesHandler(db *sql.DB) http.HandlerFunc {
 				&msg.Nome,
 				&msg.Mensagem,
 				&msg.Anonimo,
+				&msg.MetodoPagamento,
 				&msg.DataCriacao,
 			); err != nil {
 				http.Error(w, "Erro ao ler resultado: "+err.Error(), http.StatusInternalServerError)
 				return
 			}
+			// Mensagens são públicas: o documento do doador nunca é exibido completo
+			msg.CPF = cpf.Mask(msg.CPF)
 			mensagens = append(mensagens, msg)
 		}
 
@@ -484,8 +470,12 @@ func DonationMensagesHandler(db *sql.DB) http.HandlerFunc {
 }
 
 type DonationSummary struct {
-	ValorTotal    string `json:"valor_total"`
-	TotalDoadores int    `json:"total_doadores"`
+	ValorTotal    money.Centavos `json:"valor_total"`
+	TotalDoadores int            `json:"total_doadores"`
+	// Doadores mensais: assinaturas ativas, quanto somam por mês e quanto já foi pago nos ciclos
+	DoadoresRecorrentes   int            `json:"doadores_recorrentes"`
+	ValorMensalRecorrente money.Centavos `json:"valor_mensal_recorrente"`
+	ValorRecorrente       money.Centavos `json:"valor_recorrente"`
 }
 
 func DonationSummaryByIDHandler(db *sql.DB) http.HandlerFunc {
@@ -501,7 +491,7 @@ func DonationSummaryByIDHandler(db *sql.DB) http.HandlerFunc {
 
 		query := `
 			SELECT 
-				COALESCE(SUM(valor), 0)::TEXT AS valor_total,
+				COALESCE(SUM(valor), 0) AS valor_total,
 				COUNT(DISTINCT cpf) AS total_doadores
 			FROM core.pix_qrcode
 			WHERE id_doacao = $1 AND visivel = true
@@ -513,36 +503,39 @@ func DonationSummaryByIDHandler(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
+		// Os ciclos pagos também entram em valor_total, como as demais doações visíveis
+		err = db.QueryRow(`
+			SELECT
+				(SELECT COUNT(*) FROM core.doacao_recorrente WHERE id_doacao = $1 AND status = $2),
+				(SELECT COALESCE(SUM(valor), 0) FROM core.doacao_recorrente WHERE id_doacao = $1 AND status = $2),
+				(SELECT COALESCE(SUM(pq.valor), 0)
+					FROM core.doacao_recorrente_ciclo c
+					JOIN core.pix_qrcode pq ON pq.id = c.id_pix_qrcode
+					WHERE pq.id_doacao = $1 AND pq.visivel = true)
+		`, idDoacao, RecorrenciaAtiva).Scan(&resumo.DoadoresRecorrentes, &resumo.ValorMensalRecorrente, &resumo.ValorRecorrente)
+		if err != nil {
+			http.Error(w, "Erro ao buscar doações mensais: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(resumo)
 	}
 }
 
+// DonationFormMaxBytes é o limite do formulário de criação de campanha (campos e imagem)
+const DonationFormMaxBytes = 10 << 20
+
 func DonationHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			http.Error(w, "Token não fornecido", http.StatusUnauthorized)
-			return
-		}
-
-		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-		claims := jwt.MapClaims{}
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-			return jwtSecretKey1, nil
-		})
-		if err != nil || !token.Valid {
-			http.Error(w, "Token inválido", http.StatusUnauthorized)
+		principal, ok := middleware.GetPrincipal(r)
+		if !ok {
+			http.Error(w, "Usuário não autenticado", http.StatusUnauthorized)
 			return
 		}
+		idUser := principal.UserID
 
-		idUser, ok := claims["sub"].(string)
-		if !ok || idUser == "" {
-			http.Error(w, "ID do usuário inválido", http.StatusUnauthorized)
-			return
-		}
-
-		if err := r.ParseMultipartForm(10 << 20); err != nil {
+		if err := r.ParseMultipartForm(DonationFormMaxBytes); err != nil {
 			http.Error(w, "Erro ao ler formulário", http.StatusBadRequest)
 			return
 		}
@@ -557,7 +550,7 @@ func DonationHandler(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		valor, err := strconv.ParseFloat(valorStr, 64)
+		valor, err := money.Parse(valorStr)
 		if err != nil || valor <= 0 {
 			http.Error(w, "Valor inválido", http.StatusBadRequest)
 			return
@@ -650,30 +643,13 @@ func DonationHandler(db *sql.DB) http.HandlerFunc {
 
 func DonationClosedHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Verifica se foi enviado token
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			http.Error(w, "Token não fornecido", http.StatusUnauthorized)
-			return
-		}
-
-		// Extrai o token
-		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-		claims := jwt.MapClaims{}
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-			return jwtSecretKey1, nil
-		})
-		if err != nil || !token.Valid {
-			http.Error(w, "Token inválido", http.StatusUnauthorized)
-			return
-		}
-
-		// Pega o ID do usuário do token
-		idUserToken, ok := claims["sub"].(string)
-		if !ok || idUserToken == "" {
-			http.Error(w, "ID do usuário inválido no token", http.StatusUnauthorized)
+		// Pega o ID do usuário autenticado
+		principal, ok := middleware.GetPrincipal(r)
+		if !ok {
+			http.Error(w, "Usuário não autenticado", http.StatusUnauthorized)
 			return
 		}
+		idUserToken := principal.UserID
 
 		// Pega o ID da doação na URL
 		vars := mux.Vars(r)
@@ -685,7 +661,7 @@ func DonationClosedHandler(db *sql.DB) http.HandlerFunc {
 
 		// Verifica se a doação pertence ao usuário do token
 		var idUserFromDB string
-		err = db.QueryRow("SELECT id_user FROM core.doacao WHERE id = $1", donationID).Scan(&idUserFromDB)
+		err := db.QueryRow("SELECT id_user FROM core.doacao WHERE id = $1", donationID).Scan(&idUserFromDB)
 		if err == sql.ErrNoRows {
 			http.Error(w, "Doação não encontrada", http.StatusNotFound)
 			return
@@ -720,28 +696,13 @@ func DonationClosedHandler(db *sql.DB) http.HandlerFunc {
 
 func DonationRescueHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Verifica token JWT
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			http.Error(w, "Token não fornecido", http.StatusUnauthorized)
-			return
-		}
-
-		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-		claims := jwt.MapClaims{}
-		_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-			return jwtSecretKey1, nil
-		})
-		if err != nil {
-			http.Error(w, "Token inválido", http.StatusUnauthorized)
-			return
-		}
-
-		idUser, ok := claims["sub"].(string)
-		if !ok || idUser == "" {
-			http.Error(w, "ID do usuário inválido", http.StatusUnauthorized)
+		// Pega o ID do usuário autenticado
+		principal, ok := middleware.GetPrincipal(r)
+		if !ok {
+			http.Error(w, "Usuário não autenticado", http.StatusUnauthorized)
 			return
 		}
+		idUser := principal.UserID
 
 		// Pega ID da doação
 		vars := mux.Vars(r)
@@ -753,7 +714,7 @@ func DonationRescueHandler(db *sql.DB) http.HandlerFunc {
 
 		// Verifica se a doação pertence ao usuário
 		var donoDoacao string
-		err = db.QueryRow("SELECT id_user FROM core.doacao WHERE id = $1", idDoacao).Scan(&donoDoacao)
+		err := db.QueryRow("SELECT id_user FROM core.doacao WHERE id = $1", idDoacao).Scan(&donoDoacao)
 		if err == sql.ErrNoRows {
 			http.Error(w, "Doação não encontrada", http.StatusNotFound)
 			return
@@ -766,52 +727,85 @@ func DonationRescueHandler(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		// Soma dos valores da doação com status CONCLUIDA, buscar=false, finalizado=true e visivel=true
-		var totalValor float64
-		err = db.QueryRow(`
-			SELECT COALESCE(SUM(pq.valor), 0)
-			FROM core.pix_qrcode pq
-			JOIN core.pix_qrcode_status pqs ON pqs.id_pix_qrcode = pq.id
-			WHERE pq.id_doacao = $1
-			AND pqs.status = 'CONCLUIDA'
-			AND pqs.buscar = false
-			AND pqs.finalizado = true
+		tx, err := db.Begin()
+		if err != nil {
+			http.Error(w, "Erro ao iniciar transação: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		// Trava a doação para que dois resgates (ou um resgate e uma devolução) simultâneos não usem o mesmo saldo.
+		// A trava é na própria doação porque doacao_pagamentos não existe para toda campanha.
+		var idTravado string
+		err = tx.QueryRow(`SELECT id FROM core.doacao WHERE id = $1 FOR UPDATE`, idDoacao).Scan(&idTravado)
+		if err != nil {
+			http.Error(w, "Erro ao travar doação: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// O saldo disponível vem do razão (valor recebido menos taxas, saques e devoluções)
+		saldo, err := ledger.SaldoCampanha(tx, idDoacao)
+		if err != nil {
+			http.Error(w, "Erro ao buscar saldo da doação: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if saldo <= 0 {
+			http.Error(w, "Nenhum valor disponível para resgate", http.StatusBadRequest)
+			return
+		}
+
+		// Total bruto recebido, para exibição
+		var totalValor money.Centavos
+		err = tx.QueryRow(`
+			SELECT COALESCE(SUM(valor_bruto), 0) FROM core.pix_liquidacao WHERE id_doacao = $1
 		`, idDoacao).Scan(&totalValor)
 		if err != nil {
 			http.Error(w, "Erro ao calcular total recebido: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		if totalValor <= 0 {
-			http.Error(w, "Nenhum valor disponível para resgate", http.StatusBadRequest)
+		// O saldo passa para saques em trânsito até o PIX para a conta de saque ser confirmado
+		idSaque, statusSaque, err := solicitarSaque(tx, idUser, idDoacao, saldo)
+		if err == errSaqueSemChavePix {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Erro ao registrar saque: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		// Aplica 10% de taxa
-		valorDisponivel := totalValor * 0.90
+		valorDisponivel := saldo
 		dataSolicitado := time.Now()
 
 		// Atualiza doacao_pagamentos
-		_, err = db.Exec(`
+		_, err = tx.Exec(`
 			UPDATE core.doacao_pagamentos
-			SET valor_disponivel = $1,
-				data_solicitado = $2,
+			SET data_solicitado = $1,
 				status = 'PROCESS',
 				solicitado = true,
 				data_update = NOW()
-			WHERE id_doacao = $3
-		`, valorDisponivel, dataSolicitado, idDoacao)
+			WHERE id_doacao = $2
+		`, dataSolicitado, idDoacao)
 		if err != nil {
 			http.Error(w, "Erro ao atualizar pagamento: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
 
+		if err := tx.Commit(); err != nil {
+			http.Error(w, "Erro ao finalizar transação: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
 		// Retorno
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"message":          "Resgate processado com sucesso",
 			"valor_disponivel": valorDisponivel,
 			"resgate_total":    totalValor,
+			"id_saque":         idSaque,
+			"status_saque":     statusSaque,
 		})
 	}
 }
@@ -972,17 +966,17 @@ func DonationVisualization(db *sql.DB) http.HandlerFunc {
 }
 
 // DonationCreateSimpleHandler cria um usuário e uma campanha de doação
-func DonationCreateSimpleHandler(db *sql.DB) http.HandlerFunc {
+func DonationCreateSimpleHandler(db *sql.DB, mailer mail.Sender) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Parse do formulário multipart
-		if err := r.ParseMultipartForm(10 << 20); err != nil {
+		if err := r.ParseMultipartForm(DonationFormMaxBytes); err != nil {
 			http.Error(w, "Erro ao ler formulário: "+err.Error(), http.StatusBadRequest)
 			return
 		}
 
 		// Recebe campos do formulário
 		fullName := r.FormValue("fullName")
-		cpf := r.FormValue("cpf")
+		documento := r.FormValue("cpf")
 		email := r.FormValue("email")
 		senha := r.FormValue("senha")
 
@@ -992,11 +986,18 @@ func DonationCreateSimpleHandler(db *sql.DB) http.HandlerFunc {
 		texto := r.FormValue("texto")
 
 		// Validação simples
-		if fullName == "" || cpf == "" || email == "" || senha == "" || titulo == "" || metaStr == "" || categoria == "" || texto == "" {
+		if fullName == "" || documento == "" || email == "" || senha == "" || titulo == "" || metaStr == "" || categoria == "" || texto == "" {
 			http.Error(w, "Campos obrigatórios ausentes", http.StatusBadRequest)
 			return
 		}
 
+		// Valida e normaliza o CPF/CNPJ
+		documento, _, err := cpf.ParseDocumento(documento)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
 		// Hash da senha
 		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(senha), bcrypt.DefaultCost)
 		if err != nil {
@@ -1023,12 +1024,22 @@ func DonationCreateSimpleHandler(db *sql.DB) http.HandlerFunc {
 		_, err = db.Exec(`
 			INSERT INTO core.user (id, name, email, password, cpf, active, inicial, dell, date_create, date_update)
 			VALUES ($1, $2, $3, $4, $5, true, false, false, $6, $6)
-		`, userID, fullName, email, string(hashedPassword), cpf, now)
+		`, userID, fullName, email, string(hashedPassword), documento, now)
 		if err != nil {
 			http.Error(w, "Erro ao criar usuário: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
 
+		// Documento já validado pelos dígitos verificadores
+		_, err = db.Exec(`
+			INSERT INTO core.user_details (id, id_user, cpf_valid, email_valid, date_create, date_update)
+			VALUES ($1, $2, true, false, $3, $3)
+		`, uuid.NewString(), userID, now)
+		if err != nil {
+			http.Error(w, "Erro ao criar user_details: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
 		// Cria conta_nivel padrão
 		_, err = db.Exec(`
 			INSERT INTO core.conta_nivel (id, id_user, nivel, ativo, status, tipo_pagamento, data_update)
@@ -1039,9 +1050,9 @@ func DonationCreateSimpleHandler(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		// Processar meta como float
-		meta, err := utils.StringToFloat(metaStr)
-		if err != nil {
+		// Meta aceita "1234.56" ou "1.234,56"
+		meta, err := money.Parse(metaStr)
+		if err != nil || meta <= 0 {
 			http.Error(w, "Meta inválida", http.StatusBadRequest)
 			return
 		}
@@ -1098,6 +1109,11 @@ func DonationCreateSimpleHandler(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
+		// Envia o link de verificação de e-mail (falha no envio não impede o cadastro)
+		if err := sendEmailVerification(db, mailer, userID, email, fullName); err != nil {
+			log.Println("Erro ao enviar e-mail de verificação:", err)
+		}
+
 		// Retorno de sucesso
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]interface{}{
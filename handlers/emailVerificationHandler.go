@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"BACK_SORTE_GO/config"
+	"BACK_SORTE_GO/mail"
+	"BACK_SORTE_GO/middleware"
+	"BACK_SORTE_GO/utils"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// Validade do link de verificação
+	emailVerificationTTL = 48 * time.Hour
+	// Intervalo mínimo entre reenvios
+	emailVerificationResendInterval = "2 minutes"
+	// Máximo de envios por usuário em 24h
+	emailVerificationMaxPerDay = 5
+)
+
+// sendEmailVerification gera um novo token de verificação e envia o link para o e-mail do usuário
+func sendEmailVerification(db *sql.DB, sender mail.Sender, userID, email, name string) error {
+	token, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Somente o último link enviado continua válido
+	_, err = tx.Exec(`
+		UPDATE core.email_verification SET used_at = now()
+		WHERE id_user = $1 AND used_at IS NULL
+	`, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO core.email_verification (id, id_user, email, token_hash, expires_at, date_create)
+		VALUES ($1, $2, $3, $4, $5, now())
+	`, uuid.NewString(), userID, email, utils.HashToken(token), time.Now().Add(emailVerificationTTL))
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/verify-email?token=%s", config.GetAppURL(), token)
+	return sender.Send(mail.Message{
+		To:      email,
+		Subject: "Confirme seu e-mail",
+		Body:    fmt.Sprintf("Olá %s,\n\nConfirme seu e-mail acessando o link abaixo:\n\n%s\n\nO link é válido por %d horas.", name, link, int(emailVerificationTTL.Hours())),
+	})
+}
+
+// setEmailValid marca user_details.email_valid, criando o registro de detalhes se ainda não existir
+func setEmailValid(tx *sql.Tx, userID string) error {
+	res, err := tx.Exec(`
+		UPDATE core.user_details SET email_valid = true, date_update = now()
+		WHERE id_user = $1
+	`, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return nil
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO core.user_details (id, id_user, email_valid, date_create, date_update)
+		VALUES ($1, $2, true, now(), now())
+	`, uuid.NewString(), userID)
+	return err
+}
+
+// VerifyEmailHandler consome o token enviado por e-mail e marca o e-mail como verificado
+func VerifyEmailHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Token string `json:"token"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Erro ao processar o JSON", http.StatusBadRequest)
+			return
+		}
+		if req.Token == "" {
+			http.Error(w, "O campo token é obrigatório", http.StatusBadRequest)
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			http.Error(w, "Erro ao iniciar transação: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		var verificationID, userID, email, currentEmail string
+		err = tx.QueryRow(`
+			SELECT ev.id, ev.id_user, ev.email, u.email
+			FROM core.email_verification ev
+			JOIN core.user u ON u.id = ev.id_user
+			WHERE ev.token_hash = $1 AND ev.used_at IS NULL AND ev.expires_at > now()
+			FOR UPDATE OF ev
+		`, utils.HashToken(req.Token)).Scan(&verificationID, &userID, &email, &currentEmail)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Token inválido ou expirado", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Erro ao validar token: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// O e-mail pode ter sido alterado depois do envio do link
+		if email != currentEmail {
+			http.Error(w, "Token inválido ou expirado", http.StatusBadRequest)
+			return
+		}
+
+		if _, err := tx.Exec(`UPDATE core.email_verification SET used_at = now() WHERE id = $1`, verificationID); err != nil {
+			http.Error(w, "Erro ao invalidar token: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if err := setEmailValid(tx, userID); err != nil {
+			http.Error(w, "Erro ao atualizar verificação de e-mail: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			http.Error(w, "Erro ao finalizar transação: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		jsonResponse(w, http.StatusOK, map[string]string{
+			"message": "E-mail verificado com sucesso",
+		})
+	}
+}
+
+// ResendEmailVerificationHandler reenvia o link de verificação respeitando o limite de envios
+func ResendEmailVerificationHandler(db *sql.DB, sender mail.Sender) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := middleware.GetPrincipal(r)
+		if !ok {
+			http.Error(w, "Usuário não autenticado", http.StatusUnauthorized)
+			return
+		}
+
+		var (
+			name, email string
+			emailValid  sql.NullBool
+		)
+		err := db.QueryRow(`
+			SELECT u.name, u.email, ud.email_valid
+			FROM core.user u
+			LEFT JOIN core.user_details ud ON ud.id_user = u.id
+			WHERE u.id = $1
+		`, principal.UserID).Scan(&name, &email, &emailValid)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Usuário não encontrado", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Erro ao buscar usuário: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if emailValid.Bool {
+			http.Error(w, "E-mail já verificado", http.StatusBadRequest)
+			return
+		}
+
+		var (
+			enviosDia    int
+			envioRecente bool
+		)
+		err = db.QueryRow(`
+			SELECT COUNT(*), COALESCE(BOOL_OR(date_create > now() - $2::INTERVAL), false)
+			FROM core.email_verification
+			WHERE id_user = $1 AND date_create > now() - INTERVAL '24 hours'
+		`, principal.UserID, emailVerificationResendInterval).Scan(&enviosDia, &envioRecente)
+		if err != nil {
+			http.Error(w, "Erro ao verificar envios anteriores: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if enviosDia >= emailVerificationMaxPerDay || envioRecente {
+			http.Error(w, "Muitos envios solicitados, tente novamente mais tarde", http.StatusTooManyRequests)
+			return
+		}
+
+		if err := sendEmailVerification(db, sender, principal.UserID, email, name); err != nil {
+			http.Error(w, "Erro ao enviar e-mail de verificação: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		jsonResponse(w, http.StatusOK, map[string]string{
+			"message": "E-mail de verificação enviado",
+		})
+	}
+}
@@ -0,0 +1,344 @@
+package handlers
+
+import (
+	"archive/zip"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"BACK_SORTE_GO/config"
+	"BACK_SORTE_GO/middleware"
+	"BACK_SORTE_GO/utils"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Tipos gravados em core.lgpd_request
+const (
+	lgpdTipoExportacao = "EXPORT"
+	lgpdTipoExclusao   = "DELETE"
+)
+
+// lgpdExportSection é uma parte da exportação: cada query retorna uma linha JSON por registro, filtrando por id_user ($1)
+type lgpdExportSection struct {
+	nome  string
+	query string
+}
+
+// Dados vinculados ao titular. Segredos (senha, 2FA, tokens) não são exportados.
+var lgpdExportSections = []lgpdExportSection{
+	{"user", `SELECT to_jsonb(t) - 'password' FROM core.user t WHERE t.id = $1`},
+	{"user_details", `SELECT to_jsonb(t) FROM core.user_details t WHERE t.id_user = $1`},
+	{"user_role", `
+		SELECT jsonb_build_object('role_name', r.role_name)
+		FROM core.user_role ur JOIN core.role r ON r.id = ur.id_role
+		WHERE ur.id_user = $1`},
+	{"user_login", `SELECT to_jsonb(t) FROM core.user_login t WHERE t.id_user = $1 ORDER BY t.date`},
+	{"user_session", `SELECT to_jsonb(t) FROM core.user_session t WHERE t.id_user = $1 ORDER BY t.date_create`},
+	{"saque_conta", `SELECT to_jsonb(t) FROM core.saque_conta t WHERE t.id_user = $1 ORDER BY t.date_create`},
+	{"saque_details", `
+		SELECT to_jsonb(t)
+		FROM core.saque_details t JOIN core.saque_conta sc ON sc.id = t.id_saque_conta
+		WHERE sc.id_user = $1 ORDER BY t.date_create`},
+	{"conta_nivel", `SELECT to_jsonb(t) FROM core.conta_nivel t WHERE t.id_user = $1`},
+	{"conta_nivel_pagamento", `SELECT to_jsonb(t) FROM core.conta_nivel_pagamento t WHERE t.id_user = $1 ORDER BY t.data_create`},
+	{"doacao", `SELECT to_jsonb(t) FROM core.doacao t WHERE t.id_user = $1 ORDER BY t.date_create`},
+	{"doacao_details", `
+		SELECT to_jsonb(t)
+		FROM core.doacao_details t JOIN core.doacao d ON d.id = t.id_doacao
+		WHERE d.id_user = $1`},
+	{"doacao_link", `
+		SELECT to_jsonb(t)
+		FROM core.doacao_link t JOIN core.doacao d ON d.id = t.id_doacao
+		WHERE d.id_user = $1`},
+	{"doacao_qrcode", `
+		SELECT to_jsonb(t)
+		FROM core.doacao_qrcode t JOIN core.doacao d ON d.id = t.id_doacao
+		WHERE d.id_user = $1`},
+	{"doacao_pagamentos", `
+		SELECT to_jsonb(t)
+		FROM core.doacao_pagamentos t JOIN core.doacao d ON d.id = t.id_doacao
+		WHERE d.id_user = $1`},
+	// Doações feitas pelo titular, identificadas pelo CPF ou pelo e-mail informado no pagamento
+	{"pix_qrcode_doador", `
+		SELECT to_jsonb(t)
+		FROM core.pix_qrcode t JOIN core.user u ON (u.cpf = t.cpf AND u.cpf <> '') OR u.email = t.email
+		WHERE u.id = $1 ORDER BY t.data_criacao`},
+	{"pix_qrcode_status_doador", `
+		SELECT to_jsonb(t)
+		FROM core.pix_qrcode_status t
+		JOIN core.pix_qrcode pq ON pq.id = t.id_pix_qrcode
+		JOIN core.user u ON (u.cpf = pq.cpf AND u.cpf <> '') OR u.email = pq.email
+		WHERE u.id = $1 ORDER BY t.data_criacao`},
+	// Doações mensais do titular como doador e os ciclos gerados
+	{"doacao_recorrente", `
+		SELECT to_jsonb(t)
+		FROM core.doacao_recorrente t JOIN core.user u ON (u.cpf = t.cpf AND u.cpf <> '') OR u.email = t.email
+		WHERE u.id = $1 ORDER BY t.date_create`},
+	{"doacao_recorrente_ciclo", `
+		SELECT to_jsonb(t)
+		FROM core.doacao_recorrente_ciclo t
+		JOIN core.doacao_recorrente r ON r.id = t.id_recorrente
+		JOIN core.user u ON (u.cpf = r.cpf AND u.cpf <> '') OR u.email = r.email
+		WHERE u.id = $1 ORDER BY t.competencia`},
+	{"visualization_dth", `SELECT to_jsonb(t) FROM core.visualization_dth t WHERE t.id_user = $1 ORDER BY t.date_create`},
+	{"contact_us", `
+		SELECT to_jsonb(t) - 'token'
+		FROM core.contact_us t JOIN core.user u ON u.email = t.email
+		WHERE u.id = $1 ORDER BY t.data_create`},
+	{"lgpd_request", `SELECT to_jsonb(t) FROM core.lgpd_request t WHERE t.id_user = $1 ORDER BY t.date_create`},
+}
+
+// execer é satisfeito por *sql.DB e *sql.Tx
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// recordLgpdRequest registra a solicitação do titular
+func recordLgpdRequest(q execer, r *http.Request, userID, tipo string) error {
+	_, err := q.Exec(`
+		INSERT INTO core.lgpd_request (id, id_user, tipo, ip, date_create)
+		VALUES ($1, $2, $3, $4, now())
+	`, uuid.NewString(), userID, tipo, utils.ClientIP(r))
+	return err
+}
+
+// exportUserData monta um array JSON por seção com todos os dados vinculados ao usuário
+func exportUserData(db *sql.DB, userID string) (map[string]json.RawMessage, error) {
+	dados := make(map[string]json.RawMessage, len(lgpdExportSections))
+	for _, section := range lgpdExportSections {
+		var raw []byte
+		err := db.QueryRow(`
+			SELECT COALESCE(jsonb_agg(x), '[]'::jsonb) FROM (`+section.query+`) AS s(x)
+		`, userID).Scan(&raw)
+		if err != nil {
+			return nil, err
+		}
+		dados[section.nome] = raw
+	}
+	return dados, nil
+}
+
+// UserDataExportHandler gera a exportação dos dados do titular (LGPD art. 18) em JSON ou, com ?format=zip, em um ZIP com um arquivo por seção
+func UserDataExportHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := middleware.GetPrincipal(r)
+		if !ok {
+			http.Error(w, "Usuário não autenticado", http.StatusUnauthorized)
+			return
+		}
+
+		if err := recordLgpdRequest(db, r, principal.UserID, lgpdTipoExportacao); err != nil {
+			http.Error(w, "Erro ao registrar solicitação: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		dados, err := exportUserData(db, principal.UserID)
+		if err != nil {
+			http.Error(w, "Erro ao exportar dados: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		geradoEm := time.Now()
+		nomeArquivo := "dados-" + principal.UserID + "-" + geradoEm.Format("20060102150405")
+
+		if r.URL.Query().Get("format") == "zip" {
+			w.Header().Set("Content-Type", "application/zip")
+			w.Header().Set("Content-Disposition", `attachment; filename="`+nomeArquivo+`.zip"`)
+
+			zw := zip.NewWriter(w)
+			for _, section := range lgpdExportSections {
+				f, err := zw.CreateHeader(&zip.FileHeader{
+					Name:     section.nome + ".json",
+					Method:   zip.Deflate,
+					Modified: geradoEm,
+				})
+				if err != nil {
+					log.Println("Erro ao gerar ZIP da exportação:", err)
+					return
+				}
+				if _, err := f.Write(dados[section.nome]); err != nil {
+					log.Println("Erro ao gerar ZIP da exportação:", err)
+					return
+				}
+			}
+			if err := zw.Close(); err != nil {
+				log.Println("Erro ao gerar ZIP da exportação:", err)
+			}
+			return
+		}
+
+		w.Header().Set("Content-Disposition", `attachment; filename="`+nomeArquivo+`.json"`)
+		jsonResponse(w, http.StatusOK, map[string]interface{}{
+			"id_user":   principal.UserID,
+			"gerado_em": geradoEm,
+			"dados":     dados,
+		})
+	}
+}
+
+// deleteProfileImage remove a imagem de perfil do S3; falhas são apenas registradas no log
+func deleteProfileImage(key string) {
+	sess, err := session.NewSession(&aws.Config{
+		Region: aws.String(config.GetAwsRegion()),
+		Credentials: credentials.NewStaticCredentials(
+			config.GetAwsAccessKey(),
+			config.GetAwsSecretKey(),
+			"",
+		),
+	})
+	if err != nil {
+		log.Println("Erro na sessão AWS ao remover imagem de perfil:", err)
+		return
+	}
+
+	_, err = s3.New(sess).DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(config.GetAwsBucket()),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		log.Println("Erro ao remover imagem de perfil do S3:", err)
+	}
+}
+
+// UserDeleteAccountHandler exclui a conta do titular: os dados pessoais são anonimizados e os registros
+// financeiros (doações recebidas, pagamentos, resgates e contas de saque usadas) são mantidos para a contabilidade.
+func UserDeleteAccountHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := middleware.GetPrincipal(r)
+		if !ok {
+			http.Error(w, "Usuário não autenticado", http.StatusUnauthorized)
+			return
+		}
+		userID := principal.UserID
+
+		var req struct {
+			Password string `json:"password"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Erro ao processar o JSON", http.StatusBadRequest)
+			return
+		}
+
+		// Confirma a senha antes de uma operação irreversível
+		var hashedPassword string
+		err := db.QueryRow("SELECT password FROM core.user WHERE id = $1 AND date_anonymized IS NULL", userID).Scan(&hashedPassword)
+		if err != nil {
+			http.Error(w, "Usuário não encontrado", http.StatusNotFound)
+			return
+		}
+		if err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(req.Password)); err != nil {
+			http.Error(w, "Senha incorreta", http.StatusUnauthorized)
+			return
+		}
+
+		// Valores de campanhas ainda não resgatados ou em transferência dependem da conta de saque e do titular
+		var pendente bool
+		err = db.QueryRow(`
+			SELECT EXISTS (
+				SELECT 1
+				FROM core.doacao d
+				JOIN core.doacao_pagamentos dp ON dp.id_doacao = d.id
+				WHERE d.id_user = $1 AND dp.status = 'PROCESS'
+			) OR EXISTS (
+				SELECT 1
+				FROM core.doacao d
+				JOIN core.ledger_saldo ls ON ls.id_doacao = d.id AND ls.tipo = 'CAMPANHA'
+				WHERE d.id_user = $1 AND ls.saldo > 0
+			)
+		`, userID).Scan(&pendente)
+		if err != nil {
+			http.Error(w, "Erro ao verificar valores pendentes: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if pendente {
+			http.Error(w, "Existem valores de campanhas a receber ou em transferência, conclua o resgate antes de excluir a conta", http.StatusConflict)
+			return
+		}
+
+		var imgPerfil sql.NullString
+		err = db.QueryRow(`
+			SELECT MAX(img_perfil) FROM core.user_details WHERE id_user = $1
+		`, userID).Scan(&imgPerfil)
+		if err != nil {
+			http.Error(w, "Erro ao buscar dados do usuário: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			http.Error(w, "Erro ao iniciar transação: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		if err := recordLgpdRequest(tx, r, userID, lgpdTipoExclusao); err != nil {
+			http.Error(w, "Erro ao registrar solicitação: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		queries := []string{
+			// Doações feitas como doador: mantém valor e CPF do pagador (registro financeiro), remove nome, mensagem e e-mail
+			`UPDATE core.pix_qrcode pq SET nome = 'Anônimo', mensagem = NULL, anonimo = true, email = NULL
+				FROM core.user u WHERE u.id = $1 AND ((u.cpf <> '' AND pq.cpf = u.cpf) OR pq.email = u.email)`,
+			// Doações mensais: encerra as que ainda geram cobranças e e-mails e remove os dados do doador
+			`UPDATE core.doacao_recorrente r SET
+				status = CASE WHEN r.status IN ('` + RecorrenciaCancelada + `', '` + RecorrenciaEncerrada + `') THEN r.status ELSE '` + RecorrenciaCancelada + `' END,
+				date_cancelamento = COALESCE(r.date_cancelamento, now()),
+				nome = 'Anônimo', cpf = '', email = 'removido-' || r.id::TEXT || '@anonimizado.invalid',
+				mensagem = NULL, anonimo = true, date_update = now()
+				FROM core.user u WHERE u.id = $1 AND ((u.cpf <> '' AND r.cpf = u.cpf) OR r.email = u.email)`,
+			`UPDATE core.contact_us c SET nome = 'Usuário removido', email = 'removido-' || u.id::TEXT || '@anonimizado.invalid', ip = NULL, location = NULL
+				FROM core.user u WHERE u.id = $1 AND c.email = u.email`,
+			`UPDATE core.user_login SET email = 'removido-' || id_user::TEXT || '@anonimizado.invalid', ip = NULL, user_agent = NULL WHERE id_user = $1`,
+			`UPDATE core.visualization_dth SET id_user = NULL, ip = NULL WHERE id_user = $1`,
+			`UPDATE core.user_details SET cep = NULL, telefone = NULL, apelido = NULL, img_perfil = NULL, date_update = now() WHERE id_user = $1`,
+			// Contas de saque: dados bancários ficam como comprovação dos resgates, contato é removido
+			`UPDATE core.saque_conta SET telefone = NULL, endereco = NULL, active = false, dell = true, date_update = now() WHERE id_user = $1`,
+			`UPDATE core.doacao SET active = false, dell = true, date_update = now() WHERE id_user = $1`,
+			`DELETE FROM core.refresh_token WHERE id_user = $1`,
+			`UPDATE core.user_session SET revoked = true, revoked_motivo = 'ACCOUNT_DELETED', revoked_at = now() WHERE id_user = $1 AND revoked = false`,
+			`DELETE FROM core.user_recovery_code WHERE id_user = $1`,
+			`DELETE FROM core.user_totp WHERE id_user = $1`,
+			`DELETE FROM core.password_reset WHERE id_user = $1`,
+			`DELETE FROM core.email_verification WHERE id_user = $1`,
+			`DELETE FROM core.user_role WHERE id_user = $1`,
+			`UPDATE core.user SET
+				name = 'Usuário removido',
+				email = 'removido-' || id::TEXT || '@anonimizado.invalid',
+				password = '',
+				cpf = '',
+				active = false,
+				dell = true,
+				date_anonymized = now(),
+				date_update = now()
+			WHERE id = $1`,
+		}
+		for _, query := range queries {
+			if _, err := tx.Exec(query, userID); err != nil {
+				http.Error(w, "Erro ao anonimizar dados: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		if err := tx.Commit(); err != nil {
+			http.Error(w, "Erro ao confirmar transação: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if imgPerfil.Valid && imgPerfil.String != "" {
+			go deleteProfileImage(imgPerfil.String)
+		}
+
+		jsonResponse(w, http.StatusOK, map[string]string{
+			"message": "Conta excluída e dados pessoais anonimizados",
+		})
+	}
+}
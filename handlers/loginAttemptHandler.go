@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"BACK_SORTE_GO/middleware"
+	"BACK_SORTE_GO/models"
+	"BACK_SORTE_GO/utils"
+
+	"github.com/google/uuid"
+)
+
+// Motivos gravados em core.user_login
+const (
+	loginMotivoSucesso        = "SUCCESS"
+	loginMotivoSenhaInvalida  = "INVALID_PASSWORD"
+	loginMotivoUsuarioInexist = "UNKNOWN_USER"
+	loginMotivoBloqueado      = "LOCKED"
+	// Senha correta de usuário com 2FA; não zera o contador de falhas até o código ser confirmado
+	loginMotivoMFAPendente  = "MFA_REQUIRED"
+	loginMotivoTOTPInvalido = "INVALID_TOTP"
+	loginMotivoStepUp       = "STEP_UP"
+)
+
+const (
+	// Falhas permitidas antes do bloqueio, por e-mail e por IP
+	loginMaxFalhasEmail = 5
+	loginMaxFalhasIP    = 20
+	// Janela considerada para contar as falhas
+	loginJanelaFalhas = time.Hour
+	// Bloqueio inicial, dobrado a cada nova falha acima do limite
+	loginBloqueioInicial = time.Minute
+	loginBloqueioMaximo  = time.Hour
+)
+
+// recordLoginAttempt grava a tentativa de login com IP e user agent. Erros são apenas registrados no log.
+func recordLoginAttempt(db *sql.DB, r *http.Request, email, userID string, passValid bool, motivo string) {
+	var idUser interface{}
+	if userID != "" {
+		idUser = userID
+	}
+
+	userAgent := r.UserAgent()
+	if len(userAgent) > 500 {
+		userAgent = userAgent[:500]
+	}
+
+	_, err := db.Exec(`
+		INSERT INTO core.user_login (id, email, id_user, pass_valid, ip, user_agent, motivo, date)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, now())
+	`, uuid.NewString(), email, idUser, passValid, utils.ClientIP(r), userAgent, motivo)
+	if err != nil {
+		log.Println("Erro ao registrar tentativa de login:", err)
+	}
+}
+
+// lockoutDuration calcula o bloqueio progressivo a partir da quantidade de falhas
+func lockoutDuration(falhas, limite int) time.Duration {
+	if falhas < limite {
+		return 0
+	}
+	bloqueio := loginBloqueioInicial
+	for i := limite; i < falhas && bloqueio < loginBloqueioMaximo; i++ {
+		bloqueio *= 2
+	}
+	if bloqueio > loginBloqueioMaximo {
+		bloqueio = loginBloqueioMaximo
+	}
+	return bloqueio
+}
+
+// recentFailures conta as falhas na janela e há quanto tempo foi a última falha. Com zerarNoSucesso, só contam
+// as falhas depois do último login com sucesso (usado por e-mail). O contador por IP nunca é zerado: um login
+// na própria conta não pode liberar novas tentativas contra outras contas a partir do mesmo IP.
+func recentFailures(db *sql.DB, column, value string, zerarNoSucesso bool) (int, time.Duration, error) {
+	var (
+		falhas   int
+		segundos float64
+	)
+	err := db.QueryRow(`
+		SELECT COUNT(*), COALESCE(EXTRACT(EPOCH FROM now() - MAX(date)), 0)
+		FROM core.user_login
+		WHERE `+column+` = $1
+		  AND pass_valid = false
+		  AND motivo IN ('`+loginMotivoSenhaInvalida+`', '`+loginMotivoUsuarioInexist+`', '`+loginMotivoTOTPInvalido+`')
+		  AND date > now() - $2::INTERVAL
+		  AND (NOT $3 OR date > COALESCE((
+			SELECT MAX(date) FROM core.user_login
+			WHERE `+column+` = $1 AND pass_valid = true
+			  AND COALESCE(motivo, '') <> '`+loginMotivoMFAPendente+`'
+		  ), 'epoch'))
+	`, value, strconv.Itoa(int(loginJanelaFalhas.Seconds()))+" seconds", zerarNoSucesso).Scan(&falhas, &segundos)
+	if err != nil {
+		return 0, 0, err
+	}
+	return falhas, time.Duration(segundos * float64(time.Second)), nil
+}
+
+// checkLoginLock retorna quanto tempo falta para liberar novas tentativas para o e-mail ou IP (0 se liberado)
+func checkLoginLock(db *sql.DB, email, ip string) (time.Duration, error) {
+	var retryAfter time.Duration
+
+	checks := []struct {
+		column         string
+		value          string
+		limite         int
+		zerarNoSucesso bool
+	}{
+		{"email", email, loginMaxFalhasEmail, true},
+		{"ip", ip, loginMaxFalhasIP, false},
+	}
+	for _, c := range checks {
+		falhas, desdeUltima, err := recentFailures(db, c.column, c.value, c.zerarNoSucesso)
+		if err != nil {
+			return 0, err
+		}
+		if restante := lockoutDuration(falhas, c.limite) - desdeUltima; restante > retryAfter {
+			retryAfter = restante
+		}
+	}
+
+	return retryAfter, nil
+}
+
+// UserLoginHistoryHandler retorna os acessos recentes do usuário autenticado
+func UserLoginHistoryHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := middleware.GetPrincipal(r)
+		if !ok {
+			http.Error(w, "Usuário não autenticado", http.StatusUnauthorized)
+			return
+		}
+
+		limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+		if err != nil || limit < 1 || limit > 100 {
+			limit = 20
+		}
+
+		rows, err := db.Query(`
+			SELECT id, email, pass_valid, COALESCE(ip, ''), COALESCE(user_agent, ''), COALESCE(motivo, ''), date
+			FROM core.user_login
+			WHERE id_user = $1
+			ORDER BY date DESC
+			LIMIT $2
+		`, principal.UserID, limit)
+		if err != nil {
+			http.Error(w, "Erro ao buscar acessos: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		logins := []models.UserLogin{}
+		for rows.Next() {
+			var l models.UserLogin
+			if err := rows.Scan(&l.ID, &l.Email, &l.PassValid, &l.IP, &l.UserAgent, &l.Motivo, &l.Date); err != nil {
+				http.Error(w, "Erro ao ler acessos: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			logins = append(logins, l)
+		}
+
+		jsonResponse(w, http.StatusOK, logins)
+	}
+}
+
+// SuspiciousLoginsHandler lista IPs e e-mails com muitas falhas de login no período (padrão 24h)
+func SuspiciousLoginsHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		hours, err := strconv.Atoi(r.URL.Query().Get("hours"))
+		if err != nil || hours < 1 || hours > 24*30 {
+			hours = 24
+		}
+		minFalhas, err := strconv.Atoi(r.URL.Query().Get("min_failures"))
+		if err != nil || minFalhas < 1 {
+			minFalhas = loginMaxFalhasEmail
+		}
+		intervalo := strconv.Itoa(hours) + " hours"
+
+		type suspeito struct {
+			Valor           string    `json:"valor"`
+			Falhas          int       `json:"falhas"`
+			Sucessos        int       `json:"sucessos"`
+			Distintos       int       `json:"distintos"`
+			UltimaTentativa time.Time `json:"ultima_tentativa"`
+		}
+
+		buscar := func(column, distinct string) ([]suspeito, error) {
+			rows, err := db.Query(`
+				SELECT COALESCE(`+column+`, ''),
+					COUNT(*) FILTER (WHERE pass_valid = false),
+					COUNT(*) FILTER (WHERE pass_valid = true),
+					COUNT(DISTINCT `+distinct+`),
+					MAX(date)
+				FROM core.user_login
+				WHERE date > now() - $1::INTERVAL
+				GROUP BY `+column+`
+				HAVING COUNT(*) FILTER (WHERE pass_valid = false) >= $2
+				ORDER BY 2 DESC
+				LIMIT 100
+			`, intervalo, minFalhas)
+			if err != nil {
+				return nil, err
+			}
+			defer rows.Close()
+
+			lista := []suspeito{}
+			for rows.Next() {
+				var s suspeito
+				if err := rows.Scan(&s.Valor, &s.Falhas, &s.Sucessos, &s.Distintos, &s.UltimaTentativa); err != nil {
+					return nil, err
+				}
+				lista = append(lista, s)
+			}
+			return lista, rows.Err()
+		}
+
+		// Por IP, "distintos" é a quantidade de e-mails testados; por e-mail, a quantidade de IPs de origem
+		porIP, err := buscar("ip", "email")
+		if err != nil {
+			http.Error(w, "Erro ao buscar acessos suspeitos: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		porEmail, err := buscar("email", "ip")
+		if err != nil {
+			http.Error(w, "Erro ao buscar acessos suspeitos: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		jsonResponse(w, http.StatusOK, map[string]interface{}{
+			"horas":     hours,
+			"por_ip":    porIP,
+			"por_email": porEmail,
+		})
+	}
+}
@@ -5,15 +5,16 @@ import (
 	"encoding/json"
 	"errors"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
+	"BACK_SORTE_GO/middleware"
+	"BACK_SORTE_GO/utils"
+
 	"golang.org/x/crypto/bcrypt"
-	"github.com/golang-jwt/jwt/v4"
 )
 
-// Configurações para o JWT
-var jwtSecretKey = []byte("SUA_CHAVE_SECRETA") // Substitua pela sua chave secreta
-
 // Estrutura para conta_nivel
 type ContaNivel struct {
 	ID            string     `json:"id"`
@@ -28,7 +29,9 @@ type ContaNivel struct {
 
 // Estrutura para a resposta do token
 type LoginResponse struct {
-	Token      string      `json:"token"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
 	User       struct {
 		ID         string    `json:"id"`
 		Name       string    `json:"name"`
@@ -39,6 +42,7 @@ type LoginResponse struct {
 		Dell       bool      `json:"dell"`
 		DateCreate time.Time `json:"date_create"`
 	} `json:"user"`
+	Roles      []string    `json:"roles"`
 	ContaNivel *ContaNivel `json:"conta_nivel,omitempty"`
 }
 
@@ -46,9 +50,7 @@ type LoginResponse struct {
 func LoginHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Validar cabeçalhos
-		authHeader := r.Header.Get("Authorization")
-		contentType := r.Header.Get("Content-Type")
-		if authHeader != "Basic QVBJX05BTUVfQUNDRVNTOkFQSV9TRUNSRVRfQUNDRVNT" || contentType != "application/x-www-form-urlencoded" {
+		if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
 			http.Error(w, "Cabeçalhos inválidos", http.StatusUnauthorized)
 			return
 		}
@@ -59,12 +61,12 @@ func LoginHandler(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		username := r.FormValue("username")
-		password := r.FormValue("password")
 		grantType := r.FormValue("grant_type")
 
-		if grantType != "password" || username == "" || password == "" {
-			http.Error(w, "Parâmetros inválidos", http.StatusBadRequest)
+		// Cliente da API (Basic client_id:client_secret cadastrado em core.api_client)
+		client, err := authenticateApiClient(db, r, grantType)
+		if err != nil {
+			writeApiClientError(w, err)
 			return
 		}
 
@@ -83,34 +85,152 @@ func LoginHandler(db *sql.DB) http.HandlerFunc {
 		query := `
 			SELECT id, name, email, password, cpf, active, inicial, dell, date_create
 			FROM core.user
-			WHERE email = $1
 		`
-		err := db.QueryRow(query, username).Scan(
-			&user.ID, &user.Name, &user.Email, &user.Password, &user.CPF,
-			&user.Active, &user.Inicial, &user.Dell, &user.DateCreate,
-		)
-		if err != nil {
-			if errors.Is(err, sql.ErrNoRows) {
+
+		var sessionID, refreshToken string
+		switch grantType {
+		case "password":
+			username := r.FormValue("username")
+			password := r.FormValue("password")
+			if username == "" || password == "" {
+				http.Error(w, "Parâmetros inválidos", http.StatusBadRequest)
+				return
+			}
+
+			// Bloqueio progressivo por e-mail e por IP após falhas seguidas
+			retryAfter, err := checkLoginLock(db, username, utils.ClientIP(r))
+			if err != nil {
+				http.Error(w, "Erro ao verificar tentativas de login: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if retryAfter > 0 {
+				recordLoginAttempt(db, r, username, "", false, loginMotivoBloqueado)
+				w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+				http.Error(w, "Muitas tentativas de login, tente novamente mais tarde", http.StatusTooManyRequests)
+				return
+			}
+
+			err = db.QueryRow(query+" WHERE email = $1", username).Scan(
+				&user.ID, &user.Name, &user.Email, &user.Password, &user.CPF,
+				&user.Active, &user.Inicial, &user.Dell, &user.DateCreate,
+			)
+			if err != nil {
+				if errors.Is(err, sql.ErrNoRows) {
+					recordLoginAttempt(db, r, username, "", false, loginMotivoUsuarioInexist)
+					http.Error(w, "Usuário ou senha inválidos", http.StatusUnauthorized)
+					return
+				}
+				http.Error(w, "Erro ao buscar usuário", http.StatusInternalServerError)
+				return
+			}
+
+			if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
+				recordLoginAttempt(db, r, username, user.ID, false, loginMotivoSenhaInvalida)
 				http.Error(w, "Usuário ou senha inválidos", http.StatusUnauthorized)
 				return
 			}
-			http.Error(w, "Erro ao buscar usuário", http.StatusInternalServerError)
-			return
-		}
 
-		if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
-			http.Error(w, "Usuário ou senha inválidos", http.StatusUnauthorized)
-			return
-		}
+			// Com 2FA ativo, a senha correta apenas libera o segundo passo (grant_type=totp)
+			enabled, err := totpEnabled(db, user.ID)
+			if err != nil {
+				http.Error(w, "Erro ao verificar 2FA: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if enabled {
+				recordLoginAttempt(db, r, username, user.ID, true, loginMotivoMFAPendente)
 
-		// Gerar token
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-			"sub": user.ID,
-			"exp": time.Now().Add(time.Hour * 24).Unix(),
-		})
-		tokenString, err := token.SignedString(jwtSecretKey)
-		if err != nil {
-			http.Error(w, "Erro ao gerar token", http.StatusInternalServerError)
+				mfaToken, err := middleware.GenerateMFAToken(user.ID)
+				if err != nil {
+					http.Error(w, "Erro ao gerar token", http.StatusInternalServerError)
+					return
+				}
+				jsonResponse(w, http.StatusOK, map[string]interface{}{
+					"mfa_required": true,
+					"mfa_token":    mfaToken,
+					"expires_in":   int(middleware.MFATokenTTL.Seconds()),
+				})
+				return
+			}
+			recordLoginAttempt(db, r, username, user.ID, true, loginMotivoSucesso)
+
+			sessionID, refreshToken, err = createSession(db, user.ID, client.ID)
+			if err != nil {
+				http.Error(w, "Erro ao criar sessão: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+		case "totp":
+			// Segundo passo do login: mfa_token recebido no primeiro passo + código do aplicativo ou de recuperação
+			userID, err := middleware.ParseMFAToken(r.FormValue("mfa_token"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			code := r.FormValue("code")
+			if code == "" {
+				http.Error(w, "Parâmetros inválidos", http.StatusBadRequest)
+				return
+			}
+
+			valid, retryAfter, err := checkSecondFactor(db, r, userID, code, loginMotivoSucesso)
+			if err != nil {
+				http.Error(w, "Erro ao verificar código: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if !valid {
+				writeSecondFactorError(w, retryAfter)
+				return
+			}
+
+			err = db.QueryRow(query+" WHERE id = $1", userID).Scan(
+				&user.ID, &user.Name, &user.Email, &user.Password, &user.CPF,
+				&user.Active, &user.Inicial, &user.Dell, &user.DateCreate,
+			)
+			if err != nil {
+				http.Error(w, "Erro ao buscar usuário", http.StatusInternalServerError)
+				return
+			}
+
+			sessionID, refreshToken, err = createSession(db, user.ID, client.ID)
+			if err != nil {
+				http.Error(w, "Erro ao criar sessão: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			// O código acabou de ser confirmado, então a sessão já nasce liberada para operações sensíveis
+			if err := markStepUp(db, sessionID); err != nil {
+				http.Error(w, "Erro ao atualizar sessão: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+		case "refresh_token":
+			token := r.FormValue("refresh_token")
+			if token == "" {
+				http.Error(w, "Parâmetros inválidos", http.StatusBadRequest)
+				return
+			}
+
+			userID, sid, newToken, err := rotateRefreshToken(db, token, client.ID)
+			if errors.Is(err, errRefreshTokenInvalido) || errors.Is(err, errRefreshTokenReutilizado) {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			if err != nil {
+				http.Error(w, "Erro ao renovar sessão: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			sessionID, refreshToken = sid, newToken
+
+			err = db.QueryRow(query+" WHERE id = $1", userID).Scan(
+				&user.ID, &user.Name, &user.Email, &user.Password, &user.CPF,
+				&user.Active, &user.Inicial, &user.Dell, &user.DateCreate,
+			)
+			if err != nil {
+				http.Error(w, "Erro ao buscar usuário", http.StatusInternalServerError)
+				return
+			}
+
+		default:
+			http.Error(w, "Parâmetros inválidos", http.StatusBadRequest)
 			return
 		}
 
@@ -135,10 +255,33 @@ func LoginHandler(db *sql.DB) http.HandlerFunc {
 			http.Error(w, "Erro ao buscar conta_nivel: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
+		hasContaNivel := err == nil
+
+		// Buscar roles do usuário
+		roles, err := loadUserRoles(db, user.ID)
+		if err != nil {
+			http.Error(w, "Erro ao buscar roles: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// Gerar token
+		tokenString, err := middleware.GenerateToken(middleware.Principal{
+			UserID:     user.ID,
+			SessionID:  sessionID,
+			Roles:      roles,
+			ContaNivel: contaNivel.Nivel,
+		})
+		if err != nil {
+			http.Error(w, "Erro ao gerar token", http.StatusInternalServerError)
+			return
+		}
 
 		// Resposta final
 		response := LoginResponse{
-			Token: tokenString,
+			Token:        tokenString,
+			RefreshToken: refreshToken,
+			ExpiresIn:    int(middleware.AccessTokenTTL.Seconds()),
+			Roles:        roles,
 			User: struct {
 				ID         string    `json:"id"`
 				Name       string    `json:"name"`
@@ -161,7 +304,7 @@ func LoginHandler(db *sql.DB) http.HandlerFunc {
 		}
 
 		// Se encontrou dados de conta_nivel
-		if err == nil {
+		if hasContaNivel {
 			response.ContaNivel = &contaNivel
 		}
 
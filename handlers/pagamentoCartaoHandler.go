@@ -0,0 +1,575 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"BACK_SORTE_GO/config"
+	"BACK_SORTE_GO/cpf"
+	"BACK_SORTE_GO/fees"
+	"BACK_SORTE_GO/jobs"
+	"BACK_SORTE_GO/ledger"
+	"BACK_SORTE_GO/money"
+	"BACK_SORTE_GO/payment"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// JobCartaoStatus consulta o pagamento com cartão (chave = id do pagamento) até a captura ou a recusa
+const JobCartaoStatus = "CARTAO_STATUS"
+
+const (
+	// A análise do cartão costuma sair em minutos, mas pode levar até dois dias
+	cartaoStatusIntervaloInicial = 5 * time.Minute
+	cartaoStatusIntervalo        = time.Hour
+	cartaoStatusFaseInicial      = time.Hour
+	cartaoStatusPrazo            = 7 * 24 * time.Hour
+	// Espera pela notificação de uma cobrança cuja criação ficou sem resposta do provedor
+	cartaoSemCobrancaPrazo = time.Hour
+	cartaoParcelasMaximo   = 12
+)
+
+// Origens das mudanças de status registradas em core.pagamento_cartao_evento
+const (
+	pagamentoOrigemCriacao     = "CRIACAO"
+	pagamentoOrigemNotificacao = "NOTIFICACAO"
+	pagamentoOrigemConsulta    = "CONSULTA"
+)
+
+// PagamentoCartao é o pagamento de uma doação com cartão ou carteira digital
+type PagamentoCartao struct {
+	ID         string         `json:"id"`
+	IDDoacao   string         `json:"id_doacao"`
+	Metodo     string         `json:"metodo"`
+	Status     string         `json:"status"`
+	Valor      money.Centavos `json:"valor"`
+	Parcelas   int            `json:"parcelas"`
+	Motivo     *string        `json:"motivo,omitempty"`
+	DateCreate time.Time      `json:"date_create"`
+}
+
+func buscarPagamentoCartao(db *sql.DB, id string) (*PagamentoCartao, error) {
+	var p PagamentoCartao
+	err := db.QueryRow(`
+		SELECT id, id_doacao, metodo, status, valor, parcelas, motivo, date_create
+		FROM core.pagamento_cartao
+		WHERE id = $1
+	`, id).Scan(&p.ID, &p.IDDoacao, &p.Metodo, &p.Status, &p.Valor, &p.Parcelas, &p.Motivo, &p.DateCreate)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// PagamentoCartaoRequest é a doação com cartão ou carteira. payment_token é gerado no navegador pela biblioteca
+// de tokenização do provedor; telefone, nascimento e endereço só são repassados ao provedor, não são gravados.
+type PagamentoCartaoRequest struct {
+	IdDoacao     string                 `json:"id"`
+	Metodo       string                 `json:"metodo"`
+	Valor        money.Centavos         `json:"valor"`
+	Parcelas     int                    `json:"parcelas"`
+	PaymentToken string                 `json:"payment_token"`
+	Nome         string                 `json:"nome"`
+	CPF          string                 `json:"cpf"`
+	Email        string                 `json:"email"`
+	Telefone     string                 `json:"telefone"`
+	Nascimento   string                 `json:"nascimento"`
+	Endereco     payment.BillingAddress `json:"endereco"`
+	Mensagem     string                 `json:"mensagem"`
+	Anonimo      bool                   `json:"anonimo"`
+}
+
+// CreatePagamentoCartaoHandler cria a doação paga com cartão ou carteira digital. A doação é gravada em
+// pix_qrcode (com metodo_pagamento) antes da cobrança, para que nenhuma autorização fique sem registro; ela só
+// aparece na campanha depois da captura. Cartão recusado responde 402 com o motivo; sem resposta do provedor
+// o pagamento fica PENDENTE.
+func CreatePagamentoCartaoHandler(db *sql.DB, providers payment.CardProviders) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req PagamentoCartaoRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Erro ao decodificar JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		req.Metodo = strings.ToUpper(strings.TrimSpace(req.Metodo))
+		if req.Metodo == "" {
+			req.Metodo = payment.MetodoCartao
+		}
+		provider, ok := providers[req.Metodo]
+		if !ok {
+			http.Error(w, "Método de pagamento não disponível: "+req.Metodo, http.StatusUnprocessableEntity)
+			return
+		}
+
+		if req.Parcelas == 0 {
+			req.Parcelas = 1
+		}
+		if req.Valor <= 0 || req.Parcelas < 1 || req.Parcelas > cartaoParcelasMaximo {
+			http.Error(w, fmt.Sprintf("Valor inválido ou parcelas fora de 1 a %d", cartaoParcelasMaximo), http.StatusBadRequest)
+			return
+		}
+		req.Nome = strings.TrimSpace(req.Nome)
+		req.Email = strings.TrimSpace(req.Email)
+		if req.IdDoacao == "" || req.PaymentToken == "" || req.Nome == "" || req.Email == "" {
+			http.Error(w, "id, payment_token, nome e email são obrigatórios", http.StatusBadRequest)
+			return
+		}
+		documento, tipoDocumento, err := cpf.ParseDocumento(req.CPF)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if tipoDocumento != cpf.TipoCPF {
+			http.Error(w, "Pagamento com cartão exige o CPF do titular", http.StatusBadRequest)
+			return
+		}
+
+		var campanha string
+		var ativa bool
+		err = db.QueryRow(`
+			SELECT name, active AND NOT closed FROM core.doacao WHERE id = $1 AND dell = false
+		`, req.IdDoacao).Scan(&campanha, &ativa)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Doação não encontrada", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Erro ao buscar doação: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !ativa {
+			http.Error(w, "Doação encerrada ou inativa", http.StatusConflict)
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			http.Error(w, "Erro ao iniciar transação: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		idPixQRCode := uuid.NewString()
+		_, err = tx.Exec(`
+			INSERT INTO core.pix_qrcode
+			(id, id_doacao, valor, cpf, nome, mensagem, anonimo, visivel, email, metodo_pagamento, data_criacao)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, false, $8, $9, now())
+		`, idPixQRCode, req.IdDoacao, req.Valor, documento, req.Nome, req.Mensagem, req.Anonimo, req.Email, req.Metodo)
+		if err != nil {
+			http.Error(w, "Erro ao salvar doação: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		idPagamento := uuid.NewString()
+		_, err = tx.Exec(`
+			INSERT INTO core.pagamento_cartao (id, id_pix_qrcode, id_doacao, metodo, provedor, status, valor, parcelas, date_create, date_update)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, now(), now())
+		`, idPagamento, idPixQRCode, req.IdDoacao, req.Metodo, provider.Nome(), payment.CartaoPendente, req.Valor, req.Parcelas)
+		if err != nil {
+			http.Error(w, "Erro ao salvar pagamento: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// Agendado antes da cobrança: se o processo cair no meio, a consulta continua depois do reinício
+		if _, err := jobs.Enqueue(tx, JobCartaoStatus, idPagamento, nil, time.Now().Add(cartaoStatusIntervaloInicial)); err != nil {
+			http.Error(w, "Erro ao agendar verificação do pagamento: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			http.Error(w, "Erro ao confirmar transação: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		chargeReq := payment.CardChargeRequest{
+			Metodo:       req.Metodo,
+			CustomID:     idPagamento,
+			Descricao:    "Doação - " + campanha,
+			Valor:        req.Valor,
+			Parcelas:     req.Parcelas,
+			PaymentToken: req.PaymentToken,
+			Cliente: payment.CardCustomer{
+				Nome:       req.Nome,
+				CPF:        documento,
+				Email:      req.Email,
+				Telefone:   req.Telefone,
+				Nascimento: req.Nascimento,
+			},
+			Endereco: req.Endereco,
+		}
+		if base := config.GetPagamentoNotificacaoURL(); base != "" {
+			chargeReq.NotificationURL = strings.TrimRight(base, "/") + "/" + provider.Nome()
+		}
+
+		charge, err := provider.CreateCardCharge(chargeReq)
+		if err != nil && payment.FalhaDeComunicacao(err) {
+			// Sem resposta do provedor a cobrança pode ter sido criada: o pagamento fica pendente até a notificação,
+			// que encontra o pagamento pelo custom_id, ou até o prazo de CartaoStatusJob
+			log.Printf("Pagamento com cartão %s sem resposta do provedor: %v\n", idPagamento, err)
+			charge = &payment.CardCharge{Status: payment.CartaoPendente}
+		} else if err != nil {
+			charge = &payment.CardCharge{Status: payment.CartaoRecusado, Motivo: err.Error()}
+		}
+		if err := atualizarPagamentoCartao(db, idPagamento, charge, pagamentoOrigemCriacao); err != nil {
+			http.Error(w, "Erro ao atualizar pagamento: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		p, err := buscarPagamentoCartao(db, idPagamento)
+		if err != nil || p == nil {
+			http.Error(w, "Erro ao buscar pagamento", http.StatusInternalServerError)
+			return
+		}
+		status := http.StatusCreated
+		if p.Status == payment.CartaoRecusado {
+			status = http.StatusPaymentRequired
+		}
+		jsonResponse(w, status, p)
+	}
+}
+
+// PagamentoCartaoStatusHandler retorna o status do pagamento com cartão, sem dados do pagador
+func PagamentoCartaoStatusHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, err := buscarPagamentoCartao(db, mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Erro ao buscar pagamento: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if p == nil {
+			http.Error(w, "Pagamento não encontrado", http.StatusNotFound)
+			return
+		}
+		jsonResponse(w, http.StatusOK, p)
+	}
+}
+
+// atualizarPagamentoCartao aplica o status informado pelo provedor numa única transação e registra o evento.
+// A captura torna a doação visível e lança o valor e a taxa no razão; a contestação esconde a doação e retém o
+// valor na conta de contestação, liberado se o pagamento volta a CAPTURADO; o estorno de um pagamento capturado
+// tira o valor da campanha (ou da retenção) e devolve a taxa. Os lançamentos são idempotentes, então
+// notificações repetidas ou fora de ordem não lançam duas vezes.
+func atualizarPagamentoCartao(db *sql.DB, idPagamento string, charge *payment.CardCharge, origem string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var idPixQRCode, idDoacao, status string
+	var statusProvedor sql.NullString
+	var valor, taxa money.Centavos
+	var capturado, estornado, retido bool
+	var contestacoes int
+	err = tx.QueryRow(`
+		SELECT id_pix_qrcode, id_doacao, status, status_provedor, valor, COALESCE(taxa, 0),
+			capturado_em IS NOT NULL, estornado_em IS NOT NULL, contestacoes, contestacao_retida
+		FROM core.pagamento_cartao
+		WHERE id = $1
+		FOR UPDATE
+	`, idPagamento).Scan(&idPixQRCode, &idDoacao, &status, &statusProvedor, &valor, &taxa, &capturado, &estornado,
+		&contestacoes, &retido)
+	if err != nil {
+		return err
+	}
+	if status == charge.Status && statusProvedor.String == charge.StatusProvedor {
+		return nil
+	}
+
+	_, err = tx.Exec(`
+		UPDATE core.pagamento_cartao
+		SET status = $2, status_provedor = NULLIF($3, ''), id_cobranca = COALESCE(id_cobranca, NULLIF($4, '')),
+			motivo = COALESCE(NULLIF($5, ''), motivo), date_update = now()
+		WHERE id = $1
+	`, idPagamento, charge.Status, charge.StatusProvedor, charge.ID, charge.Motivo)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO core.pagamento_cartao_evento (id_pagamento, status_anterior, status, status_provedor, origem, date_create)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5, now())
+	`, idPagamento, status, charge.Status, charge.StatusProvedor, origem)
+	if err != nil {
+		return err
+	}
+
+	visivel := false
+	switch charge.Status {
+	case payment.CartaoAutorizado:
+		_, err = tx.Exec(`UPDATE core.pagamento_cartao SET autorizado_em = COALESCE(autorizado_em, now()) WHERE id = $1`, idPagamento)
+	case payment.CartaoCapturado:
+		visivel = true
+		if !capturado {
+			err = registrarCapturaCartao(tx, idPagamento, idDoacao, valor)
+		} else if retido {
+			err = liberarContestacaoCartao(tx, idPagamento, idDoacao, valor, contestacoes)
+		}
+	case payment.CartaoContestado:
+		_, err = tx.Exec(`UPDATE core.pagamento_cartao SET contestado_em = COALESCE(contestado_em, now()) WHERE id = $1`, idPagamento)
+		if err == nil && capturado && !estornado && !retido {
+			err = reterContestacaoCartao(tx, idPagamento, idDoacao, valor, contestacoes+1)
+		}
+	case payment.CartaoEstornado:
+		if capturado && !estornado {
+			err = registrarEstornoCartao(tx, idPagamento, idDoacao, valor, taxa, retido)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`UPDATE core.pix_qrcode SET visivel = $2 WHERE id = $1`, idPixQRCode, visivel); err != nil {
+		return fmt.Errorf("erro ao atualizar visibilidade da doação: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Printf("Pagamento com cartão %s: %s -> %s (%s)\n", idPagamento, status, charge.Status, origem)
+	return nil
+}
+
+// registrarCapturaCartao grava a taxa vigente na captura e lança o valor recebido e a taxa no razão
+func registrarCapturaCartao(tx *sql.Tx, idPagamento, idDoacao string, valor money.Centavos) error {
+	taxa, err := fees.Calcular(tx, idDoacao, valor, time.Now())
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		UPDATE core.pagamento_cartao
+		SET capturado_em = now(), autorizado_em = COALESCE(autorizado_em, now()),
+			taxa = $2, valor_liquido = $3, id_taxa_regra = NULLIF($4, '')::UUID,
+			taxa_percentual = $5, taxa_valor_fixo = $6, taxa_valor_minimo = $7
+		WHERE id = $1
+	`, idPagamento, taxa.Taxa, valor-taxa.Taxa, taxa.IDRegra, taxa.Percentual, taxa.ValorFixo, taxa.ValorMinimo)
+	if err != nil {
+		return err
+	}
+
+	if _, err := ledger.Lancar(tx, ledger.CartaoRecebido(idDoacao, idPagamento, valor)); err != nil {
+		return fmt.Errorf("erro ao lançar pagamento com cartão: %w", err)
+	}
+	if taxa.Taxa > 0 {
+		if _, err := ledger.Lancar(tx, ledger.Taxa(idDoacao, idPagamento, taxa.Taxa)); err != nil {
+			return fmt.Errorf("erro ao lançar taxa: %w", err)
+		}
+	}
+	return nil
+}
+
+// registrarEstornoCartao tira da campanha o valor estornado ao portador e devolve a taxa da plataforma. Num
+// chargeback perdido o valor já tinha saído da campanha na contestação e sai da retenção.
+func registrarEstornoCartao(tx *sql.Tx, idPagamento, idDoacao string, valor, taxa money.Centavos, retido bool) error {
+	if _, err := tx.Exec(`
+		UPDATE core.pagamento_cartao SET estornado_em = now(), contestacao_retida = false WHERE id = $1
+	`, idPagamento); err != nil {
+		return err
+	}
+	estorno := ledger.CartaoEstornado(idDoacao, idPagamento, valor)
+	if retido {
+		estorno = ledger.CartaoEstornadoRetido(idDoacao, idPagamento, valor)
+	}
+	if _, err := ledger.Lancar(tx, estorno); err != nil {
+		return fmt.Errorf("erro ao lançar estorno do cartão: %w", err)
+	}
+	if taxa > 0 {
+		if _, err := ledger.Lancar(tx, ledger.EstornoTaxa(idDoacao, idPagamento, taxa)); err != nil {
+			return fmt.Errorf("erro ao lançar estorno da taxa: %w", err)
+		}
+	}
+	return nil
+}
+
+// contestacaoReferencia identifica no razão a n-ésima contestação do pagamento
+func contestacaoReferencia(idPagamento string, n int) string {
+	return fmt.Sprintf("%s:%d", idPagamento, n)
+}
+
+// reterContestacaoCartao retém o valor do pagamento contestado, que deixa de estar disponível para saque
+func reterContestacaoCartao(tx *sql.Tx, idPagamento, idDoacao string, valor money.Centavos, n int) error {
+	if _, err := tx.Exec(`
+		UPDATE core.pagamento_cartao SET contestacoes = $2, contestacao_retida = true WHERE id = $1
+	`, idPagamento, n); err != nil {
+		return err
+	}
+	if _, err := ledger.Lancar(tx, ledger.CartaoRetido(idDoacao, contestacaoReferencia(idPagamento, n), valor)); err != nil {
+		return fmt.Errorf("erro ao lançar retenção da contestação: %w", err)
+	}
+	return nil
+}
+
+// liberarContestacaoCartao devolve à campanha o valor retido quando a contestação é decidida a favor dela
+func liberarContestacaoCartao(tx *sql.Tx, idPagamento, idDoacao string, valor money.Centavos, n int) error {
+	if _, err := tx.Exec(`UPDATE core.pagamento_cartao SET contestacao_retida = false WHERE id = $1`, idPagamento); err != nil {
+		return err
+	}
+	if _, err := ledger.Lancar(tx, ledger.CartaoLiberado(idDoacao, contestacaoReferencia(idPagamento, n), valor)); err != nil {
+		return fmt.Errorf("erro ao lançar liberação da contestação: %w", err)
+	}
+	return nil
+}
+
+// CartaoStatusJob consulta o pagamento no provedor até a captura, a recusa ou o cancelamento. Contestações
+// depois da captura chegam pela URL de notificação.
+func CartaoStatusJob(db *sql.DB, providers payment.CardProviders) jobs.Handler {
+	return func(ctx context.Context, job jobs.Job) (time.Duration, error) {
+		idPagamento := job.Chave
+
+		var provedor, status string
+		var idCobranca sql.NullString
+		var criacao time.Time
+		err := db.QueryRowContext(ctx, `
+			SELECT provedor, id_cobranca, status, date_create FROM core.pagamento_cartao WHERE id = $1
+		`, idPagamento).Scan(&provedor, &idCobranca, &status, &criacao)
+		if err == sql.ErrNoRows {
+			log.Println("Pagamento com cartão não encontrado para o job de status:", idPagamento)
+			return 0, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		if (&payment.CardCharge{Status: status}).Final() {
+			return 0, nil
+		}
+		if !idCobranca.Valid {
+			// Criação sem resposta do provedor: a notificação traz a cobrança, se ela existir; sem notificação no
+			// prazo, a cobrança não foi criada
+			if time.Since(criacao) < cartaoSemCobrancaPrazo {
+				return cartaoStatusIntervaloInicial, nil
+			}
+			recusa := &payment.CardCharge{Status: payment.CartaoRecusado, Motivo: "sem resposta do provedor na criação da cobrança"}
+			if err := atualizarPagamentoCartao(db, idPagamento, recusa, pagamentoOrigemConsulta); err != nil {
+				return 0, fmt.Errorf("erro ao atualizar pagamento com cartão: %w", err)
+			}
+			return 0, nil
+		}
+
+		provider, ok := providers.Provedor(provedor)
+		if !ok {
+			return 0, fmt.Errorf("provedor de cartão %s não configurado", provedor)
+		}
+		charge, err := provider.DetailCardCharge(idCobranca.String)
+		if err != nil {
+			return 0, fmt.Errorf("erro ao consultar pagamento com cartão: %w", err)
+		}
+		if err := atualizarPagamentoCartao(db, idPagamento, charge, pagamentoOrigemConsulta); err != nil {
+			return 0, fmt.Errorf("erro ao atualizar pagamento com cartão: %w", err)
+		}
+		if charge.Final() {
+			return 0, nil
+		}
+
+		if time.Since(criacao) > cartaoStatusPrazo {
+			log.Println("Pagamento com cartão sem conclusão no prazo de consulta:", idPagamento)
+			return 0, nil
+		}
+		if time.Since(criacao) < cartaoStatusFaseInicial {
+			return cartaoStatusIntervaloInicial, nil
+		}
+		return cartaoStatusIntervalo, nil
+	}
+}
+
+// PagamentoNotificacaoHandler recebe as notificações do provedor de cartão ({provedor} na URL). O token é
+// consultado no próprio provedor, e cada cobrança alterada é detalhada antes de atualizar o pagamento, então uma
+// notificação forjada só provoca uma nova consulta.
+func PagamentoNotificacaoHandler(db *sql.DB, providers payment.CardProviders) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		provedor := mux.Vars(r)["provedor"]
+		provider, ok := providers.Provedor(provedor)
+		if !ok {
+			http.Error(w, "Provedor não encontrado", http.StatusNotFound)
+			return
+		}
+
+		token := r.FormValue("notification")
+		if token == "" {
+			http.Error(w, "notification é obrigatório", http.StatusBadRequest)
+			return
+		}
+
+		ids, err := provider.Notification(token)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Erro ao consultar notificação: %v", err), http.StatusBadGateway)
+			return
+		}
+
+		for _, idCobranca := range ids {
+			charge, err := provider.DetailCardCharge(idCobranca)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Erro ao consultar cobrança %s: %v", idCobranca, err), http.StatusBadGateway)
+				return
+			}
+
+			var idPagamento string
+			err = db.QueryRow(`
+				SELECT id FROM core.pagamento_cartao
+				WHERE provedor = $1 AND (id_cobranca = $2 OR id::TEXT = $3)
+				LIMIT 1
+			`, provedor, charge.ID, charge.CustomID).Scan(&idPagamento)
+			if err == sql.ErrNoRows {
+				log.Printf("Notificação de cobrança %s sem pagamento correspondente\n", charge.ID)
+				continue
+			}
+			if err != nil {
+				http.Error(w, "Erro ao buscar pagamento: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			if err := atualizarPagamentoCartao(db, idPagamento, charge, pagamentoOrigemNotificacao); err != nil {
+				http.Error(w, "Erro ao atualizar pagamento: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// PagamentoFakeStatusHandler muda o status de um pagamento no provedor fake e processa como uma notificação,
+// permitindo testar offline captura, contestação e estorno. Só é registrada com PIX_PROVIDER=fake.
+func PagamentoFakeStatusHandler(db *sql.DB, provider *payment.FakeCardProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		idPagamento := vars["id"]
+
+		var idCobranca string
+		err := db.QueryRow(`
+			SELECT COALESCE(id_cobranca, '') FROM core.pagamento_cartao WHERE id = $1
+		`, idPagamento).Scan(&idCobranca)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Pagamento não encontrado", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Erro ao buscar pagamento: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		charge, err := provider.SetStatus(idCobranca, strings.ToUpper(vars["status"]))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := atualizarPagamentoCartao(db, idPagamento, charge, pagamentoOrigemNotificacao); err != nil {
+			http.Error(w, "Erro ao atualizar pagamento: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		p, err := buscarPagamentoCartao(db, idPagamento)
+		if err != nil {
+			http.Error(w, "Erro ao buscar pagamento: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		jsonResponse(w, http.StatusOK, p)
+	}
+}
@@ -0,0 +1,247 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"BACK_SORTE_GO/config"
+	"BACK_SORTE_GO/jobs"
+	"BACK_SORTE_GO/mail"
+	"BACK_SORTE_GO/utils"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// JobPasswordReset gera o token de recuperação e envia o e-mail (chave = e-mail informado). Fora da requisição,
+// o tempo de resposta não muda entre e-mails cadastrados e não cadastrados.
+const JobPasswordReset = "PASSWORD_RESET"
+
+const (
+	// Validade do token de recuperação de senha
+	passwordResetTTL = time.Hour
+	// Intervalo mínimo entre pedidos, por usuário e por IP
+	passwordResetInterval = "2 minutes"
+	// Máximo de pedidos em 24h, por usuário e por IP
+	passwordResetMaxPerDay = 5
+)
+
+// PasswordResetRequestHandler agenda o envio do link de recuperação de senha.
+// A resposta é sempre a mesma para não revelar quais e-mails estão cadastrados; o limite por IP responde 429
+// e o limite por usuário é aplicado no job, sem aparecer na resposta.
+func PasswordResetRequestHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Email string `json:"email"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Erro ao processar o JSON", http.StatusBadRequest)
+			return
+		}
+		req.Email = strings.TrimSpace(req.Email)
+		if req.Email == "" {
+			http.Error(w, "O campo email é obrigatório", http.StatusBadRequest)
+			return
+		}
+
+		ip := utils.ClientIP(r)
+		var pedidosDia int
+		var pedidoRecente bool
+		err := db.QueryRow(`
+			SELECT COUNT(*), COALESCE(BOOL_OR(date_create > now() - $2::INTERVAL), false)
+			FROM core.password_reset_request
+			WHERE ip = $1 AND date_create > now() - INTERVAL '24 hours'
+		`, ip, passwordResetInterval).Scan(&pedidosDia, &pedidoRecente)
+		if err != nil {
+			http.Error(w, "Erro ao verificar pedidos anteriores: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if pedidosDia >= passwordResetMaxPerDay || pedidoRecente {
+			http.Error(w, "Muitos pedidos de redefinição, tente novamente mais tarde", http.StatusTooManyRequests)
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			http.Error(w, "Erro ao iniciar transação: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		if _, err := tx.Exec(`
+			INSERT INTO core.password_reset_request (ip, date_create) VALUES ($1, now())
+		`, ip); err != nil {
+			http.Error(w, "Erro ao registrar pedido: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if _, err := jobs.Enqueue(tx, JobPasswordReset, req.Email, map[string]string{"ip": ip}, time.Now()); err != nil {
+			http.Error(w, "Erro ao agendar envio: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			http.Error(w, "Erro ao finalizar transação: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		jsonResponse(w, http.StatusOK, map[string]string{
+			"message": "Se o e-mail estiver cadastrado, você receberá as instruções para redefinir a senha",
+		})
+	}
+}
+
+// PasswordResetJob busca o usuário do e-mail, aplica o limite por usuário, grava o token e envia o link.
+// O token só é gravado se o e-mail sair: numa falha de envio a transação é desfeita e o job tenta de novo.
+func PasswordResetJob(db *sql.DB, sender mail.Sender) jobs.Handler {
+	return func(ctx context.Context, job jobs.Job) (time.Duration, error) {
+		email := job.Chave
+		var payload struct {
+			IP string `json:"ip"`
+		}
+		if len(job.Payload) > 0 {
+			if err := json.Unmarshal(job.Payload, &payload); err != nil {
+				return 0, fmt.Errorf("payload inválido: %w", err)
+			}
+		}
+
+		var userID, name string
+		err := db.QueryRowContext(ctx, `
+			SELECT id, name FROM core.user WHERE email = $1 AND dell = false
+		`, email).Scan(&userID, &name)
+		if err == sql.ErrNoRows {
+			return 0, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		var enviosDia int
+		var envioRecente bool
+		err = db.QueryRowContext(ctx, `
+			SELECT COUNT(*), COALESCE(BOOL_OR(date_create > now() - $2::INTERVAL), false)
+			FROM core.password_reset
+			WHERE id_user = $1 AND date_create > now() - INTERVAL '24 hours'
+		`, userID, passwordResetInterval).Scan(&enviosDia, &envioRecente)
+		if err != nil {
+			return 0, err
+		}
+		if enviosDia >= passwordResetMaxPerDay || envioRecente {
+			log.Println("Pedido de redefinição de senha ignorado pelo limite por usuário:", userID)
+			return 0, nil
+		}
+
+		token, err := utils.GenerateRandomToken(32)
+		if err != nil {
+			return 0, err
+		}
+
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return 0, err
+		}
+		defer tx.Rollback()
+
+		// Invalida tokens anteriores ainda não usados
+		if _, err := tx.Exec(`
+			UPDATE core.password_reset SET used_at = now()
+			WHERE id_user = $1 AND used_at IS NULL
+		`, userID); err != nil {
+			return 0, fmt.Errorf("erro ao invalidar tokens anteriores: %w", err)
+		}
+		if _, err := tx.Exec(`
+			INSERT INTO core.password_reset (id, id_user, token_hash, expires_at, ip, date_create)
+			VALUES ($1, $2, $3, $4, NULLIF($5, ''), now())
+		`, uuid.NewString(), userID, utils.HashToken(token), time.Now().Add(passwordResetTTL), payload.IP); err != nil {
+			return 0, fmt.Errorf("erro ao salvar token: %w", err)
+		}
+
+		link := fmt.Sprintf("%s/reset-password?token=%s", config.GetAppURL(), token)
+		err = sender.Send(mail.Message{
+			To:      email,
+			Subject: "Redefinição de senha",
+			Body: fmt.Sprintf("Olá %s,\n\nRecebemos um pedido para redefinir sua senha. Acesse o link abaixo em até %d minutos:\n\n%s\n\nSe você não fez este pedido, ignore este e-mail.",
+				name, int(passwordResetTTL.Minutes()), link),
+		})
+		if err != nil {
+			return 0, fmt.Errorf("erro ao enviar e-mail de redefinição de senha: %w", err)
+		}
+
+		return 0, tx.Commit()
+	}
+}
+
+// PasswordResetConfirmHandler troca a senha a partir de um token de recuperação válido
+func PasswordResetConfirmHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Token       string `json:"token"`
+			NewPassword string `json:"new_password"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Erro ao processar o JSON", http.StatusBadRequest)
+			return
+		}
+		if req.Token == "" || req.NewPassword == "" {
+			http.Error(w, "Os campos token e new_password são obrigatórios", http.StatusBadRequest)
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			http.Error(w, "Erro ao iniciar transação: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		var resetID, userID string
+		err = tx.QueryRow(`
+			SELECT id, id_user FROM core.password_reset
+			WHERE token_hash = $1 AND used_at IS NULL AND expires_at > now()
+			FOR UPDATE
+		`, utils.HashToken(req.Token)).Scan(&resetID, &userID)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Token inválido ou expirado", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Erro ao validar token: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+		if err != nil {
+			http.Error(w, "Erro ao criptografar nova senha", http.StatusInternalServerError)
+			return
+		}
+
+		if _, err := tx.Exec(`UPDATE core.password_reset SET used_at = now() WHERE id = $1`, resetID); err != nil {
+			http.Error(w, "Erro ao invalidar token: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if _, err := tx.Exec(`UPDATE core.user SET password = $1, date_update = NOW() WHERE id = $2`, string(hashedPassword), userID); err != nil {
+			http.Error(w, "Erro ao atualizar a senha", http.StatusInternalServerError)
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			http.Error(w, "Erro ao finalizar transação: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// Encerra sessões abertas com a senha antiga
+		if err := revokeUserSessions(db, userID, "PASSWORD_RESET"); err != nil {
+			http.Error(w, "Erro ao encerrar sessões: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		jsonResponse(w, http.StatusOK, map[string]string{
+			"message": "Senha redefinida com sucesso",
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"BACK_SORTE_GO/brcode"
+	"BACK_SORTE_GO/config"
+	"BACK_SORTE_GO/cpf"
+	"BACK_SORTE_GO/money"
+	"BACK_SORTE_GO/payment"
+
+	"github.com/google/uuid"
+)
+
+// Tipos de cobrança gravados em pix_qrcode_status.tipo_pagamento
+const (
+	tipoPagamentoCob  = "v1"
+	tipoPagamentoCobv = "cobv"
+)
+
+// Dias em que a cobrança com vencimento ainda pode ser paga depois da data de vencimento
+const (
+	cobvValidadePadrao = 30
+	cobvValidadeMaxima = 365
+)
+
+var errVencimentoInvalido = errors.New("vencimento deve ser uma data (AAAA-MM-DD) a partir de hoje")
+
+// CobrancaVencimentoRequest são os campos que transformam a cobrança em cobrança com vencimento (cobv), com
+// multa, juros e desconto no formato da API PIX (ex.: {"multa": {"modalidade": 2, "valorPerc": "2.00"}})
+type CobrancaVencimentoRequest struct {
+	Vencimento             string           `json:"vencimento"`
+	ValidadeAposVencimento *int             `json:"validade_apos_vencimento"`
+	Encargos               payment.Encargos `json:"encargos"`
+}
+
+// ComVencimento indica se foi pedida uma cobrança com vencimento
+func (c CobrancaVencimentoRequest) ComVencimento() bool {
+	return strings.TrimSpace(c.Vencimento) != ""
+}
+
+// dueChargeRequest valida vencimento, validade e encargos e monta a cobv num txid novo para o devedor
+func (c CobrancaVencimentoRequest) dueChargeRequest(valor money.Centavos, chave, nome, documento, tipoDocumento, solicitacao string) (payment.DueChargeRequest, error) {
+	vencimento, err := time.Parse("2006-01-02", strings.TrimSpace(c.Vencimento))
+	if err != nil {
+		return payment.DueChargeRequest{}, errVencimentoInvalido
+	}
+	// O vencimento é um dia no horário de Brasília
+	hoje := time.Now().In(payment.FusoBrasilia)
+	if vencimento.Before(time.Date(hoje.Year(), hoje.Month(), hoje.Day(), 0, 0, 0, 0, time.UTC)) {
+		return payment.DueChargeRequest{}, errVencimentoInvalido
+	}
+
+	validade := cobvValidadePadrao
+	if c.ValidadeAposVencimento != nil {
+		validade = *c.ValidadeAposVencimento
+	}
+	if validade < 0 || validade > cobvValidadeMaxima {
+		return payment.DueChargeRequest{}, fmt.Errorf("validade após o vencimento deve ser de 0 a %d dias", cobvValidadeMaxima)
+	}
+	if err := c.Encargos.Validar(vencimento); err != nil {
+		return payment.DueChargeRequest{}, err
+	}
+
+	req := payment.DueChargeRequest{
+		// txid da cobv: de 26 a 35 caracteres alfanuméricos
+		Txid:                   strings.ReplaceAll(uuid.NewString(), "-", ""),
+		Valor:                  valor.String(),
+		Chave:                  chave,
+		DataVencimento:         vencimento,
+		ValidadeAposVencimento: validade,
+		DevedorNome:            nome,
+		SolicitacaoPagador:     solicitacao,
+		Encargos:               c.Encargos,
+	}
+	if tipoDocumento == cpf.TipoCNPJ {
+		req.DevedorCNPJ = documento
+	} else {
+		req.DevedorCPF = documento
+	}
+	return req, nil
+}
+
+// cobrancaPixCopiaECola devolve o copia e cola da cobrança. Se o provedor não o enviar, monta o BR Code
+// dinâmico a partir do location; sem nome e cidade do recebedor configurados, usa o próprio location.
+func cobrancaPixCopiaECola(charge *payment.Charge) string {
+	if charge.PixCopiaECola != "" || charge.Location == "" {
+		return charge.PixCopiaECola
+	}
+	payload, err := brcode.Pix{
+		URL:           charge.Location,
+		NomeRecebedor: config.GetPixRecebedorNome(),
+		Cidade:        config.GetPixRecebedorCidade(),
+	}.Payload()
+	if err != nil {
+		return charge.Location
+	}
+	return payload
+}
+
+// salvarStatusCobranca grava o acompanhamento da cobrança em pix_qrcode_status, de uma doação (idPixQRCode)
+// ou de uma fatura de plano (idFatura). Na cobv guarda o vencimento, a validade e os encargos.
+func salvarStatusCobranca(tx *sql.Tx, idPixQRCode, idFatura, chave string, charge *payment.Charge, pixCopiaECola string, encargos payment.Encargos) error {
+	tipoPagamento := tipoPagamentoCob
+	var vencimento, validade, encargosJSON interface{}
+	if charge.ComVencimento() {
+		tipoPagamento = tipoPagamentoCobv
+		vencimento = charge.DataVencimento
+		validade = charge.ValidadeAposVencimento
+		if !encargos.Vazio() {
+			b, err := json.Marshal(encargos)
+			if err != nil {
+				return err
+			}
+			encargosJSON = string(b)
+		}
+	}
+
+	_, err := tx.Exec(`
+		INSERT INTO core.pix_qrcode_status (
+			id, id_pix_qrcode, id_conta_nivel_pagamento, data_criacao, expiracao, tipo_pagamento,
+			loc_id, loc_tipo_cob, loc_criacao, location, pix_copia_e_cola,
+			chave, id_pix, status, buscar, finalizado, data_pago,
+			data_vencimento, validade_apos_vencimento, encargos
+		) VALUES (
+			$1, NULLIF($2, '')::UUID, NULLIF($3, '')::UUID, $4, $5, $6,
+			$7, $8, $9, $10, $11,
+			$12, $13, $14, true, false, NULL,
+			$15, $16, $17
+		)
+	`,
+		uuid.NewString(),
+		idPixQRCode,
+		idFatura,
+		charge.Criacao,
+		charge.Expiracao,
+		tipoPagamento,
+		charge.LocID,
+		charge.LocTipoCob,
+		charge.LocCriacao,
+		charge.Location,
+		pixCopiaECola,
+		chave,
+		charge.Txid,
+		charge.Status,
+		vencimento,
+		validade,
+		encargosJSON,
+	)
+	return err
+}
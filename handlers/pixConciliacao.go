@@ -0,0 +1,497 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"BACK_SORTE_GO/jobs"
+	"BACK_SORTE_GO/money"
+	"BACK_SORTE_GO/payment"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// JobPixConciliacao concilia diariamente as cobranças das últimas conciliacaoJanela com a listagem do provedor
+const JobPixConciliacao = "PIX_CONCILIACAO"
+
+const (
+	conciliacaoJanela    = 48 * time.Hour
+	conciliacaoIntervalo = 24 * time.Hour
+	// Período máximo aceito numa conciliação manual
+	conciliacaoPeriodoMaximo = 31 * 24 * time.Hour
+	// Diferença aceita entre o horário do PIX no provedor e data_pago (a consulta de status confirma com atraso)
+	conciliacaoToleranciaPagamento = 15 * time.Minute
+)
+
+// A listagem de cobranças do provedor só traz cobranças imediatas (cob): os PIX do QR Code estático e as
+// cobranças com vencimento ficam fora da comparação
+var tiposPagamentoForaDaListagem = []string{tipoPagamentoQRCodeEstatico, tipoPagamentoCobv}
+
+// Origens da conciliação registradas em core.pix_conciliacao
+const (
+	ConciliacaoOrigemJob     = "JOB"
+	ConciliacaoOrigemManual  = "MANUAL"
+	ConciliacaoOrigemComando = "COMANDO"
+)
+
+// Tipos de divergência. As duas primeiras são corrigidas automaticamente; as demais ficam para os operadores.
+const (
+	conciliacaoConcluidaNaoRegistrada = "CONCLUIDA_NAO_REGISTRADA"
+	conciliacaoAtivaVencida           = "ATIVA_VENCIDA"
+	conciliacaoSemRegistro            = "COBRANCA_SEM_REGISTRO"
+	conciliacaoAusenteNoProvedor      = "COBRANCA_AUSENTE_NO_PROVEDOR"
+	conciliacaoValorDivergente        = "VALOR_DIVERGENTE"
+	conciliacaoValorPagoDivergente    = "VALOR_PAGO_DIVERGENTE"
+	conciliacaoLiquidadaSemPagamento  = "LIQUIDADA_SEM_PAGAMENTO"
+	conciliacaoEndToEndDivergente     = "END_TO_END_DIVERGENTE"
+	conciliacaoDataPagoDivergente     = "DATA_PAGAMENTO_DIVERGENTE"
+	conciliacaoStatusDivergente       = "STATUS_DIVERGENTE"
+)
+
+// ConciliacaoItem é uma divergência encontrada entre o registro local e o provedor
+type ConciliacaoItem struct {
+	Txid           string          `json:"txid"`
+	Tipo           string          `json:"tipo"`
+	Corrigido      bool            `json:"corrigido"`
+	StatusLocal    string          `json:"status_local,omitempty"`
+	StatusProvedor string          `json:"status_provedor,omitempty"`
+	ValorLocal     *money.Centavos `json:"valor_local,omitempty"`
+	ValorProvedor  *money.Centavos `json:"valor_provedor,omitempty"`
+	PagoLocal      *time.Time      `json:"pago_local,omitempty"`
+	PagoProvedor   *time.Time      `json:"pago_provedor,omitempty"`
+	Detalhe        string          `json:"detalhe,omitempty"`
+}
+
+// Conciliacao é uma execução da conciliação com o resumo e, no detalhe, as divergências
+type Conciliacao struct {
+	ID                string            `json:"id"`
+	Inicio            time.Time         `json:"inicio"`
+	Fim               time.Time         `json:"fim"`
+	Origem            string            `json:"origem"`
+	CobrancasProvedor int               `json:"cobrancas_provedor"`
+	CobrancasLocais   int               `json:"cobrancas_locais"`
+	Corrigidas        int               `json:"corrigidas"`
+	Divergencias      int               `json:"divergencias"`
+	Erro              *string           `json:"erro,omitempty"`
+	DateCreate        time.Time         `json:"date_create"`
+	DateFim           *time.Time        `json:"date_fim,omitempty"`
+	Itens             []ConciliacaoItem `json:"itens,omitempty"`
+}
+
+// cobrancaLocal é o estado local de uma cobrança usado na comparação
+type cobrancaLocal struct {
+	Status     string
+	Valor      money.Centavos
+	DataPago   *time.Time
+	Liquidada  bool
+	EndToEndID string
+}
+
+func buscarCobrancaLocal(db *sql.DB, txid string) (*cobrancaLocal, error) {
+	var c cobrancaLocal
+	// data_pago é gravada com now() no fuso da sessão; o cast para TIMESTAMPTZ devolve o instante correto
+	err := db.QueryRow(`
+		SELECT COALESCE(pqs.status, ''), pq.valor, pqs.data_pago::TIMESTAMPTZ, l.txid IS NOT NULL, COALESCE(l.end_to_end_id, '')
+		FROM core.pix_qrcode_status pqs
+		JOIN core.pix_qrcode pq ON pq.id = pqs.id_pix_qrcode
+		LEFT JOIN core.pix_liquidacao l ON l.txid = pqs.id_pix
+		WHERE pqs.id_pix = $1
+		LIMIT 1
+	`, txid).Scan(&c.Status, &c.Valor, &c.DataPago, &c.Liquidada, &c.EndToEndID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// conciliarCobranca compara uma cobrança do provedor com o registro local. Corrige o que é seguro — pagamento
+// não registrado (com o valor pago igual ao da cobrança) e cobrança vencida ainda ativa — e retorna as divergências.
+func conciliarCobranca(db *sql.DB, charge payment.Charge, agora time.Time) ([]ConciliacaoItem, error) {
+	local, err := buscarCobrancaLocal(db, charge.Txid)
+	if err != nil {
+		return nil, err
+	}
+
+	item := ConciliacaoItem{Txid: charge.Txid, StatusProvedor: charge.Status}
+	valorProvedor, errValor := money.Parse(charge.Valor)
+	if errValor == nil {
+		item.ValorProvedor = &valorProvedor
+	}
+
+	if local == nil {
+		item.Tipo = conciliacaoSemRegistro
+		item.Detalhe = "cobrança do provedor sem registro em pix_qrcode_status"
+		return []ConciliacaoItem{item}, nil
+	}
+	item.StatusLocal = local.Status
+	item.ValorLocal = &local.Valor
+	item.PagoLocal = local.DataPago
+
+	if errValor != nil || valorProvedor != local.Valor {
+		item.Tipo = conciliacaoValorDivergente
+		item.Detalhe = fmt.Sprintf("valor no provedor %q", charge.Valor)
+		return []ConciliacaoItem{item}, nil
+	}
+
+	switch charge.Status {
+	case payment.StatusConcluida:
+		var pago money.Centavos
+		var endToEndID string
+		for i, p := range charge.Pix {
+			v, err := money.Parse(p.Valor)
+			if err != nil {
+				continue
+			}
+			pago += v
+			if i == 0 {
+				endToEndID = p.EndToEndID
+				if t, err := time.Parse(time.RFC3339, p.Horario); err == nil {
+					item.PagoProvedor = &t
+				}
+			}
+		}
+
+		if !local.Liquidada {
+			if pago != valorProvedor {
+				item.Tipo = conciliacaoValorPagoDivergente
+				item.Detalhe = fmt.Sprintf("valor pago %s diferente da cobrança %s", pago, valorProvedor)
+				return []ConciliacaoItem{item}, nil
+			}
+			liquidou, err := liquidarPagamento(db, charge.Txid, endToEndID, 0, liquidacaoOrigemConciliacao)
+			if err != nil {
+				return nil, err
+			}
+			if !liquidou {
+				// Liquidada por outro caminho entre a leitura e a correção
+				return nil, nil
+			}
+			item.Tipo = conciliacaoConcluidaNaoRegistrada
+			item.Corrigido = true
+			item.Detalhe = "pagamento liquidado pela conciliação"
+			return []ConciliacaoItem{item}, nil
+		}
+
+		var itens []ConciliacaoItem
+		if local.EndToEndID != "" && endToEndID != "" && local.EndToEndID != endToEndID {
+			e := item
+			e.Tipo = conciliacaoEndToEndDivergente
+			e.Detalhe = fmt.Sprintf("endToEndId local %s, no provedor %s", local.EndToEndID, endToEndID)
+			itens = append(itens, e)
+		}
+		if local.DataPago != nil && item.PagoProvedor != nil {
+			diff := local.DataPago.Sub(*item.PagoProvedor)
+			if diff < -conciliacaoToleranciaPagamento || diff > conciliacaoToleranciaPagamento {
+				d := item
+				d.Tipo = conciliacaoDataPagoDivergente
+				d.Detalhe = fmt.Sprintf("diferença de %s", diff.Round(time.Second))
+				itens = append(itens, d)
+			}
+		}
+		return itens, nil
+
+	case payment.StatusAtiva, payment.StatusRemovidaRecebedor, payment.StatusRemovidaPSP:
+		if local.Liquidada {
+			item.Tipo = conciliacaoLiquidadaSemPagamento
+			item.Detalhe = "pagamento liquidado localmente sem PIX concluído no provedor"
+			return []ConciliacaoItem{item}, nil
+		}
+
+		vencida := charge.Status != payment.StatusAtiva ||
+			agora.After(charge.Criacao.Add(time.Duration(charge.Expiracao)*time.Second))
+		if vencida {
+			if local.Status == "VENCIDO" {
+				return nil, nil
+			}
+			if err := marcarPagamentoVencido(db, charge.Txid); err != nil {
+				return nil, err
+			}
+			item.Tipo = conciliacaoAtivaVencida
+			item.Corrigido = true
+			item.Detalhe = "cobrança vencida no provedor marcada como VENCIDO"
+			return []ConciliacaoItem{item}, nil
+		}
+
+		if local.Status == "VENCIDO" {
+			item.Tipo = conciliacaoStatusDivergente
+			item.Detalhe = "cobrança vencida localmente ainda ativa no provedor"
+			return []ConciliacaoItem{item}, nil
+		}
+		return nil, nil
+	}
+
+	item.Tipo = conciliacaoStatusDivergente
+	item.Detalhe = "status desconhecido no provedor"
+	return []ConciliacaoItem{item}, nil
+}
+
+func gravarConciliacaoItem(db *sql.DB, idConciliacao string, item ConciliacaoItem) error {
+	_, err := db.Exec(`
+		INSERT INTO core.pix_conciliacao_item (id_conciliacao, txid, tipo, corrigido, status_local, status_provedor,
+			valor_local, valor_provedor, pago_local, pago_provedor, detalhe, date_create)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, ''), $7, $8, $9, $10, NULLIF($11, ''), now())
+	`, idConciliacao, item.Txid, item.Tipo, item.Corrigido, item.StatusLocal, item.StatusProvedor,
+		item.ValorLocal, item.ValorProvedor, item.PagoLocal, item.PagoProvedor, item.Detalhe)
+	return err
+}
+
+// ConciliarPix percorre a listagem de cobranças do provedor criadas entre inicio e fim, compara status, valor e
+// horário do pagamento com os registros locais, corrige as divergências seguras e grava o relatório em
+// core.pix_conciliacao. Cobranças locais do período que não aparecem no provedor também são relatadas.
+func ConciliarPix(db *sql.DB, provider payment.PixProvider, inicio, fim time.Time, origem string) (*Conciliacao, error) {
+	c := &Conciliacao{ID: uuid.NewString(), Inicio: inicio, Fim: fim, Origem: origem, DateCreate: time.Now()}
+	_, err := db.Exec(`
+		INSERT INTO core.pix_conciliacao (id, inicio, fim, origem, date_create) VALUES ($1, $2, $3, $4, $5)
+	`, c.ID, inicio, fim, origem, c.DateCreate)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conciliar(db, provider, c); err != nil {
+		msg := err.Error()
+		c.Erro = &msg
+		if _, errUpdate := db.Exec(`
+			UPDATE core.pix_conciliacao
+			SET cobrancas_provedor = $1, corrigidas = $2, divergencias = $3, erro = $4, date_fim = now()
+			WHERE id = $5
+		`, c.CobrancasProvedor, c.Corrigidas, c.Divergencias, msg, c.ID); errUpdate != nil {
+			log.Println("Erro ao registrar falha da conciliação:", errUpdate)
+		}
+		return c, err
+	}
+
+	_, err = db.Exec(`
+		UPDATE core.pix_conciliacao
+		SET cobrancas_provedor = $1, cobrancas_locais = $2, corrigidas = $3, divergencias = $4, date_fim = now()
+		WHERE id = $5
+	`, c.CobrancasProvedor, c.CobrancasLocais, c.Corrigidas, c.Divergencias, c.ID)
+	return c, err
+}
+
+func conciliar(db *sql.DB, provider payment.PixProvider, c *Conciliacao) error {
+	registrar := func(item ConciliacaoItem) error {
+		if err := gravarConciliacaoItem(db, c.ID, item); err != nil {
+			return err
+		}
+		if item.Corrigido {
+			c.Corrigidas++
+		} else {
+			c.Divergencias++
+		}
+		c.Itens = append(c.Itens, item)
+		return nil
+	}
+
+	agora := time.Now()
+	vistos := []string{}
+	for pagina := 0; ; pagina++ {
+		page, err := provider.ListCharges(c.Inicio, c.Fim, pagina)
+		if err != nil {
+			return fmt.Errorf("erro ao listar cobranças (página %d): %w", pagina, err)
+		}
+		for _, charge := range page.Charges {
+			c.CobrancasProvedor++
+			vistos = append(vistos, charge.Txid)
+
+			itens, err := conciliarCobranca(db, charge, agora)
+			if err != nil {
+				return fmt.Errorf("erro ao conciliar %s: %w", charge.Txid, err)
+			}
+			for _, item := range itens {
+				if err := registrar(item); err != nil {
+					return err
+				}
+			}
+		}
+		if pagina+1 >= page.TotalPaginas {
+			break
+		}
+	}
+
+	// data_criacao guarda o horário UTC devolvido pelo provedor
+	err := db.QueryRow(`
+		SELECT COUNT(*) FROM core.pix_qrcode_status
+		WHERE data_criacao BETWEEN $1 AND $2 AND id_pix IS NOT NULL
+		  AND COALESCE(tipo_pagamento, '') <> ALL($3)
+	`, c.Inicio.UTC(), c.Fim.UTC(), pq.Array(tiposPagamentoForaDaListagem)).Scan(&c.CobrancasLocais)
+	if err != nil {
+		return err
+	}
+
+	rows, err := db.Query(`
+		SELECT pqs.id_pix, COALESCE(pqs.status, ''), pq.valor
+		FROM core.pix_qrcode_status pqs
+		JOIN core.pix_qrcode pq ON pq.id = pqs.id_pix_qrcode
+		WHERE pqs.data_criacao BETWEEN $1 AND $2
+		  AND pqs.id_pix IS NOT NULL
+		  AND NOT (pqs.id_pix = ANY($3))
+		  AND COALESCE(pqs.tipo_pagamento, '') <> ALL($4)
+	`, c.Inicio.UTC(), c.Fim.UTC(), pq.Array(vistos), pq.Array(tiposPagamentoForaDaListagem))
+	if err != nil {
+		return err
+	}
+	var ausentes []ConciliacaoItem
+	for rows.Next() {
+		item := ConciliacaoItem{Tipo: conciliacaoAusenteNoProvedor, Detalhe: "cobrança local não encontrada na listagem do provedor"}
+		var valor money.Centavos
+		if err := rows.Scan(&item.Txid, &item.StatusLocal, &valor); err != nil {
+			rows.Close()
+			return err
+		}
+		item.ValorLocal = &valor
+		ausentes = append(ausentes, item)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, item := range ausentes {
+		if err := registrar(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EnqueuePixConciliacaoJob agenda a conciliação diária, se ainda não houver uma pendente
+func EnqueuePixConciliacaoJob(db *sql.DB) error {
+	_, err := jobs.Enqueue(db, JobPixConciliacao, "diaria", nil, time.Now().Add(time.Minute))
+	return err
+}
+
+// PixConciliacaoJob concilia as cobranças das últimas conciliacaoJanela e se reagenda para o dia seguinte
+func PixConciliacaoJob(db *sql.DB, provider payment.PixProvider) jobs.Handler {
+	return func(ctx context.Context, job jobs.Job) (time.Duration, error) {
+		fim := time.Now()
+		c, err := ConciliarPix(db, provider, fim.Add(-conciliacaoJanela), fim, ConciliacaoOrigemJob)
+		if err != nil {
+			return 0, fmt.Errorf("erro na conciliação PIX: %w", err)
+		}
+		if c.Divergencias > 0 {
+			log.Printf("Conciliação PIX %s: %d divergência(s) para análise\n", c.ID, c.Divergencias)
+		}
+		return conciliacaoIntervalo, nil
+	}
+}
+
+// PixConciliacaoHandler executa a conciliação para o período informado e retorna o relatório
+func PixConciliacaoHandler(db *sql.DB, provider payment.PixProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Inicio time.Time `json:"inicio"`
+			Fim    time.Time `json:"fim"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Erro ao processar o JSON (datas no formato RFC 3339)", http.StatusBadRequest)
+			return
+		}
+		if req.Fim.IsZero() {
+			req.Fim = time.Now()
+		}
+		if req.Inicio.IsZero() {
+			req.Inicio = req.Fim.Add(-conciliacaoJanela)
+		}
+		if !req.Fim.After(req.Inicio) || req.Fim.Sub(req.Inicio) > conciliacaoPeriodoMaximo {
+			http.Error(w, "Período inválido: fim deve ser posterior ao início, com no máximo 31 dias", http.StatusBadRequest)
+			return
+		}
+
+		c, err := ConciliarPix(db, provider, req.Inicio, req.Fim, ConciliacaoOrigemManual)
+		if err != nil {
+			http.Error(w, "Erro na conciliação: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		jsonResponse(w, http.StatusOK, c)
+	}
+}
+
+// PixConciliacaoListHandler lista as últimas conciliações executadas
+func PixConciliacaoListHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rows, err := db.Query(`
+			SELECT id, inicio, fim, origem, cobrancas_provedor, cobrancas_locais, corrigidas, divergencias, erro,
+				date_create, date_fim
+			FROM core.pix_conciliacao
+			ORDER BY date_create DESC
+			LIMIT 50
+		`)
+		if err != nil {
+			http.Error(w, "Erro ao buscar conciliações: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		conciliacoes := []Conciliacao{}
+		for rows.Next() {
+			var c Conciliacao
+			if err := rows.Scan(&c.ID, &c.Inicio, &c.Fim, &c.Origem, &c.CobrancasProvedor, &c.CobrancasLocais,
+				&c.Corrigidas, &c.Divergencias, &c.Erro, &c.DateCreate, &c.DateFim); err != nil {
+				http.Error(w, "Erro ao ler conciliações: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			conciliacoes = append(conciliacoes, c)
+		}
+
+		jsonResponse(w, http.StatusOK, conciliacoes)
+	}
+}
+
+// PixConciliacaoDetailHandler retorna uma conciliação com as divergências. Com ?pendentes=true, só as não corrigidas.
+func PixConciliacaoDetailHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+
+		var c Conciliacao
+		err := db.QueryRow(`
+			SELECT id, inicio, fim, origem, cobrancas_provedor, cobrancas_locais, corrigidas, divergencias, erro,
+				date_create, date_fim
+			FROM core.pix_conciliacao
+			WHERE id = $1
+		`, id).Scan(&c.ID, &c.Inicio, &c.Fim, &c.Origem, &c.CobrancasProvedor, &c.CobrancasLocais,
+			&c.Corrigidas, &c.Divergencias, &c.Erro, &c.DateCreate, &c.DateFim)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Conciliação não encontrada", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, "Erro ao buscar conciliação: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		rows, err := db.Query(`
+			SELECT txid, tipo, corrigido, COALESCE(status_local, ''), COALESCE(status_provedor, ''),
+				valor_local, valor_provedor, pago_local, pago_provedor, COALESCE(detalhe, '')
+			FROM core.pix_conciliacao_item
+			WHERE id_conciliacao = $1 AND ($2 = false OR corrigido = false)
+			ORDER BY date_create
+		`, id, r.URL.Query().Get("pendentes") == "true")
+		if err != nil {
+			http.Error(w, "Erro ao buscar divergências: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		c.Itens = []ConciliacaoItem{}
+		for rows.Next() {
+			var item ConciliacaoItem
+			if err := rows.Scan(&item.Txid, &item.Tipo, &item.Corrigido, &item.StatusLocal, &item.StatusProvedor,
+				&item.ValorLocal, &item.ValorProvedor, &item.PagoLocal, &item.PagoProvedor, &item.Detalhe); err != nil {
+				http.Error(w, "Erro ao ler divergências: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			c.Itens = append(c.Itens, item)
+		}
+
+		jsonResponse(w, http.StatusOK, c)
+	}
+}
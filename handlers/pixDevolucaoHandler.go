@@ -0,0 +1,547 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"BACK_SORTE_GO/jobs"
+	"BACK_SORTE_GO/ledger"
+	"BACK_SORTE_GO/mail"
+	"BACK_SORTE_GO/middleware"
+	"BACK_SORTE_GO/money"
+	"BACK_SORTE_GO/payment"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// JobPixDevolucao solicita a devolução ao PSP e acompanha até ser realizada (chave = id da devolução)
+const JobPixDevolucao = "PIX_DEVOLUCAO"
+
+// Intervalo entre as consultas de uma devolução em processamento
+const devolucaoConsultaIntervalo = 30 * time.Second
+
+var (
+	errDevolucaoValorInvalido = errors.New("valor da devolução deve ser maior que zero e até o valor ainda não devolvido")
+	errDevolucaoSemEndToEnd   = errors.New("pagamento sem endToEndId, não é possível devolver pelo PSP")
+	errDevolucaoSemSaldo      = errors.New("saldo da campanha insuficiente para a devolução (valor já resgatado)")
+)
+
+// Devolucao é um registro de core.pix_devolucao
+type Devolucao struct {
+	ID            string          `json:"id"`
+	Txid          string          `json:"txid"`
+	IDDoacao      string          `json:"id_doacao"`
+	Valor         money.Centavos  `json:"valor"`
+	TaxaEstornada money.Centavos  `json:"taxa_estornada"`
+	Motivo        string          `json:"motivo"`
+	Status        string          `json:"status"`
+	RtrID         *string         `json:"rtr_id,omitempty"`
+	Erro          *string         `json:"erro,omitempty"`
+	Resposta      json.RawMessage `json:"resposta,omitempty"`
+	DateCreate    time.Time       `json:"date_create"`
+}
+
+type devolucaoRequest struct {
+	// Valor vazio ou zero devolve tudo o que ainda não foi devolvido
+	Valor  money.Centavos `json:"valor"`
+	Motivo string         `json:"motivo"`
+}
+
+// devolucaoIDPSP é o id da devolução na EfiPay (até 35 caracteres alfanuméricos), derivado do id interno
+// para que repetir a solicitação não devolva duas vezes
+func devolucaoIDPSP(id string) string {
+	return strings.ReplaceAll(id, "-", "")
+}
+
+// solicitarDevolucao registra a devolução de um pagamento liquidado, debita a campanha e estorna a taxa
+// proporcional no razão, esconde a mensagem do doador e agenda a chamada ao PSP. Valor zero devolve o restante.
+func solicitarDevolucao(tx *sql.Tx, txid string, valor money.Centavos, motivo, solicitadoPor string) (*Devolucao, error) {
+	var idDoacao, idPixQRCode, endToEndID string
+	var bruto, taxa money.Centavos
+	err := tx.QueryRow(`
+		SELECT id_doacao, id_pix_qrcode, COALESCE(end_to_end_id, ''), valor_bruto, taxa
+		FROM core.pix_liquidacao
+		WHERE txid = $1
+		FOR UPDATE
+	`, txid).Scan(&idDoacao, &idPixQRCode, &endToEndID, &bruto, &taxa)
+	if err == sql.ErrNoRows {
+		return nil, errCobrancaNaoEncontrada
+	}
+	if err != nil {
+		return nil, err
+	}
+	if endToEndID == "" {
+		return nil, errDevolucaoSemEndToEnd
+	}
+
+	// Mesmo travamento do resgate (na própria doação): o saldo não pode ser usado ao mesmo tempo por um saque
+	var idTravado string
+	if err := tx.QueryRow(`SELECT id FROM core.doacao WHERE id = $1 FOR UPDATE`, idDoacao).Scan(&idTravado); err != nil {
+		return nil, err
+	}
+
+	var devolvido, taxaEstornada money.Centavos
+	err = tx.QueryRow(`
+		SELECT COALESCE(SUM(valor), 0), COALESCE(SUM(taxa_estornada), 0)
+		FROM core.pix_devolucao
+		WHERE txid = $1 AND status <> $2
+	`, txid, payment.DevolucaoNaoRealizado).Scan(&devolvido, &taxaEstornada)
+	if err != nil {
+		return nil, err
+	}
+
+	restante := bruto - devolvido
+	if valor == 0 {
+		valor = restante
+	}
+	if valor <= 0 || valor > restante {
+		return nil, errDevolucaoValorInvalido
+	}
+
+	// Taxa estornada proporcional ao valor devolvido; a última devolução estorna o que faltar
+	estorno := taxa - taxaEstornada
+	if valor < restante {
+		estorno = money.Centavos(int64(taxa) * int64(valor) / int64(bruto))
+	}
+	if estorno < 0 {
+		estorno = 0
+	}
+
+	saldo, err := ledger.SaldoCampanha(tx, idDoacao)
+	if err != nil {
+		return nil, err
+	}
+	if saldo < valor-estorno {
+		return nil, errDevolucaoSemSaldo
+	}
+
+	d := &Devolucao{
+		ID:            uuid.NewString(),
+		Txid:          txid,
+		IDDoacao:      idDoacao,
+		Valor:         valor,
+		TaxaEstornada: estorno,
+		Motivo:        motivo,
+		Status:        payment.DevolucaoEmProcessamento,
+		DateCreate:    time.Now(),
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO core.pix_devolucao (id, id_devolucao, txid, id_pix_qrcode, id_doacao, end_to_end_id, valor,
+			taxa_estornada, motivo, status, solicitado_por, date_create, date_update)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, NULLIF($11, '')::UUID, $12, now())
+	`, d.ID, devolucaoIDPSP(d.ID), txid, idPixQRCode, idDoacao, endToEndID, valor, estorno, motivo, d.Status,
+		solicitadoPor, d.DateCreate)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := ledger.Lancar(tx, ledger.Devolucao(idDoacao, d.ID, valor)); err != nil {
+		return nil, err
+	}
+	if estorno > 0 {
+		if _, err := ledger.Lancar(tx, ledger.EstornoTaxa(idDoacao, d.ID, estorno)); err != nil {
+			return nil, err
+		}
+	}
+
+	// Doação devolvida (mesmo que parcialmente) deixa de aparecer nas mensagens da campanha
+	if _, err := tx.Exec(`UPDATE core.pix_qrcode SET visivel = false WHERE id = $1`, idPixQRCode); err != nil {
+		return nil, err
+	}
+
+	if _, err := jobs.Enqueue(tx, JobPixDevolucao, d.ID, nil, time.Now()); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// PixDevolucaoJob solicita a devolução ao PSP (idempotente pelo id) e consulta até DEVOLVIDO ou NAO_REALIZADO.
+// Quando realizada, o doador é avisado por e-mail se informou um na doação.
+func PixDevolucaoJob(db *sql.DB, provider payment.PixProvider, mailer mail.Sender) jobs.Handler {
+	return func(ctx context.Context, job jobs.Job) (time.Duration, error) {
+		id := job.Chave
+
+		var status, endToEndID string
+		var rtrID sql.NullString
+		var valor money.Centavos
+		err := db.QueryRowContext(ctx, `
+			SELECT status, end_to_end_id, rtr_id, valor FROM core.pix_devolucao WHERE id = $1
+		`, id).Scan(&status, &endToEndID, &rtrID, &valor)
+		if err == sql.ErrNoRows {
+			log.Println("Devolução não encontrada para o job:", id)
+			return 0, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		if status != payment.DevolucaoEmProcessamento {
+			return 0, nil
+		}
+
+		var refund *payment.Refund
+		if !rtrID.Valid {
+			refund, err = provider.Refund(endToEndID, devolucaoIDPSP(id), valor.String())
+			if err != nil {
+				return 0, fmt.Errorf("erro ao solicitar devolução: %w", err)
+			}
+			if _, err := db.ExecContext(ctx, `
+				UPDATE core.pix_devolucao SET rtr_id = NULLIF($1, ''), resposta = $2, date_update = now() WHERE id = $3
+			`, refund.RtrID, []byte(refund.Raw), id); err != nil {
+				return 0, err
+			}
+		} else {
+			refund, err = provider.DetailRefund(endToEndID, devolucaoIDPSP(id))
+			if err != nil {
+				return 0, fmt.Errorf("erro ao consultar devolução: %w", err)
+			}
+		}
+
+		switch refund.Status {
+		case payment.DevolucaoDevolvido:
+			res, err := db.ExecContext(ctx, `
+				UPDATE core.pix_devolucao SET status = $1, resposta = $2, date_update = now()
+				WHERE id = $3 AND status = $4
+			`, payment.DevolucaoDevolvido, []byte(refund.Raw), id, payment.DevolucaoEmProcessamento)
+			if err != nil {
+				return 0, err
+			}
+			if n, _ := res.RowsAffected(); n == 1 {
+				if err := notificarDevolucao(db, mailer, id); err != nil {
+					log.Println("Erro ao avisar o doador da devolução:", err)
+				}
+			}
+			return 0, nil
+		case payment.DevolucaoNaoRealizado:
+			if err := falharDevolucao(db, id, refund); err != nil {
+				return 0, fmt.Errorf("erro ao registrar devolução não realizada: %w", err)
+			}
+			return 0, nil
+		}
+		return devolucaoConsultaIntervalo, nil
+	}
+}
+
+// falharDevolucao desfaz os lançamentos da devolução recusada pelo PSP e volta a exibir a mensagem do doador
+// se não restar outra devolução para o pagamento
+func falharDevolucao(db *sql.DB, id string, refund *payment.Refund) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var idDoacao, idPixQRCode string
+	var valor, estorno money.Centavos
+	err = tx.QueryRow(`
+		UPDATE core.pix_devolucao
+		SET status = $1, resposta = $2, error = 'Devolução não realizada pelo PSP', date_update = now()
+		WHERE id = $3 AND status = $4
+		RETURNING id_doacao, id_pix_qrcode, valor, taxa_estornada
+	`, payment.DevolucaoNaoRealizado, []byte(refund.Raw), id, payment.DevolucaoEmProcessamento).Scan(&idDoacao, &idPixQRCode, &valor, &estorno)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := ledger.Lancar(tx, ledger.DevolucaoFalhou(idDoacao, id, valor, estorno)); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE core.pix_qrcode SET visivel = true
+		WHERE id = $1
+		  AND NOT EXISTS (SELECT 1 FROM core.pix_devolucao WHERE id_pix_qrcode = $1 AND status <> $2)
+	`, idPixQRCode, payment.DevolucaoNaoRealizado)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// notificarDevolucao envia ao doador o aviso da devolução, quando ele informou e-mail ao doar
+func notificarDevolucao(db *sql.DB, mailer mail.Sender, id string) error {
+	var email, nome, campanha string
+	var valor money.Centavos
+	err := db.QueryRow(`
+		SELECT COALESCE(pq.email, ''), pq.nome, COALESCE(d.name, ''), pd.valor
+		FROM core.pix_devolucao pd
+		JOIN core.pix_qrcode pq ON pq.id = pd.id_pix_qrcode
+		LEFT JOIN core.doacao d ON d.id = pd.id_doacao
+		WHERE pd.id = $1
+	`, id).Scan(&email, &nome, &campanha, &valor)
+	if err != nil {
+		return err
+	}
+	if email == "" {
+		return nil
+	}
+
+	return mailer.Send(mail.Message{
+		To:      email,
+		Subject: "Sua doação foi devolvida",
+		Body: fmt.Sprintf("Olá %s,\n\nDevolvemos R$ %s da sua doação para a campanha \"%s\". O valor volta para a conta de origem do PIX.",
+			nome, valor.Format(), campanha),
+	})
+}
+
+// podeDevolver verifica se o usuário é o dono da campanha do pagamento ou um operador
+func podeDevolver(db *sql.DB, principal middleware.Principal, txid string) (bool, error) {
+	if principal.HasRole(middleware.RoleOperator) {
+		return true, nil
+	}
+	var idUser string
+	err := db.QueryRow(`
+		SELECT d.id_user
+		FROM core.pix_liquidacao l
+		JOIN core.doacao d ON d.id = l.id_doacao
+		WHERE l.txid = $1
+	`, txid).Scan(&idUser)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return idUser == principal.UserID, nil
+}
+
+// devolucaoErroStatus traduz os erros de solicitarDevolucao em status HTTP
+func devolucaoErroStatus(err error) int {
+	switch err {
+	case errCobrancaNaoEncontrada:
+		return http.StatusNotFound
+	case errDevolucaoValorInvalido:
+		return http.StatusBadRequest
+	case errDevolucaoSemEndToEnd, errDevolucaoSemSaldo:
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// PixDevolucaoHandler devolve ao doador, total ou parcialmente, um pagamento liquidado.
+// Pode ser usado pelo dono da campanha ou por um operador.
+func PixDevolucaoHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := middleware.GetPrincipal(r)
+		if !ok {
+			http.Error(w, "Usuário não autenticado", http.StatusUnauthorized)
+			return
+		}
+		txid := mux.Vars(r)["txid"]
+
+		var req devolucaoRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Erro ao processar o JSON", http.StatusBadRequest)
+			return
+		}
+		req.Motivo = strings.TrimSpace(req.Motivo)
+		if req.Motivo == "" {
+			http.Error(w, "motivo é obrigatório", http.StatusBadRequest)
+			return
+		}
+		if len([]rune(req.Motivo)) > 255 {
+			http.Error(w, "motivo deve ter até 255 caracteres", http.StatusBadRequest)
+			return
+		}
+
+		permitido, err := podeDevolver(db, principal, txid)
+		if err != nil {
+			http.Error(w, "Erro ao verificar pagamento: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !permitido {
+			http.Error(w, "Você não tem permissão para devolver este pagamento", http.StatusForbidden)
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			http.Error(w, "Erro ao iniciar transação: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		devolucao, err := solicitarDevolucao(tx, txid, req.Valor, req.Motivo, principal.UserID)
+		if err != nil {
+			http.Error(w, err.Error(), devolucaoErroStatus(err))
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			http.Error(w, "Erro ao finalizar transação: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		jsonResponse(w, http.StatusAccepted, devolucao)
+	}
+}
+
+// PixDevolucaoListHandler lista as devoluções de um pagamento e o valor que ainda pode ser devolvido
+func PixDevolucaoListHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := middleware.GetPrincipal(r)
+		if !ok {
+			http.Error(w, "Usuário não autenticado", http.StatusUnauthorized)
+			return
+		}
+		txid := mux.Vars(r)["txid"]
+
+		permitido, err := podeDevolver(db, principal, txid)
+		if err != nil {
+			http.Error(w, "Erro ao verificar pagamento: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !permitido {
+			http.Error(w, "Pagamento não encontrado", http.StatusNotFound)
+			return
+		}
+
+		rows, err := db.Query(`
+			SELECT id, txid, id_doacao, valor, taxa_estornada, COALESCE(motivo, ''), status, rtr_id, error, resposta, date_create
+			FROM core.pix_devolucao
+			WHERE txid = $1
+			ORDER BY date_create
+		`, txid)
+		if err != nil {
+			http.Error(w, "Erro ao buscar devoluções: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		devolucoes := []Devolucao{}
+		var devolvido money.Centavos
+		for rows.Next() {
+			var d Devolucao
+			var resposta []byte
+			if err := rows.Scan(&d.ID, &d.Txid, &d.IDDoacao, &d.Valor, &d.TaxaEstornada, &d.Motivo, &d.Status,
+				&d.RtrID, &d.Erro, &resposta, &d.DateCreate); err != nil {
+				http.Error(w, "Erro ao ler devoluções: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			d.Resposta = resposta
+			if d.Status != payment.DevolucaoNaoRealizado {
+				devolvido += d.Valor
+			}
+			devolucoes = append(devolucoes, d)
+		}
+
+		var bruto money.Centavos
+		if err := db.QueryRow(`SELECT valor_bruto FROM core.pix_liquidacao WHERE txid = $1`, txid).Scan(&bruto); err != nil {
+			http.Error(w, "Erro ao buscar pagamento: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		jsonResponse(w, http.StatusOK, map[string]interface{}{
+			"txid":       txid,
+			"valor_pago": bruto,
+			"devolvido":  devolvido,
+			"restante":   bruto - devolvido,
+			"devolucoes": devolucoes,
+		})
+	}
+}
+
+// DonationDevolverTudoHandler devolve aos doadores tudo o que ainda não foi devolvido de uma campanha removida.
+// Todas as devoluções são registradas na mesma transação: se o saldo não cobrir alguma, nenhuma é feita.
+func DonationDevolverTudoHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := middleware.GetPrincipal(r)
+		if !ok {
+			http.Error(w, "Usuário não autenticado", http.StatusUnauthorized)
+			return
+		}
+		idDoacao := mux.Vars(r)["id"]
+
+		var req devolucaoRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Erro ao processar o JSON", http.StatusBadRequest)
+			return
+		}
+		req.Motivo = strings.TrimSpace(req.Motivo)
+		if req.Motivo == "" {
+			req.Motivo = "Campanha removida"
+		}
+
+		var removida bool
+		err := db.QueryRow(`SELECT dell FROM core.doacao WHERE id = $1`, idDoacao).Scan(&removida)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Doação não encontrada", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, "Erro ao buscar doação: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !removida {
+			http.Error(w, "Só é possível devolver todos os pagamentos de uma campanha removida", http.StatusConflict)
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			http.Error(w, "Erro ao iniciar transação: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		rows, err := tx.Query(`
+			SELECT l.txid
+			FROM core.pix_liquidacao l
+			WHERE l.id_doacao = $1
+			  AND COALESCE(l.end_to_end_id, '') <> ''
+			  AND l.valor_bruto > COALESCE((
+				SELECT SUM(d.valor) FROM core.pix_devolucao d WHERE d.txid = l.txid AND d.status <> $2
+			  ), 0)
+			ORDER BY l.date_create
+		`, idDoacao, payment.DevolucaoNaoRealizado)
+		if err != nil {
+			http.Error(w, "Erro ao buscar pagamentos: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var txids []string
+		for rows.Next() {
+			var txid string
+			if err := rows.Scan(&txid); err != nil {
+				rows.Close()
+				http.Error(w, "Erro ao ler pagamentos: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			txids = append(txids, txid)
+		}
+		rows.Close()
+
+		devolucoes := []*Devolucao{}
+		var total money.Centavos
+		for _, txid := range txids {
+			d, err := solicitarDevolucao(tx, txid, 0, req.Motivo, principal.UserID)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Pagamento %s: %s", txid, err.Error()), devolucaoErroStatus(err))
+				return
+			}
+			total += d.Valor
+			devolucoes = append(devolucoes, d)
+		}
+
+		if err := tx.Commit(); err != nil {
+			http.Error(w, "Erro ao finalizar transação: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		jsonResponse(w, http.StatusAccepted, map[string]interface{}{
+			"message":    "Devoluções solicitadas",
+			"total":      total,
+			"devolucoes": devolucoes,
+		})
+	}
+}
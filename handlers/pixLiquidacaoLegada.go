@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"BACK_SORTE_GO/jobs"
+	"BACK_SORTE_GO/money"
+	"BACK_SORTE_GO/payment"
+)
+
+// JobPixLegado confere no provedor uma cobrança marcada CONCLUIDA antes de core.pix_liquidacao (chave = txid).
+// A consulta simulada da versão antiga marcava toda cobrança como CONCLUIDA, então o status local não prova
+// o pagamento: só o que o provedor confirmar é creditado.
+const JobPixLegado = "PIX_LEGADO"
+
+// liquidacaoOrigemMigracao marca as liquidações de cobranças anteriores à tabela, confirmadas por JobPixLegado
+const liquidacaoOrigemMigracao = "MIGRACAO"
+
+// EnqueuePixLegadoJobs agenda a conferência das cobranças de doação CONCLUIDA sem liquidação. Cada cobrança é
+// conferida uma única vez: o job concluído ou com falha fica em core.job e impede um novo agendamento nos
+// próximos reinícios. Retorna quantos jobs foram criados.
+func EnqueuePixLegadoJobs(db *sql.DB) (int64, error) {
+	res, err := db.Exec(`
+		INSERT INTO core.job (tipo, chave, payload, status, attempts, max_attempts, next_run_at, date_create, date_update)
+		SELECT $1, pqs.id_pix, '{}', $2, 0, $3, now(), now(), now()
+		FROM core.pix_qrcode_status pqs
+		JOIN core.pix_qrcode pq ON pq.id = pqs.id_pix_qrcode
+		WHERE pqs.status = 'CONCLUIDA'
+		  AND pqs.id_pix IS NOT NULL
+		  AND pqs.id_conta_nivel_pagamento IS NULL
+		  AND COALESCE(pqs.tipo_pagamento, '') <> $4
+		  AND NOT EXISTS (SELECT 1 FROM core.pix_liquidacao l WHERE l.txid = pqs.id_pix)
+		  AND NOT EXISTS (SELECT 1 FROM core.job j WHERE j.tipo = $1 AND j.chave = pqs.id_pix)
+		ON CONFLICT (tipo, chave) WHERE status IN ('PENDING', 'RUNNING') DO NOTHING
+	`, JobPixLegado, jobs.StatusPending, jobs.DefaultMaxAttempts, tipoPagamentoQRCodeEstatico)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// PixLegadoJob consulta a cobrança no provedor: concluída → liquida com origem MIGRACAO; caso contrário a
+// cobrança volta a ATIVA e passa pelo job de status, que a vence ou continua acompanhando.
+func PixLegadoJob(db *sql.DB, provider payment.PixProvider) jobs.Handler {
+	return func(ctx context.Context, job jobs.Job) (time.Duration, error) {
+		txid := job.Chave
+
+		var status string
+		var vencimento sql.NullTime
+		var liquidada bool
+		err := db.QueryRowContext(ctx, `
+			SELECT COALESCE(pqs.status, ''), pqs.data_vencimento,
+				EXISTS (SELECT 1 FROM core.pix_liquidacao l WHERE l.txid = pqs.id_pix)
+			FROM core.pix_qrcode_status pqs
+			WHERE pqs.id_pix = $1
+			LIMIT 1
+		`, txid).Scan(&status, &vencimento, &liquidada)
+		if err == sql.ErrNoRows {
+			log.Println("Cobrança não encontrada para a conferência de legado:", txid)
+			return 0, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		if liquidada || status != "CONCLUIDA" {
+			return 0, nil
+		}
+
+		var charge *payment.Charge
+		if vencimento.Valid {
+			charge, err = provider.DetailDueCharge(txid)
+		} else {
+			charge, err = provider.DetailCharge(txid)
+		}
+		if err != nil {
+			return 0, fmt.Errorf("erro ao consultar cobrança no provedor: %w", err)
+		}
+
+		if charge.Status == payment.StatusConcluida {
+			endToEndID := ""
+			var valorPago money.Centavos
+			if len(charge.Pix) > 0 {
+				endToEndID = charge.Pix[0].EndToEndID
+				valorPago, _ = money.Parse(charge.Pix[0].Valor)
+			}
+			if _, err := liquidarCobranca(db, txid, endToEndID, valorPago, liquidacaoOrigemMigracao); err != nil {
+				return 0, fmt.Errorf("erro ao liquidar pagamento: %w", err)
+			}
+			return 0, nil
+		}
+
+		log.Printf("Cobrança %s marcada CONCLUIDA sem pagamento no provedor (status %s)", txid, charge.Status)
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return 0, err
+		}
+		defer tx.Rollback()
+		_, err = tx.Exec(`
+			UPDATE core.pix_qrcode_status
+			SET status = 'ATIVA', buscar = true, finalizado = false, data_pago = NULL
+			WHERE id_pix = $1 AND status = 'CONCLUIDA'
+			  AND NOT EXISTS (SELECT 1 FROM core.pix_liquidacao l WHERE l.txid = $1)
+		`, txid)
+		if err != nil {
+			return 0, fmt.Errorf("erro ao reabrir cobrança: %w", err)
+		}
+		_, err = tx.Exec(`
+			UPDATE core.pix_qrcode SET visivel = false
+			WHERE id IN (SELECT id_pix_qrcode FROM core.pix_qrcode_status WHERE id_pix = $1)
+		`, txid)
+		if err != nil {
+			return 0, fmt.Errorf("erro ao ocultar doação não paga: %w", err)
+		}
+		if err := jobs.RunNow(tx, JobPixStatus, txid); err != nil {
+			return 0, err
+		}
+		return 0, tx.Commit()
+	}
+}
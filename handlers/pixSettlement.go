@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"BACK_SORTE_GO/fees"
+	"BACK_SORTE_GO/ledger"
+	"BACK_SORTE_GO/money"
+)
+
+// Origens da liquidação registradas em core.pix_liquidacao
+const (
+	liquidacaoOrigemWebhook     = "WEBHOOK"
+	liquidacaoOrigemConsulta    = "CONSULTA"
+	liquidacaoOrigemConciliacao = "CONCILIACAO"
+)
+
+var errCobrancaNaoEncontrada = errors.New("cobrança não encontrada")
+
+// liquidarCobranca liquida a cobrança do txid pelo tipo: fatura de plano (conta_nivel_pagamento) ou doação.
+// valorPago só é considerado na cobrança com vencimento, em que o PSP acrescenta multa e juros ou aplica o
+// desconto; zero mantém o valor original.
+func liquidarCobranca(db *sql.DB, txid, endToEndID string, valorPago money.Centavos, origem string) (bool, error) {
+	var idFatura sql.NullString
+	err := db.QueryRow(`
+		SELECT id_conta_nivel_pagamento FROM core.pix_qrcode_status WHERE id_pix = $1 LIMIT 1
+	`, txid).Scan(&idFatura)
+	if err == sql.ErrNoRows {
+		return false, errCobrancaNaoEncontrada
+	}
+	if err != nil {
+		return false, err
+	}
+	if idFatura.Valid {
+		return liquidarFatura(db, idFatura.String, txid, endToEndID, valorPago, origem)
+	}
+	return liquidarPagamento(db, txid, endToEndID, valorPago, origem)
+}
+
+// liquidarPagamento conclui a cobrança do txid e lança no razão o valor recebido e a taxa numa única transação.
+// A liquidação é registrada em core.pix_liquidacao (txid único), então chamadas repetidas — webhook, consulta
+// de status ou conciliação — não creditam o valor de novo. Retorna true quando esta chamada fez o crédito.
+func liquidarPagamento(db *sql.DB, txid, endToEndID string, valorPago money.Centavos, origem string) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var idStatus, idPixQRCode, idDoacao string
+	var valor money.Centavos
+	var comVencimento bool
+	err = tx.QueryRow(`
+		SELECT pqs.id, pq.id, pq.id_doacao, pq.valor, pqs.data_vencimento IS NOT NULL
+		FROM core.pix_qrcode_status pqs
+		JOIN core.pix_qrcode pq ON pq.id = pqs.id_pix_qrcode
+		WHERE pqs.id_pix = $1
+		LIMIT 1
+		FOR UPDATE OF pqs
+	`, txid).Scan(&idStatus, &idPixQRCode, &idDoacao, &valor, &comVencimento)
+	if err == sql.ErrNoRows {
+		return false, errCobrancaNaoEncontrada
+	}
+	if err != nil {
+		return false, err
+	}
+
+	// Na cobrança com vencimento a doação vale o que foi pago, com multa, juros ou desconto
+	if comVencimento && valorPago > 0 {
+		valor = valorPago
+	}
+
+	// Taxa pela regra vigente na confirmação; os parâmetros ficam gravados na liquidação
+	taxa, err := fees.Calcular(tx, idDoacao, valor, time.Now())
+	if err != nil {
+		return false, err
+	}
+
+	res, err := tx.Exec(`
+		INSERT INTO core.pix_liquidacao (
+			txid, id_pix_qrcode, id_doacao, end_to_end_id, valor_bruto, taxa, valor_liquido, origem,
+			id_taxa_regra, taxa_percentual, taxa_valor_fixo, taxa_valor_minimo, date_create
+		)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6, $7, $8, NULLIF($9, '')::UUID, $10, $11, $12, now())
+		ON CONFLICT (txid) DO NOTHING
+	`, txid, idPixQRCode, idDoacao, endToEndID, valor, taxa.Taxa, valor-taxa.Taxa, origem,
+		taxa.IDRegra, taxa.Percentual, taxa.ValorFixo, taxa.ValorMinimo)
+	if err != nil {
+		return false, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		fmt.Println("Pagamento PIX já liquidado para id:", txid)
+		return false, nil
+	}
+
+	fmt.Println("Liquida pagamento confirmado PIX para id:", txid)
+
+	_, err = tx.Exec(`
+		UPDATE core.pix_qrcode_status
+		SET status = 'CONCLUIDA', buscar = false, finalizado = true,
+			data_pago = COALESCE(data_pago, now()),
+			end_to_end_id = COALESCE(NULLIF($2, ''), end_to_end_id),
+			valor_pago = $3
+		WHERE id = $1
+	`, idStatus, endToEndID, valor)
+	if err != nil {
+		return false, fmt.Errorf("erro ao atualizar pix_qrcode_status: %w", err)
+	}
+
+	// Somente a doação deste txid passa a aparecer na página da campanha, com o valor efetivamente pago
+	_, err = tx.Exec(`UPDATE core.pix_qrcode SET visivel = true, valor = $2 WHERE id = $1`, idPixQRCode, valor)
+	if err != nil {
+		return false, fmt.Errorf("erro ao atualizar visibilidade do PIX: %w", err)
+	}
+
+	// Lançamentos no razão: PIX recebido (bruto) e taxa da plataforma
+	if _, err := ledger.Lancar(tx, ledger.PixRecebido(idDoacao, txid, valor)); err != nil {
+		return false, fmt.Errorf("erro ao lançar PIX recebido: %w", err)
+	}
+	if taxa.Taxa > 0 {
+		if _, err := ledger.Lancar(tx, ledger.Taxa(idDoacao, txid, taxa.Taxa)); err != nil {
+			return false, fmt.Errorf("erro ao lançar taxa: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
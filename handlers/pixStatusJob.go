@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"BACK_SORTE_GO/jobs"
+	"BACK_SORTE_GO/money"
+	"BACK_SORTE_GO/payment"
+)
+
+// JobPixStatus consulta o status de uma cobrança PIX (chave = txid) até ser paga ou vencer
+const JobPixStatus = "PIX_STATUS"
+
+const (
+	// Consultas a cada 30s nos primeiros minutos, quando a maioria dos pagamentos acontece, e depois a cada minuto
+	pixStatusIntervaloInicial = 30 * time.Second
+	pixStatusIntervalo        = time.Minute
+	pixStatusFaseInicial      = 5 * time.Minute
+	// Cobranças com vencimento ficam abertas por dias: sem webhook, são consultadas a cada hora
+	pixStatusIntervaloVencimento = time.Hour
+	// Margem após a expiração antes de considerar a cobrança vencida
+	pixStatusMargemExpiracao = time.Minute
+)
+
+// enqueuePixStatusJob agenda a consulta de status da cobrança. Com o webhook configurado, a confirmação chega
+// por /pix/webhook e o job só roda após o limite de pagamento (expiração da cob ou validade após o vencimento
+// da cobv), para vencer a cobrança ou pegar uma notificação perdida.
+func enqueuePixStatusJob(q jobs.Execer, txid string, limite time.Time) error {
+	runAt := time.Now().Add(pixStatusIntervaloInicial)
+	if pixWebhookEnabled() {
+		runAt = limite.Add(pixStatusMargemExpiracao)
+	}
+	_, err := jobs.Enqueue(q, JobPixStatus, txid, nil, runAt)
+	return err
+}
+
+// EnqueuePendingPixStatusJobs agenda a consulta para todas as cobranças com buscar = true e finalizado = false
+// que ainda não têm job pendente. Retorna quantos jobs foram criados.
+func EnqueuePendingPixStatusJobs(db *sql.DB) (int64, error) {
+	res, err := db.Exec(`
+		INSERT INTO core.job (tipo, chave, payload, status, attempts, max_attempts, next_run_at, date_create, date_update)
+		SELECT $1, pqs.id_pix, '{}', $2, 0, $3, now(), now(), now()
+		FROM core.pix_qrcode_status pqs
+		WHERE pqs.buscar = true
+		  AND pqs.finalizado = false
+		  AND pqs.id_pix IS NOT NULL
+		ON CONFLICT (tipo, chave) WHERE status IN ('PENDING', 'RUNNING') DO NOTHING
+	`, JobPixStatus, jobs.StatusPending, jobs.DefaultMaxAttempts)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// PixStatusJob consulta a cobrança no provedor: concluída → confirma o pagamento; removida ou fora do prazo de
+// pagamento → vencida; ainda ativa → reagenda. Cobranças com buscar = false ou finalizado = true encerram o job.
+func PixStatusJob(db *sql.DB, provider payment.PixProvider) jobs.Handler {
+	return func(ctx context.Context, job jobs.Job) (time.Duration, error) {
+		txid := job.Chave
+
+		var buscar, finalizado bool
+		var criacao time.Time
+		var expiracao, validade int
+		var vencimento sql.NullTime
+		err := db.QueryRowContext(ctx, `
+			SELECT buscar, finalizado, data_criacao, expiracao, data_vencimento, COALESCE(validade_apos_vencimento, 0)
+			FROM core.pix_qrcode_status
+			WHERE id_pix = $1
+			LIMIT 1
+		`, txid).Scan(&buscar, &finalizado, &criacao, &expiracao, &vencimento, &validade)
+		if err == sql.ErrNoRows {
+			log.Println("Cobrança não encontrada para o job de status:", txid)
+			return 0, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		if !buscar || finalizado {
+			return 0, nil
+		}
+
+		var charge *payment.Charge
+		if vencimento.Valid {
+			charge, err = provider.DetailDueCharge(txid)
+		} else {
+			charge, err = provider.DetailCharge(txid)
+		}
+		if err != nil {
+			return 0, fmt.Errorf("erro ao consultar status PIX: %w", err)
+		}
+
+		switch charge.Status {
+		case payment.StatusConcluida:
+			endToEndID := ""
+			var valorPago money.Centavos
+			if len(charge.Pix) > 0 {
+				endToEndID = charge.Pix[0].EndToEndID
+				valorPago, _ = money.Parse(charge.Pix[0].Valor)
+			}
+			if _, err := liquidarCobranca(db, txid, endToEndID, valorPago, liquidacaoOrigemConsulta); err != nil {
+				return 0, fmt.Errorf("erro ao liquidar pagamento: %w", err)
+			}
+			return 0, nil
+		case payment.StatusRemovidaRecebedor, payment.StatusRemovidaPSP:
+			marcarPagamentoVencido(db, txid)
+			return 0, nil
+		}
+
+		// A cob expira segundos após a criação; a cobv só depois dos dias de validade após o vencimento
+		limite := payment.LimitePagamento(criacao, expiracao, vencimento.Time, validade)
+		restante := time.Until(limite.Add(pixStatusMargemExpiracao))
+		if restante <= 0 {
+			fmt.Println("Cobrança fora do prazo de pagamento sem pagamento concluído:", txid)
+			marcarPagamentoVencido(db, txid)
+			return 0, nil
+		}
+
+		if pixWebhookEnabled() {
+			// Ainda dentro do prazo: volta a verificar só após o limite de pagamento
+			if restante > pixStatusIntervaloInicial {
+				return restante, nil
+			}
+			return pixStatusIntervaloInicial, nil
+		}
+		if vencimento.Valid {
+			return pixStatusIntervaloVencimento, nil
+		}
+		if time.Since(criacao) < pixStatusFaseInicial {
+			return pixStatusIntervaloInicial, nil
+		}
+		return pixStatusIntervalo, nil
+	}
+}
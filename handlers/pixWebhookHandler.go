@@ -0,0 +1,269 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"BACK_SORTE_GO/config"
+	"BACK_SORTE_GO/money"
+	"BACK_SORTE_GO/payment"
+
+	"github.com/gorilla/mux"
+)
+
+// Tamanho máximo aceito para o corpo da notificação
+const pixWebhookMaxBody = 1 << 20
+
+// pixWebhookEnabled indica que a confirmação dos pagamentos é feita pelo webhook
+func pixWebhookEnabled() bool {
+	return config.GetPixWebhookURL() != ""
+}
+
+// pixWebhookSignature é o HMAC incluído como parâmetro na URL registrada na EfiPay
+func pixWebhookSignature(secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("pix-webhook"))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// pixWebhookRegisterURL monta a URL registrada na EfiPay. O parâmetro "ignorar" recebe o sufixo /pix
+// que a EfiPay acrescenta à URL, mantendo a rota /pix/webhook.
+func pixWebhookRegisterURL() string {
+	params := url.Values{}
+	if secret := config.GetPixWebhookSecret(); secret != "" {
+		params.Set("hmac", pixWebhookSignature(secret))
+	}
+	params.Set("ignorar", "")
+	return config.GetPixWebhookURL() + "?" + params.Encode()
+}
+
+// verifyPixWebhook valida a origem da notificação: certificado de cliente da EfiPay (mTLS, quando o listener TLS
+// está configurado) e/ou o HMAC da URL. Sem nenhum dos dois configurados, todas as notificações são recusadas.
+func verifyPixWebhook(r *http.Request) bool {
+	mtls := config.GetPixWebhookClientCA() != ""
+	secret := config.GetPixWebhookSecret()
+	if !mtls && secret == "" {
+		return false
+	}
+
+	if mtls && (r.TLS == nil || len(r.TLS.VerifiedChains) == 0) {
+		return false
+	}
+	if secret != "" {
+		expected := pixWebhookSignature(secret)
+		if !hmac.Equal([]byte(r.URL.Query().Get("hmac")), []byte(expected)) {
+			return false
+		}
+	}
+	return true
+}
+
+// processPixWebhookItem registra a notificação e liquida a cobrança pelo mesmo caminho da consulta de status.
+// Notificações repetidas (mesmo endToEndId) são ignoradas.
+func processPixWebhookItem(db *sql.DB, item payment.ReceivedPix) error {
+	if item.EndToEndID == "" {
+		return fmt.Errorf("notificação sem endToEndId")
+	}
+
+	payload, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+
+	var horario interface{}
+	if t, err := time.Parse(time.RFC3339, item.Horario); err == nil {
+		horario = t
+	}
+	var valor interface{}
+	valorRecebido, errValor := money.Parse(item.Valor)
+	if errValor == nil {
+		valor = valorRecebido
+	}
+
+	res, err := db.Exec(`
+		INSERT INTO core.pix_webhook_event (end_to_end_id, txid, valor, horario, payload, date_create)
+		VALUES ($1, NULLIF($2, ''), $3, $4, $5, now())
+		ON CONFLICT (end_to_end_id) DO NOTHING
+	`, item.EndToEndID, item.Txid, valor, horario, payload)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		// Reenvio: só processa de novo se a tentativa anterior falhou antes de concluir
+		var finalizado bool
+		err := db.QueryRow(`
+			SELECT processado OR erro IS NOT NULL FROM core.pix_webhook_event WHERE end_to_end_id = $1
+		`, item.EndToEndID).Scan(&finalizado)
+		if err != nil {
+			return err
+		}
+		if finalizado {
+			log.Println("Notificação PIX já recebida:", item.EndToEndID)
+			return nil
+		}
+	}
+
+	// PIX recebido sem cobrança (direto na chave) não tem doação associada
+	if item.Txid == "" {
+		return marcarEventoWebhook(db, item.EndToEndID, "PIX sem txid")
+	}
+
+	// Cobrança de doação (pix_qrcode) ou fatura de plano (conta_nivel_pagamento)
+	var valorCobranca money.Centavos
+	var comVencimento bool
+	err = db.QueryRow(`
+		SELECT COALESCE(pq.valor, f.valor), pqs.data_vencimento IS NOT NULL
+		FROM core.pix_qrcode_status pqs
+		LEFT JOIN core.pix_qrcode pq ON pq.id = pqs.id_pix_qrcode
+		LEFT JOIN core.conta_nivel_pagamento f ON f.id = pqs.id_conta_nivel_pagamento
+		WHERE pqs.id_pix = $1
+		LIMIT 1
+	`, item.Txid).Scan(&valorCobranca, &comVencimento)
+	if err == sql.ErrNoRows {
+		// Sem cobrança: pode ser um PIX pago pelo QR Code estático de uma campanha
+		if estatico, err := receberPixQRCodeEstatico(db, item, valorRecebido); estatico || err != nil {
+			return err
+		}
+		return marcarEventoWebhook(db, item.EndToEndID, "txid não encontrado")
+	}
+	if err != nil {
+		return err
+	}
+
+	// Na cobrança com vencimento o PSP calcula multa, juros e desconto, então o valor pago pode diferir do original
+	if errValor != nil || valorRecebido <= 0 || (!comVencimento && valorRecebido != valorCobranca) {
+		return marcarEventoWebhook(db, item.EndToEndID, fmt.Sprintf("valor recebido %s diferente da cobrança %s", item.Valor, valorCobranca))
+	}
+
+	if _, err := liquidarCobranca(db, item.Txid, item.EndToEndID, valorRecebido, liquidacaoOrigemWebhook); err != nil {
+		return err
+	}
+
+	return marcarEventoWebhook(db, item.EndToEndID, "")
+}
+
+// marcarEventoWebhook finaliza o registro da notificação, com o motivo quando ela não pôde ser conciliada
+func marcarEventoWebhook(db *sql.DB, endToEndID, erro string) error {
+	if erro != "" {
+		log.Printf("Notificação PIX %s não conciliada: %s\n", endToEndID, erro)
+	}
+	_, err := db.Exec(`
+		UPDATE core.pix_webhook_event SET processado = $1, erro = NULLIF($2, '') WHERE end_to_end_id = $3
+	`, erro == "", erro, endToEndID)
+	return err
+}
+
+// PixWebhookHandler recebe as notificações de PIX recebido enviadas pela EfiPay
+func PixWebhookHandler(db *sql.DB, provider payment.PixProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !verifyPixWebhook(r) {
+			http.Error(w, "Notificação não autorizada", http.StatusUnauthorized)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, pixWebhookMaxBody))
+		if err != nil {
+			http.Error(w, "Erro ao ler a notificação", http.StatusBadRequest)
+			return
+		}
+
+		// A EfiPay envia uma requisição de teste sem PIX ao registrar o webhook
+		items, err := provider.ParseWebhook(body)
+		if err != nil {
+			http.Error(w, "Erro ao processar o JSON", http.StatusBadRequest)
+			return
+		}
+
+		for _, item := range items {
+			if err := processPixWebhookItem(db, item); err != nil {
+				// Erro de banco: a EfiPay reenvia a notificação quando a resposta não é 200
+				log.Println("Erro ao processar notificação PIX:", err)
+				http.Error(w, "Erro ao processar notificação", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK"))
+	}
+}
+
+// PixWebhookConfigHandler registra na EfiPay a URL do webhook para a chave PIX informada
+func PixWebhookConfigHandler(provider payment.PixProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Chave string `json:"chave"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Erro ao processar o JSON", http.StatusBadRequest)
+			return
+		}
+		if req.Chave == "" {
+			http.Error(w, "chave é obrigatória", http.StatusBadRequest)
+			return
+		}
+		if !pixWebhookEnabled() {
+			http.Error(w, "PIX_WEBHOOK_URL não definida nas variáveis de ambiente", http.StatusBadRequest)
+			return
+		}
+
+		res, err := provider.ConfigWebhook(req.Chave, pixWebhookRegisterURL())
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Erro ao registrar webhook: %v", err), http.StatusBadGateway)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(res)
+	}
+}
+
+// PixWebhookDetailHandler consulta na EfiPay o webhook registrado para a chave PIX
+func PixWebhookDetailHandler(provider payment.PixProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		chave := r.URL.Query().Get("chave")
+		if chave == "" {
+			http.Error(w, "chave é obrigatória", http.StatusBadRequest)
+			return
+		}
+
+		res, err := provider.DetailWebhook(chave)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Erro ao consultar webhook: %v", err), http.StatusBadGateway)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(res)
+	}
+}
+
+// PixFakePayHandler conclui uma cobrança do provedor fake e processa a notificação como se viesse do webhook,
+// permitindo testar offline o fluxo completo cobrança → pagamento. Só é registrada com PIX_PROVIDER=fake.
+func PixFakePayHandler(db *sql.DB, provider *payment.FakeProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		txid := mux.Vars(r)["txid"]
+
+		item, err := provider.Pay(txid)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := processPixWebhookItem(db, item); err != nil {
+			http.Error(w, "Erro ao processar notificação: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		jsonResponse(w, http.StatusOK, item)
+	}
+}
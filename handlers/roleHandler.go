@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+
+	"BACK_SORTE_GO/models"
+
+	"github.com/gorilla/mux"
+)
+
+// loadUserRoles busca os nomes das roles do usuário em core.user_role
+func loadUserRoles(db *sql.DB, userID string) ([]string, error) {
+	rows, err := db.Query(`
+		SELECT DISTINCT r.role_name
+		FROM core.user_role ur
+		JOIN core.role r ON r.id = ur.id_role
+		WHERE ur.id_user = $1
+		ORDER BY r.role_name
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []string{}
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	return roles, rows.Err()
+}
+
+// RoleListHandler lista as roles disponíveis
+func RoleListHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rows, err := db.Query(`
+			SELECT MIN(id), role_name FROM core.role GROUP BY role_name ORDER BY role_name
+		`)
+		if err != nil {
+			http.Error(w, "Erro ao buscar roles: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		roles := []models.Role{}
+		for rows.Next() {
+			var role models.Role
+			if err := rows.Scan(&role.ID, &role.RoleName); err != nil {
+				http.Error(w, "Erro ao ler roles: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			roles = append(roles, role)
+		}
+
+		jsonResponse(w, http.StatusOK, roles)
+	}
+}
+
+// UserRolesHandler lista as roles de um usuário
+func UserRolesHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := mux.Vars(r)["id"]
+
+		roles, err := loadUserRoles(db, userID)
+		if err != nil {
+			http.Error(w, "Erro ao buscar roles do usuário: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		jsonResponse(w, http.StatusOK, map[string]interface{}{
+			"id_user": userID,
+			"roles":   roles,
+		})
+	}
+}
+
+// UserRoleGrantHandler concede uma role ao usuário
+func UserRoleGrantHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := mux.Vars(r)["id"]
+
+		var req struct {
+			RoleName string `json:"role_name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Erro ao processar o JSON", http.StatusBadRequest)
+			return
+		}
+
+		var exists bool
+		err := db.QueryRow(`SELECT EXISTS(SELECT 1 FROM core.user WHERE id = $1)`, userID).Scan(&exists)
+		if err != nil {
+			http.Error(w, "Erro ao buscar usuário: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !exists {
+			http.Error(w, "Usuário não encontrado", http.StatusNotFound)
+			return
+		}
+
+		var roleID sql.NullInt64
+		err = db.QueryRow(`SELECT MIN(id) FROM core.role WHERE role_name = $1`, req.RoleName).Scan(&roleID)
+		if err != nil {
+			http.Error(w, "Erro ao buscar role: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !roleID.Valid {
+			http.Error(w, "Role não encontrada", http.StatusBadRequest)
+			return
+		}
+
+		_, err = db.Exec(`
+			INSERT INTO core.user_role (id_user, id_role) VALUES ($1, $2)
+			ON CONFLICT DO NOTHING
+		`, userID, roleID.Int64)
+		if err != nil {
+			http.Error(w, "Erro ao conceder role: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		jsonResponse(w, http.StatusOK, map[string]string{
+			"message": "Role concedida com sucesso",
+		})
+	}
+}
+
+// UserRoleRevokeHandler remove uma role do usuário e encerra as sessões que ainda carregam a role no token
+func UserRoleRevokeHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		userID := vars["id"]
+		roleName := vars["role"]
+
+		res, err := db.Exec(`
+			DELETE FROM core.user_role
+			WHERE id_user = $1 AND id_role IN (SELECT id FROM core.role WHERE role_name = $2)
+		`, userID, roleName)
+		if err != nil {
+			http.Error(w, "Erro ao remover role: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			http.Error(w, "Usuário não possui esta role", http.StatusNotFound)
+			return
+		}
+
+		if err := revokeUserSessions(db, userID, "ROLE_REVOKED"); err != nil {
+			http.Error(w, "Erro ao encerrar sessões: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		jsonResponse(w, http.StatusOK, map[string]string{
+			"message": "Role removida com sucesso",
+		})
+	}
+}
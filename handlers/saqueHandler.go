@@ -0,0 +1,583 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"BACK_SORTE_GO/config"
+	"BACK_SORTE_GO/cpf"
+	"BACK_SORTE_GO/jobs"
+	"BACK_SORTE_GO/ledger"
+	"BACK_SORTE_GO/middleware"
+	"BACK_SORTE_GO/money"
+	"BACK_SORTE_GO/payment"
+	"BACK_SORTE_GO/utils"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// JobPixSaque envia o PIX de um saque aprovado e acompanha o envio até a confirmação (chave = id do saque)
+const JobPixSaque = "PIX_SAQUE"
+
+// Status de core.saque_details
+const (
+	saqueSolicitado = "SOLICITADO"
+	saqueAprovado   = "APROVADO"
+	saqueEnviado    = "ENVIADO"
+	saqueConfirmado = "CONFIRMADO"
+	saqueFalhou     = "FALHOU"
+)
+
+// Intervalo entre as consultas de um PIX enviado ainda em processamento
+const saqueConsultaIntervalo = 30 * time.Second
+
+var errSaqueSemChavePix = errors.New("cadastre uma conta de saque com chave PIX para resgatar a doação")
+
+// Saque é um registro de core.saque_details
+type Saque struct {
+	ID              string          `json:"id"`
+	IDDoacao        string          `json:"id_doacao"`
+	Valor           money.Centavos  `json:"valor"`
+	Status          string          `json:"status"`
+	ChaveDestino    string          `json:"chave_destino"`
+	EndToEndID      *string         `json:"end_to_end_id,omitempty"`
+	Erro            *string         `json:"erro,omitempty"`
+	AprovadoPor     *string         `json:"aprovado_por,omitempty"`
+	DataAprovacao   *time.Time      `json:"data_aprovacao,omitempty"`
+	DataEnvio       *time.Time      `json:"data_envio,omitempty"`
+	DataConfirmacao *time.Time      `json:"data_confirmacao,omitempty"`
+	Comprovante     json.RawMessage `json:"comprovante,omitempty"`
+	DateCreate      time.Time       `json:"date_create"`
+}
+
+const saqueSelect = `
+	SELECT sd.id, sd.id_doacao, sd.valor, sd.status, COALESCE(sd.chave_destino, ''), sd.end_to_end_id, sd.error,
+		sd.aprovado_por, sd.data_aprovacao, sd.data_envio, sd.data_confirmacao, sd.comprovante, sd.date_create
+	FROM core.saque_details sd
+`
+
+func scanSaques(rows *sql.Rows) ([]Saque, error) {
+	saques := []Saque{}
+	for rows.Next() {
+		var s Saque
+		var comprovante []byte
+		if err := rows.Scan(
+			&s.ID, &s.IDDoacao, &s.Valor, &s.Status, &s.ChaveDestino, &s.EndToEndID, &s.Erro,
+			&s.AprovadoPor, &s.DataAprovacao, &s.DataEnvio, &s.DataConfirmacao, &comprovante, &s.DateCreate,
+		); err != nil {
+			return nil, err
+		}
+		s.Comprovante = comprovante
+		saques = append(saques, s)
+	}
+	return saques, rows.Err()
+}
+
+// solicitarSaque registra o saque do saldo da campanha para a chave PIX da conta de saque do dono e move o valor
+// para saques em trânsito no razão. Com SAQUE_APROVACAO_AUTOMATICA=true o envio é agendado sem passar pelo operador.
+// Deve rodar na mesma transação que travou o saldo.
+func solicitarSaque(tx *sql.Tx, idUser, idDoacao string, valor money.Centavos) (string, string, error) {
+	var idConta, chave string
+	err := tx.QueryRow(`
+		SELECT id, pix
+		FROM core.saque_conta
+		WHERE id_user = $1 AND active = true AND dell = false AND COALESCE(pix, '') <> ''
+		ORDER BY date_create DESC
+		LIMIT 1
+	`, idUser).Scan(&idConta, &chave)
+	if err == sql.ErrNoRows {
+		return "", "", errSaqueSemChavePix
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	idSaque := uuid.NewString()
+	status := saqueSolicitado
+	var dataAprovacao interface{}
+	if config.GetSaqueAprovacaoAutomatica() == "true" {
+		status = saqueAprovado
+		dataAprovacao = time.Now()
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO core.saque_details (id, id_saque_conta, id_doacao, valor, status, chave_destino, realizado,
+			data_aprovacao, date_create, date_update)
+		VALUES ($1, $2, $3, $4, $5, $6, false, $7, now(), now())
+	`, idSaque, idConta, idDoacao, valor, status, chave, dataAprovacao)
+	if err != nil {
+		return "", "", err
+	}
+
+	if _, err := ledger.Lancar(tx, ledger.SaqueSolicitado(idDoacao, idSaque, valor)); err != nil {
+		return "", "", err
+	}
+
+	if status == saqueAprovado {
+		if _, err := jobs.Enqueue(tx, JobPixSaque, idSaque, nil, time.Now()); err != nil {
+			return "", "", err
+		}
+	}
+	return idSaque, status, nil
+}
+
+// saqueIDEnvio é o identificador do envio na EfiPay (até 35 caracteres alfanuméricos), derivado do id do saque
+// para que reenvios do mesmo saque não transfiram duas vezes
+func saqueIDEnvio(idSaque string) string {
+	return strings.ReplaceAll(idSaque, "-", "")
+}
+
+// truncarErro limita a mensagem ao tamanho de saque_details.error
+func truncarErro(msg string) string {
+	if r := []rune(msg); len(r) > 255 {
+		return string(r[:255])
+	}
+	return msg
+}
+
+// PixSaqueJob envia o PIX dos saques aprovados e consulta o envio até o PSP confirmar ou recusar.
+// O saque passa para ENVIADO antes da chamada ao provedor, o que impede a recusa pelo operador durante o envio;
+// como o idEnvio é fixo, repetir o envio após uma falha de comunicação não transfere duas vezes.
+// Se o PSP recusa a primeira chamada (ErrRecusado), o saque é cancelado e o valor volta à campanha. Uma recusa
+// depois de outra tentativa não é conclusiva — a anterior pode ter sido processada —, então o job para e o
+// operador confere no PSP antes de recusar o saque (SaqueRecusarHandler aceita ENVIADO sem endToEndId).
+func PixSaqueJob(db *sql.DB, provider payment.PixProvider) jobs.Handler {
+	return func(ctx context.Context, job jobs.Job) (time.Duration, error) {
+		idSaque := job.Chave
+
+		var status, chave, documento string
+		var endToEndID sql.NullString
+		var valor money.Centavos
+		err := db.QueryRowContext(ctx, `
+			SELECT sd.status, sd.valor, COALESCE(sd.chave_destino, ''), sd.end_to_end_id, COALESCE(sc.cpf, '')
+			FROM core.saque_details sd
+			JOIN core.saque_conta sc ON sc.id = sd.id_saque_conta
+			WHERE sd.id = $1
+		`, idSaque).Scan(&status, &valor, &chave, &endToEndID, &documento)
+		if err == sql.ErrNoRows {
+			log.Println("Saque não encontrado para o job de envio:", idSaque)
+			return 0, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		// Sem a chave do pagador nenhum envio é possível: o saque continua APROVADO e pode ser recusado
+		semEndToEnd := !endToEndID.Valid || endToEndID.String == ""
+		chavePagador := config.GetPixChavePagador()
+		if chavePagador == "" && (status == saqueAprovado || (status == saqueEnviado && semEndToEnd)) {
+			return 0, fmt.Errorf("PIX_CHAVE_PAGADOR não definida nas variáveis de ambiente")
+		}
+
+		primeiroEnvio := false
+		if status == saqueAprovado {
+			res, err := db.ExecContext(ctx, `
+				UPDATE core.saque_details
+				SET status = $1, id_envio = $2, data_envio = now(), date_update = now()
+				WHERE id = $3 AND status = $4
+			`, saqueEnviado, saqueIDEnvio(idSaque), idSaque, saqueAprovado)
+			if err != nil {
+				return 0, err
+			}
+			if n, _ := res.RowsAffected(); n == 0 {
+				// Recusado pelo operador entre a leitura e o envio
+				return 0, nil
+			}
+			status = saqueEnviado
+			primeiroEnvio = true
+		}
+		if status != saqueEnviado {
+			return 0, nil
+		}
+
+		// Sem endToEndId o envio ainda não foi aceito pelo PSP
+		if semEndToEnd {
+			req := payment.SendRequest{
+				Valor:           valor.String(),
+				ChavePagador:    chavePagador,
+				InfoPagador:     "Resgate de doações",
+				ChaveFavorecido: chave,
+			}
+			if doc, tipo, err := cpf.ParseDocumento(documento); err == nil {
+				if tipo == cpf.TipoCNPJ {
+					req.CNPJFavorecido = doc
+				} else {
+					req.CPFFavorecido = doc
+				}
+			}
+
+			send, err := provider.SendPix(saqueIDEnvio(idSaque), req)
+			if errors.Is(err, payment.ErrRecusado) {
+				if primeiroEnvio {
+					if err := cancelarSaque(db, idSaque, []string{saqueEnviado}, "PIX recusado pelo PSP: "+err.Error()); err != nil && err != sql.ErrNoRows {
+						return 0, fmt.Errorf("erro ao cancelar saque: %w", err)
+					}
+					return 0, nil
+				}
+				log.Printf("Saque %s: envio recusado após nova tentativa, conferir no PSP: %v\n", idSaque, err)
+				if _, err := db.ExecContext(ctx, `
+					UPDATE core.saque_details SET error = $1, date_update = now() WHERE id = $2
+				`, truncarErro("Envio recusado pelo PSP após nova tentativa, conferir antes de recusar: "+err.Error()), idSaque); err != nil {
+					return 0, err
+				}
+				return 0, nil
+			}
+			if err != nil {
+				return 0, fmt.Errorf("erro ao enviar PIX do saque: %w", err)
+			}
+			if _, err := db.ExecContext(ctx, `
+				UPDATE core.saque_details SET end_to_end_id = $1, date_update = now() WHERE id = $2
+			`, send.EndToEndID, idSaque); err != nil {
+				return 0, err
+			}
+			return saqueConsultaIntervalo, nil
+		}
+
+		send, err := provider.DetailSend(endToEndID.String)
+		if err != nil {
+			return 0, fmt.Errorf("erro ao consultar PIX enviado: %w", err)
+		}
+
+		switch send.Status {
+		case payment.EnvioRealizado:
+			if err := confirmarSaque(db, idSaque, send); err != nil {
+				return 0, fmt.Errorf("erro ao confirmar saque: %w", err)
+			}
+			return 0, nil
+		case payment.EnvioNaoRealizado:
+			if err := cancelarSaque(db, idSaque, []string{saqueEnviado}, "PIX não realizado pelo PSP"); err != nil {
+				return 0, fmt.Errorf("erro ao cancelar saque: %w", err)
+			}
+			return 0, nil
+		}
+		return saqueConsultaIntervalo, nil
+	}
+}
+
+// confirmarSaque conclui o saque enviado: guarda o comprovante do PSP e baixa o valor em trânsito no razão
+func confirmarSaque(db *sql.DB, idSaque string, send *payment.Send) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var idDoacao string
+	var valor money.Centavos
+	err = tx.QueryRow(`
+		UPDATE core.saque_details
+		SET status = $1, realizado = true, data_confirmacao = now(), comprovante = $2, error = NULL, date_update = now()
+		WHERE id = $3 AND status = $4
+		RETURNING id_doacao, valor
+	`, saqueConfirmado, []byte(send.Raw), idSaque, saqueEnviado).Scan(&idDoacao, &valor)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := ledger.Lancar(tx, ledger.SaqueConcluido(idDoacao, idSaque, valor)); err != nil {
+		return err
+	}
+
+	// O valor transferido da campanha é calculado pelo razão; aqui só fica a data e o status do último saque
+	if _, err := tx.Exec(`
+		UPDATE core.doacao_pagamentos
+		SET data_tranferido = now(), status = 'CONCLUIDO', data_update = now()
+		WHERE id_doacao = $1
+	`, idDoacao); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// cancelarSaque marca o saque como FALHOU e devolve o valor em trânsito ao saldo da campanha.
+// Só altera saques em um dos status informados; retorna sql.ErrNoRows caso contrário.
+func cancelarSaque(db *sql.DB, idSaque string, permitidos []string, motivo string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var idDoacao string
+	var valor money.Centavos
+	err = tx.QueryRow(`
+		UPDATE core.saque_details
+		SET status = $1, error = $2, date_update = now()
+		WHERE id = $3 AND status = ANY($4)
+		RETURNING id_doacao, valor
+	`, saqueFalhou, truncarErro(motivo), idSaque, pq.Array(permitidos)).Scan(&idDoacao, &valor)
+	if err != nil {
+		return err
+	}
+
+	if err := estornarSaqueCancelado(tx, idDoacao, idSaque, valor); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// estornarSaqueCancelado devolve ao saldo da campanha o valor em trânsito do saque cancelado
+func estornarSaqueCancelado(tx *sql.Tx, idDoacao, idSaque string, valor money.Centavos) error {
+	if _, err := ledger.Lancar(tx, ledger.SaqueCancelado(idDoacao, idSaque, valor)); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`
+		UPDATE core.doacao_pagamentos SET status = 'FALHOU', data_update = now() WHERE id_doacao = $1
+	`, idDoacao)
+	return err
+}
+
+// cancelarSaqueNaoAceito cancela um saque ENVIADO sem endToEndId cujo job de envio já parou (concluído ou sem
+// tentativas restantes). Com o job pendente ou em execução o PSP ainda pode receber o envio.
+func cancelarSaqueNaoAceito(db *sql.DB, idSaque, motivo string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var emAndamento bool
+	err = tx.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM core.job WHERE tipo = $1 AND chave = $2 AND status IN ($3, $4)
+		)
+	`, JobPixSaque, idSaque, jobs.StatusPending, jobs.StatusRunning).Scan(&emAndamento)
+	if err != nil {
+		return err
+	}
+	if emAndamento {
+		return sql.ErrNoRows
+	}
+
+	var idDoacao string
+	var valor money.Centavos
+	err = tx.QueryRow(`
+		UPDATE core.saque_details
+		SET status = $1, error = $2, date_update = now()
+		WHERE id = $3 AND status = $4 AND COALESCE(end_to_end_id, '') = ''
+		RETURNING id_doacao, valor
+	`, saqueFalhou, truncarErro(motivo), idSaque, saqueEnviado).Scan(&idDoacao, &valor)
+	if err != nil {
+		return err
+	}
+	if err := estornarSaqueCancelado(tx, idDoacao, idSaque, valor); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SaqueListHandler lista os saques para o operador, filtrando por ?status= (ex.: SOLICITADO para a fila de aprovação)
+func SaqueListHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := strings.ToUpper(r.URL.Query().Get("status"))
+
+		rows, err := db.Query(saqueSelect+`
+			WHERE ($1 = '' OR sd.status = $1) AND sd.id_doacao IS NOT NULL
+			ORDER BY sd.date_create
+		`, status)
+		if err != nil {
+			http.Error(w, "Erro ao buscar saques: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		saques, err := scanSaques(rows)
+		if err != nil {
+			http.Error(w, "Erro ao ler saques: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		jsonResponse(w, http.StatusOK, saques)
+	}
+}
+
+// UserSaqueListHandler lista os saques das doações do usuário autenticado
+func UserSaqueListHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := middleware.GetPrincipal(r)
+		if !ok {
+			http.Error(w, "Usuário não autenticado", http.StatusUnauthorized)
+			return
+		}
+
+		rows, err := db.Query(saqueSelect+`
+			JOIN core.doacao d ON d.id = sd.id_doacao
+			WHERE d.id_user = $1
+			ORDER BY sd.date_create DESC
+		`, principal.UserID)
+		if err != nil {
+			http.Error(w, "Erro ao buscar saques: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		saques, err := scanSaques(rows)
+		if err != nil {
+			http.Error(w, "Erro ao ler saques: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		jsonResponse(w, http.StatusOK, saques)
+	}
+}
+
+// SaqueAprovarHandler aprova um saque solicitado e agenda o envio do PIX.
+// O operador não pode aprovar o saque de uma doação própria.
+func SaqueAprovarHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := middleware.GetPrincipal(r)
+		if !ok {
+			http.Error(w, "Usuário não autenticado", http.StatusUnauthorized)
+			return
+		}
+		idSaque := mux.Vars(r)["id"]
+
+		tx, err := db.Begin()
+		if err != nil {
+			http.Error(w, "Erro ao iniciar transação: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		res, err := tx.Exec(`
+			UPDATE core.saque_details sd
+			SET status = $1, aprovado_por = $2, data_aprovacao = now(), date_update = now()
+			FROM core.doacao d
+			WHERE sd.id = $3 AND sd.status = $4 AND d.id = sd.id_doacao AND d.id_user <> $2
+		`, saqueAprovado, principal.UserID, idSaque, saqueSolicitado)
+		if err != nil {
+			http.Error(w, "Erro ao aprovar saque: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			http.Error(w, "Saque não encontrado ou não está aguardando aprovação", http.StatusConflict)
+			return
+		}
+
+		if _, err := jobs.Enqueue(tx, JobPixSaque, idSaque, nil, time.Now()); err != nil {
+			http.Error(w, "Erro ao agendar envio do saque: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			http.Error(w, "Erro ao finalizar transação: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		jsonResponse(w, http.StatusOK, map[string]string{
+			"message": "Saque aprovado, envio agendado",
+			"id":      idSaque,
+		})
+	}
+}
+
+// SaqueRecusarHandler recusa um saque ainda não enviado e devolve o valor ao saldo da campanha. Também cancela
+// um saque ENVIADO que o PSP nunca aceitou (sem endToEndId), desde que o job de envio tenha parado: o operador
+// deve conferir no PSP que o PIX não saiu.
+func SaqueRecusarHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		idSaque := mux.Vars(r)["id"]
+
+		var req struct {
+			Motivo string `json:"motivo"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Erro ao processar o JSON", http.StatusBadRequest)
+			return
+		}
+		if strings.TrimSpace(req.Motivo) == "" {
+			http.Error(w, "motivo é obrigatório", http.StatusBadRequest)
+			return
+		}
+
+		err := cancelarSaque(db, idSaque, []string{saqueSolicitado, saqueAprovado}, "Recusado: "+strings.TrimSpace(req.Motivo))
+		if err == sql.ErrNoRows {
+			err = cancelarSaqueNaoAceito(db, idSaque, "Recusado: "+strings.TrimSpace(req.Motivo))
+		}
+		if err == sql.ErrNoRows {
+			http.Error(w, "Saque não encontrado, já aceito pelo PSP ou com envio em andamento", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Erro ao recusar saque: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		jsonResponse(w, http.StatusOK, map[string]string{
+			"message": "Saque recusado",
+			"id":      idSaque,
+		})
+	}
+}
+
+// SaqueComprovanteHandler recebe o comprovante do saque (imagem ou PDF) e grava a URL em doacao_pagamentos.img/pdf
+func SaqueComprovanteHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		idSaque := mux.Vars(r)["id"]
+
+		var idDoacao, status string
+		err := db.QueryRow(`SELECT id_doacao, status FROM core.saque_details WHERE id = $1`, idSaque).Scan(&idDoacao, &status)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Saque não encontrado", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, "Erro ao buscar saque: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if status != saqueConfirmado {
+			http.Error(w, "O comprovante só pode ser anexado a um saque confirmado", http.StatusConflict)
+			return
+		}
+
+		if err := r.ParseMultipartForm(10 << 20); err != nil { // 10MB
+			http.Error(w, "Erro ao parsear o formulário: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		file, handler, err := r.FormFile("comprovante")
+		if err != nil {
+			http.Error(w, "Erro ao ler o arquivo: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+
+		coluna := "img"
+		ext := strings.ToLower(filepath.Ext(handler.Filename))
+		switch ext {
+		case ".pdf":
+			coluna = "pdf"
+		case ".jpg", ".jpeg", ".png":
+		default:
+			http.Error(w, "Formato de comprovante não suportado (use PDF, JPG ou PNG)", http.StatusBadRequest)
+			return
+		}
+
+		url, err := utils.UploadToS3(file, "comprovante_saque_"+idSaque+ext, config.GetawsBucketNameImgDoacao())
+		if err != nil {
+			http.Error(w, "Erro ao fazer upload do comprovante: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		_, err = db.Exec(`UPDATE core.doacao_pagamentos SET `+coluna+` = $1, data_update = now() WHERE id_doacao = $2`, url, idDoacao)
+		if err != nil {
+			http.Error(w, "Erro ao salvar comprovante: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		jsonResponse(w, http.StatusOK, map[string]string{"url": url})
+	}
+}
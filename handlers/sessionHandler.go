@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"BACK_SORTE_GO/middleware"
+	"BACK_SORTE_GO/utils"
+
+	"github.com/google/uuid"
+)
+
+// Tempo de vida da sessão / refresh token
+const refreshTokenTTL = 30 * 24 * time.Hour
+
+var (
+	errRefreshTokenInvalido    = errors.New("refresh token inválido ou expirado")
+	errRefreshTokenReutilizado = errors.New("refresh token reutilizado, sessão revogada")
+)
+
+// createSession abre uma nova sessão do usuário pelo cliente da API e retorna o id da sessão e o refresh token em claro
+func createSession(db *sql.DB, userID, apiClientID string) (string, string, error) {
+	sessionID := uuid.NewString()
+	expiresAt := time.Now().Add(refreshTokenTTL)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return "", "", err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO core.user_session (id, id_user, id_api_client, revoked, expires_at, date_create)
+		VALUES ($1, $2, $3, false, $4, now())
+	`, sessionID, userID, apiClientID, expiresAt)
+	if err != nil {
+		return "", "", err
+	}
+
+	refreshToken, err := insertRefreshToken(tx, sessionID, userID, expiresAt)
+	if err != nil {
+		return "", "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", "", err
+	}
+
+	return sessionID, refreshToken, nil
+}
+
+func insertRefreshToken(tx *sql.Tx, sessionID, userID string, expiresAt time.Time) (string, error) {
+	refreshToken, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO core.refresh_token (id, id_session, id_user, token_hash, used, expires_at, date_create)
+		VALUES ($1, $2, $3, $4, false, $5, now())
+	`, uuid.NewString(), sessionID, userID, utils.HashToken(refreshToken), expiresAt)
+	if err != nil {
+		return "", err
+	}
+
+	return refreshToken, nil
+}
+
+// rotateRefreshToken troca um refresh token válido por um novo da mesma sessão.
+// Se o token já tiver sido usado, a sessão inteira é revogada (detecção de reuso).
+// O token só é aceito pelo mesmo cliente da API que abriu a sessão.
+func rotateRefreshToken(db *sql.DB, refreshToken, apiClientID string) (userID, sessionID, newRefreshToken string, err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return "", "", "", err
+	}
+	defer tx.Rollback()
+
+	var (
+		tokenID        string
+		used           bool
+		expiresAt      time.Time
+		sessionRevoked bool
+		sessionClient  sql.NullString
+	)
+	err = tx.QueryRow(`
+		SELECT rt.id, rt.id_user, rt.id_session, rt.used, rt.expires_at, s.revoked, s.id_api_client
+		FROM core.refresh_token rt
+		JOIN core.user_session s ON s.id = rt.id_session
+		WHERE rt.token_hash = $1
+		FOR UPDATE OF rt, s
+	`, utils.HashToken(refreshToken)).Scan(&tokenID, &userID, &sessionID, &used, &expiresAt, &sessionRevoked, &sessionClient)
+	if err == sql.ErrNoRows {
+		return "", "", "", errRefreshTokenInvalido
+	}
+	if err != nil {
+		return "", "", "", err
+	}
+
+	// Sessões abertas antes do cadastro de clientes não têm cliente associado
+	if sessionClient.Valid && sessionClient.String != apiClientID {
+		return "", "", "", errRefreshTokenInvalido
+	}
+
+	if used {
+		if _, err := tx.Exec(`
+			UPDATE core.user_session
+			SET revoked = true, revoked_motivo = 'REFRESH_REUSE', revoked_at = now()
+			WHERE id = $1 AND revoked = false
+		`, sessionID); err != nil {
+			return "", "", "", err
+		}
+		if err := tx.Commit(); err != nil {
+			return "", "", "", err
+		}
+		return "", "", "", errRefreshTokenReutilizado
+	}
+
+	if sessionRevoked || time.Now().After(expiresAt) {
+		return "", "", "", errRefreshTokenInvalido
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE core.refresh_token SET used = true, used_at = now() WHERE id = $1
+	`, tokenID); err != nil {
+		return "", "", "", err
+	}
+
+	newRefreshToken, err = insertRefreshToken(tx, sessionID, userID, expiresAt)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", "", "", err
+	}
+
+	return userID, sessionID, newRefreshToken, nil
+}
+
+// revokeUserSessions encerra todas as sessões ativas do usuário (ex.: troca de senha)
+func revokeUserSessions(db *sql.DB, userID, motivo string) error {
+	_, err := db.Exec(`
+		UPDATE core.user_session
+		SET revoked = true, revoked_motivo = $1, revoked_at = now()
+		WHERE id_user = $2 AND revoked = false
+	`, motivo, userID)
+	return err
+}
+
+// LogoutHandler encerra a sessão atual, ou todas as sessões do usuário com ?all=true
+func LogoutHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := middleware.GetPrincipal(r)
+		if !ok {
+			http.Error(w, "Usuário não autenticado", http.StatusUnauthorized)
+			return
+		}
+
+		var err error
+		if r.URL.Query().Get("all") == "true" {
+			err = revokeUserSessions(db, principal.UserID, "LOGOUT_ALL")
+		} else {
+			_, err = db.Exec(`
+				UPDATE core.user_session
+				SET revoked = true, revoked_motivo = 'LOGOUT', revoked_at = now()
+				WHERE id = $1 AND id_user = $2
+			`, principal.SessionID, principal.UserID)
+		}
+		if err != nil {
+			http.Error(w, "Erro ao encerrar sessão: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		jsonResponse(w, http.StatusOK, map[string]string{
+			"message": "Sessão encerrada com sucesso",
+		})
+	}
+}
@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"BACK_SORTE_GO/fees"
+	"BACK_SORTE_GO/models"
+	"BACK_SORTE_GO/money"
+
+	"github.com/gorilla/mux"
+)
+
+type taxaRegraRequest struct {
+	Nome           *string         `json:"nome"`
+	Percentual     *float64        `json:"percentual"`
+	ValorFixo      *money.Centavos `json:"valor_fixo"`
+	ValorMinimo    *money.Centavos `json:"valor_minimo"`
+	Area           *string         `json:"area"`
+	Nivel          *string         `json:"nivel"`
+	Prioridade     *int            `json:"prioridade"`
+	VigenciaInicio *time.Time      `json:"vigencia_inicio"`
+	VigenciaFim    *time.Time      `json:"vigencia_fim"`
+	Ativo          *bool           `json:"ativo"`
+}
+
+func (req taxaRegraRequest) validate() error {
+	if req.Percentual != nil && (*req.Percentual < 0 || *req.Percentual > 100) {
+		return errors.New("percentual deve estar entre 0 e 100")
+	}
+	if req.ValorFixo != nil && *req.ValorFixo < 0 {
+		return errors.New("valor_fixo não pode ser negativo")
+	}
+	if req.ValorMinimo != nil && *req.ValorMinimo < 0 {
+		return errors.New("valor_minimo não pode ser negativo")
+	}
+	if req.VigenciaInicio != nil && req.VigenciaFim != nil && !req.VigenciaFim.After(*req.VigenciaInicio) {
+		return errors.New("vigencia_fim deve ser posterior a vigencia_inicio")
+	}
+	return nil
+}
+
+// emptyToNil trata "" como ausência de filtro (regra válida para todas as áreas/planos)
+func emptyToNil(v *string) interface{} {
+	if v == nil || strings.TrimSpace(*v) == "" {
+		return nil
+	}
+	return strings.TrimSpace(*v)
+}
+
+// TaxaRegraListHandler lista as regras de taxa, das mais prioritárias para as menos
+func TaxaRegraListHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rows, err := db.Query(`
+			SELECT id, nome, percentual, valor_fixo, valor_minimo, area, nivel, prioridade,
+				vigencia_inicio, vigencia_fim, ativo, date_create, date_update
+			FROM core.taxa_regra
+			ORDER BY ativo DESC, prioridade DESC, date_create DESC
+		`)
+		if err != nil {
+			http.Error(w, "Erro ao buscar regras de taxa: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		regras := []models.TaxaRegra{}
+		for rows.Next() {
+			var t models.TaxaRegra
+			if err := rows.Scan(
+				&t.ID, &t.Nome, &t.Percentual, &t.ValorFixo, &t.ValorMinimo, &t.Area, &t.Nivel, &t.Prioridade,
+				&t.VigenciaInicio, &t.VigenciaFim, &t.Ativo, &t.DateCreate, &t.DateUpdate,
+			); err != nil {
+				http.Error(w, "Erro ao ler regras de taxa: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			regras = append(regras, t)
+		}
+
+		jsonResponse(w, http.StatusOK, regras)
+	}
+}
+
+// TaxaRegraCreateHandler cadastra uma regra de taxa. Vale para pagamentos confirmados a partir de agora.
+func TaxaRegraCreateHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req taxaRegraRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Erro ao processar o JSON", http.StatusBadRequest)
+			return
+		}
+		if req.Nome == nil || strings.TrimSpace(*req.Nome) == "" {
+			http.Error(w, "Nome da regra é obrigatório", http.StatusBadRequest)
+			return
+		}
+		if err := req.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var id string
+		err := db.QueryRow(`
+			INSERT INTO core.taxa_regra (
+				nome, percentual, valor_fixo, valor_minimo, area, nivel, prioridade,
+				vigencia_inicio, vigencia_fim, ativo, date_create
+			)
+			VALUES ($1, COALESCE($2, 0), COALESCE($3, 0), COALESCE($4, 0), $5, $6, COALESCE($7, 0), $8, $9, COALESCE($10, true), now())
+			RETURNING id
+		`, strings.TrimSpace(*req.Nome), req.Percentual, req.ValorFixo, req.ValorMinimo, emptyToNil(req.Area), emptyToNil(req.Nivel),
+			req.Prioridade, req.VigenciaInicio, req.VigenciaFim, req.Ativo).Scan(&id)
+		if err != nil {
+			http.Error(w, "Erro ao cadastrar regra de taxa: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		jsonResponse(w, http.StatusCreated, map[string]string{
+			"message": "Regra de taxa cadastrada com sucesso",
+			"id":      id,
+		})
+	}
+}
+
+// TaxaRegraUpdateHandler altera uma regra. Pagamentos já liquidados mantêm a taxa gravada na liquidação.
+func TaxaRegraUpdateHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+
+		var req taxaRegraRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Erro ao processar o JSON", http.StatusBadRequest)
+			return
+		}
+		if err := req.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Para area e nivel, "" remove o filtro
+		res, err := db.Exec(`
+			UPDATE core.taxa_regra SET
+				nome = COALESCE($2, nome),
+				percentual = COALESCE($3, percentual),
+				valor_fixo = COALESCE($4, valor_fixo),
+				valor_minimo = COALESCE($5, valor_minimo),
+				area = CASE WHEN $6::TEXT IS NULL THEN area ELSE NULLIF(TRIM($6), '') END,
+				nivel = CASE WHEN $7::TEXT IS NULL THEN nivel ELSE NULLIF(TRIM($7), '') END,
+				prioridade = COALESCE($8, prioridade),
+				vigencia_inicio = COALESCE($9, vigencia_inicio),
+				vigencia_fim = COALESCE($10, vigencia_fim),
+				ativo = COALESCE($11, ativo),
+				date_update = now()
+			WHERE id = $1
+		`, id, req.Nome, req.Percentual, req.ValorFixo, req.ValorMinimo, req.Area, req.Nivel,
+			req.Prioridade, req.VigenciaInicio, req.VigenciaFim, req.Ativo)
+		if err != nil {
+			http.Error(w, "Erro ao atualizar regra de taxa: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			http.Error(w, "Regra de taxa não encontrada", http.StatusNotFound)
+			return
+		}
+
+		jsonResponse(w, http.StatusOK, map[string]string{
+			"message": "Regra de taxa atualizada com sucesso",
+		})
+	}
+}
+
+// TaxaSimularHandler mostra a taxa que seria aplicada agora a um pagamento da campanha (?id_doacao=&valor=)
+func TaxaSimularHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		idDoacao := r.URL.Query().Get("id_doacao")
+		valor, err := money.Parse(r.URL.Query().Get("valor"))
+		if idDoacao == "" || err != nil || valor <= 0 {
+			http.Error(w, "id_doacao e valor são obrigatórios", http.StatusBadRequest)
+			return
+		}
+
+		taxa, err := fees.Calcular(db, idDoacao, valor, time.Now())
+		if err != nil {
+			http.Error(w, "Erro ao calcular taxa: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		jsonResponse(w, http.StatusOK, map[string]interface{}{
+			"id_regra":      taxa.IDRegra,
+			"percentual":    taxa.Percentual,
+			"valor_fixo":    taxa.ValorFixo,
+			"valor_minimo":  taxa.ValorMinimo,
+			"valor_bruto":   valor,
+			"taxa":          taxa.Taxa,
+			"valor_liquido": valor - taxa.Taxa,
+		})
+	}
+}
@@ -1,43 +1,37 @@
 package handlers
 
 import (
-	"BACK_SORTE_GO/config"
+	"BACK_SORTE_GO/cpf"
+	"BACK_SORTE_GO/jobs"
+	"BACK_SORTE_GO/money"
+	"BACK_SORTE_GO/payment"
 	"database/sql"
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"time"
+	"strings"
 
-	"github.com/efipay/sdk-go-apis-efi/src/efipay/pix"
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 )
 
 // PixChargeRequest define a estrutura do JSON recebido na requisição
 type PixChargeRequest struct {
-	Valor    string `json:"valor"`
+	Valor    money.Centavos `json:"valor"`
 	CPF     string `json:"cpf"`
 	Nome     string `json:"nome"`
 	Chave    string `json:"chave"`
 	Mensagem string `json:"mensagem"`
 	Anonimo	 bool  `json:"anonimo"`
 	IdDoacao string `json:"id"`
-}
-
-// parseTime faz parse de string ISO para time.Time
-func parseTimeISO(v interface{}) time.Time {
-	if v == nil {
-		return time.Now()
-	}
-	t, err := time.Parse(time.RFC3339, v.(string))
-	if err != nil {
-		return time.Now()
-	}
-	return t
+	// Email opcional do doador, usado para avisar sobre devoluções
+	Email    string `json:"email"`
+	// Com vencimento, a doação é uma promessa de pagamento até a data (cobv), com multa, juros e desconto
+	CobrancaVencimentoRequest
 }
 
 // TestPixTokenHandler cria uma cobrança PIX ao receber uma requisição HTTP
-func CreatePixTokenHandler(db *sql.DB) http.HandlerFunc {
+func CreatePixTokenHandler(db *sql.DB, provider payment.PixProvider) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
@@ -50,38 +44,55 @@ func CreatePixTokenHandler(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		efi := pix.NewEfiPay(config.GetCredentials())
-
-		body := map[string]interface{}{
-			"calendario": map[string]interface{}{"expiracao": 3600},
-			"devedor": map[string]interface{}{
-				"cpf":  req.CPF,
-				"nome": req.Nome,
-			},
-			"valor":              map[string]interface{}{"original": req.Valor},
-			"chave":              req.Chave,
-			"solicitacaoPagador": "pagamento de doação",
+		if req.Valor <= 0 {
+			http.Error(w, "Valor inválido", http.StatusBadRequest)
+			return
 		}
 
-		// Chamada da API
-		resStr, err := efi.CreateImmediateCharge(body)
+		// O devedor enviado à EfiPay precisa de um CPF/CNPJ válido e só com dígitos
+		documento, tipoDocumento, err := cpf.ParseDocumento(req.CPF)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Erro ao criar cobrança PIX: %v", err), http.StatusInternalServerError)
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+		req.CPF = documento
 
-		// Converte resposta em map
-		var resMap map[string]interface{}
-		if err := json.Unmarshal([]byte(resStr), &resMap); err != nil {
-			http.Error(w, "Erro ao decodificar resposta do PIX: "+err.Error(), http.StatusInternalServerError)
-			return
-		}
+		// Chamada da API: cobrança com vencimento (promessa) ou imediata com expiração de 1 hora
+		var charge *payment.Charge
+		if req.ComVencimento() {
+			dueReq, err := req.dueChargeRequest(req.Valor, req.Chave, req.Nome, documento, tipoDocumento, "promessa de doação")
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			charge, err = provider.CreateDueCharge(dueReq)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Erro ao criar cobrança PIX com vencimento: %v", err), http.StatusInternalServerError)
+				return
+			}
+		} else {
+			chargeReq := payment.ChargeRequest{
+				Valor:              req.Valor.String(),
+				Chave:              req.Chave,
+				Expiracao:          3600,
+				DevedorNome:        req.Nome,
+				SolicitacaoPagador: "pagamento de doação",
+			}
+			if tipoDocumento == cpf.TipoCNPJ {
+				chargeReq.DevedorCNPJ = documento
+			} else {
+				chargeReq.DevedorCPF = documento
+			}
 
-		txid, ok := resMap["txid"].(string)
-		if !ok || txid == "" {
-			http.Error(w, "Resposta inválida da API (txid ausente)", http.StatusInternalServerError)
-			return
+			charge, err = provider.CreateImmediateCharge(chargeReq)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Erro ao criar cobrança PIX: %v", err), http.StatusInternalServerError)
+				return
+			}
 		}
+		txid := charge.Txid
+
+		pixCopiaECola := cobrancaPixCopiaECola(charge)
 
 		// Inicia transação
 		tx, err := db.Begin()
@@ -96,8 +107,8 @@ func CreatePixTokenHandler(db *sql.DB) http.HandlerFunc {
 		// Insert pix_qrcode
 		_, err = tx.Exec(`
 			INSERT INTO core.pix_qrcode 
-			(id, id_doacao, valor, cpf, nome, mensagem, anonimo, visivel, data_criacao)
-			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, now())
+			(id, id_doacao, valor, cpf, nome, mensagem, anonimo, visivel, email, data_criacao)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, ''), now())
 		`,
 			idPixQRCode,
 			req.IdDoacao,
@@ -107,6 +118,7 @@ func CreatePixTokenHandler(db *sql.DB) http.HandlerFunc {
 			req.Mensagem,
 			req.Anonimo,
 			false,
+			strings.TrimSpace(req.Email),
 		)
 		if err != nil {
 			http.Error(w, "Erro ao salvar pix_qrcode: "+err.Error(), http.StatusInternalServerError)
@@ -116,60 +128,30 @@ func CreatePixTokenHandler(db *sql.DB) http.HandlerFunc {
 		fmt.Printf("Salvando status para id_pix_qrcode: %v\n", idPixQRCode)
 
 		// Insert pix_qrcode_status
-		_, err = tx.Exec(`
-			INSERT INTO core.pix_qrcode_status (
-				id, id_pix_qrcode, data_criacao, expiracao, tipo_pagamento,
-				loc_id, loc_tipo_cob, loc_criacao, location, pix_copia_e_cola,
-				chave, id_pix, status, buscar, finalizado, data_pago
-			) VALUES (
-				$1, $2, $3, $4, $5,
-				$6, $7, $8, $9, $10,
-				$11, $12, $13, $14, $15, $16
-			)
-		`,
-			uuid.NewString(),
-			idPixQRCode,
-			parseTimeISO(resMap["calendario"].(map[string]interface{})["criacao"]),
-			int(resMap["calendario"].(map[string]interface{})["expiracao"].(float64)),
-			"v1",
-			int(resMap["loc"].(map[string]interface{})["id"].(float64)),
-			resMap["loc"].(map[string]interface{})["tipoCob"],
-			parseTimeISO(resMap["loc"].(map[string]interface{})["criacao"]),
-			resMap["loc"].(map[string]interface{})["location"],
-			resMap["loc"].(map[string]interface{})["location"], // ou outro campo
-			req.Chave,
-			txid,
-			resMap["status"],
-			true,
-			false,
-			nil,
-		)
-		if err != nil {
+		if err := salvarStatusCobranca(tx, idPixQRCode.String(), "", req.Chave, charge, pixCopiaECola, req.Encargos); err != nil {
 			http.Error(w, "Erro ao salvar pix_qrcode_status: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
 
+		// Agenda a verificação de status na mesma transação, para não perder a cobrança num reinício
+		if err := enqueuePixStatusJob(tx, txid, charge.LimitePagamento()); err != nil {
+			http.Error(w, "Erro ao agendar verificação do pagamento: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
 		// Commit transação
 		if err := tx.Commit(); err != nil {
 			http.Error(w, "Erro ao commitar transação: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		// Iniciar verificação de status em background (não bloqueia)
-		go func(txid string) {
-			err := IniciarMonitoramentoStatusPagamento(db, txid)
-			if err != nil {
-				fmt.Println("Erro ao iniciar monitoramento do pagamento:", err)
-			}
-		}(txid)
-
 		// Retorno da API
 		w.Header().Set("Content-Type", "application/json")
-		w.Write([]byte(resStr))
+		w.Write(charge.Raw)
 	}
 }
 
-func PixChargeStatusHandler() http.HandlerFunc {
+func PixChargeStatusHandler(db *sql.DB, provider payment.PixProvider) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
 		txid := vars["txid"]
@@ -178,69 +160,34 @@ func PixChargeStatusHandler() http.HandlerFunc {
 			return
 		}
 
-		// Obtém as credenciais do config.go
-		//credentials := Credentials
-		credentials := config.GetCredentials()
-		fmt.Printf("Credentials: %+v\n", credentials)
-		efi := pix.NewEfiPay(credentials)
-
-		//efi := pix.NewEfiPay(config.GetCredentials())
+		// Cobrança com vencimento é consultada no endpoint da cobv
+		var comVencimento bool
+		err := db.QueryRow(`
+			SELECT EXISTS (SELECT 1 FROM core.pix_qrcode_status WHERE id_pix = $1 AND data_vencimento IS NOT NULL)
+		`, txid).Scan(&comVencimento)
+		if err != nil {
+			http.Error(w, "Erro ao buscar cobrança: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
 
 		// Consulta o status da cobrança PIX
-		res, err := efi.DetailCharge(txid)
+		var charge *payment.Charge
+		if comVencimento {
+			charge, err = provider.DetailDueCharge(txid)
+		} else {
+			charge, err = provider.DetailCharge(txid)
+		}
 		if err != nil {
 			http.Error(w, fmt.Sprintf("Erro ao consultar status do PIX: %v", err), http.StatusBadRequest)
 			return
 		}
 
 		w.Header().Set("Content-Type", "application/json")
-		w.Write([]byte(res)) // Retorna a resposta original da API
+		w.Write(charge.Raw) // Retorna a resposta original da API
 	}
 }
 
-//executa monitoramente de pagamento apos chamada da função por param
-func IniciarMonitoramentoStatusPagamento(db *sql.DB, txid string) error {
-	checkInterval := []time.Duration{30 * time.Second, 1 * time.Minute}
-	attempts := []int{10, 21}
-
-	for phase := 0; phase < 2; phase++ {
-		for i := 0; i < attempts[phase]; i++ {
-			fmt.Printf("Verificando status para txid: %s (tentativa %d/%d)\n", txid, i+1, attempts[phase])
-
-			status, err := consultarStatusPix(txid)
-			if err != nil {
-				fmt.Println("Erro ao consultar status PIX:", err)
-				return err
-			}
-
-
-			fmt.Println("MOCK PARA QUE TODOS OS PIX SEJAM VALIDO REMOVER:", status)
-			/**
-			*
-			*  ATEMÇÂO
-			*
-			*  MOCK PARA QUE TODOS OS PIX SEJAM VALIDO REMOVER 
-			*
-			* DESCOMENTE O IF E APAQUE A CHAMADA AUTOMATICA DA FUNÇÂO atualizarStatusPagamento
-			*/
-			/*
-			if status == "CONCLUIDA" {
-				atualizarStatusPagamento(db, txid)
-				return nil
-			}*/
-			atualizarStatusPagamento(db, txid)
-			return nil
-
-			time.Sleep(checkInterval[phase])
-		}
-	}
-
-	fmt.Println("Verificações encerradas sem pagamento concluído para:", txid)
-	marcarPagamentoVencido(db, txid)
-	return nil
-}
-
-// executa monitoramente de pagamento apos chamada por POST
+// executa monitoramente de pagamento apos chamada por POST (agenda a consulta imediata na fila de jobs)
 func MonitorarStatusPagamentoHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
@@ -250,183 +197,69 @@ func MonitorarStatusPagamentoHandler(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		go func() {
-			checkInterval := []time.Duration{30 * time.Second, 1 * time.Minute}
-			attempts := []int{10, 21}
-
-			for phase := 0; phase < 2; phase++ {
-				for i := 0; i < attempts[phase]; i++ {
-					fmt.Printf("Verificando status para txid: %s (tentativa %d/%d)\n", txid, i+1, attempts[phase])
-
-					status, err := consultarStatusPix(txid)
-					if err != nil {
-						fmt.Println("Erro ao consultar status PIX:", err)
-						break
-					}
-
-					fmt.Println("MOCK PARA QUE TODOS OS PIX SEJAM VALIDO REMOVER:", status)
-					/**
-					*
-					*  ATEMÇÂO
-					*
-					*  MOCK PARA QUE TODOS OS PIX SEJAM VALIDO REMOVER 
-					*
-					* DESCOMENTE O IF E APAQUE A CHAMADA AUTOMATICA DA FUNÇÂO atualizarStatusPagamento
-					*/
-					/*if status == "CONCLUIDA" {
-						// Atualizar banco de dados
-						atualizarStatusPagamento(db, txid)
-						return
-					}*/
-					atualizarStatusPagamento(db, txid)
+		var existe bool
+		err := db.QueryRow(`
+			SELECT EXISTS (SELECT 1 FROM core.pix_qrcode_status WHERE id_pix = $1)
+		`, txid).Scan(&existe)
+		if err != nil {
+			http.Error(w, "Erro ao buscar cobrança: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !existe {
+			http.Error(w, "Cobrança não encontrada", http.StatusNotFound)
+			return
+		}
 
-					time.Sleep(checkInterval[phase])
-				}
-			}
-			fmt.Println("Verificações encerradas sem pagamento concluído para:", txid)
-			marcarPagamentoVencido(db, txid) // ✅ Adicionando chamada para marcar como VENCIDO
-		}()
+		if err := jobs.RunNow(db, JobPixStatus, txid); err != nil {
+			http.Error(w, "Erro ao agendar monitoramento: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
 
 		w.WriteHeader(http.StatusAccepted)
 		w.Write([]byte("Monitoramento iniciado"))
 	}
 }
 
-func consultarStatusPix(txid string) (string, error) {
-	//fmt.Println("Verifica status pix para id:", txid)
-	efi := pix.NewEfiPay(config.GetCredentials())
-	res, err := efi.DetailCharge(txid)
-	if err != nil {
-		return "", err
-	}
-
-	var resMap map[string]interface{}
-	if err := json.Unmarshal([]byte(res), &resMap); err != nil {
-		return "", err
-	}
-
-	status, ok := resMap["status"].(string)
-	if !ok {
-		return "", fmt.Errorf("status não encontrado na resposta")
-	}
-
-	return status, nil
-}
-
-func atualizarStatusPagamento(db *sql.DB, txid string) {
-	now := time.Now()
-	fmt.Println("Atualiza pagamento confirmado PIX para id:", txid)
-
-	// Atualiza status da cobrança
-	_, err := db.Exec(`
-		UPDATE core.pix_qrcode_status
-		SET status = 'CONCLUIDA', buscar = false, finalizado = true, data_pago = $1
-		WHERE id_pix = $2
-	`, now, txid)
-	if err != nil {
-		fmt.Println("Erro ao atualizar pix_qrcode_status:", err)
-		return
-	}
-
-	// Recupera id_doacao e valor original do PIX (para cálculo de 90%)
-	var idDoacao string
-	var valorOriginal float64
-	err = db.QueryRow(`
-		SELECT pq.id_doacao, pq.valor
-		FROM core.pix_qrcode pq
-		JOIN core.pix_qrcode_status pqs ON pqs.id_pix_qrcode = pq.id
-		WHERE pqs.id_pix = $1
-		LIMIT 1
-	`, txid).Scan(&idDoacao, &valorOriginal)
-	if err != nil {
-		fmt.Println("Erro ao buscar valor e doação do PIX:", err)
-		return
-	}
-
-	// Aplica taxa de 10%
-	valorLiquido := valorOriginal * 0.90
-
-	// Atualiza campo visível do PIX
-	_, err = db.Exec(`
-		UPDATE core.pix_qrcode
-		SET visivel = true
-		WHERE id_doacao = $1 AND valor = $2
-	`, idDoacao, valorOriginal)
-	if err != nil {
-		fmt.Println("Erro ao atualizar visibilidade do PIX:", err)
-	}
-
-	// Soma o valor líquido ao campo valor_disponivel em doacao_pagamentos
-	_, err = db.Exec(`
-		UPDATE core.doacao_pagamentos
-		SET valor_disponivel = COALESCE(valor_disponivel, 0) + $1,
-			data_update = $2
-		WHERE id_doacao = $3
-	`, valorLiquido, now, idDoacao)
-	if err != nil {
-		fmt.Println("Erro ao atualizar doacao_pagamentos:", err)
-	}
-}
-
-func marcarPagamentoVencido(db *sql.DB, txid string) {
+func marcarPagamentoVencido(db *sql.DB, txid string) error {
 	fmt.Println("Atializa Vencido pix para id:", txid)
 	_, err := db.Exec(`
 		UPDATE core.pix_qrcode_status
 		SET status = 'VENCIDO', buscar = false
-		WHERE id_pix = $1
+		WHERE id_pix = $1 AND status IS DISTINCT FROM 'CONCLUIDA'
 	`, txid)
 	if err != nil {
 		fmt.Println("Erro ao marcar cobrança como vencida:", err)
+		return err
 	}
+
+	// Fatura de plano não paga deixa de valer
+	_, err = db.Exec(`
+		UPDATE core.conta_nivel_pagamento
+		SET status = $2, pg_status = 'VENCIDO', valido = false
+		WHERE txid = $1 AND pago = false
+	`, txid, FaturaVencida)
+	if err != nil {
+		fmt.Println("Erro ao marcar fatura como vencida:", err)
+	}
+	return err
 }
 
 
+// MonitorarStatusAllPagamentosHandler agenda a verificação de todas as cobranças em aberto. Os jobs são
+// executados pela fila com concorrência limitada (JOB_WORKERS).
 func MonitorarStatusAllPagamentosHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Verifica o header de segurança
-		authKey := r.Header.Get("KEY")
-		if authKey != "MINHAKEY_123" {
-			http.Error(w, "Chave de acesso inválida", http.StatusUnauthorized)
-			return
-		}
-
-		// Consulta os txids que devem ser monitorados
-		rows, err := db.Query(`
-			SELECT id_pix 
-			FROM core.pix_qrcode_status 
-			WHERE status = 'ATIVA' 
-			  AND buscar = true 
-			  AND finalizado = false 
-			  AND data_pago IS NULL
-		`)
+		total, err := EnqueuePendingPixStatusJobs(db)
 		if err != nil {
-			http.Error(w, "Erro ao buscar cobranças ativas: "+err.Error(), http.StatusInternalServerError)
+			http.Error(w, "Erro ao agendar cobranças ativas: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
-		defer rows.Close()
-
-		var txids []string
-		for rows.Next() {
-			var txid string
-			if err := rows.Scan(&txid); err == nil {
-				txids = append(txids, txid)
-			}
-		}
-
-		// Iniciar verificação assíncrona para cada cobrança
-		for _, txid := range txids {
-			go func(id string) {
-				if err := IniciarMonitoramentoStatusPagamento(db, id); err != nil {
-					fmt.Printf("Erro ao monitorar txid %s: %v\n", id, err)
-				}
-			}(txid)
-		}
 
 		// Resposta imediata
 		w.WriteHeader(http.StatusAccepted)
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"message":         "Monitoramento iniciado",
-			"total_monitorar": len(txids),
+			"total_monitorar": total,
 		})
 	}
-}
\ No newline at end of file
+}
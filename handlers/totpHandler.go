@@ -0,0 +1,425 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"BACK_SORTE_GO/config"
+	"BACK_SORTE_GO/middleware"
+	"BACK_SORTE_GO/totp"
+	"BACK_SORTE_GO/utils"
+
+	"github.com/google/uuid"
+)
+
+// Quantidade de códigos de recuperação gerados ao ativar o 2FA
+const recoveryCodeCount = 10
+
+type totpCodeRequest struct {
+	Code string `json:"code"`
+}
+
+func totpIssuer() string {
+	if issuer := config.GetTotpIssuer(); issuer != "" {
+		return issuer
+	}
+	return "BACK_SORTE"
+}
+
+// normalizeRecoveryCode aceita o código com ou sem hífen e em qualquer caixa
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// totpEnabled informa se o usuário concluiu a ativação do 2FA
+func totpEnabled(db *sql.DB, userID string) (bool, error) {
+	var enabled bool
+	err := db.QueryRow(`
+		SELECT COALESCE((SELECT enabled FROM core.user_totp WHERE id_user = $1), false)
+	`, userID).Scan(&enabled)
+	return enabled, err
+}
+
+// generateRecoveryCodes substitui os códigos de recuperação do usuário e retorna os novos em claro
+func generateRecoveryCodes(tx *sql.Tx, userID string) ([]string, error) {
+	if _, err := tx.Exec(`DELETE FROM core.user_recovery_code WHERE id_user = $1`, userID); err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		raw, err := totp.GenerateSecret()
+		if err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(raw[:5] + "-" + raw[5:10])
+
+		_, err = tx.Exec(`
+			INSERT INTO core.user_recovery_code (id, id_user, code_hash, date_create)
+			VALUES ($1, $2, $3, now())
+		`, uuid.NewString(), userID, utils.HashToken(normalizeRecoveryCode(code)))
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+
+	return codes, nil
+}
+
+// verifySecondFactor confere um código TOTP (sem permitir reuso do mesmo código) ou um código de recuperação ainda não usado
+func verifySecondFactor(db *sql.DB, userID, code string) (bool, error) {
+	var secret string
+	err := db.QueryRow(`
+		SELECT secret FROM core.user_totp WHERE id_user = $1 AND enabled = true
+	`, userID).Scan(&secret)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if step, ok := totp.Validate(secret, code, time.Now()); ok {
+		res, err := db.Exec(`
+			UPDATE core.user_totp SET last_step = $1 WHERE id_user = $2 AND last_step < $1
+		`, step, userID)
+		if err != nil {
+			return false, err
+		}
+		n, _ := res.RowsAffected()
+		return n == 1, nil
+	}
+
+	res, err := db.Exec(`
+		UPDATE core.user_recovery_code SET used_at = now()
+		WHERE id_user = $1 AND code_hash = $2 AND used_at IS NULL
+	`, userID, utils.HashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n == 1, nil
+}
+
+// checkSecondFactor aplica o bloqueio progressivo do login à verificação do código e registra a tentativa.
+// Retorna o tempo de espera quando o usuário está bloqueado.
+func checkSecondFactor(db *sql.DB, r *http.Request, userID, code, motivoSucesso string) (bool, time.Duration, error) {
+	var email string
+	if err := db.QueryRow(`SELECT email FROM core.user WHERE id = $1`, userID).Scan(&email); err != nil {
+		return false, 0, err
+	}
+
+	retryAfter, err := checkLoginLock(db, email, utils.ClientIP(r))
+	if err != nil {
+		return false, 0, err
+	}
+	if retryAfter > 0 {
+		recordLoginAttempt(db, r, email, userID, false, loginMotivoBloqueado)
+		return false, retryAfter, nil
+	}
+
+	ok, err := verifySecondFactor(db, userID, code)
+	if err != nil {
+		return false, 0, err
+	}
+	if !ok {
+		recordLoginAttempt(db, r, email, userID, false, loginMotivoTOTPInvalido)
+		return false, 0, nil
+	}
+	recordLoginAttempt(db, r, email, userID, true, motivoSucesso)
+
+	return true, 0, nil
+}
+
+// writeSecondFactorError responde a falha de checkSecondFactor
+func writeSecondFactorError(w http.ResponseWriter, retryAfter time.Duration) {
+	if retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+		http.Error(w, "Muitas tentativas, tente novamente mais tarde", http.StatusTooManyRequests)
+		return
+	}
+	http.Error(w, "Código de verificação inválido", http.StatusUnauthorized)
+}
+
+// markStepUp registra na sessão que o 2FA acabou de ser confirmado
+func markStepUp(db *sql.DB, sessionID string) error {
+	_, err := db.Exec(`UPDATE core.user_session SET step_up_at = now() WHERE id = $1`, sessionID)
+	return err
+}
+
+// TotpSetupHandler gera um novo segredo TOTP (ainda inativo) e retorna a URI para o QR code do aplicativo autenticador
+func TotpSetupHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := middleware.GetPrincipal(r)
+		if !ok {
+			http.Error(w, "Usuário não autenticado", http.StatusUnauthorized)
+			return
+		}
+
+		enabled, err := totpEnabled(db, principal.UserID)
+		if err != nil {
+			http.Error(w, "Erro ao verificar 2FA: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if enabled {
+			http.Error(w, "Autenticação em duas etapas já está ativa", http.StatusConflict)
+			return
+		}
+
+		var email string
+		if err := db.QueryRow(`SELECT email FROM core.user WHERE id = $1`, principal.UserID).Scan(&email); err != nil {
+			http.Error(w, "Erro ao buscar usuário: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		secret, err := totp.GenerateSecret()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		_, err = db.Exec(`
+			INSERT INTO core.user_totp (id_user, secret, enabled, last_step, date_create)
+			VALUES ($1, $2, false, 0, now())
+			ON CONFLICT (id_user) DO UPDATE
+			SET secret = EXCLUDED.secret, last_step = 0, date_create = now()
+			WHERE core.user_totp.enabled = false
+		`, principal.UserID, secret)
+		if err != nil {
+			http.Error(w, "Erro ao salvar 2FA: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		jsonResponse(w, http.StatusOK, map[string]string{
+			"secret":      secret,
+			"otpauth_url": totp.URL(totpIssuer(), email, secret),
+		})
+	}
+}
+
+// TotpEnableHandler confirma o primeiro código do aplicativo, ativa o 2FA e devolve os códigos de recuperação (exibidos uma única vez)
+func TotpEnableHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := middleware.GetPrincipal(r)
+		if !ok {
+			http.Error(w, "Usuário não autenticado", http.StatusUnauthorized)
+			return
+		}
+
+		var req totpCodeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Erro ao processar o JSON", http.StatusBadRequest)
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			http.Error(w, "Erro ao iniciar transação: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		var secret string
+		err = tx.QueryRow(`
+			SELECT secret FROM core.user_totp WHERE id_user = $1 AND enabled = false FOR UPDATE
+		`, principal.UserID).Scan(&secret)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Nenhuma configuração de 2FA pendente, gere um novo segredo", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Erro ao buscar 2FA: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		step, valid := totp.Validate(secret, req.Code, time.Now())
+		if !valid {
+			http.Error(w, "Código de verificação inválido", http.StatusUnauthorized)
+			return
+		}
+
+		_, err = tx.Exec(`
+			UPDATE core.user_totp SET enabled = true, last_step = $1, date_enabled = now() WHERE id_user = $2
+		`, step, principal.UserID)
+		if err != nil {
+			http.Error(w, "Erro ao ativar 2FA: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		codes, err := generateRecoveryCodes(tx, principal.UserID)
+		if err != nil {
+			http.Error(w, "Erro ao gerar códigos de recuperação: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if _, err := tx.Exec(`UPDATE core.user_session SET step_up_at = now() WHERE id = $1`, principal.SessionID); err != nil {
+			http.Error(w, "Erro ao atualizar sessão: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			http.Error(w, "Erro ao confirmar transação: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		jsonResponse(w, http.StatusOK, map[string]interface{}{
+			"message":        "Autenticação em duas etapas ativada com sucesso",
+			"recovery_codes": codes,
+		})
+	}
+}
+
+// TotpVerifyHandler confirma o código na sessão atual (step-up), liberando por alguns minutos
+// as operações sensíveis como alterar a conta de saque e solicitar o resgate
+func TotpVerifyHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := middleware.GetPrincipal(r)
+		if !ok {
+			http.Error(w, "Usuário não autenticado", http.StatusUnauthorized)
+			return
+		}
+
+		var req totpCodeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Erro ao processar o JSON", http.StatusBadRequest)
+			return
+		}
+
+		enabled, err := totpEnabled(db, principal.UserID)
+		if err != nil {
+			http.Error(w, "Erro ao verificar 2FA: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !enabled {
+			http.Error(w, "Autenticação em duas etapas não está ativa", http.StatusBadRequest)
+			return
+		}
+
+		valid, retryAfter, err := checkSecondFactor(db, r, principal.UserID, req.Code, loginMotivoStepUp)
+		if err != nil {
+			http.Error(w, "Erro ao verificar código: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !valid {
+			writeSecondFactorError(w, retryAfter)
+			return
+		}
+
+		if err := markStepUp(db, principal.SessionID); err != nil {
+			http.Error(w, "Erro ao atualizar sessão: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		jsonResponse(w, http.StatusOK, map[string]interface{}{
+			"message":    "Código confirmado",
+			"expires_in": int(middleware.StepUpTTL.Seconds()),
+		})
+	}
+}
+
+// TotpRecoveryCodesHandler gera novos códigos de recuperação, invalidando os anteriores
+func TotpRecoveryCodesHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := middleware.GetPrincipal(r)
+		if !ok {
+			http.Error(w, "Usuário não autenticado", http.StatusUnauthorized)
+			return
+		}
+
+		var req totpCodeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Erro ao processar o JSON", http.StatusBadRequest)
+			return
+		}
+
+		valid, retryAfter, err := checkSecondFactor(db, r, principal.UserID, req.Code, loginMotivoStepUp)
+		if err != nil {
+			http.Error(w, "Erro ao verificar código: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !valid {
+			writeSecondFactorError(w, retryAfter)
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			http.Error(w, "Erro ao iniciar transação: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		codes, err := generateRecoveryCodes(tx, principal.UserID)
+		if err != nil {
+			http.Error(w, "Erro ao gerar códigos de recuperação: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			http.Error(w, "Erro ao confirmar transação: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		jsonResponse(w, http.StatusOK, map[string]interface{}{
+			"recovery_codes": codes,
+		})
+	}
+}
+
+// TotpDisableHandler desativa o 2FA após confirmar um código válido
+func TotpDisableHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := middleware.GetPrincipal(r)
+		if !ok {
+			http.Error(w, "Usuário não autenticado", http.StatusUnauthorized)
+			return
+		}
+
+		var req totpCodeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Erro ao processar o JSON", http.StatusBadRequest)
+			return
+		}
+
+		valid, retryAfter, err := checkSecondFactor(db, r, principal.UserID, req.Code, loginMotivoStepUp)
+		if err != nil {
+			http.Error(w, "Erro ao verificar código: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !valid {
+			writeSecondFactorError(w, retryAfter)
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			http.Error(w, "Erro ao iniciar transação: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		if _, err := tx.Exec(`DELETE FROM core.user_recovery_code WHERE id_user = $1`, principal.UserID); err != nil {
+			http.Error(w, "Erro ao remover códigos de recuperação: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if _, err := tx.Exec(`DELETE FROM core.user_totp WHERE id_user = $1`, principal.UserID); err != nil {
+			http.Error(w, "Erro ao desativar 2FA: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			http.Error(w, "Erro ao confirmar transação: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		jsonResponse(w, http.StatusOK, map[string]string{
+			"message": "Autenticação em duas etapas desativada",
+		})
+	}
+}
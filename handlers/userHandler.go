@@ -2,9 +2,13 @@ package handlers
 
 import (
 	"BACK_SORTE_GO/config"
+	"BACK_SORTE_GO/cpf"
+	"BACK_SORTE_GO/mail"
+	"BACK_SORTE_GO/middleware"
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"log"
 	"mime/multipart"
 	"net/http"
 	"path/filepath"
@@ -17,13 +21,12 @@ import (
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
-	"github.com/golang-jwt/jwt/v4"
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	"golang.org/x/crypto/bcrypt"
 )
 
-func CreateUserHandler(db *sql.DB) http.HandlerFunc {
+func CreateUserHandler(db *sql.DB, mailer mail.Sender) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var req struct {
 			Name     string `json:"name"`
@@ -44,9 +47,17 @@ func CreateUserHandler(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
+		// Valida e normaliza o CPF/CNPJ
+		documento, _, err := cpf.ParseDocumento(req.CPF)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		req.CPF = documento
+
 		// Verificar duplicação de email
 		var exists bool
-		err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM core.user WHERE email = $1)", req.Email).Scan(&exists)
+		err = db.QueryRow("SELECT EXISTS(SELECT 1 FROM core.user WHERE email = $1)", req.Email).Scan(&exists)
 		if err != nil {
 			http.Error(w, "Erro ao verificar duplicação de email: "+err.Error(), http.StatusInternalServerError)
 			return
@@ -78,6 +89,16 @@ func CreateUserHandler(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
+		// Documento já validado pelos dígitos verificadores
+		_, err = db.Exec(`
+			INSERT INTO core.user_details (id, id_user, cpf_valid, email_valid, date_create, date_update)
+			VALUES ($1, $2, true, false, $3, $3)
+		`, uuid.NewString(), userID, now)
+		if err != nil {
+			http.Error(w, "Erro ao criar user_details: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
 		// Inserir em conta_nivel
 		_, err = db.Exec(`
 			INSERT INTO core.conta_nivel (
@@ -105,6 +126,11 @@ func CreateUserHandler(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
+		// Envia o link de verificação de e-mail (falha no envio não impede o cadastro)
+		if err := sendEmailVerification(db, mailer, userID, req.Email, req.Name); err != nil {
+			log.Println("Erro ao enviar e-mail de verificação:", err)
+		}
+
 		jsonResponse(w, http.StatusCreated, map[string]string{
 			"message": "Usuário criado com sucesso",
 			"id":      userID,
@@ -121,30 +147,13 @@ func jsonResponse(w http.ResponseWriter, status int, data interface{}) {
 
 func UserPasswordChangeHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Obter o token do cabeçalho Authorization
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			http.Error(w, "Token não fornecido", http.StatusUnauthorized)
-			return
-		}
-		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-
-		// Validar e extrair claims
-		claims := jwt.MapClaims{}
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-			return jwtSecretKey, nil
-		})
-		if err != nil || !token.Valid {
-			http.Error(w, "Token inválido", http.StatusUnauthorized)
-			return
-		}
-
-		// Obter o ID do usuário do token
-		userID, ok := claims["sub"].(string)
-		if !ok || userID == "" {
-			http.Error(w, "ID do usuário inválido no token", http.StatusUnauthorized)
+		// Obter o ID do usuário autenticado
+		principal, ok := middleware.GetPrincipal(r)
+		if !ok {
+			http.Error(w, "Usuário não autenticado", http.StatusUnauthorized)
 			return
 		}
+		userID := principal.UserID
 
 		// Estrutura esperada no JSON
 		var req struct {
@@ -163,7 +172,7 @@ func UserPasswordChangeHandler(db *sql.DB) http.HandlerFunc {
 
 		// Obter a senha atual do banco
 		var hashedPassword string
-		err = db.QueryRow("SELECT password FROM core.user WHERE id = $1", userID).Scan(&hashedPassword)
+		err := db.QueryRow("SELECT password FROM core.user WHERE id = $1", userID).Scan(&hashedPassword)
 		if err != nil {
 			http.Error(w, "Usuário não encontrado", http.StatusNotFound)
 			return
@@ -189,38 +198,27 @@ func UserPasswordChangeHandler(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
+		// Encerra todas as sessões abertas com a senha antiga
+		if err := revokeUserSessions(db, userID, "PASSWORD_CHANGE"); err != nil {
+			http.Error(w, "Erro ao encerrar sessões: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
 		jsonResponse(w, http.StatusOK, map[string]string{
-			"message": "Senha atualizada com sucesso",
+			"message": "Senha atualizada com sucesso, faça login novamente",
 		})
 	}
 }
 
 func UserBankAccountHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Obter token
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			http.Error(w, "Token não fornecido", http.StatusUnauthorized)
-			return
-		}
-		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-
-		// Parse do token
-		claims := jwt.MapClaims{}
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-			return jwtSecretKey, nil
-		})
-		if err != nil || !token.Valid {
-			http.Error(w, "Token inválido", http.StatusUnauthorized)
-			return
-		}
-
-		// Extrair id_user
-		idUser, ok := claims["sub"].(string)
-		if !ok || idUser == "" {
-			http.Error(w, "ID do usuário inválido", http.StatusUnauthorized)
+		// Obter o ID do usuário autenticado
+		principal, ok := middleware.GetPrincipal(r)
+		if !ok {
+			http.Error(w, "Usuário não autenticado", http.StatusUnauthorized)
 			return
 		}
+		idUser := principal.UserID
 
 		// Estrutura da requisição
 		var req struct {
@@ -246,6 +244,14 @@ func UserBankAccountHandler(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
+		// Valida e normaliza o CPF/CNPJ
+		documento, _, err := cpf.ParseDocumento(req.CPF)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		req.CPF = documento
+
 		// Inserir no banco
 		id := uuid.NewString()
 		_, err = db.Exec(`
@@ -270,27 +276,13 @@ func UserBankAccountHandler(db *sql.DB) http.HandlerFunc {
 
 func UserBankAccountUpdateHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Validar e extrair o token
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			http.Error(w, "Token não fornecido", http.StatusUnauthorized)
-			return
-		}
-		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-		claims := jwt.MapClaims{}
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-			return jwtSecretKey, nil
-		})
-		if err != nil || !token.Valid {
-			http.Error(w, "Token inválido", http.StatusUnauthorized)
-			return
-		}
-
-		idUser, ok := claims["sub"].(string)
-		if !ok || idUser == "" {
-			http.Error(w, "ID do usuário inválido", http.StatusUnauthorized)
+		// Obter o ID do usuário autenticado
+		principal, ok := middleware.GetPrincipal(r)
+		if !ok {
+			http.Error(w, "Usuário não autenticado", http.StatusUnauthorized)
 			return
 		}
+		idUser := principal.UserID
 
 		// Estrutura da requisição
 		var req struct {
@@ -309,12 +301,20 @@ func UserBankAccountUpdateHandler(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
+		// Valida e normaliza o CPF/CNPJ
+		documento, _, err := cpf.ParseDocumento(req.CPF)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		req.CPF = documento
+
 		// Verificar se a conta antiga pertence ao usuário e está ativa
 		var exists bool
 		err = db.QueryRow(`
 			SELECT EXISTS (
 				SELECT 1 FROM core.saque_conta 
-				WHERE id = $1 AND id_user = $1 AND active = true
+				WHERE id = $1 AND id_user = $2 AND active = true
 			)
 		`, req.IDContaOld, idUser).Scan(&exists)
 		if err != nil {
@@ -361,28 +361,13 @@ func UserBankAccountUpdateHandler(db *sql.DB) http.HandlerFunc {
 
 func UserBankAccountGetHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Extrair o token
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			http.Error(w, "Token não fornecido", http.StatusUnauthorized)
-			return
-		}
-		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-		claims := jwt.MapClaims{}
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-			return jwtSecretKey, nil
-		})
-		if err != nil || !token.Valid {
-			http.Error(w, "Token inválido", http.StatusUnauthorized)
-			return
-		}
-
-		// Pegar id do token
-		idFromToken, ok := claims["sub"].(string)
-		if !ok || idFromToken == "" {
-			http.Error(w, "ID do usuário inválido", http.StatusUnauthorized)
+		// Pegar id do usuário autenticado
+		principal, ok := middleware.GetPrincipal(r)
+		if !ok {
+			http.Error(w, "Usuário não autenticado", http.StatusUnauthorized)
 			return
 		}
+		idFromToken := principal.UserID
 
 		// Pegar id_user da query
 		idFromQuery := r.URL.Query().Get("id_user")
@@ -410,7 +395,7 @@ func UserBankAccountGetHandler(db *sql.DB) http.HandlerFunc {
 			Pix       string `json:"pix"`
 		}
 
-		err = db.QueryRow(`
+		err := db.QueryRow(`
 			SELECT id, banco, banco_nome, conta, agencia, digito, cpf, telefone, COALESCE(pix, '') 
 			FROM core.saque_conta 
 			WHERE id_user = $1 AND active = true AND dell = false
@@ -426,6 +411,7 @@ func UserBankAccountGetHandler(db *sql.DB) http.HandlerFunc {
 			http.Error(w, "Erro ao buscar os dados: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
+		conta.CPF = cpf.FormatDocumento(conta.CPF)
 
 		jsonResponse(w, http.StatusOK, conta)
 	}
@@ -433,39 +419,16 @@ func UserBankAccountGetHandler(db *sql.DB) http.HandlerFunc {
 
 func UploadUserProfileImageHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Validar token JWT
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			http.Error(w, "Token ausente", http.StatusUnauthorized)
-			return
-		}
-
-		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-
-		// Validar e extrair claims config.GetJwtSecret()
-		claims := jwt.MapClaims{}
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-			return jwtSecretKey, nil
-		})
-		if err != nil || !token.Valid {
-			http.Error(w, "Token inválido", http.StatusUnauthorized)
-			return
-		}
-
-		claims, ok := token.Claims.(jwt.MapClaims)
+		// Obter o ID do usuário autenticado
+		principal, ok := middleware.GetPrincipal(r)
 		if !ok {
-			http.Error(w, "Erro ao processar claims do token", http.StatusUnauthorized)
-			return
-		}
-
-		idFromToken, ok := claims["sub"].(string)
-		if !ok || idFromToken == "" {
-			http.Error(w, "ID do usuário inválido", http.StatusUnauthorized)
+			http.Error(w, "Usuário não autenticado", http.StatusUnauthorized)
 			return
 		}
+		idFromToken := principal.UserID
 
 		// Parse do arquivo enviado
-		err = r.ParseMultipartForm(10 << 20) // 10MB
+		err := r.ParseMultipartForm(10 << 20) // 10MB
 		if err != nil {
 			http.Error(w, "Erro ao parsear o formulário: "+err.Error(), http.StatusBadRequest)
 			return
@@ -653,31 +616,13 @@ type UserNameChangeRequest struct {
 func UserNameChangeHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
-		// Obter token
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			http.Error(w, "Token não fornecido", http.StatusUnauthorized)
-			return
-		}
-
-		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-
-		// Validar e extrair claims config.GetJwtSecret()
-		claims := jwt.MapClaims{}
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-			return jwtSecretKey, nil
-		})
-		if err != nil || !token.Valid {
-			http.Error(w, "Token inválido", http.StatusUnauthorized)
-			return
-		}
-
-		// ID do token
-		idFromToken, ok := claims["sub"].(string)
-		if !ok || idFromToken == "" {
-			http.Error(w, "ID do usuário inválido", http.StatusUnauthorized)
+		// ID do usuário autenticado
+		principal, ok := middleware.GetPrincipal(r)
+		if !ok {
+			http.Error(w, "Usuário não autenticado", http.StatusUnauthorized)
 			return
 		}
+		idFromToken := principal.UserID
 
 		// Decodificar body
 		var req UserNameChangeRequest
@@ -694,7 +639,7 @@ func UserNameChangeHandler(db *sql.DB) http.HandlerFunc {
 
 		// Verificar se o nome antigo está correto
 		var currentName string
-		err = db.QueryRow(`SELECT name FROM core.user WHERE id = $1`, req.IDUser).Scan(&currentName)
+		err := db.QueryRow(`SELECT name FROM core.user WHERE id = $1`, req.IDUser).Scan(&currentName)
 		if err == sql.ErrNoRows {
 			http.Error(w, "Usuário não encontrado", http.StatusNotFound)
 			return
@@ -0,0 +1,71 @@
+package jobs
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// Status de core.job
+const (
+	StatusPending = "PENDING"
+	StatusRunning = "RUNNING"
+	StatusDone    = "DONE"
+	StatusFailed  = "FAILED"
+)
+
+// Tentativas com erro antes de o job ser marcado como FAILED
+const DefaultMaxAttempts = 10
+
+// Job é uma tarefa persistida em core.job. Chave identifica o objeto da tarefa (ex.: txid) e
+// evita dois jobs pendentes do mesmo tipo para a mesma chave.
+type Job struct {
+	ID          string
+	Tipo        string
+	Chave       string
+	Payload     json.RawMessage
+	Attempts    int
+	MaxAttempts int
+	NextRunAt   time.Time
+}
+
+// Execer é satisfeito por *sql.DB e *sql.Tx, permitindo enfileirar na mesma transação que cria o objeto
+type Execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// Enqueue agenda um job para runAt. Se já existir um job pendente do mesmo tipo e chave, nada é feito
+// e o retorno é false.
+func Enqueue(q Execer, tipo, chave string, payload interface{}, runAt time.Time) (bool, error) {
+	raw := []byte("{}")
+	if payload != nil {
+		var err error
+		if raw, err = json.Marshal(payload); err != nil {
+			return false, err
+		}
+	}
+
+	res, err := q.Exec(`
+		INSERT INTO core.job (tipo, chave, payload, status, attempts, max_attempts, next_run_at, date_create, date_update)
+		VALUES ($1, $2, $3, $4, 0, $5, $6, now(), now())
+		ON CONFLICT (tipo, chave) WHERE status IN ('PENDING', 'RUNNING') DO NOTHING
+	`, tipo, chave, raw, StatusPending, DefaultMaxAttempts, runAt)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n == 1, nil
+}
+
+// RunNow agenda o job para execução imediata, antecipando o job pendente do mesmo tipo e chave se já existir
+func RunNow(q Execer, tipo, chave string) error {
+	created, err := Enqueue(q, tipo, chave, nil, time.Now())
+	if err != nil || created {
+		return err
+	}
+	_, err = q.Exec(`
+		UPDATE core.job SET next_run_at = now(), date_update = now()
+		WHERE tipo = $1 AND chave = $2 AND status = $3 AND next_run_at > now()
+	`, tipo, chave, StatusPending)
+	return err
+}
@@ -0,0 +1,241 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Handler executa o job. Retornar retryAfter > 0 reagenda o job sem contar como falha (ex.: cobrança ainda
+// não paga); retornar erro conta uma tentativa e reagenda com backoff exponencial.
+type Handler func(ctx context.Context, job Job) (retryAfter time.Duration, err error)
+
+const (
+	// Intervalo de busca quando não há jobs prontos
+	pollInterval = 2 * time.Second
+	// Tempo máximo de execução de um job
+	jobTimeout = time.Minute
+	// Jobs em RUNNING há mais que isso são considerados abandonados (processo encerrado no meio)
+	staleAfter = 5 * time.Minute
+	// Backoff: 10s, 20s, 40s... limitado a 30 minutos
+	backoffBase = 10 * time.Second
+	backoffMax  = 30 * time.Minute
+)
+
+// Runner executa os jobs de core.job com um número limitado de workers.
+// Cada worker reserva um job por vez com FOR UPDATE SKIP LOCKED, então várias instâncias podem rodar juntas.
+type Runner struct {
+	db       *sql.DB
+	workers  int
+	workerID string
+	handlers map[string]Handler
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewRunner(db *sql.DB, workers int) *Runner {
+	if workers < 1 {
+		workers = 1
+	}
+	hostname, _ := os.Hostname()
+	return &Runner{
+		db:       db,
+		workers:  workers,
+		workerID: hostname + "-" + strconv.Itoa(os.Getpid()),
+		handlers: map[string]Handler{},
+	}
+}
+
+// Register associa o handler ao tipo de job. Deve ser chamado antes de Start.
+func (r *Runner) Register(tipo string, h Handler) {
+	r.handlers[tipo] = h
+}
+
+func (r *Runner) tipos() []string {
+	tipos := make([]string, 0, len(r.handlers))
+	for tipo := range r.handlers {
+		tipos = append(tipos, tipo)
+	}
+	return tipos
+}
+
+// Start inicia os workers. Eles param de reservar jobs quando ctx é cancelado ou Stop é chamado.
+func (r *Runner) Start(ctx context.Context) {
+	ctx, r.cancel = context.WithCancel(ctx)
+
+	if err := r.releaseStale(); err != nil {
+		log.Println("Erro ao liberar jobs abandonados:", err)
+	}
+
+	for i := 0; i < r.workers; i++ {
+		r.wg.Add(1)
+		go r.work(ctx)
+	}
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		ticker := time.NewTicker(staleAfter)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := r.releaseStale(); err != nil {
+					log.Println("Erro ao liberar jobs abandonados:", err)
+				}
+			}
+		}
+	}()
+
+	log.Printf("Fila de jobs iniciada com %d workers\n", r.workers)
+}
+
+// Stop para de reservar novos jobs e aguarda os jobs em execução terminarem, até o timeout.
+// Jobs interrompidos continuam em RUNNING e voltam para a fila em releaseStale.
+func (r *Runner) Stop(timeout time.Duration) error {
+	if r.cancel != nil {
+		r.cancel()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		log.Println("Fila de jobs encerrada")
+		return nil
+	case <-time.After(timeout):
+		return errors.New("tempo esgotado aguardando os jobs em execução")
+	}
+}
+
+func (r *Runner) work(ctx context.Context) {
+	defer r.wg.Done()
+
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+
+		job, ok, err := r.claim()
+		if err != nil {
+			log.Println("Erro ao reservar job:", err)
+		}
+		if err != nil || !ok {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(pollInterval):
+			}
+			continue
+		}
+
+		r.run(job)
+	}
+}
+
+// claim reserva o próximo job pronto de um tipo com handler registrado
+func (r *Runner) claim() (Job, bool, error) {
+	var job Job
+	err := r.db.QueryRow(`
+		UPDATE core.job SET status = $1, locked_at = now(), locked_by = $2, date_update = now()
+		WHERE id = (
+			SELECT id FROM core.job
+			WHERE status = $3 AND next_run_at <= now() AND tipo = ANY($4)
+			ORDER BY next_run_at
+			FOR UPDATE SKIP LOCKED
+			LIMIT 1
+		)
+		RETURNING id, tipo, chave, payload, attempts, max_attempts, next_run_at
+	`, StatusRunning, r.workerID, StatusPending, pq.Array(r.tipos())).Scan(
+		&job.ID, &job.Tipo, &job.Chave, &job.Payload, &job.Attempts, &job.MaxAttempts, &job.NextRunAt,
+	)
+	if err == sql.ErrNoRows {
+		return job, false, nil
+	}
+	if err != nil {
+		return job, false, err
+	}
+	return job, true, nil
+}
+
+// run executa o job com um contexto próprio, para que o encerramento do servidor não interrompa o job no meio
+func (r *Runner) run(job Job) {
+	ctx, cancel := context.WithTimeout(context.Background(), jobTimeout)
+	defer cancel()
+
+	retryAfter, err := r.safeCall(ctx, job)
+
+	switch {
+	case err != nil:
+		job.Attempts++
+		if job.Attempts >= job.MaxAttempts {
+			log.Printf("Job %s (%s %s) falhou definitivamente: %v\n", job.ID, job.Tipo, job.Chave, err)
+			r.finish(job, StatusFailed, job.Attempts, 0, err.Error())
+			return
+		}
+		log.Printf("Job %s (%s %s) falhou, tentativa %d/%d: %v\n", job.ID, job.Tipo, job.Chave, job.Attempts, job.MaxAttempts, err)
+		r.finish(job, StatusPending, job.Attempts, backoff(job.Attempts), err.Error())
+	case retryAfter > 0:
+		r.finish(job, StatusPending, job.Attempts, retryAfter, "")
+	default:
+		r.finish(job, StatusDone, job.Attempts, 0, "")
+	}
+}
+
+func (r *Runner) safeCall(ctx context.Context, job Job) (retryAfter time.Duration, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic no job: %v", p)
+		}
+	}()
+	return r.handlers[job.Tipo](ctx, job)
+}
+
+func (r *Runner) finish(job Job, status string, attempts int, after time.Duration, lastError string) {
+	_, err := r.db.Exec(`
+		UPDATE core.job
+		SET status = $2, attempts = $3, next_run_at = now() + $4::INTERVAL,
+			last_error = NULLIF($5, ''), locked_at = NULL, locked_by = NULL, date_update = now()
+		WHERE id = $1
+	`, job.ID, status, attempts, strconv.Itoa(int(after.Seconds()))+" seconds", lastError)
+	if err != nil {
+		log.Printf("Erro ao atualizar job %s: %v\n", job.ID, err)
+	}
+}
+
+// releaseStale devolve para a fila os jobs reservados por um processo que não terminou
+func (r *Runner) releaseStale() error {
+	_, err := r.db.Exec(`
+		UPDATE core.job
+		SET status = $1, locked_at = NULL, locked_by = NULL, date_update = now()
+		WHERE status = $2 AND locked_at < now() - $3::INTERVAL
+	`, StatusPending, StatusRunning, strconv.Itoa(int(staleAfter.Seconds()))+" seconds")
+	return err
+}
+
+// backoff calcula a espera exponencial após a tentativa informada
+func backoff(attempts int) time.Duration {
+	d := backoffBase
+	for i := 1; i < attempts && d < backoffMax; i++ {
+		d *= 2
+	}
+	if d > backoffMax {
+		d = backoffMax
+	}
+	return d
+}
@@ -0,0 +1,183 @@
+package ledger
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"BACK_SORTE_GO/money"
+)
+
+// Natureza da conta: o saldo de contas devedoras cresce com débitos, o de contas credoras com créditos
+const (
+	NaturezaDevedora = "D"
+	NaturezaCredora  = "C"
+)
+
+// Tipos de conta
+const (
+	TipoProvedor       = "PROVEDOR"
+	TipoTaxaPlataforma = "TAXA_PLATAFORMA"
+	TipoSaqueTransito  = "SAQUE_TRANSITO"
+	TipoCampanha       = "CAMPANHA"
+	TipoReceitaPlano   = "RECEITA_PLANO"
+	TipoContestacao    = "CONTESTACAO_CARTAO"
+)
+
+// Tipos de transação
+const (
+	TransacaoPixRecebido     = "PIX_RECEBIDO"
+	TransacaoTaxa            = "TAXA"
+	TransacaoSaqueSolicitado = "SAQUE_SOLICITADO"
+	TransacaoSaqueConcluido  = "SAQUE_CONCLUIDO"
+	TransacaoSaqueCancelado  = "SAQUE_CANCELADO"
+	TransacaoDevolucao       = "DEVOLUCAO"
+	TransacaoEstornoTaxa     = "ESTORNO_TAXA"
+	TransacaoDevolucaoFalhou = "DEVOLUCAO_FALHOU"
+	TransacaoPlanoRecebido   = "PLANO_RECEBIDO"
+	TransacaoCartaoRecebido  = "CARTAO_RECEBIDO"
+	TransacaoCartaoEstornado = "CARTAO_ESTORNADO"
+	TransacaoCartaoRetido    = "CARTAO_RETIDO"
+	TransacaoCartaoLiberado  = "CARTAO_LIBERADO"
+)
+
+var (
+	ErrTransacaoDesbalanceada = errors.New("transação contábil não está balanceada")
+	ErrTransacaoInvalida      = errors.New("transação contábil precisa de ao menos dois lançamentos com valor")
+)
+
+// Conta contábil. Contas de campanha são criadas no primeiro lançamento.
+type Conta struct {
+	Codigo   string
+	Tipo     string
+	Natureza string
+	IDDoacao string
+}
+
+var (
+	// Dinheiro mantido no PSP (EfiPay): entra com o PIX e o cartão recebidos, sai com saques, devoluções e estornos
+	ContaProvedor = Conta{Codigo: TipoProvedor, Tipo: TipoProvedor, Natureza: NaturezaDevedora}
+	// Receita de taxas da plataforma
+	ContaTaxaPlataforma = Conta{Codigo: TipoTaxaPlataforma, Tipo: TipoTaxaPlataforma, Natureza: NaturezaCredora}
+	// Saques solicitados ainda não transferidos
+	ContaSaqueTransito = Conta{Codigo: TipoSaqueTransito, Tipo: TipoSaqueTransito, Natureza: NaturezaCredora}
+	// Receita das faturas de plano (conta_nivel_pagamento)
+	ContaReceitaPlano = Conta{Codigo: TipoReceitaPlano, Tipo: TipoReceitaPlano, Natureza: NaturezaCredora}
+	// Pagamentos com cartão em contestação (chargeback), retidos do saldo da campanha até a decisão
+	ContaContestacao = Conta{Codigo: TipoContestacao, Tipo: TipoContestacao, Natureza: NaturezaCredora}
+)
+
+// ContaCampanha é o saldo devido ao dono da campanha
+func ContaCampanha(idDoacao string) Conta {
+	return Conta{Codigo: TipoCampanha + ":" + idDoacao, Tipo: TipoCampanha, Natureza: NaturezaCredora, IDDoacao: idDoacao}
+}
+
+// Lancamento: valor positivo é débito, negativo é crédito
+type Lancamento struct {
+	Conta Conta
+	Valor money.Centavos
+}
+
+// Transacao agrupa lançamentos que somam zero. Tipo + Referencia é único: lançar de novo a mesma transação não tem efeito.
+type Transacao struct {
+	Tipo        string
+	Referencia  string
+	IDDoacao    string
+	Descricao   string
+	Lancamentos []Lancamento
+}
+
+// Querier é satisfeito por *sql.DB e *sql.Tx. Os lançamentos devem ser feitos na transação que altera o estado
+// correspondente (liquidação, saque, devolução).
+type Querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func valida(t Transacao) error {
+	var soma money.Centavos
+	lancamentos := 0
+	for _, l := range t.Lancamentos {
+		if l.Valor == 0 {
+			continue
+		}
+		soma += l.Valor
+		lancamentos++
+	}
+	if lancamentos < 2 {
+		return ErrTransacaoInvalida
+	}
+	if soma != 0 {
+		return ErrTransacaoDesbalanceada
+	}
+	return nil
+}
+
+func contaID(q Querier, c Conta) (string, error) {
+	_, err := q.Exec(`
+		INSERT INTO core.ledger_account (codigo, tipo, natureza, id_doacao, date_create)
+		VALUES ($1, $2, $3, NULLIF($4, '')::UUID, now())
+		ON CONFLICT (codigo) DO NOTHING
+	`, c.Codigo, c.Tipo, c.Natureza, c.IDDoacao)
+	if err != nil {
+		return "", err
+	}
+
+	var id string
+	err = q.QueryRow(`SELECT id FROM core.ledger_account WHERE codigo = $1`, c.Codigo).Scan(&id)
+	return id, err
+}
+
+// Lancar grava a transação e seus lançamentos. Retorna false quando a transação (tipo, referência) já existia.
+func Lancar(q Querier, t Transacao) (bool, error) {
+	if err := valida(t); err != nil {
+		return false, fmt.Errorf("%w: %s %s", err, t.Tipo, t.Referencia)
+	}
+
+	var idTransacao string
+	err := q.QueryRow(`
+		INSERT INTO core.ledger_transaction (tipo, referencia, id_doacao, descricao, date_create)
+		VALUES ($1, $2, NULLIF($3, '')::UUID, NULLIF($4, ''), now())
+		ON CONFLICT (tipo, referencia) DO NOTHING
+		RETURNING id
+	`, t.Tipo, t.Referencia, t.IDDoacao, t.Descricao).Scan(&idTransacao)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	for _, l := range t.Lancamentos {
+		if l.Valor == 0 {
+			continue
+		}
+		idConta, err := contaID(q, l.Conta)
+		if err != nil {
+			return false, fmt.Errorf("erro ao buscar conta %s: %w", l.Conta.Codigo, err)
+		}
+		_, err = q.Exec(`
+			INSERT INTO core.ledger_entry (id_transaction, id_account, valor, date_create)
+			VALUES ($1, $2, $3, now())
+		`, idTransacao, idConta, int64(l.Valor))
+		if err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// Saldo retorna o saldo da conta no sentido da sua natureza (a coluna guarda centavos)
+func Saldo(q Querier, c Conta) (money.Centavos, error) {
+	var saldo int64
+	err := q.QueryRow(`SELECT saldo FROM core.ledger_saldo WHERE codigo = $1`, c.Codigo).Scan(&saldo)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return money.Centavos(saldo), err
+}
+
+// SaldoCampanha retorna o valor disponível para saque da campanha
+func SaldoCampanha(q Querier, idDoacao string) (money.Centavos, error) {
+	return Saldo(q, ContaCampanha(idDoacao))
+}
@@ -0,0 +1,201 @@
+package ledger
+
+import "BACK_SORTE_GO/money"
+
+// PixRecebido: o valor bruto entra no PSP e é devido à campanha
+func PixRecebido(idDoacao, txid string, bruto money.Centavos) Transacao {
+	return Transacao{
+		Tipo:       TransacaoPixRecebido,
+		Referencia: txid,
+		IDDoacao:   idDoacao,
+		Descricao:  "PIX recebido",
+		Lancamentos: []Lancamento{
+			{Conta: ContaProvedor, Valor: bruto},
+			{Conta: ContaCampanha(idDoacao), Valor: -bruto},
+		},
+	}
+}
+
+// Taxa: a taxa da plataforma sai do saldo da campanha para a receita
+func Taxa(idDoacao, txid string, taxa money.Centavos) Transacao {
+	return Transacao{
+		Tipo:       TransacaoTaxa,
+		Referencia: txid,
+		IDDoacao:   idDoacao,
+		Descricao:  "Taxa da plataforma",
+		Lancamentos: []Lancamento{
+			{Conta: ContaCampanha(idDoacao), Valor: taxa},
+			{Conta: ContaTaxaPlataforma, Valor: -taxa},
+		},
+	}
+}
+
+// SaqueSolicitado: o valor sai do saldo da campanha e fica em trânsito até a transferência
+func SaqueSolicitado(idDoacao, idSaque string, valor money.Centavos) Transacao {
+	return Transacao{
+		Tipo:       TransacaoSaqueSolicitado,
+		Referencia: idSaque,
+		IDDoacao:   idDoacao,
+		Descricao:  "Saque solicitado",
+		Lancamentos: []Lancamento{
+			{Conta: ContaCampanha(idDoacao), Valor: valor},
+			{Conta: ContaSaqueTransito, Valor: -valor},
+		},
+	}
+}
+
+// SaqueConcluido: a transferência saiu do PSP
+func SaqueConcluido(idDoacao, idSaque string, valor money.Centavos) Transacao {
+	return Transacao{
+		Tipo:       TransacaoSaqueConcluido,
+		Referencia: idSaque,
+		IDDoacao:   idDoacao,
+		Descricao:  "Saque concluído",
+		Lancamentos: []Lancamento{
+			{Conta: ContaSaqueTransito, Valor: valor},
+			{Conta: ContaProvedor, Valor: -valor},
+		},
+	}
+}
+
+// SaqueCancelado: o valor em trânsito volta para o saldo da campanha
+func SaqueCancelado(idDoacao, idSaque string, valor money.Centavos) Transacao {
+	return Transacao{
+		Tipo:       TransacaoSaqueCancelado,
+		Referencia: idSaque,
+		IDDoacao:   idDoacao,
+		Descricao:  "Saque cancelado",
+		Lancamentos: []Lancamento{
+			{Conta: ContaSaqueTransito, Valor: valor},
+			{Conta: ContaCampanha(idDoacao), Valor: -valor},
+		},
+	}
+}
+
+// Devolucao: o valor devolvido ao doador sai do saldo da campanha e do PSP
+func Devolucao(idDoacao, idDevolucao string, valor money.Centavos) Transacao {
+	return Transacao{
+		Tipo:       TransacaoDevolucao,
+		Referencia: idDevolucao,
+		IDDoacao:   idDoacao,
+		Descricao:  "Devolução ao doador",
+		Lancamentos: []Lancamento{
+			{Conta: ContaCampanha(idDoacao), Valor: valor},
+			{Conta: ContaProvedor, Valor: -valor},
+		},
+	}
+}
+
+// EstornoTaxa: a taxa correspondente à devolução volta para a campanha
+func EstornoTaxa(idDoacao, idDevolucao string, taxa money.Centavos) Transacao {
+	return Transacao{
+		Tipo:       TransacaoEstornoTaxa,
+		Referencia: idDevolucao,
+		IDDoacao:   idDoacao,
+		Descricao:  "Estorno da taxa da plataforma",
+		Lancamentos: []Lancamento{
+			{Conta: ContaTaxaPlataforma, Valor: taxa},
+			{Conta: ContaCampanha(idDoacao), Valor: -taxa},
+		},
+	}
+}
+
+// DevolucaoFalhou desfaz a devolução e o estorno da taxa quando o PSP não realiza a devolução
+func DevolucaoFalhou(idDoacao, idDevolucao string, valor, taxa money.Centavos) Transacao {
+	return Transacao{
+		Tipo:       TransacaoDevolucaoFalhou,
+		Referencia: idDevolucao,
+		IDDoacao:   idDoacao,
+		Descricao:  "Devolução não realizada",
+		Lancamentos: []Lancamento{
+			{Conta: ContaProvedor, Valor: valor},
+			{Conta: ContaCampanha(idDoacao), Valor: taxa - valor},
+			{Conta: ContaTaxaPlataforma, Valor: -taxa},
+		},
+	}
+}
+
+// PlanoRecebido: o pagamento da fatura de plano entra no PSP como receita da plataforma, sem campanha
+func PlanoRecebido(idFatura string, valor money.Centavos) Transacao {
+	return Transacao{
+		Tipo:       TransacaoPlanoRecebido,
+		Referencia: idFatura,
+		Descricao:  "Fatura de plano paga",
+		Lancamentos: []Lancamento{
+			{Conta: ContaProvedor, Valor: valor},
+			{Conta: ContaReceitaPlano, Valor: -valor},
+		},
+	}
+}
+
+// CartaoRecebido: o pagamento capturado no cartão ou carteira entra no provedor e é devido à campanha
+func CartaoRecebido(idDoacao, idPagamento string, bruto money.Centavos) Transacao {
+	return Transacao{
+		Tipo:       TransacaoCartaoRecebido,
+		Referencia: idPagamento,
+		IDDoacao:   idDoacao,
+		Descricao:  "Pagamento com cartão recebido",
+		Lancamentos: []Lancamento{
+			{Conta: ContaProvedor, Valor: bruto},
+			{Conta: ContaCampanha(idDoacao), Valor: -bruto},
+		},
+	}
+}
+
+// CartaoEstornado: o estorno ou chargeback do cartão sai do saldo da campanha e do provedor
+func CartaoEstornado(idDoacao, idPagamento string, valor money.Centavos) Transacao {
+	return Transacao{
+		Tipo:       TransacaoCartaoEstornado,
+		Referencia: idPagamento,
+		IDDoacao:   idDoacao,
+		Descricao:  "Estorno do pagamento com cartão",
+		Lancamentos: []Lancamento{
+			{Conta: ContaCampanha(idDoacao), Valor: valor},
+			{Conta: ContaProvedor, Valor: -valor},
+		},
+	}
+}
+
+// CartaoEstornadoRetido: o chargeback decidido contra a campanha sai do valor retido na contestação, que já
+// tinha saído do saldo dela. Mesmo tipo e referência de CartaoEstornado: o pagamento só é estornado uma vez.
+func CartaoEstornadoRetido(idDoacao, idPagamento string, valor money.Centavos) Transacao {
+	return Transacao{
+		Tipo:       TransacaoCartaoEstornado,
+		Referencia: idPagamento,
+		IDDoacao:   idDoacao,
+		Descricao:  "Estorno do pagamento com cartão contestado",
+		Lancamentos: []Lancamento{
+			{Conta: ContaContestacao, Valor: valor},
+			{Conta: ContaProvedor, Valor: -valor},
+		},
+	}
+}
+
+// CartaoRetido: a contestação do pagamento retém o valor, que deixa de estar disponível para saque.
+// A referência identifica a contestação (um pagamento pode ser contestado de novo depois de liberado).
+func CartaoRetido(idDoacao, referencia string, valor money.Centavos) Transacao {
+	return Transacao{
+		Tipo:       TransacaoCartaoRetido,
+		Referencia: referencia,
+		IDDoacao:   idDoacao,
+		Descricao:  "Pagamento com cartão em contestação",
+		Lancamentos: []Lancamento{
+			{Conta: ContaCampanha(idDoacao), Valor: valor},
+			{Conta: ContaContestacao, Valor: -valor},
+		},
+	}
+}
+
+// CartaoLiberado: a contestação decidida a favor da campanha devolve o valor retido ao saldo dela
+func CartaoLiberado(idDoacao, referencia string, valor money.Centavos) Transacao {
+	return Transacao{
+		Tipo:       TransacaoCartaoLiberado,
+		Referencia: referencia,
+		IDDoacao:   idDoacao,
+		Descricao:  "Contestação do pagamento com cartão encerrada",
+		Lancamentos: []Lancamento{
+			{Conta: ContaContestacao, Valor: valor},
+			{Conta: ContaCampanha(idDoacao), Valor: -valor},
+		},
+	}
+}
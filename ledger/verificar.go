@@ -0,0 +1,125 @@
+package ledger
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// Inconsistencia encontrada na verificação do razão
+type Inconsistencia struct {
+	Regra   string `json:"regra"`
+	Detalhe string `json:"detalhe"`
+}
+
+// verificacoes: cada consulta retorna uma linha (texto) por inconsistência
+var verificacoes = []struct {
+	regra string
+	query string
+}{
+	{"transacao_desbalanceada", `
+		SELECT t.tipo || ' ' || t.referencia || ': soma ' || COALESCE(SUM(e.valor), 0) || ', ' || COUNT(e.id) || ' lançamentos'
+		FROM core.ledger_transaction t
+		LEFT JOIN core.ledger_entry e ON e.id_transaction = t.id
+		GROUP BY t.id
+		HAVING COALESCE(SUM(e.valor), 0) <> 0 OR COUNT(e.id) < 2
+	`},
+	{"liquidacao_sem_lancamento", `
+		SELECT l.txid || ': sem ' || x.tipo
+		FROM core.pix_liquidacao l
+		CROSS JOIN LATERAL (VALUES ('PIX_RECEBIDO', l.valor_bruto), ('TAXA', l.taxa)) AS x(tipo, valor)
+		WHERE x.valor > 0
+		  AND NOT EXISTS (
+			SELECT 1 FROM core.ledger_transaction t WHERE t.tipo = x.tipo AND t.referencia = l.txid
+		  )
+	`},
+	{"liquidacao_valor_divergente", `
+		SELECT l.txid || ': ' || t.tipo || ' lançado ' || SUM(e.valor) FILTER (WHERE e.valor > 0) ||
+			' centavos, liquidação ' || round(CASE t.tipo WHEN 'TAXA' THEN l.taxa ELSE l.valor_bruto END * 100)::BIGINT
+		FROM core.pix_liquidacao l
+		JOIN core.ledger_transaction t ON t.referencia = l.txid AND t.tipo IN ('PIX_RECEBIDO', 'TAXA')
+		JOIN core.ledger_entry e ON e.id_transaction = t.id
+		GROUP BY l.txid, l.taxa, l.valor_bruto, t.tipo
+		HAVING SUM(e.valor) FILTER (WHERE e.valor > 0) <> round(CASE t.tipo WHEN 'TAXA' THEN l.taxa ELSE l.valor_bruto END * 100)::BIGINT
+	`},
+	{"lancamento_sem_liquidacao", `
+		SELECT t.tipo || ' ' || t.referencia
+		FROM core.ledger_transaction t
+		WHERE t.tipo IN ('PIX_RECEBIDO', 'TAXA')
+		  AND NOT EXISTS (SELECT 1 FROM core.pix_liquidacao l WHERE l.txid = t.referencia)
+		  AND NOT (t.tipo = 'TAXA' AND EXISTS (SELECT 1 FROM core.pagamento_cartao c WHERE c.id::TEXT = t.referencia))
+	`},
+	{"devolucao_sem_lancamento", `
+		SELECT d.id::TEXT || ': sem ' || x.tipo
+		FROM core.pix_devolucao d
+		CROSS JOIN LATERAL (VALUES ('DEVOLUCAO', true), ('DEVOLUCAO_FALHOU', d.status = 'NAO_REALIZADO')) AS x(tipo, exigido)
+		WHERE x.exigido
+		  AND NOT EXISTS (
+			SELECT 1 FROM core.ledger_transaction t WHERE t.tipo = x.tipo AND t.referencia = d.id::TEXT
+		  )
+	`},
+	{"cartao_sem_lancamento", `
+		SELECT c.id::TEXT || ': sem ' || x.tipo
+		FROM core.pagamento_cartao c
+		CROSS JOIN LATERAL (VALUES
+			('CARTAO_RECEBIDO', c.capturado_em IS NOT NULL),
+			('TAXA', c.capturado_em IS NOT NULL AND c.taxa > 0),
+			('CARTAO_ESTORNADO', c.capturado_em IS NOT NULL AND c.estornado_em IS NOT NULL)
+		) AS x(tipo, exigido)
+		WHERE x.exigido
+		  AND NOT EXISTS (
+			SELECT 1 FROM core.ledger_transaction t WHERE t.tipo = x.tipo AND t.referencia = c.id::TEXT
+		  )
+	`},
+	{"contestacao_sem_retencao", `
+		SELECT c.id::TEXT || ': contestação ' || c.contestacoes || ' sem CARTAO_RETIDO'
+		FROM core.pagamento_cartao c
+		WHERE c.contestacao_retida
+		  AND NOT EXISTS (
+			SELECT 1 FROM core.ledger_transaction t
+			WHERE t.tipo = 'CARTAO_RETIDO' AND t.referencia = c.id::TEXT || ':' || c.contestacoes
+		  )
+	`},
+	{"fatura_sem_lancamento", `
+		SELECT f.id::TEXT || ': sem PLANO_RECEBIDO'
+		FROM core.conta_nivel_pagamento f
+		JOIN core.pix_qrcode_status pqs ON pqs.id_conta_nivel_pagamento = f.id
+		WHERE f.pago = true
+		  AND NOT EXISTS (
+			SELECT 1 FROM core.ledger_transaction t WHERE t.tipo = 'PLANO_RECEBIDO' AND t.referencia = f.id::TEXT
+		  )
+	`},
+	{"saldo_negativo", `
+		SELECT codigo || ': ' || saldo || ' centavos'
+		FROM core.ledger_saldo
+		WHERE saldo < 0
+	`},
+}
+
+// Verificar confere a consistência do razão: transações balanceadas, uma transação por liquidação e taxa com
+// os mesmos valores, pagamentos com cartão, devoluções e faturas de plano lançados, e nenhuma conta com saldo
+// negativo.
+func Verificar(db *sql.DB) ([]Inconsistencia, error) {
+	var inconsistencias []Inconsistencia
+
+	for _, v := range verificacoes {
+		rows, err := db.Query(v.query)
+		if err != nil {
+			return nil, fmt.Errorf("erro na verificação %s: %w", v.regra, err)
+		}
+		for rows.Next() {
+			var detalhe string
+			if err := rows.Scan(&detalhe); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			inconsistencias = append(inconsistencias, Inconsistencia{Regra: v.regra, Detalhe: detalhe})
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return inconsistencias, nil
+}
@@ -0,0 +1,49 @@
+package mail
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// LogSender apenas registra o e-mail no log, para desenvolvimento local. O corpo, que pode ter links com
+// tokens de acesso, só é registrado com Corpo = true (MAIL_DRIVER=log).
+type LogSender struct {
+	Corpo bool
+}
+
+func (s LogSender) Send(msg Message) error {
+	if !s.Corpo {
+		log.Printf("E-mail para %s | %s (não enviado: MAIL_DRIVER não definido)\n", msg.To, msg.Subject)
+		return nil
+	}
+	log.Printf("E-mail para %s | %s\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+// FileSender grava cada e-mail em um arquivo .eml no diretório informado, para desenvolvimento e testes
+type FileSender struct {
+	dir string
+}
+
+func NewFileSender(dir string) *FileSender {
+	return &FileSender{dir: dir}
+}
+
+func (s *FileSender) Send(msg Message) error {
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return fmt.Errorf("erro ao criar diretório de e-mails: %w", err)
+	}
+
+	destinatario := strings.NewReplacer("@", "_at_", "/", "_").Replace(msg.To)
+	name := fmt.Sprintf("%d_%s.eml", time.Now().UnixNano(), destinatario)
+	content := fmt.Sprintf("To: %s\r\nSubject: %s\r\n\r\n%s\r\n", msg.To, msg.Subject, msg.Body)
+
+	if err := os.WriteFile(filepath.Join(s.dir, name), []byte(content), 0o644); err != nil {
+		return fmt.Errorf("erro ao gravar e-mail: %w", err)
+	}
+	return nil
+}
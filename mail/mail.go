@@ -0,0 +1,45 @@
+package mail
+
+import (
+	"log"
+
+	"BACK_SORTE_GO/config"
+)
+
+// Message representa um e-mail a ser enviado
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Sender é a interface de envio de e-mails, permitindo trocar SMTP por um envio local em desenvolvimento e testes
+type Sender interface {
+	Send(msg Message) error
+}
+
+// NewSender cria o Sender configurado em MAIL_DRIVER (smtp, file ou log). Sem MAIL_DRIVER os e-mails não são
+// enviados e o log fica só com destinatário e assunto; MAIL_DRIVER=log registra também o corpo, para desenvolvimento.
+func NewSender() Sender {
+	switch config.GetMailDriver() {
+	case "smtp":
+		return NewSMTPSender(
+			config.GetSmtpHost(),
+			config.GetSmtpPort(),
+			config.GetSmtpUser(),
+			config.GetSmtpPassword(),
+			config.GetMailFrom(),
+		)
+	case "file":
+		dir := config.GetMailFileDir()
+		if dir == "" {
+			dir = "mails"
+		}
+		return NewFileSender(dir)
+	case "log":
+		return LogSender{Corpo: true}
+	default:
+		log.Println("MAIL_DRIVER não definido, e-mails não serão enviados; só destinatário e assunto vão para o log.")
+		return LogSender{}
+	}
+}
@@ -0,0 +1,51 @@
+package mail
+
+import (
+	"fmt"
+	"net/smtp"
+	"strings"
+)
+
+// SMTPSender envia e-mails através de um servidor SMTP
+type SMTPSender struct {
+	host     string
+	port     string
+	user     string
+	password string
+	from     string
+}
+
+func NewSMTPSender(host, port, user, password, from string) *SMTPSender {
+	if port == "" {
+		port = "587"
+	}
+	if from == "" {
+		from = user
+	}
+	return &SMTPSender{host: host, port: port, user: user, password: password, from: from}
+}
+
+func (s *SMTPSender) Send(msg Message) error {
+	if s.host == "" {
+		return fmt.Errorf("SMTP_HOST não definida nas variáveis de ambiente")
+	}
+
+	var auth smtp.Auth
+	if s.user != "" {
+		auth = smtp.PlainAuth("", s.user, s.password, s.host)
+	}
+
+	headers := []string{
+		"From: " + s.from,
+		"To: " + msg.To,
+		"Subject: " + msg.Subject,
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=\"UTF-8\"",
+	}
+	body := strings.Join(headers, "\r\n") + "\r\n\r\n" + msg.Body
+
+	if err := smtp.SendMail(s.host+":"+s.port, auth, s.from, []string{msg.To}, []byte(body)); err != nil {
+		return fmt.Errorf("erro ao enviar e-mail: %w", err)
+	}
+	return nil
+}
@@ -1,13 +1,25 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"BACK_SORTE_GO/config"
 	"BACK_SORTE_GO/database"
+	"BACK_SORTE_GO/handlers"
+	"BACK_SORTE_GO/jobs"
+	"BACK_SORTE_GO/mail"
 	"BACK_SORTE_GO/routes"
 	"BACK_SORTE_GO/middleware"
+	"BACK_SORTE_GO/payment"
 )
 
 func main() {
@@ -29,11 +41,104 @@ func main() {
 	// Continuar com a inicialização normal da aplicação
 	log.Println("Migrações executadas com sucesso!")
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	pixProvider := payment.NewProvider()
+	cardProviders := payment.NewCardProviders()
+
+	// Fila de jobs: cobranças em aberto de antes do reinício voltam a ser verificadas
+	runner := jobs.NewRunner(db, config.GetJobWorkers())
+	runner.Register(handlers.JobPixStatus, handlers.PixStatusJob(db, pixProvider))
+	runner.Register(handlers.JobPixSaque, handlers.PixSaqueJob(db, pixProvider))
+	runner.Register(handlers.JobPixDevolucao, handlers.PixDevolucaoJob(db, pixProvider, mail.NewSender()))
+	runner.Register(handlers.JobPixConciliacao, handlers.PixConciliacaoJob(db, pixProvider))
+	runner.Register(handlers.JobDoacaoRecorrente, handlers.DoacaoRecorrenteJob(db, pixProvider, mail.NewSender()))
+	runner.Register(handlers.JobCartaoStatus, handlers.CartaoStatusJob(db, cardProviders))
+	runner.Register(handlers.JobPixLegado, handlers.PixLegadoJob(db, pixProvider))
+	runner.Register(handlers.JobPasswordReset, handlers.PasswordResetJob(db, mail.NewSender()))
+	if total, err := handlers.EnqueuePendingPixStatusJobs(db); err != nil {
+		log.Println("Erro ao agendar cobranças em aberto:", err)
+	} else if total > 0 {
+		log.Println("Cobranças em aberto agendadas para verificação:", total)
+	}
+	if total, err := handlers.EnqueuePixLegadoJobs(db); err != nil {
+		log.Println("Erro ao agendar a conferência de cobranças antigas:", err)
+	} else if total > 0 {
+		log.Println("Cobranças antigas agendadas para conferência no provedor:", total)
+	}
+	if err := handlers.EnqueuePixConciliacaoJob(db); err != nil {
+		log.Println("Erro ao agendar a conciliação PIX:", err)
+	}
+	if err := handlers.EnqueueDoacaoRecorrenteJob(db); err != nil {
+		log.Println("Erro ao agendar as doações mensais:", err)
+	}
+	runner.Start(ctx)
+
 	// Configurar as rotas
-	router := routes.SetupRoutes(db)
+	router := routes.SetupRoutes(db, pixProvider, cardProviders)
+
+	servers := []*http.Server{}
+
+	// Listener TLS com mTLS para o webhook PIX da EfiPay (opcional)
+	if addr := config.GetPixWebhookTLSAddr(); addr != "" {
+		servers = append(servers, startWebhookTLSServer(addr, router))
+	}
 
 	// Iniciar o servidor
 	portServerRum := config.GetPortServerStart()
-	log.Println("Servidor rodando na porta :", portServerRum, "...")
-	log.Fatal(http.ListenAndServe(":8798", middleware.CorsMiddleware(router)))
+	server := &http.Server{Addr: ":8798", Handler: middleware.CorsMiddleware(router)}
+	servers = append(servers, server)
+	go func() {
+		log.Println("Servidor rodando na porta :", portServerRum, "...")
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	// Encerramento: para de aceitar requisições e aguarda os jobs em execução
+	<-ctx.Done()
+	log.Println("Encerrando servidor...")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	for _, s := range servers {
+		if err := s.Shutdown(shutdownCtx); err != nil {
+			log.Println("Erro ao encerrar servidor HTTP:", err)
+		}
+	}
+	if err := runner.Stop(30 * time.Second); err != nil {
+		log.Println("Erro ao encerrar fila de jobs:", err)
+	}
+}
+
+// startWebhookTLSServer serve as rotas em TLS pedindo o certificado de cliente emitido pela EfiPay.
+// O handler do webhook exige a cadeia verificada; as demais rotas continuam funcionando sem certificado.
+func startWebhookTLSServer(addr string, handler http.Handler) *http.Server {
+	caPEM, err := os.ReadFile(config.GetPixWebhookClientCA())
+	if err != nil {
+		log.Fatalf("Erro ao ler PIX_WEBHOOK_CLIENT_CA: %v", err)
+	}
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(caPEM) {
+		log.Fatal("PIX_WEBHOOK_CLIENT_CA não contém certificados válidos")
+	}
+
+	server := &http.Server{
+		Addr:    addr,
+		Handler: handler,
+		TLSConfig: &tls.Config{
+			ClientCAs:  clientCAs,
+			ClientAuth: tls.VerifyClientCertIfGiven,
+			MinVersion: tls.VersionTLS12,
+		},
+	}
+
+	go func() {
+		log.Println("Webhook PIX (mTLS) rodando em", addr, "...")
+		if err := server.ListenAndServeTLS(config.GetPixWebhookTLSCert(), config.GetPixWebhookTLSKey()); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+	return server
 }
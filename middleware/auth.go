@@ -0,0 +1,227 @@
+package middleware
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"BACK_SORTE_GO/config"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Principal representa o usuário autenticado da requisição
+type Principal struct {
+	UserID     string
+	SessionID  string
+	Roles      []string
+	ContaNivel string
+}
+
+// Claims são as informações gravadas no token JWT
+type Claims struct {
+	SessionID  string   `json:"sid"`
+	Roles      []string `json:"roles,omitempty"`
+	ContaNivel string   `json:"conta_nivel,omitempty"`
+	jwt.RegisteredClaims
+}
+
+type contextKey string
+
+const principalKey contextKey = "principal"
+
+// Tempo de vida do token de acesso; a renovação é feita com o refresh token
+const AccessTokenTTL = 15 * time.Minute
+
+// Tempo para concluir o segundo passo do login quando o 2FA está ativo
+const MFATokenTTL = 5 * time.Minute
+
+// Audiência do token intermediário do login com 2FA, que não dá acesso às rotas protegidas
+const mfaAudience = "mfa"
+
+var errSecretNaoConfigurado = errors.New("JWT_SECRET não definida nas variáveis de ambiente")
+
+func jwtSecret() ([]byte, error) {
+	secret := config.GetJwtSecret()
+	if secret == "" {
+		return nil, errSecretNaoConfigurado
+	}
+	return []byte(secret), nil
+}
+
+// GenerateToken gera um token de acesso assinado com config.GetJwtSecret()
+func GenerateToken(principal Principal) (string, error) {
+	secret, err := jwtSecret()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims := Claims{
+		SessionID:  principal.SessionID,
+		Roles:      principal.Roles,
+		ContaNivel: principal.ContaNivel,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   principal.UserID,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(AccessTokenTTL)),
+		},
+	}
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+}
+
+// ParseToken valida o token e devolve as claims tipadas
+func ParseToken(tokenString string) (*Claims, error) {
+	secret, err := jwtSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("método de assinatura inesperado")
+		}
+		return secret, nil
+	})
+	if err != nil || !token.Valid {
+		return nil, errors.New("token inválido")
+	}
+	if claims.Subject == "" || claims.SessionID == "" {
+		return nil, errors.New("token sem id_user ou sessão")
+	}
+	if claims.VerifyAudience(mfaAudience, true) {
+		return nil, errors.New("token de 2FA não pode ser usado como token de acesso")
+	}
+
+	return claims, nil
+}
+
+// GenerateMFAToken gera o token intermediário entregue após a senha correta de um usuário com 2FA ativo
+func GenerateMFAToken(userID string) (string, error) {
+	secret, err := jwtSecret()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims := jwt.RegisteredClaims{
+		Subject:   userID,
+		Audience:  jwt.ClaimStrings{mfaAudience},
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(MFATokenTTL)),
+	}
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+}
+
+// ParseMFAToken valida o token intermediário do login com 2FA e retorna o id do usuário
+func ParseMFAToken(tokenString string) (string, error) {
+	secret, err := jwtSecret()
+	if err != nil {
+		return "", err
+	}
+
+	claims := &jwt.RegisteredClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("método de assinatura inesperado")
+		}
+		return secret, nil
+	})
+	if err != nil || !token.Valid || !claims.VerifyAudience(mfaAudience, true) || claims.Subject == "" {
+		return "", errors.New("token de 2FA inválido ou expirado")
+	}
+
+	return claims.Subject, nil
+}
+
+// bearerToken extrai o token do cabeçalho Authorization
+func bearerToken(r *http.Request) string {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimPrefix(authHeader, "Bearer "))
+}
+
+// sessionActive verifica se a sessão do token não foi revogada (logout, troca de senha ou reuso de refresh token)
+func sessionActive(db *sql.DB, claims *Claims) (bool, error) {
+	var revoked bool
+	err := db.QueryRow(`
+		SELECT revoked OR expires_at < now()
+		FROM core.user_session
+		WHERE id = $1 AND id_user = $2
+	`, claims.SessionID, claims.Subject).Scan(&revoked)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return !revoked, nil
+}
+
+func withPrincipal(r *http.Request, claims *Claims) *http.Request {
+	principal := Principal{
+		UserID:     claims.Subject,
+		SessionID:  claims.SessionID,
+		Roles:      claims.Roles,
+		ContaNivel: claims.ContaNivel,
+	}
+	return r.WithContext(context.WithValue(r.Context(), principalKey, principal))
+}
+
+// RequireAuth exige um token válido de uma sessão ativa e coloca o Principal no contexto da requisição
+func RequireAuth(db *sql.DB, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tokenString := bearerToken(r)
+		if tokenString == "" {
+			http.Error(w, "Token não fornecido", http.StatusUnauthorized)
+			return
+		}
+
+		claims, err := ParseToken(tokenString)
+		if err != nil {
+			http.Error(w, "Token inválido", http.StatusUnauthorized)
+			return
+		}
+
+		active, err := sessionActive(db, claims)
+		if err != nil {
+			http.Error(w, "Erro ao validar sessão: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !active {
+			http.Error(w, "Sessão encerrada", http.StatusUnauthorized)
+			return
+		}
+
+		next(w, withPrincipal(r, claims))
+	}
+}
+
+// OptionalAuth preenche o Principal quando há um token válido, sem bloquear a requisição
+func OptionalAuth(db *sql.DB, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if tokenString := bearerToken(r); tokenString != "" {
+			if claims, err := ParseToken(tokenString); err == nil {
+				if active, err := sessionActive(db, claims); err == nil && active {
+					r = withPrincipal(r, claims)
+				}
+			}
+		}
+
+		next(w, r)
+	}
+}
+
+// GetPrincipal retorna o usuário autenticado colocado no contexto por RequireAuth
+func GetPrincipal(r *http.Request) (Principal, bool) {
+	principal, ok := r.Context().Value(principalKey).(Principal)
+	return principal, ok && principal.UserID != ""
+}
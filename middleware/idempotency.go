@@ -0,0 +1,240 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// IdempotencyKeyHeader é o cabeçalho com a chave gerada pelo cliente para cada tentativa de criação
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// IdempotencyReplayedHeader marca a resposta devolvida do cache de uma requisição anterior
+const IdempotencyReplayedHeader = "Idempotency-Replayed"
+
+// Tempo em que a chave e a resposta original ficam guardadas
+const IdempotencyTTL = 24 * time.Hour
+
+const idempotencyKeyMaxLen = 255
+
+// IdempotencyJSONMaxBytes é o limite de corpo para rotas JSON, que não têm limite de upload próprio
+const IdempotencyJSONMaxBytes = 1 << 20
+
+// Status de core.idempotency_key
+const (
+	idempotencyProcessando = "PROCESSANDO"
+	idempotencyConcluida   = "CONCLUIDA"
+)
+
+// Idempotency torna a rota segura contra repetições (duplo clique, retentativa do cliente) quando a requisição
+// traz o cabeçalho Idempotency-Key. A primeira requisição com a chave é executada e a resposta é guardada em
+// core.idempotency_key; as seguintes com o mesmo corpo recebem a resposta guardada, sem executar a rota de
+// novo. A mesma chave com outro corpo é rejeitada com 422, e uma repetição enquanto a primeira ainda está em
+// andamento recebe 409. Respostas 5xx não são guardadas, liberando a chave para uma nova tentativa.
+// A chave vale por rota e por usuário; em rotas autenticadas deve ser usada dentro de RequireAuth.
+// maxBytes é o limite de upload da própria rota: o corpo é lido inteiro para o fingerprint, antes do limite do
+// handler, e acima dele a requisição recebe 413.
+func Idempotency(db *sql.DB, maxBytes int64, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		chave := strings.TrimSpace(r.Header.Get(IdempotencyKeyHeader))
+		if chave == "" {
+			next(w, r)
+			return
+		}
+		if len(chave) > idempotencyKeyMaxLen {
+			http.Error(w, "Idempotency-Key deve ter até "+strconv.Itoa(idempotencyKeyMaxLen)+" caracteres", http.StatusBadRequest)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBytes))
+		if err != nil {
+			var excedido *http.MaxBytesError
+			if errors.As(err, &excedido) {
+				http.Error(w, "Requisição maior que o limite de "+strconv.FormatInt(maxBytes>>20, 10)+" MB", http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, "Erro ao ler requisição: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		fingerprint, err := requestFingerprint(r, body)
+		if err != nil {
+			http.Error(w, "Erro ao ler requisição: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		escopo := r.Method + " " + r.URL.Path
+		if principal, ok := GetPrincipal(r); ok {
+			escopo += " " + principal.UserID
+		}
+
+		// Chaves expiradas são apagadas antes, para que a mesma chave possa ser usada de novo depois do prazo
+		if _, err := db.Exec(`DELETE FROM core.idempotency_key WHERE expira_em < now()`); err != nil {
+			http.Error(w, "Erro ao verificar Idempotency-Key: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		var id string
+		err = db.QueryRow(`
+			INSERT INTO core.idempotency_key (escopo, chave, fingerprint, status, date_create, date_update, expira_em)
+			VALUES ($1, $2, $3, $4, now(), now(), now() + $5::INTERVAL)
+			ON CONFLICT (escopo, chave) DO NOTHING
+			RETURNING id
+		`, escopo, chave, fingerprint, idempotencyProcessando, strconv.Itoa(int(IdempotencyTTL.Seconds()))+" seconds").Scan(&id)
+		if err == sql.ErrNoRows {
+			replayIdempotency(db, w, escopo, chave, fingerprint)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Erro ao registrar Idempotency-Key: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		rec := &idempotencyRecorder{ResponseWriter: w, status: http.StatusOK}
+		salva := false
+		defer func() {
+			// Erro interno ou panic: a chave é liberada para o cliente tentar de novo
+			if salva {
+				return
+			}
+			if _, err := db.Exec(`DELETE FROM core.idempotency_key WHERE id = $1`, id); err != nil {
+				log.Println("Erro ao liberar Idempotency-Key:", err)
+			}
+		}()
+
+		next(rec, r)
+
+		if rec.status >= http.StatusInternalServerError {
+			return
+		}
+		_, err = db.Exec(`
+			UPDATE core.idempotency_key
+			SET status = $2, status_code = $3, content_type = $4, resposta = $5, date_update = now()
+			WHERE id = $1
+		`, id, idempotencyConcluida, rec.status, rec.Header().Get("Content-Type"), rec.body.Bytes())
+		if err != nil {
+			log.Println("Erro ao guardar resposta da Idempotency-Key:", err)
+			return
+		}
+		salva = true
+	}
+}
+
+// replayIdempotency responde a uma requisição cuja chave já foi usada
+func replayIdempotency(db *sql.DB, w http.ResponseWriter, escopo, chave, fingerprint string) {
+	var fingerprintOriginal, status string
+	var statusCode sql.NullInt64
+	var contentType sql.NullString
+	var resposta []byte
+	err := db.QueryRow(`
+		SELECT fingerprint, status, status_code, content_type, resposta
+		FROM core.idempotency_key
+		WHERE escopo = $1 AND chave = $2
+	`, escopo, chave).Scan(&fingerprintOriginal, &status, &statusCode, &contentType, &resposta)
+	if err == sql.ErrNoRows {
+		// A primeira requisição falhou e liberou a chave entre o INSERT e esta consulta
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "Requisição com esta Idempotency-Key falhou, tente novamente", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Erro ao buscar Idempotency-Key: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if fingerprintOriginal != fingerprint {
+		http.Error(w, "Idempotency-Key já usada com outra requisição", http.StatusUnprocessableEntity)
+		return
+	}
+	if status != idempotencyConcluida {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "Requisição com esta Idempotency-Key ainda em processamento", http.StatusConflict)
+		return
+	}
+
+	if contentType.String != "" {
+		w.Header().Set("Content-Type", contentType.String)
+	}
+	w.Header().Set(IdempotencyReplayedHeader, "true")
+	w.WriteHeader(int(statusCode.Int64))
+	w.Write(resposta)
+}
+
+// requestFingerprint resume a requisição em um hash SHA-256. Em multipart/form-data o hash é feito sobre os
+// campos e arquivos, e não sobre o corpo bruto, porque o navegador gera um boundary novo a cada envio.
+func requestFingerprint(r *http.Request, body []byte) (string, error) {
+	h := sha256.New()
+	escreverCampo(h, []byte(r.Method+" "+r.URL.RequestURI()))
+
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" || params["boundary"] == "" {
+		escreverCampo(h, body)
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+
+	escreverCampo(h, []byte(mediaType))
+	mr := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		conteudo, err := io.ReadAll(part)
+		if err != nil {
+			return "", err
+		}
+		escreverCampo(h, []byte(part.FormName()))
+		escreverCampo(h, []byte(part.FileName()))
+		escreverCampo(h, conteudo)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// escreverCampo grava o tamanho antes do conteúdo, para que campos vizinhos não se confundam no hash
+func escreverCampo(h hash.Hash, b []byte) {
+	var n [8]byte
+	binary.BigEndian.PutUint64(n[:], uint64(len(b)))
+	h.Write(n[:])
+	h.Write(b)
+}
+
+// idempotencyRecorder repassa a resposta ao cliente e guarda uma cópia para as repetições
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (rec *idempotencyRecorder) WriteHeader(status int) {
+	if rec.wroteHeader {
+		return
+	}
+	rec.wroteHeader = true
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *idempotencyRecorder) Write(b []byte) (int, error) {
+	if !rec.wroteHeader {
+		rec.WriteHeader(http.StatusOK)
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
@@ -8,7 +8,8 @@ func CorsMiddleware(next http.Handler) http.Handler {
 		//w.Header().Set("Access-Control-Allow-Origin", "http://localhost:4200")
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE, PATCH")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Idempotency-Key")
+		w.Header().Set("Access-Control-Expose-Headers", "Idempotency-Replayed")
 		w.Header().Set("Access-Control-Allow-Credentials", "true")
 
 		// Responde diretamente as requisições OPTIONS (pré-flight)
@@ -0,0 +1,91 @@
+package middleware
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RequireVerifiedEmail bloqueia a rota para usuários que ainda não confirmaram o e-mail.
+// Deve ser usada dentro de RequireAuth.
+func RequireVerifiedEmail(db *sql.DB, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := GetPrincipal(r)
+		if !ok {
+			http.Error(w, "Usuário não autenticado", http.StatusUnauthorized)
+			return
+		}
+
+		var emailValid bool
+		err := db.QueryRow(`
+			SELECT COALESCE(BOOL_OR(email_valid), false)
+			FROM core.user_details
+			WHERE id_user = $1
+		`, principal.UserID).Scan(&emailValid)
+		if err != nil {
+			http.Error(w, "Erro ao verificar e-mail: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !emailValid {
+			http.Error(w, "Confirme seu e-mail antes de continuar", http.StatusForbidden)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// Janela em que a confirmação do 2FA na sessão vale para operações sensíveis
+const StepUpTTL = 5 * time.Minute
+
+// RequireStepUp exige, para usuários com 2FA ativo, que o código TOTP tenha sido confirmado
+// na sessão atual há menos de StepUpTTL (login com 2FA ou POST /users/2fa/verify).
+// Usada antes de alterar a conta de saque ou solicitar o resgate. Deve ser usada dentro de RequireAuth.
+func RequireStepUp(db *sql.DB, next http.HandlerFunc) http.HandlerFunc {
+	return stepUp(db, false, next)
+}
+
+// RequireTOTPStepUp é RequireStepUp para operações de operador que movimentam dinheiro (aprovação de saque):
+// sem 2FA ativo a requisição é recusada com 403, em vez de passar sem confirmação. Deve ser usada dentro de
+// RequireAuth e depois de RequireRole.
+func RequireTOTPStepUp(db *sql.DB, next http.HandlerFunc) http.HandlerFunc {
+	return stepUp(db, true, next)
+}
+
+func stepUp(db *sql.DB, exigeTOTP bool, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := GetPrincipal(r)
+		if !ok {
+			http.Error(w, "Usuário não autenticado", http.StatusUnauthorized)
+			return
+		}
+
+		var enabled, confirmed bool
+		err := db.QueryRow(`
+			SELECT
+				COALESCE((SELECT enabled FROM core.user_totp WHERE id_user = $1), false),
+				COALESCE((
+					SELECT step_up_at > now() - $3::INTERVAL
+					FROM core.user_session
+					WHERE id = $2 AND id_user = $1
+				), false)
+		`, principal.UserID, principal.SessionID, strconv.Itoa(int(StepUpTTL.Seconds()))+" seconds").Scan(&enabled, &confirmed)
+		if err != nil {
+			http.Error(w, "Erro ao verificar 2FA: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !enabled && exigeTOTP {
+			w.Header().Set("X-Step-Up-Required", "totp-setup")
+			http.Error(w, "Ative a verificação em duas etapas para realizar esta operação", http.StatusForbidden)
+			return
+		}
+		if enabled && !confirmed {
+			w.Header().Set("X-Step-Up-Required", "totp")
+			http.Error(w, "Confirme o código de verificação em duas etapas", http.StatusForbidden)
+			return
+		}
+
+		next(w, r)
+	}
+}
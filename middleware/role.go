@@ -0,0 +1,44 @@
+package middleware
+
+import "net/http"
+
+// Roles cadastradas em core.role
+const (
+	RoleOperator = "ROLE_OPERATOR"
+	RoleAdmin    = "ROLE_ADMIN"
+)
+
+// HasRole informa se o usuário possui alguma das roles. ROLE_ADMIN tem acesso a todas as rotas protegidas por role.
+func (p Principal) HasRole(roles ...string) bool {
+	for _, owned := range p.Roles {
+		if owned == RoleAdmin {
+			return true
+		}
+		for _, role := range roles {
+			if owned == role {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// RequireRole restringe a rota a usuários com alguma das roles informadas.
+// Deve ser usada dentro de RequireAuth, ex.: RequireAuth(db, RequireRole(RoleAdmin)(handler)).
+func RequireRole(roles ...string) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			principal, ok := GetPrincipal(r)
+			if !ok {
+				http.Error(w, "Usuário não autenticado", http.StatusUnauthorized)
+				return
+			}
+			if !principal.HasRole(roles...) {
+				http.Error(w, "Acesso negado", http.StatusForbidden)
+				return
+			}
+
+			next(w, r)
+		}
+	}
+}
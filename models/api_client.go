@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+type ApiClient struct {
+	ID             string     `json:"id" db:"id"`
+	ClientID       string     `json:"client_id" db:"client_id"`
+	Name           string     `json:"name" db:"name"`
+	GrantTypes     []string   `json:"grant_types" db:"grant_types"`
+	AllowedOrigins []string   `json:"allowed_origins" db:"allowed_origins"`
+	Active         bool       `json:"active" db:"active"`
+	LastUsedAt     *time.Time `json:"last_used_at,omitempty" db:"last_used_at"`
+	DateCreate     time.Time  `json:"date_create" db:"date_create"`
+	DateUpdate     *time.Time `json:"date_update,omitempty" db:"date_update"`
+}
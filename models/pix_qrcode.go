@@ -3,13 +3,15 @@ package models
 import (
 	"time"
 
+	"BACK_SORTE_GO/money"
+
 	"github.com/google/uuid"
 )
 
 type PixQRCode struct {
 	ID         uuid.UUID
 	IdDoacao   string
-	Valor      string
+	Valor      money.Centavos
 	CPF        string
 	Nome       string
 	Mensagem   string
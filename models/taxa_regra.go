@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+
+	"BACK_SORTE_GO/money"
+)
+
+// TaxaRegra define a taxa da plataforma. Area e Nivel vazios valem para todas as campanhas;
+// uma regra com percentual e valor fixo zero e vigência definida é uma promoção sem taxa.
+type TaxaRegra struct {
+	ID             string         `json:"id" db:"id"`
+	Nome           string         `json:"nome" db:"nome"`
+	Percentual     float64        `json:"percentual" db:"percentual"`
+	ValorFixo      money.Centavos `json:"valor_fixo" db:"valor_fixo"`
+	ValorMinimo    money.Centavos `json:"valor_minimo" db:"valor_minimo"`
+	Area           *string        `json:"area,omitempty" db:"area"`
+	Nivel          *string        `json:"nivel,omitempty" db:"nivel"`
+	Prioridade     int            `json:"prioridade" db:"prioridade"`
+	VigenciaInicio *time.Time     `json:"vigencia_inicio,omitempty" db:"vigencia_inicio"`
+	VigenciaFim    *time.Time     `json:"vigencia_fim,omitempty" db:"vigencia_fim"`
+	Ativo          bool           `json:"ativo" db:"ativo"`
+	DateCreate     time.Time      `json:"date_create" db:"date_create"`
+	DateUpdate     *time.Time     `json:"date_update,omitempty" db:"date_update"`
+}
@@ -7,5 +7,8 @@ type UserLogin struct {
 	Email     string    `json:"email" db:"email"`
 	IDUser    *string   `json:"id_user,omitempty" db:"id_user"`
 	PassValid bool      `json:"pass_valid" db:"pass_valid"`
+	IP        string    `json:"ip" db:"ip"`
+	UserAgent string    `json:"user_agent" db:"user_agent"`
+	Motivo    string    `json:"motivo" db:"motivo"`
 	Date      time.Time `json:"date" db:"date"`
 }
@@ -0,0 +1,248 @@
+// Package money representa valores em reais como inteiros de centavos, evitando erros de arredondamento de float.
+package money
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Centavos é um valor em reais armazenado em centavos
+type Centavos int64
+
+var ErrValorInvalido = errors.New("valor monetário inválido")
+
+// FromFloat converte reais em float para centavos, arredondando. Use apenas na borda com código legado.
+func FromFloat(v float64) Centavos {
+	return Centavos(math.Round(v * 100))
+}
+
+// Float64 retorna o valor em reais como float, para cálculos de exibição e código legado
+func (c Centavos) Float64() float64 {
+	return float64(c) / 100
+}
+
+// String retorna o valor no formato da API PIX e do banco: "1234.56"
+func (c Centavos) String() string {
+	sinal := ""
+	v := int64(c)
+	if v < 0 {
+		sinal = "-"
+		v = -v
+	}
+	return fmt.Sprintf("%s%d.%02d", sinal, v/100, v%100)
+}
+
+// Format retorna o valor no formato brasileiro: "1.234,56"
+func (c Centavos) Format() string {
+	sinal := ""
+	v := int64(c)
+	if v < 0 {
+		sinal = "-"
+		v = -v
+	}
+
+	inteiro := strconv.FormatInt(v/100, 10)
+	var b strings.Builder
+	for i, d := range inteiro {
+		if i > 0 && (len(inteiro)-i)%3 == 0 {
+			b.WriteByte('.')
+		}
+		b.WriteRune(d)
+	}
+	return fmt.Sprintf("%s%s,%02d", sinal, b.String(), v%100)
+}
+
+// Parse aceita "1234.56", "1234,56", "1.234,56", "1,234.56", "R$ 1.234,56" e inteiros ("10").
+// Com os dois separadores, o último é o decimal; com um só, ele é decimal se tiver até duas casas depois dele
+// e aparecer uma única vez ("1.234" é mil duzentos e trinta e quatro). Uma única vírgula seguida de três dígitos
+// ("12,345") é ambígua e dá erro, assim como mais de duas casas decimais.
+func Parse(s string) (Centavos, error) {
+	s = strings.TrimSpace(s)
+	s = strings.TrimSpace(strings.TrimPrefix(s, "R$"))
+	if s == "" {
+		return 0, ErrValorInvalido
+	}
+
+	negativo := false
+	if s[0] == '-' {
+		negativo = true
+		s = strings.TrimSpace(s[1:])
+	}
+
+	inteiro, decimal := s, ""
+	ponto, virgula := strings.LastIndex(s, "."), strings.LastIndex(s, ",")
+	switch {
+	case ponto >= 0 && virgula >= 0:
+		sep := ponto
+		milhar := ","
+		if virgula > ponto {
+			sep = virgula
+			milhar = "."
+		}
+		inteiro, decimal = s[:sep], s[sep+1:]
+		if strings.Contains(decimal, milhar) || !gruposDeMilhar(strings.Split(inteiro, milhar)) {
+			return 0, ErrValorInvalido
+		}
+		inteiro = strings.ReplaceAll(inteiro, milhar, "")
+	case ponto >= 0 || virgula >= 0:
+		sep, char := ponto, "."
+		if virgula >= 0 {
+			sep, char = virgula, ","
+		}
+		if strings.Count(s, char) == 1 && len(s)-sep-1 <= 2 {
+			inteiro, decimal = s[:sep], s[sep+1:]
+		} else if char == "," && strings.Count(s, char) == 1 {
+			// Vírgula decimal com três casas ou milhar no formato americano: não há como saber
+			return 0, ErrValorInvalido
+		} else {
+			// Separador de milhar: grupos de três dígitos
+			partes := strings.Split(s, char)
+			if !gruposDeMilhar(partes) {
+				return 0, ErrValorInvalido
+			}
+			inteiro = strings.Join(partes, "")
+		}
+	}
+
+	if inteiro == "" {
+		inteiro = "0"
+	}
+	if len(decimal) > 2 || !somenteDigitos(inteiro) || !somenteDigitos(decimal) {
+		return 0, ErrValorInvalido
+	}
+	for len(decimal) < 2 {
+		decimal += "0"
+	}
+
+	reais, err := strconv.ParseInt(inteiro, 10, 64)
+	if err != nil || reais > math.MaxInt64/100-1 {
+		return 0, ErrValorInvalido
+	}
+	centavos, _ := strconv.ParseInt(decimal, 10, 64)
+
+	v := Centavos(reais*100 + centavos)
+	if negativo {
+		v = -v
+	}
+	return v, nil
+}
+
+// gruposDeMilhar confere que, após o primeiro, todos os grupos têm três dígitos
+func gruposDeMilhar(partes []string) bool {
+	for i, p := range partes {
+		if (i > 0 && len(p) != 3) || (i == 0 && len(partes) > 1 && p == "") {
+			return false
+		}
+	}
+	return true
+}
+
+func somenteDigitos(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// MarshalJSON grava o valor como número em reais (12.5 → 12.50), mantendo o formato das respostas atuais
+func (c Centavos) MarshalJSON() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+// UnmarshalJSON aceita número (12.5, 1e2) ou string em qualquer formato de Parse ("12,50", "1.234,56").
+// Número JSON sempre usa ponto decimal e não pode ter mais de duas casas (10.005 é erro, não 10.005,00).
+func (c *Centavos) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+
+	if len(data) == 0 || data[0] != '"' {
+		v, err := parseNumero(string(data))
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrValorInvalido, data)
+		}
+		*c = v
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	v, err := Parse(s)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrValorInvalido, s)
+	}
+	*c = v
+	return nil
+}
+
+// parseNumero converte um número JSON exatamente (sem passar por float), exigindo valor inteiro em centavos
+func parseNumero(s string) (Centavos, error) {
+	var n json.Number
+	if err := json.Unmarshal([]byte(s), &n); err != nil {
+		return 0, ErrValorInvalido
+	}
+	r, ok := new(big.Rat).SetString(n.String())
+	if !ok {
+		return 0, ErrValorInvalido
+	}
+	r.Mul(r, big.NewRat(100, 1))
+	if !r.IsInt() || !r.Num().IsInt64() || r.Num().Int64() > math.MaxInt64-100 || r.Num().Int64() < -(math.MaxInt64-100) {
+		return 0, ErrValorInvalido
+	}
+	return Centavos(r.Num().Int64()), nil
+}
+
+// Scan lê colunas NUMERIC (texto), DOUBLE PRECISION e inteiras
+func (c *Centavos) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*c = 0
+		return nil
+	case []byte:
+		return c.scanString(string(v))
+	case string:
+		return c.scanString(v)
+	case float64:
+		*c = FromFloat(v)
+		return nil
+	case int64:
+		*c = Centavos(v * 100)
+		return nil
+	}
+	return fmt.Errorf("money: tipo não suportado %T", src)
+}
+
+func (c *Centavos) scanString(s string) error {
+	// NUMERIC com mais casas (ex.: 12.500) é arredondado para centavos
+	if i := strings.IndexByte(s, '.'); i >= 0 && len(s)-i-1 > 2 {
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		*c = FromFloat(f)
+		return nil
+	}
+	v, err := Parse(s)
+	if err != nil {
+		return err
+	}
+	*c = v
+	return nil
+}
+
+// Value grava o valor como texto decimal ("1234.56"), aceito por colunas NUMERIC
+func (c Centavos) Value() (driver.Value, error) {
+	return c.String(), nil
+}
@@ -0,0 +1,87 @@
+package money
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	casos := []struct {
+		entrada string
+		valor   Centavos
+		erro    bool
+	}{
+		{entrada: "10", valor: 1000},
+		{entrada: "1234.56", valor: 123456},
+		{entrada: "1234,56", valor: 123456},
+		{entrada: "1.234,56", valor: 123456},
+		{entrada: "1,234.56", valor: 123456},
+		{entrada: "R$ 1.234,56", valor: 123456},
+		{entrada: "1.234", valor: 123400},
+		{entrada: "0,5", valor: 50},
+		{entrada: "-10,50", valor: -1050},
+		{entrada: "12,345", erro: true},
+		{entrada: "10.005,00.1", erro: true},
+		{entrada: "1.2345", erro: true},
+		{entrada: "abc", erro: true},
+		{entrada: "", erro: true},
+	}
+	for _, c := range casos {
+		v, err := Parse(c.entrada)
+		if c.erro {
+			if err == nil {
+				t.Errorf("Parse(%q) = %s, esperado erro", c.entrada, v)
+			}
+			continue
+		}
+		if err != nil || v != c.valor {
+			t.Errorf("Parse(%q) = %s, %v; esperado %s", c.entrada, v, err, c.valor)
+		}
+	}
+}
+
+func TestUnmarshalJSON(t *testing.T) {
+	casos := []struct {
+		entrada string
+		valor   Centavos
+		erro    bool
+	}{
+		{entrada: `10`, valor: 1000},
+		{entrada: `10.5`, valor: 1050},
+		{entrada: `10.50`, valor: 1050},
+		{entrada: `1234.56`, valor: 123456},
+		{entrada: `1e2`, valor: 10000},
+		{entrada: `-3.2`, valor: -320},
+		{entrada: `null`, valor: 0},
+		{entrada: `10.005`, erro: true},
+		{entrada: `0.001`, erro: true},
+		{entrada: `1e-3`, erro: true},
+		{entrada: `1e30`, erro: true},
+		{entrada: `true`, erro: true},
+		{entrada: `"1.234,56"`, valor: 123456},
+		{entrada: `"10,50"`, valor: 1050},
+		{entrada: `"12,345"`, erro: true},
+	}
+	for _, c := range casos {
+		var v Centavos
+		err := json.Unmarshal([]byte(c.entrada), &v)
+		if c.erro {
+			if err == nil {
+				t.Errorf("Unmarshal(%s) = %s, esperado erro", c.entrada, v)
+			}
+			continue
+		}
+		if err != nil || v != c.valor {
+			t.Errorf("Unmarshal(%s) = %s, %v; esperado %s", c.entrada, v, err, c.valor)
+		}
+	}
+}
+
+func TestMarshalJSON(t *testing.T) {
+	b, err := json.Marshal(struct {
+		Valor Centavos `json:"valor"`
+	}{Valor: 123456})
+	if err != nil || string(b) != `{"valor":1234.56}` {
+		t.Errorf("Marshal = %s, %v", b, err)
+	}
+}
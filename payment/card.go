@@ -0,0 +1,121 @@
+package payment
+
+import (
+	"encoding/json"
+
+	"BACK_SORTE_GO/config"
+	"BACK_SORTE_GO/money"
+)
+
+// Métodos de pagamento de uma doação, gravados em pix_qrcode.metodo_pagamento
+const (
+	MetodoPix       = "PIX"
+	MetodoCartao    = "CARTAO"
+	MetodoPayPal    = "PAYPAL"
+	MetodoGooglePay = "GOOGLE_PAY"
+)
+
+// Status do pagamento com cartão ou carteira, normalizados a partir do status de cada provedor
+const (
+	CartaoPendente = "PENDENTE"
+	// Autorizado pelo emissor, aguardando a análise e a captura pelo provedor
+	CartaoAutorizado = "AUTORIZADO"
+	CartaoCapturado  = "CAPTURADO"
+	CartaoRecusado   = "RECUSADO"
+	CartaoCancelado  = "CANCELADO"
+	// Chargeback aberto pelo portador do cartão, em disputa
+	CartaoContestado = "CONTESTADO"
+	// Estornado ao portador: devolução ou chargeback perdido
+	CartaoEstornado = "ESTORNADO"
+)
+
+// CardCustomer é o pagador exigido pelo provedor na cobrança com cartão. Nascimento no formato AAAA-MM-DD.
+type CardCustomer struct {
+	Nome       string `json:"nome"`
+	CPF        string `json:"cpf"`
+	Email      string `json:"email"`
+	Telefone   string `json:"telefone"`
+	Nascimento string `json:"nascimento"`
+}
+
+// BillingAddress é o endereço de cobrança do cartão
+type BillingAddress struct {
+	Rua         string `json:"rua"`
+	Numero      string `json:"numero"`
+	Bairro      string `json:"bairro"`
+	CEP         string `json:"cep"`
+	Cidade      string `json:"cidade"`
+	Estado      string `json:"estado"`
+	Complemento string `json:"complemento,omitempty"`
+}
+
+// CardChargeRequest são os dados de uma cobrança com cartão ou carteira. O PaymentToken vem da tokenização feita
+// no navegador (o número do cartão nunca passa pelo backend). CustomID é o id do pagamento, devolvido nas
+// notificações do provedor.
+type CardChargeRequest struct {
+	Metodo          string
+	CustomID        string
+	Descricao       string
+	Valor           money.Centavos
+	Parcelas        int
+	PaymentToken    string
+	NotificationURL string
+	Cliente         CardCustomer
+	Endereco        BillingAddress
+}
+
+// CardCharge é a cobrança com cartão retornada pelo provedor. Status é o status normalizado (Cartao*) e
+// StatusProvedor o original; Motivo explica a recusa quando o provedor informa.
+type CardCharge struct {
+	ID             string
+	CustomID       string
+	Status         string
+	StatusProvedor string
+	Valor          money.Centavos
+	Parcelas       int
+	Motivo         string
+	Raw            json.RawMessage
+}
+
+// Final indica que a cobrança não muda mais sem uma ação do portador (contestação ou estorno)
+func (c *CardCharge) Final() bool {
+	switch c.Status {
+	case CartaoCapturado, CartaoRecusado, CartaoCancelado, CartaoEstornado:
+		return true
+	}
+	return false
+}
+
+// CardProvider isola os handlers do provedor de pagamentos com cartão ou carteira digital
+type CardProvider interface {
+	// Nome identifica o provedor nas cobranças gravadas e na URL de notificação
+	Nome() string
+	CreateCardCharge(req CardChargeRequest) (*CardCharge, error)
+	DetailCardCharge(id string) (*CardCharge, error)
+	// Notification consulta o token recebido na URL de notificação e retorna os ids das cobranças alteradas
+	Notification(token string) ([]string, error)
+}
+
+// CardProviders são os provedores por método de pagamento. Métodos sem provedor não são oferecidos.
+type CardProviders map[string]CardProvider
+
+// NewCardProviders escolhe os provedores por PIX_PROVIDER: "fake" atende todos os métodos para desenvolvimento e
+// testes offline; nos demais casos o cartão é cobrado pela EfiPay. As carteiras só ficam disponíveis quando
+// houver um provedor para elas.
+func NewCardProviders() CardProviders {
+	if config.GetPixProvider() == "fake" {
+		fake := NewFakeCardProvider(config.GetPixFakeAutoPay() != "false")
+		return CardProviders{MetodoCartao: fake, MetodoPayPal: fake, MetodoGooglePay: fake}
+	}
+	return CardProviders{MetodoCartao: NewEfiPayCardProvider(config.GetCredentials())}
+}
+
+// Provedor retorna o provedor pelo nome gravado na cobrança
+func (p CardProviders) Provedor(nome string) (CardProvider, bool) {
+	for _, provider := range p {
+		if provider.Nome() == nome {
+			return provider, true
+		}
+	}
+	return nil, false
+}
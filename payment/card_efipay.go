@@ -0,0 +1,173 @@
+package payment
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"BACK_SORTE_GO/money"
+
+	"github.com/efipay/sdk-go-apis-efi/src/efipay"
+)
+
+// Status da cobrança (API de cobranças da EfiPay) convertidos para os status de cartão
+var efiCardStatus = map[string]string{
+	"new":        CartaoPendente,
+	"waiting":    CartaoPendente,
+	"approved":   CartaoAutorizado,
+	"paid":       CartaoCapturado,
+	"identified": CartaoCapturado,
+	"settled":    CartaoCapturado,
+	"unpaid":     CartaoRecusado,
+	"canceled":   CartaoCancelado,
+	"expired":    CartaoCancelado,
+	"contested":  CartaoContestado,
+	"refunded":   CartaoEstornado,
+}
+
+// efiCardCharge é o formato de data na criação (one-step) e no detalhe da cobrança da EfiPay
+type efiCardCharge struct {
+	ChargeID     int    `json:"charge_id"`
+	CustomID     string `json:"custom_id"`
+	Status       string `json:"status"`
+	Total        int64  `json:"total"`
+	Installments int    `json:"installments"`
+	Reason       string `json:"reason"`
+	Payment      struct {
+		CreditCard struct {
+			Installments int `json:"installments"`
+		} `json:"credit_card"`
+	} `json:"payment"`
+}
+
+// EfiPayCardProvider cobra cartão de crédito pela API de cobranças da EfiPay, com o payment_token gerado pela
+// biblioteca de tokenização da EfiPay no navegador. A EfiPay autoriza e captura sozinha após a análise.
+type EfiPayCardProvider struct {
+	credentials map[string]interface{}
+}
+
+func NewEfiPayCardProvider(credentials map[string]interface{}) *EfiPayCardProvider {
+	return &EfiPayCardProvider{credentials: credentials}
+}
+
+func (p *EfiPayCardProvider) Nome() string {
+	return "efipay"
+}
+
+func parseCardCharge(raw string) (*CardCharge, error) {
+	var r struct {
+		Data efiCardCharge `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(raw), &r); err != nil {
+		return nil, fmt.Errorf("erro ao decodificar resposta da cobrança com cartão: %w", err)
+	}
+	if r.Data.ChargeID == 0 {
+		return nil, fmt.Errorf("resposta inválida da API (charge_id ausente)")
+	}
+
+	status, ok := efiCardStatus[r.Data.Status]
+	if !ok {
+		status = CartaoPendente
+	}
+	parcelas := r.Data.Installments
+	if parcelas == 0 {
+		parcelas = r.Data.Payment.CreditCard.Installments
+	}
+
+	return &CardCharge{
+		ID:             strconv.Itoa(r.Data.ChargeID),
+		CustomID:       r.Data.CustomID,
+		Status:         status,
+		StatusProvedor: r.Data.Status,
+		Valor:          money.Centavos(r.Data.Total),
+		Parcelas:       parcelas,
+		Motivo:         r.Data.Reason,
+		Raw:            json.RawMessage(raw),
+	}, nil
+}
+
+func (p *EfiPayCardProvider) CreateCardCharge(req CardChargeRequest) (*CardCharge, error) {
+	if req.Metodo != MetodoCartao {
+		return nil, fmt.Errorf("método de pagamento %s não atendido pela EfiPay", req.Metodo)
+	}
+
+	body := map[string]interface{}{
+		"items": []map[string]interface{}{
+			{"name": req.Descricao, "value": int64(req.Valor), "amount": 1},
+		},
+		"metadata": map[string]interface{}{"custom_id": req.CustomID},
+		"payment": map[string]interface{}{
+			"credit_card": map[string]interface{}{
+				"installments":  req.Parcelas,
+				"payment_token": req.PaymentToken,
+				"customer": map[string]interface{}{
+					"name":         req.Cliente.Nome,
+					"cpf":          req.Cliente.CPF,
+					"email":        req.Cliente.Email,
+					"phone_number": req.Cliente.Telefone,
+					"birth":        req.Cliente.Nascimento,
+				},
+				"billing_address": map[string]interface{}{
+					"street":       req.Endereco.Rua,
+					"number":       req.Endereco.Numero,
+					"neighborhood": req.Endereco.Bairro,
+					"zipcode":      req.Endereco.CEP,
+					"city":         req.Endereco.Cidade,
+					"state":        req.Endereco.Estado,
+					"complement":   req.Endereco.Complemento,
+				},
+			},
+		},
+	}
+	if req.NotificationURL != "" {
+		body["metadata"].(map[string]interface{})["notification_url"] = req.NotificationURL
+	}
+
+	res, err := efipay.NewEfiPay(p.credentials).CreateOneStepCharge(body)
+	if err != nil {
+		return nil, err
+	}
+	return parseCardCharge(res)
+}
+
+func (p *EfiPayCardProvider) DetailCardCharge(id string) (*CardCharge, error) {
+	chargeID, err := strconv.Atoi(id)
+	if err != nil {
+		return nil, fmt.Errorf("id de cobrança inválido: %s", id)
+	}
+	res, err := efipay.NewEfiPay(p.credentials).DetailCharge(chargeID)
+	if err != nil {
+		return nil, err
+	}
+	return parseCardCharge(res)
+}
+
+// Notification lê o histórico de alterações do token enviado pela EfiPay na URL de notificação
+func (p *EfiPayCardProvider) Notification(token string) ([]string, error) {
+	res, err := efipay.NewEfiPay(p.credentials).GetNotification(token)
+	if err != nil {
+		return nil, err
+	}
+
+	var n struct {
+		Data []struct {
+			Identifiers struct {
+				ChargeID int `json:"charge_id"`
+			} `json:"identifiers"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(res), &n); err != nil {
+		return nil, fmt.Errorf("erro ao decodificar notificação: %w", err)
+	}
+
+	vistos := map[int]bool{}
+	var ids []string
+	for _, d := range n.Data {
+		if d.Identifiers.ChargeID == 0 || vistos[d.Identifiers.ChargeID] {
+			continue
+		}
+		vistos[d.Identifiers.ChargeID] = true
+		ids = append(ids, strconv.Itoa(d.Identifiers.ChargeID))
+	}
+	return ids, nil
+}
@@ -0,0 +1,101 @@
+package payment
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// Token que faz o provedor fake recusar o cartão
+const FakeCardTokenRecusado = "recusado"
+
+// FakeCardProvider simula cobranças com cartão e carteiras em memória. A cobrança nasce autorizada (ou recusada
+// com o token FakeCardTokenRecusado) e, com autoPay, é capturada na primeira consulta; SetStatus simula as
+// mudanças feitas pelo provedor, como contestação e estorno.
+type FakeCardProvider struct {
+	mu      sync.Mutex
+	autoPay bool
+	seq     int
+	charges map[string]*CardCharge
+}
+
+func NewFakeCardProvider(autoPay bool) *FakeCardProvider {
+	return &FakeCardProvider{autoPay: autoPay, charges: map[string]*CardCharge{}}
+}
+
+func (f *FakeCardProvider) Nome() string {
+	return "fake"
+}
+
+// copia devolve a cobrança com Raw atualizado, sem expor o ponteiro guardado
+func (f *FakeCardProvider) copia(c *CardCharge) (*CardCharge, error) {
+	out := *c
+	raw, err := json.Marshal(map[string]interface{}{
+		"id": c.ID, "custom_id": c.CustomID, "status": c.Status, "valor": c.Valor, "parcelas": c.Parcelas, "motivo": c.Motivo,
+	})
+	if err != nil {
+		return nil, err
+	}
+	out.Raw = raw
+	return &out, nil
+}
+
+func (f *FakeCardProvider) CreateCardCharge(req CardChargeRequest) (*CardCharge, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.seq++
+	c := &CardCharge{
+		ID:       fmt.Sprintf("FAKE-%s-%d", req.Metodo, f.seq),
+		CustomID: req.CustomID,
+		Status:   CartaoAutorizado,
+		Valor:    req.Valor,
+		Parcelas: req.Parcelas,
+	}
+	if req.PaymentToken == FakeCardTokenRecusado {
+		c.Status = CartaoRecusado
+		c.Motivo = "cartão recusado pelo emissor (simulado)"
+	}
+	c.StatusProvedor = c.Status
+	f.charges[c.ID] = c
+	return f.copia(c)
+}
+
+func (f *FakeCardProvider) DetailCardCharge(id string) (*CardCharge, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c, ok := f.charges[id]
+	if !ok {
+		return nil, fmt.Errorf("cobrança %s não encontrada", id)
+	}
+	if f.autoPay && c.Status == CartaoAutorizado {
+		c.Status = CartaoCapturado
+		c.StatusProvedor = c.Status
+	}
+	return f.copia(c)
+}
+
+// Notification recebe como token o próprio id da cobrança
+func (f *FakeCardProvider) Notification(token string) ([]string, error) {
+	return []string{token}, nil
+}
+
+// SetStatus altera o status da cobrança como se o provedor o tivesse feito
+func (f *FakeCardProvider) SetStatus(id, status string) (*CardCharge, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c, ok := f.charges[id]
+	if !ok {
+		return nil, fmt.Errorf("cobrança %s não encontrada", id)
+	}
+	switch status {
+	case CartaoPendente, CartaoAutorizado, CartaoCapturado, CartaoRecusado, CartaoCancelado, CartaoContestado, CartaoEstornado:
+	default:
+		return nil, fmt.Errorf("status %s inválido", status)
+	}
+	c.Status = status
+	c.StatusProvedor = status
+	return f.copia(c)
+}
@@ -0,0 +1,317 @@
+package payment
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/efipay/sdk-go-apis-efi/src/efipay/pix"
+)
+
+// efiCharge é o formato da cobrança (cob) retornado pela EfiPay
+type efiCharge struct {
+	Calendario struct {
+		Criacao                string `json:"criacao"`
+		Expiracao              int    `json:"expiracao,omitempty"`
+		DataDeVencimento       string `json:"dataDeVencimento,omitempty"`
+		ValidadeAposVencimento int    `json:"validadeAposVencimento,omitempty"`
+	} `json:"calendario"`
+	Txid    string `json:"txid"`
+	Revisao int    `json:"revisao"`
+	Loc     struct {
+		ID       int    `json:"id"`
+		Location string `json:"location"`
+		TipoCob  string `json:"tipoCob"`
+		Criacao  string `json:"criacao"`
+	} `json:"loc"`
+	Location string `json:"location"`
+	Status   string `json:"status"`
+	Devedor  struct {
+		CPF  string `json:"cpf,omitempty"`
+		CNPJ string `json:"cnpj,omitempty"`
+		Nome string `json:"nome"`
+	} `json:"devedor"`
+	Valor struct {
+		Original string `json:"original"`
+		Encargos
+	} `json:"valor"`
+	Chave              string        `json:"chave"`
+	SolicitacaoPagador string        `json:"solicitacaoPagador,omitempty"`
+	PixCopiaECola      string        `json:"pixCopiaECola,omitempty"`
+	Pix                []ReceivedPix `json:"pix,omitempty"`
+}
+
+// parseTime faz parse de string ISO, usando o horário atual quando ausente ou inválida
+func parseTime(v string) time.Time {
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Now()
+	}
+	return t
+}
+
+func parseCharge(raw string) (*Charge, error) {
+	var c efiCharge
+	if err := json.Unmarshal([]byte(raw), &c); err != nil {
+		return nil, fmt.Errorf("erro ao decodificar resposta do PIX: %w", err)
+	}
+	if c.Txid == "" {
+		return nil, fmt.Errorf("resposta inválida da API (txid ausente)")
+	}
+
+	location := c.Loc.Location
+	if location == "" {
+		location = c.Location
+	}
+
+	// Só a cobrança com vencimento (cobv) tem data de vencimento
+	var vencimento time.Time
+	if c.Calendario.DataDeVencimento != "" {
+		v, err := time.Parse(formatoData, c.Calendario.DataDeVencimento)
+		if err != nil {
+			return nil, fmt.Errorf("data de vencimento inválida na resposta do PIX: %w", err)
+		}
+		vencimento = v
+	}
+
+	return &Charge{
+		Txid:                   c.Txid,
+		Status:                 c.Status,
+		Valor:                  c.Valor.Original,
+		Chave:                  c.Chave,
+		Criacao:                parseTime(c.Calendario.Criacao),
+		Expiracao:              c.Calendario.Expiracao,
+		DataVencimento:         vencimento,
+		ValidadeAposVencimento: c.Calendario.ValidadeAposVencimento,
+		LocID:                  c.Loc.ID,
+		LocTipoCob:             c.Loc.TipoCob,
+		LocCriacao:             parseTime(c.Loc.Criacao),
+		Location:               location,
+		PixCopiaECola:          c.PixCopiaECola,
+		Pix:                    c.Pix,
+		Raw:                    json.RawMessage(raw),
+	}, nil
+}
+
+// EfiPayProvider usa o SDK da EfiPay com as credenciais de config.GetCredentials()
+type EfiPayProvider struct {
+	credentials map[string]interface{}
+}
+
+func NewEfiPayProvider(credentials map[string]interface{}) *EfiPayProvider {
+	return &EfiPayProvider{credentials: credentials}
+}
+
+func (p *EfiPayProvider) CreateImmediateCharge(req ChargeRequest) (*Charge, error) {
+	devedor := map[string]interface{}{"nome": req.DevedorNome}
+	if req.DevedorCNPJ != "" {
+		devedor["cnpj"] = req.DevedorCNPJ
+	} else {
+		devedor["cpf"] = req.DevedorCPF
+	}
+
+	body := map[string]interface{}{
+		"calendario":         map[string]interface{}{"expiracao": req.Expiracao},
+		"devedor":            devedor,
+		"valor":              map[string]interface{}{"original": req.Valor},
+		"chave":              req.Chave,
+		"solicitacaoPagador": req.SolicitacaoPagador,
+	}
+
+	res, err := pix.NewEfiPay(p.credentials).CreateImmediateCharge(body)
+	if err != nil {
+		return nil, err
+	}
+	return parseCharge(res)
+}
+
+func (p *EfiPayProvider) DetailCharge(txid string) (*Charge, error) {
+	res, err := pix.NewEfiPay(p.credentials).DetailCharge(txid)
+	if err != nil {
+		return nil, err
+	}
+	return parseCharge(res)
+}
+
+func (p *EfiPayProvider) CreateDueCharge(req DueChargeRequest) (*Charge, error) {
+	devedor := map[string]interface{}{"nome": req.DevedorNome}
+	if req.DevedorCNPJ != "" {
+		devedor["cnpj"] = req.DevedorCNPJ
+	} else {
+		devedor["cpf"] = req.DevedorCPF
+	}
+
+	valor := map[string]interface{}{"original": req.Valor}
+	if req.Encargos.Multa != nil {
+		valor["multa"] = req.Encargos.Multa
+	}
+	if req.Encargos.Juros != nil {
+		valor["juros"] = req.Encargos.Juros
+	}
+	if req.Encargos.Desconto != nil {
+		valor["desconto"] = req.Encargos.Desconto
+	}
+
+	body := map[string]interface{}{
+		"calendario": map[string]interface{}{
+			"dataDeVencimento":       req.DataVencimento.Format(formatoData),
+			"validadeAposVencimento": req.ValidadeAposVencimento,
+		},
+		"devedor":            devedor,
+		"valor":              valor,
+		"chave":              req.Chave,
+		"solicitacaoPagador": req.SolicitacaoPagador,
+	}
+
+	res, err := pix.NewEfiPay(p.credentials).CreateDueCharge(req.Txid, body)
+	if err != nil {
+		return nil, err
+	}
+	return parseCharge(res)
+}
+
+func (p *EfiPayProvider) DetailDueCharge(txid string) (*Charge, error) {
+	res, err := pix.NewEfiPay(p.credentials).DetailDueCharge(txid)
+	if err != nil {
+		return nil, err
+	}
+	return parseCharge(res)
+}
+
+// ListCharges lista as cobranças criadas entre inicio e fim. O SDK só monta inicio e fim na URL, sem escapar os
+// valores, então a página vai como parâmetro extra junto com fim.
+func (p *EfiPayProvider) ListCharges(inicio, fim time.Time, pagina int) (*ChargePage, error) {
+	fimParam := fmt.Sprintf("%s&paginacao.paginaAtual=%d", fim.UTC().Format(time.RFC3339), pagina)
+	res, err := pix.NewEfiPay(p.credentials).ListCharges(inicio.UTC().Format(time.RFC3339), fimParam)
+	if err != nil {
+		return nil, err
+	}
+
+	var l struct {
+		Parametros struct {
+			Paginacao struct {
+				PaginaAtual         int `json:"paginaAtual"`
+				QuantidadeDePaginas int `json:"quantidadeDePaginas"`
+			} `json:"paginacao"`
+		} `json:"parametros"`
+		Cobs []json.RawMessage `json:"cobs"`
+	}
+	if err := json.Unmarshal([]byte(res), &l); err != nil {
+		return nil, fmt.Errorf("erro ao decodificar listagem de cobranças: %w", err)
+	}
+
+	page := &ChargePage{
+		Pagina:       l.Parametros.Paginacao.PaginaAtual,
+		TotalPaginas: l.Parametros.Paginacao.QuantidadeDePaginas,
+	}
+	for _, raw := range l.Cobs {
+		c, err := parseCharge(string(raw))
+		if err != nil {
+			return nil, err
+		}
+		page.Charges = append(page.Charges, *c)
+	}
+	return page, nil
+}
+
+func (p *EfiPayProvider) Refund(endToEndID, refundID, valor string) (*Refund, error) {
+	res, err := pix.NewEfiPay(p.credentials).PixDevolution(endToEndID, refundID, map[string]interface{}{"valor": valor})
+	if err != nil {
+		return nil, err
+	}
+	return parseRefund(res)
+}
+
+func (p *EfiPayProvider) DetailRefund(endToEndID, refundID string) (*Refund, error) {
+	res, err := pix.NewEfiPay(p.credentials).PixDetailDevolution(endToEndID, refundID)
+	if err != nil {
+		return nil, err
+	}
+	return parseRefund(res)
+}
+
+// parseRefund lê a resposta da solicitação e da consulta de devolução
+func parseRefund(res string) (*Refund, error) {
+	var d struct {
+		ID     string `json:"id"`
+		RtrID  string `json:"rtrId"`
+		Valor  string `json:"valor"`
+		Status string `json:"status"`
+	}
+	if err := json.Unmarshal([]byte(res), &d); err != nil {
+		return nil, fmt.Errorf("erro ao decodificar resposta da devolução: %w", err)
+	}
+
+	return &Refund{ID: d.ID, RtrID: d.RtrID, Valor: d.Valor, Status: d.Status, Raw: json.RawMessage(res)}, nil
+}
+
+func (p *EfiPayProvider) ParseWebhook(body []byte) ([]ReceivedPix, error) {
+	return parseWebhookBody(body)
+}
+
+func (p *EfiPayProvider) ConfigWebhook(chave, webhookURL string) (json.RawMessage, error) {
+	res, err := pix.NewEfiPay(p.credentials).PixConfigWebhook(chave, map[string]interface{}{"webhookUrl": webhookURL})
+	if err != nil {
+		return nil, err
+	}
+	return json.RawMessage(res), nil
+}
+
+func (p *EfiPayProvider) DetailWebhook(chave string) (json.RawMessage, error) {
+	res, err := pix.NewEfiPay(p.credentials).PixDetailWebhook(chave)
+	if err != nil {
+		return nil, err
+	}
+	return json.RawMessage(res), nil
+}
+
+func (p *EfiPayProvider) SendPix(idEnvio string, req SendRequest) (*Send, error) {
+	favorecido := map[string]interface{}{"chave": req.ChaveFavorecido}
+	if req.CNPJFavorecido != "" {
+		favorecido["cnpj"] = req.CNPJFavorecido
+	} else if req.CPFFavorecido != "" {
+		favorecido["cpf"] = req.CPFFavorecido
+	}
+
+	pagador := map[string]interface{}{"chave": req.ChavePagador}
+	if req.InfoPagador != "" {
+		pagador["infoPagador"] = req.InfoPagador
+	}
+
+	body := map[string]interface{}{
+		"valor":      req.Valor,
+		"pagador":    pagador,
+		"favorecido": favorecido,
+	}
+
+	res, err := pix.NewEfiPay(p.credentials).PixSend(idEnvio, body)
+	if err != nil {
+		return nil, erroEfi(err)
+	}
+	return parseSend(res)
+}
+
+func (p *EfiPayProvider) DetailSend(endToEndID string) (*Send, error) {
+	res, err := pix.NewEfiPay(p.credentials).PixSendDetail(endToEndID)
+	if err != nil {
+		return nil, err
+	}
+	return parseSend(res)
+}
+
+// erroEfi marca com ErrRecusado as recusas da EfiPay. O SDK devolve o corpo da resposta como erro; o provedor
+// recusou quando o corpo é o JSON de erro da API ({"nome", "mensagem"}) e não um erro interno dele.
+func erroEfi(err error) error {
+	if FalhaDeComunicacao(err) {
+		return err
+	}
+	var corpo struct {
+		Nome     string `json:"nome"`
+		Mensagem string `json:"mensagem"`
+	}
+	if json.Unmarshal([]byte(err.Error()), &corpo) != nil || corpo.Nome == "" || strings.Contains(corpo.Nome, "interno") {
+		return err
+	}
+	return fmt.Errorf("%w: %s: %s", ErrRecusado, corpo.Nome, corpo.Mensagem)
+}
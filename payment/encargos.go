@@ -0,0 +1,177 @@
+package payment
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// Modalidades da multa (padrão Bacen para cobv)
+const (
+	MultaValorFixo  = 1
+	MultaPercentual = 2
+)
+
+// Modalidades dos juros: valor ou percentual por dia, mês ou ano, em dias corridos (1 a 4) ou úteis (5 a 8)
+const (
+	JurosValorDiaCorrido      = 1
+	JurosPercentualDiaCorrido = 2
+	JurosPercentualMesCorrido = 3
+	JurosPercentualAnoCorrido = 4
+	JurosValorDiaUtil         = 5
+	JurosPercentualDiaUtil    = 6
+	JurosPercentualMesUtil    = 7
+	JurosPercentualAnoUtil    = 8
+)
+
+// Modalidades do desconto: valor ou percentual fixo até as datas informadas (1 e 2) ou por dia de antecipação
+const (
+	DescontoValorDataFixa                = 1
+	DescontoPercentualDataFixa           = 2
+	DescontoValorAntecipacaoCorrido      = 3
+	DescontoValorAntecipacaoUtil         = 4
+	DescontoPercentualAntecipacaoCorrido = 5
+	DescontoPercentualAntecipacaoUtil    = 6
+)
+
+const (
+	descontoDatasMaximo = 3
+	formatoData         = "2006-01-02"
+)
+
+var (
+	ErrEncargoInvalido = errors.New("encargo da cobrança inválido")
+
+	valorPercValido = regexp.MustCompile(`^\d{1,10}\.\d{2}$`)
+)
+
+// Encargo é a multa ou os juros da cobrança com vencimento: valor (reais) ou percentual conforme a modalidade
+type Encargo struct {
+	Modalidade int    `json:"modalidade"`
+	ValorPerc  string `json:"valorPerc"`
+}
+
+// DescontoDataFixa é o desconto concedido para pagamento até a data (AAAA-MM-DD)
+type DescontoDataFixa struct {
+	Data      string `json:"data"`
+	ValorPerc string `json:"valorPerc"`
+}
+
+// Desconto da cobrança com vencimento. Nas modalidades de data fixa usa DescontoDataFixa; nas de antecipação, ValorPerc.
+type Desconto struct {
+	Modalidade       int                `json:"modalidade"`
+	ValorPerc        string             `json:"valorPerc,omitempty"`
+	DescontoDataFixa []DescontoDataFixa `json:"descontoDataFixa,omitempty"`
+}
+
+// Encargos são as regras de multa, juros e desconto no formato da API PIX, calculadas pelo PSP no pagamento
+type Encargos struct {
+	Multa    *Encargo  `json:"multa,omitempty"`
+	Juros    *Encargo  `json:"juros,omitempty"`
+	Desconto *Desconto `json:"desconto,omitempty"`
+}
+
+// Vazio indica que a cobrança não tem multa, juros nem desconto
+func (e Encargos) Vazio() bool {
+	return e.Multa == nil && e.Juros == nil && e.Desconto == nil
+}
+
+// Validar confere modalidades e valores. Percentuais vão até 100 e as datas de desconto devem ser crescentes
+// e até o vencimento.
+func (e Encargos) Validar(vencimento time.Time) error {
+	if e.Multa != nil {
+		if e.Multa.Modalidade != MultaValorFixo && e.Multa.Modalidade != MultaPercentual {
+			return fmt.Errorf("%w: modalidade da multa deve ser 1 ou 2", ErrEncargoInvalido)
+		}
+		if err := validarValorPerc("multa", e.Multa.ValorPerc, e.Multa.Modalidade == MultaPercentual); err != nil {
+			return err
+		}
+	}
+
+	if e.Juros != nil {
+		if e.Juros.Modalidade < JurosValorDiaCorrido || e.Juros.Modalidade > JurosPercentualAnoUtil {
+			return fmt.Errorf("%w: modalidade dos juros deve ser de 1 a 8", ErrEncargoInvalido)
+		}
+		percentual := e.Juros.Modalidade != JurosValorDiaCorrido && e.Juros.Modalidade != JurosValorDiaUtil
+		if err := validarValorPerc("juros", e.Juros.ValorPerc, percentual); err != nil {
+			return err
+		}
+	}
+
+	if d := e.Desconto; d != nil {
+		switch d.Modalidade {
+		case DescontoValorDataFixa, DescontoPercentualDataFixa:
+			if d.ValorPerc != "" {
+				return fmt.Errorf("%w: desconto por data fixa usa descontoDataFixa", ErrEncargoInvalido)
+			}
+			if len(d.DescontoDataFixa) == 0 || len(d.DescontoDataFixa) > descontoDatasMaximo {
+				return fmt.Errorf("%w: informe de 1 a %d datas de desconto", ErrEncargoInvalido, descontoDatasMaximo)
+			}
+			var anterior time.Time
+			for _, dd := range d.DescontoDataFixa {
+				data, err := time.Parse(formatoData, dd.Data)
+				if err != nil {
+					return fmt.Errorf("%w: data de desconto %q", ErrEncargoInvalido, dd.Data)
+				}
+				if !data.After(anterior) || data.After(dataSemHorario(vencimento)) {
+					return fmt.Errorf("%w: datas de desconto devem ser crescentes e até o vencimento", ErrEncargoInvalido)
+				}
+				anterior = data
+				if err := validarValorPerc("desconto", dd.ValorPerc, d.Modalidade == DescontoPercentualDataFixa); err != nil {
+					return err
+				}
+			}
+		case DescontoValorAntecipacaoCorrido, DescontoValorAntecipacaoUtil, DescontoPercentualAntecipacaoCorrido, DescontoPercentualAntecipacaoUtil:
+			if len(d.DescontoDataFixa) > 0 {
+				return fmt.Errorf("%w: desconto por antecipação não usa descontoDataFixa", ErrEncargoInvalido)
+			}
+			percentual := d.Modalidade == DescontoPercentualAntecipacaoCorrido || d.Modalidade == DescontoPercentualAntecipacaoUtil
+			if err := validarValorPerc("desconto", d.ValorPerc, percentual); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("%w: modalidade do desconto deve ser de 1 a 6", ErrEncargoInvalido)
+		}
+	}
+	return nil
+}
+
+func validarValorPerc(campo, valor string, percentual bool) error {
+	if !valorPercValido.MatchString(valor) {
+		return fmt.Errorf("%w: %s deve ter o formato 0.00", ErrEncargoInvalido, campo)
+	}
+	v, _ := strconv.ParseFloat(valor, 64)
+	if v <= 0 || (percentual && v > 100) {
+		return fmt.Errorf("%w: %s fora do intervalo permitido", ErrEncargoInvalido, campo)
+	}
+	return nil
+}
+
+// dataSemHorario mantém só o dia, em UTC, como as datas AAAA-MM-DD lidas com time.Parse
+func dataSemHorario(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// FusoBrasilia: o vencimento da cobv é um dia no horário de Brasília (sem horário de verão desde 2019)
+var FusoBrasilia = time.FixedZone("BRT", -3*60*60)
+
+// LimitePagamento é o instante a partir do qual a cobrança não pode mais ser paga: criação + expiração na
+// cobrança imediata; fim do dia do vencimento somado aos dias de validade na cobrança com vencimento.
+func LimitePagamento(criacao time.Time, expiracao int, vencimento time.Time, validadeAposVencimento int) time.Time {
+	if vencimento.IsZero() {
+		return criacao.Add(time.Duration(expiracao) * time.Second)
+	}
+	return time.Date(vencimento.Year(), vencimento.Month(), vencimento.Day()+validadeAposVencimento+1, 0, 0, 0, 0, FusoBrasilia)
+}
+
+// LimitePagamento da cobrança, conforme o tipo (cob ou cobv)
+func (c *Charge) LimitePagamento() time.Time {
+	return LimitePagamento(c.Criacao, c.Expiracao, c.DataVencimento, c.ValidadeAposVencimento)
+}
+
+// ComVencimento indica se é uma cobrança com vencimento (cobv)
+func (c *Charge) ComVencimento() bool {
+	return !c.DataVencimento.IsZero()
+}
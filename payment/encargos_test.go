@@ -0,0 +1,89 @@
+package payment
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestEncargosValidar(t *testing.T) {
+	vencimento := time.Date(2025, 3, 10, 15, 0, 0, 0, FusoBrasilia)
+	datas := func(dd ...DescontoDataFixa) *Desconto {
+		return &Desconto{Modalidade: DescontoValorDataFixa, DescontoDataFixa: dd}
+	}
+
+	casos := []struct {
+		nome     string
+		encargos Encargos
+		valido   bool
+	}{
+		{"vazio", Encargos{}, true},
+		{"multa fixa", Encargos{Multa: &Encargo{Modalidade: MultaValorFixo, ValorPerc: "150.00"}}, true},
+		{"multa percentual", Encargos{Multa: &Encargo{Modalidade: MultaPercentual, ValorPerc: "2.00"}}, true},
+		{"juros ao mês em dias úteis", Encargos{Juros: &Encargo{Modalidade: JurosPercentualMesUtil, ValorPerc: "1.00"}}, true},
+		{"juros em valor por dia acima de 100", Encargos{Juros: &Encargo{Modalidade: JurosValorDiaCorrido, ValorPerc: "150.00"}}, true},
+		{"desconto em datas crescentes", Encargos{Desconto: datas(
+			DescontoDataFixa{Data: "2025-03-01", ValorPerc: "10.00"},
+			DescontoDataFixa{Data: "2025-03-05", ValorPerc: "5.00"},
+			DescontoDataFixa{Data: "2025-03-10", ValorPerc: "2.00"},
+		)}, true},
+		{"desconto por antecipação", Encargos{Desconto: &Desconto{Modalidade: DescontoPercentualAntecipacaoUtil, ValorPerc: "0.50"}}, true},
+
+		{"modalidade da multa 0", Encargos{Multa: &Encargo{Modalidade: 0, ValorPerc: "2.00"}}, false},
+		{"modalidade da multa 3", Encargos{Multa: &Encargo{Modalidade: 3, ValorPerc: "2.00"}}, false},
+		{"multa percentual acima de 100", Encargos{Multa: &Encargo{Modalidade: MultaPercentual, ValorPerc: "100.01"}}, false},
+		{"multa zero", Encargos{Multa: &Encargo{Modalidade: MultaValorFixo, ValorPerc: "0.00"}}, false},
+		{"multa sem centavos", Encargos{Multa: &Encargo{Modalidade: MultaValorFixo, ValorPerc: "2"}}, false},
+		{"multa com vírgula", Encargos{Multa: &Encargo{Modalidade: MultaValorFixo, ValorPerc: "2,00"}}, false},
+		{"modalidade dos juros 0", Encargos{Juros: &Encargo{Modalidade: 0, ValorPerc: "1.00"}}, false},
+		{"modalidade dos juros 9", Encargos{Juros: &Encargo{Modalidade: 9, ValorPerc: "1.00"}}, false},
+		{"juros percentual acima de 100", Encargos{Juros: &Encargo{Modalidade: JurosPercentualAnoCorrido, ValorPerc: "120.00"}}, false},
+		{"modalidade do desconto 0", Encargos{Desconto: &Desconto{Modalidade: 0, ValorPerc: "1.00"}}, false},
+		{"modalidade do desconto 7", Encargos{Desconto: &Desconto{Modalidade: 7, ValorPerc: "1.00"}}, false},
+		{"desconto por data fixa com valorPerc", Encargos{Desconto: &Desconto{Modalidade: DescontoValorDataFixa, ValorPerc: "1.00",
+			DescontoDataFixa: []DescontoDataFixa{{Data: "2025-03-01", ValorPerc: "1.00"}}}}, false},
+		{"desconto por data fixa sem datas", Encargos{Desconto: datas()}, false},
+		{"desconto com quatro datas", Encargos{Desconto: datas(
+			DescontoDataFixa{Data: "2025-03-01", ValorPerc: "4.00"},
+			DescontoDataFixa{Data: "2025-03-02", ValorPerc: "3.00"},
+			DescontoDataFixa{Data: "2025-03-03", ValorPerc: "2.00"},
+			DescontoDataFixa{Data: "2025-03-04", ValorPerc: "1.00"},
+		)}, false},
+		{"data de desconto inválida", Encargos{Desconto: datas(DescontoDataFixa{Data: "01/03/2025", ValorPerc: "1.00"})}, false},
+		{"datas de desconto fora de ordem", Encargos{Desconto: datas(
+			DescontoDataFixa{Data: "2025-03-05", ValorPerc: "5.00"},
+			DescontoDataFixa{Data: "2025-03-01", ValorPerc: "10.00"},
+		)}, false},
+		{"datas de desconto repetidas", Encargos{Desconto: datas(
+			DescontoDataFixa{Data: "2025-03-01", ValorPerc: "5.00"},
+			DescontoDataFixa{Data: "2025-03-01", ValorPerc: "10.00"},
+		)}, false},
+		{"data de desconto depois do vencimento", Encargos{Desconto: datas(DescontoDataFixa{Data: "2025-03-11", ValorPerc: "1.00"})}, false},
+		{"desconto percentual por data acima de 100", Encargos{Desconto: &Desconto{Modalidade: DescontoPercentualDataFixa,
+			DescontoDataFixa: []DescontoDataFixa{{Data: "2025-03-01", ValorPerc: "100.50"}}}}, false},
+		{"desconto por antecipação com datas", Encargos{Desconto: &Desconto{Modalidade: DescontoValorAntecipacaoCorrido, ValorPerc: "1.00",
+			DescontoDataFixa: []DescontoDataFixa{{Data: "2025-03-01", ValorPerc: "1.00"}}}}, false},
+		{"desconto percentual por antecipação acima de 100", Encargos{Desconto: &Desconto{Modalidade: DescontoPercentualAntecipacaoCorrido, ValorPerc: "101.00"}}, false},
+	}
+	for _, c := range casos {
+		err := c.encargos.Validar(vencimento)
+		if c.valido && err != nil {
+			t.Errorf("%s: Validar = %v, esperado válido", c.nome, err)
+		}
+		if !c.valido && !errors.Is(err, ErrEncargoInvalido) {
+			t.Errorf("%s: Validar = %v, esperado ErrEncargoInvalido", c.nome, err)
+		}
+	}
+}
+
+func TestLimitePagamento(t *testing.T) {
+	criacao := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	if got := LimitePagamento(criacao, 3600, time.Time{}, 0); !got.Equal(criacao.Add(time.Hour)) {
+		t.Errorf("cob: LimitePagamento = %s, esperado %s", got, criacao.Add(time.Hour))
+	}
+	vencimento := time.Date(2025, 3, 10, 0, 0, 0, 0, time.UTC)
+	esperado := time.Date(2025, 3, 13, 3, 0, 0, 0, time.UTC)
+	if got := LimitePagamento(criacao, 0, vencimento, 2); !got.Equal(esperado) {
+		t.Errorf("cobv: LimitePagamento = %s, esperado %s", got, esperado)
+	}
+}
@@ -0,0 +1,326 @@
+package payment
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// FakeProvider simula a EfiPay em memória, com txid e endToEndId determinísticos (sequenciais).
+// Com autoPay, a cobrança é concluída na primeira consulta; sem ele, só após Pay.
+// PIX enviados e devoluções ficam em processamento até a primeira consulta, quando são realizados.
+type FakeProvider struct {
+	mu       sync.Mutex
+	autoPay  bool
+	seq      int
+	charges  map[string]*efiCharge
+	refunds  map[string]Refund
+	sends    map[string]*Send
+	webhooks map[string]string
+	now      func() time.Time
+}
+
+func NewFakeProvider(autoPay bool) *FakeProvider {
+	return &FakeProvider{
+		autoPay:  autoPay,
+		charges:  map[string]*efiCharge{},
+		refunds:  map[string]Refund{},
+		sends:    map[string]*Send{},
+		webhooks: map[string]string{},
+		now:      time.Now,
+	}
+}
+
+func (f *FakeProvider) toCharge(c *efiCharge) (*Charge, error) {
+	raw, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	return parseCharge(string(raw))
+}
+
+func (f *FakeProvider) CreateImmediateCharge(req ChargeRequest) (*Charge, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.seq++
+	txid := fmt.Sprintf("FAKE%028d", f.seq)
+	criacao := f.now().UTC().Format(time.RFC3339)
+
+	c := &efiCharge{Txid: txid, Status: StatusAtiva, Chave: req.Chave, SolicitacaoPagador: req.SolicitacaoPagador}
+	c.Calendario.Criacao = criacao
+	c.Calendario.Expiracao = req.Expiracao
+	c.Loc.ID = f.seq
+	c.Loc.TipoCob = "cob"
+	c.Loc.Criacao = criacao
+	c.Loc.Location = "fake.pix.local/v2/" + txid
+	c.Location = c.Loc.Location
+	c.Devedor.Nome = req.DevedorNome
+	c.Devedor.CPF = req.DevedorCPF
+	c.Devedor.CNPJ = req.DevedorCNPJ
+	c.Valor.Original = req.Valor
+	c.PixCopiaECola = "FAKE-PIX-" + txid
+
+	f.charges[txid] = c
+	return f.toCharge(c)
+}
+
+func (f *FakeProvider) DetailCharge(txid string) (*Charge, error) {
+	return f.detail(txid, "cob")
+}
+
+// CreateDueCharge registra a cobrança com vencimento no txid informado. O pagamento simulado é sempre do
+// valor original, sem calcular multa, juros ou desconto.
+func (f *FakeProvider) CreateDueCharge(req DueChargeRequest) (*Charge, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if req.Txid == "" {
+		return nil, fmt.Errorf("txid é obrigatório na cobrança com vencimento")
+	}
+	if _, ok := f.charges[req.Txid]; ok {
+		return nil, fmt.Errorf("txid %s já utilizado", req.Txid)
+	}
+
+	f.seq++
+	criacao := f.now().UTC().Format(time.RFC3339)
+
+	c := &efiCharge{Txid: req.Txid, Status: StatusAtiva, Chave: req.Chave, SolicitacaoPagador: req.SolicitacaoPagador}
+	c.Calendario.Criacao = criacao
+	c.Calendario.DataDeVencimento = req.DataVencimento.Format(formatoData)
+	c.Calendario.ValidadeAposVencimento = req.ValidadeAposVencimento
+	c.Loc.ID = f.seq
+	c.Loc.TipoCob = "cobv"
+	c.Loc.Criacao = criacao
+	c.Loc.Location = "fake.pix.local/v2/cobv/" + req.Txid
+	c.Location = c.Loc.Location
+	c.Devedor.Nome = req.DevedorNome
+	c.Devedor.CPF = req.DevedorCPF
+	c.Devedor.CNPJ = req.DevedorCNPJ
+	c.Valor.Original = req.Valor
+	c.Valor.Encargos = req.Encargos
+	c.PixCopiaECola = "FAKE-PIX-" + req.Txid
+
+	f.charges[req.Txid] = c
+	return f.toCharge(c)
+}
+
+func (f *FakeProvider) DetailDueCharge(txid string) (*Charge, error) {
+	return f.detail(txid, "cobv")
+}
+
+// detail consulta a cobrança do tipo informado (cob ou cobv), concluindo-a com autoPay
+func (f *FakeProvider) detail(txid, tipoCob string) (*Charge, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c, ok := f.charges[txid]
+	if !ok || c.Loc.TipoCob != tipoCob {
+		return nil, fmt.Errorf("cobrança %s não encontrada", txid)
+	}
+	if f.autoPay && c.Status == StatusAtiva {
+		f.pay(c)
+	}
+	return f.toCharge(c)
+}
+
+// fakePageSize é o tamanho da página da listagem, o mesmo padrão da EfiPay
+const fakePageSize = 100
+
+func (f *FakeProvider) ListCharges(inicio, fim time.Time, pagina int) (*ChargePage, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var txids []string
+	for txid, c := range f.charges {
+		if c.Loc.TipoCob != "cob" {
+			continue
+		}
+		criacao := parseTime(c.Calendario.Criacao)
+		if !criacao.Before(inicio) && !criacao.After(fim) {
+			txids = append(txids, txid)
+		}
+	}
+	sort.Strings(txids)
+
+	page := &ChargePage{Pagina: pagina, TotalPaginas: (len(txids) + fakePageSize - 1) / fakePageSize}
+	for i := pagina * fakePageSize; i < len(txids) && i < (pagina+1)*fakePageSize; i++ {
+		c, err := f.toCharge(f.charges[txids[i]])
+		if err != nil {
+			return nil, err
+		}
+		page.Charges = append(page.Charges, *c)
+	}
+	return page, nil
+}
+
+// pay conclui a cobrança registrando o PIX recebido
+func (f *FakeProvider) pay(c *efiCharge) ReceivedPix {
+	received := ReceivedPix{
+		EndToEndID: fmt.Sprintf("E00000000%s%011d", f.now().UTC().Format("200601021504"), c.Loc.ID),
+		Txid:       c.Txid,
+		Chave:      c.Chave,
+		Valor:      c.Valor.Original,
+		Horario:    f.now().UTC().Format(time.RFC3339),
+	}
+	c.Status = StatusConcluida
+	c.Pix = append(c.Pix, received)
+	return received
+}
+
+// Pay conclui a cobrança e retorna o PIX no formato da notificação do webhook
+func (f *FakeProvider) Pay(txid string) (ReceivedPix, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c, ok := f.charges[txid]
+	if !ok {
+		return ReceivedPix{}, fmt.Errorf("cobrança %s não encontrada", txid)
+	}
+	if c.Status != StatusAtiva {
+		return ReceivedPix{}, fmt.Errorf("cobrança %s com status %s", txid, c.Status)
+	}
+	return f.pay(c), nil
+}
+
+func (f *FakeProvider) Refund(endToEndID, refundID, valor string) (*Refund, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key := endToEndID + "/" + refundID
+	if r, ok := f.refunds[key]; ok {
+		return &r, nil
+	}
+
+	found := false
+	for _, c := range f.charges {
+		for _, p := range c.Pix {
+			if p.EndToEndID == endToEndID {
+				found = true
+			}
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("PIX %s não encontrado", endToEndID)
+	}
+
+	r := Refund{ID: refundID, RtrID: fmt.Sprintf("D%s", endToEndID[1:]), Valor: valor, Status: DevolucaoEmProcessamento}
+	if err := refundRaw(&r); err != nil {
+		return nil, err
+	}
+	f.refunds[key] = r
+	return &r, nil
+}
+
+// DetailRefund realiza a devolução na primeira consulta
+func (f *FakeProvider) DetailRefund(endToEndID, refundID string) (*Refund, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key := endToEndID + "/" + refundID
+	r, ok := f.refunds[key]
+	if !ok {
+		return nil, fmt.Errorf("devolução %s não encontrada", refundID)
+	}
+	if r.Status == DevolucaoEmProcessamento {
+		r.Status = DevolucaoDevolvido
+		if err := refundRaw(&r); err != nil {
+			return nil, err
+		}
+		f.refunds[key] = r
+	}
+	return &r, nil
+}
+
+func refundRaw(r *Refund) error {
+	raw, err := json.Marshal(map[string]string{"id": r.ID, "rtrId": r.RtrID, "valor": r.Valor, "status": r.Status})
+	if err != nil {
+		return err
+	}
+	r.Raw = raw
+	return nil
+}
+
+func (f *FakeProvider) ParseWebhook(body []byte) ([]ReceivedPix, error) {
+	return parseWebhookBody(body)
+}
+
+func (f *FakeProvider) ConfigWebhook(chave, webhookURL string) (json.RawMessage, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.webhooks[chave] = webhookURL
+	return json.Marshal(map[string]string{"webhookUrl": webhookURL})
+}
+
+func (f *FakeProvider) DetailWebhook(chave string) (json.RawMessage, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	webhookURL, ok := f.webhooks[chave]
+	if !ok {
+		return nil, fmt.Errorf("webhook não encontrado para a chave %s", chave)
+	}
+	return json.Marshal(map[string]string{"chave": chave, "webhookUrl": webhookURL})
+}
+
+func (f *FakeProvider) sendRaw(s *Send) error {
+	raw, err := json.Marshal(map[string]string{
+		"idEnvio": s.IDEnvio, "endToEndId": s.EndToEndID, "valor": s.Valor, "status": s.Status,
+	})
+	if err != nil {
+		return err
+	}
+	s.Raw = raw
+	return nil
+}
+
+func (f *FakeProvider) SendPix(idEnvio string, req SendRequest) (*Send, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if s, ok := f.sends[idEnvio]; ok {
+		copia := *s
+		return &copia, nil
+	}
+	if req.ChaveFavorecido == "" {
+		return nil, fmt.Errorf("chave do favorecido é obrigatória")
+	}
+
+	f.seq++
+	s := &Send{
+		IDEnvio:    idEnvio,
+		EndToEndID: fmt.Sprintf("E00000000%s%011d", f.now().UTC().Format("200601021504"), f.seq),
+		Valor:      req.Valor,
+		Status:     EnvioEmProcessamento,
+	}
+	if err := f.sendRaw(s); err != nil {
+		return nil, err
+	}
+	f.sends[idEnvio] = s
+
+	copia := *s
+	return &copia, nil
+}
+
+func (f *FakeProvider) DetailSend(endToEndID string) (*Send, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, s := range f.sends {
+		if s.EndToEndID != endToEndID {
+			continue
+		}
+		if s.Status == EnvioEmProcessamento {
+			s.Status = EnvioRealizado
+			if err := f.sendRaw(s); err != nil {
+				return nil, err
+			}
+		}
+		copia := *s
+		return &copia, nil
+	}
+	return nil, fmt.Errorf("PIX enviado %s não encontrado", endToEndID)
+}
@@ -0,0 +1,205 @@
+package payment
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"BACK_SORTE_GO/config"
+)
+
+// Status de cobrança retornados pelo provedor (padrão da API PIX do Bacen)
+const (
+	StatusAtiva             = "ATIVA"
+	StatusConcluida         = "CONCLUIDA"
+	StatusRemovidaRecebedor = "REMOVIDA_PELO_USUARIO_RECEBEDOR"
+	StatusRemovidaPSP       = "REMOVIDA_PELO_PSP"
+)
+
+// ErrRecusado indica que o provedor respondeu e recusou a operação (chave inválida, dados rejeitados): repetir
+// a mesma requisição não muda o resultado
+var ErrRecusado = errors.New("operação recusada pelo provedor")
+
+// FalhaDeComunicacao indica que o erro aconteceu antes da resposta do provedor (rede, timeout, TLS): a operação
+// pode ter sido processada mesmo assim
+func FalhaDeComunicacao(err error) bool {
+	var urlErr *url.Error
+	var netErr net.Error
+	return errors.As(err, &urlErr) || errors.As(err, &netErr)
+}
+
+// ChargeRequest são os dados para criar uma cobrança imediata (cob)
+type ChargeRequest struct {
+	Valor              string
+	Chave              string
+	Expiracao          int
+	DevedorNome        string
+	DevedorCPF         string
+	DevedorCNPJ        string
+	SolicitacaoPagador string
+}
+
+// DueChargeRequest são os dados para criar uma cobrança com vencimento (cobv). O txid é gerado por nós
+// (26 a 35 caracteres alfanuméricos) e o devedor precisa de nome e CPF/CNPJ.
+type DueChargeRequest struct {
+	Txid                   string
+	Valor                  string
+	Chave                  string
+	DataVencimento         time.Time
+	ValidadeAposVencimento int
+	DevedorNome            string
+	DevedorCPF             string
+	DevedorCNPJ            string
+	SolicitacaoPagador     string
+	Encargos               Encargos
+}
+
+// Charge é a cobrança retornada pelo provedor. Raw guarda a resposta original, devolvida ao frontend.
+// DataVencimento só é preenchida na cobrança com vencimento (cobv), que não tem Expiracao.
+type Charge struct {
+	Txid                   string
+	Status                 string
+	Valor                  string
+	Chave                  string
+	Criacao                time.Time
+	Expiracao              int
+	DataVencimento         time.Time
+	ValidadeAposVencimento int
+	LocID                  int
+	LocTipoCob             string
+	LocCriacao             time.Time
+	Location               string
+	PixCopiaECola          string
+	Pix                    []ReceivedPix
+	Raw                    json.RawMessage
+}
+
+// ChargePage é uma página da listagem de cobranças por data de criação. Pagina começa em 0.
+type ChargePage struct {
+	Charges      []Charge
+	Pagina       int
+	TotalPaginas int
+}
+
+// ReceivedPix é um PIX recebido, seja no detalhe da cobrança ou na notificação do webhook
+type ReceivedPix struct {
+	EndToEndID  string          `json:"endToEndId"`
+	Txid        string          `json:"txid"`
+	Chave       string          `json:"chave"`
+	Valor       string          `json:"valor"`
+	Horario     string          `json:"horario"`
+	InfoPagador string          `json:"infoPagador,omitempty"`
+	Devolucoes  json.RawMessage `json:"devolucoes,omitempty"`
+}
+
+// Status da devolução de um PIX recebido
+const (
+	DevolucaoEmProcessamento = "EM_PROCESSAMENTO"
+	DevolucaoDevolvido       = "DEVOLVIDO"
+	DevolucaoNaoRealizado    = "NAO_REALIZADO"
+)
+
+// Refund é uma devolução de um PIX recebido
+type Refund struct {
+	ID     string
+	RtrID  string
+	Valor  string
+	Status string
+	Raw    json.RawMessage
+}
+
+// Status do envio de PIX (pix enviar da EfiPay)
+const (
+	EnvioEmProcessamento = "EM_PROCESSAMENTO"
+	EnvioRealizado       = "REALIZADO"
+	EnvioNaoRealizado    = "NAO_REALIZADO"
+)
+
+// SendRequest são os dados de um envio de PIX para a chave do favorecido. CPF/CNPJ, quando informados,
+// fazem o PSP recusar o envio se a chave pertencer a outra pessoa.
+type SendRequest struct {
+	Valor           string
+	ChavePagador    string
+	InfoPagador     string
+	ChaveFavorecido string
+	CPFFavorecido   string
+	CNPJFavorecido  string
+}
+
+// Send é um PIX enviado. Raw guarda a resposta original, usada como comprovante.
+type Send struct {
+	IDEnvio    string
+	EndToEndID string
+	Valor      string
+	Status     string
+	Raw        json.RawMessage
+}
+
+// PixProvider isola os handlers do PSP usado para as cobranças PIX
+type PixProvider interface {
+	CreateImmediateCharge(req ChargeRequest) (*Charge, error)
+	DetailCharge(txid string) (*Charge, error)
+	// CreateDueCharge cria a cobrança com vencimento no txid informado em req.Txid
+	CreateDueCharge(req DueChargeRequest) (*Charge, error)
+	DetailDueCharge(txid string) (*Charge, error)
+	ListCharges(inicio, fim time.Time, pagina int) (*ChargePage, error)
+	// Refund solicita a devolução. O refundID torna a chamada idempotente por PIX recebido.
+	Refund(endToEndID, refundID, valor string) (*Refund, error)
+	DetailRefund(endToEndID, refundID string) (*Refund, error)
+	// ParseWebhook converte o corpo da notificação recebida em /pix/webhook
+	ParseWebhook(body []byte) ([]ReceivedPix, error)
+	ConfigWebhook(chave, webhookURL string) (json.RawMessage, error)
+	DetailWebhook(chave string) (json.RawMessage, error)
+	// SendPix envia um PIX. O idEnvio torna a chamada idempotente: repetir com o mesmo id não envia de novo.
+	SendPix(idEnvio string, req SendRequest) (*Send, error)
+	DetailSend(endToEndID string) (*Send, error)
+}
+
+// NewProvider escolhe o provedor por PIX_PROVIDER: "fake" para desenvolvimento e testes offline, EfiPay nos demais casos
+func NewProvider() PixProvider {
+	if config.GetPixProvider() == "fake" {
+		return NewFakeProvider(config.GetPixFakeAutoPay() != "false")
+	}
+	return NewEfiPayProvider(config.GetCredentials())
+}
+
+// parseSend lê a resposta do envio e do detalhe do PIX enviado (e2eId no envio, endToEndId no detalhe)
+func parseSend(raw string) (*Send, error) {
+	var s struct {
+		IDEnvio    string `json:"idEnvio"`
+		E2EID      string `json:"e2eId"`
+		EndToEndID string `json:"endToEndId"`
+		Valor      string `json:"valor"`
+		Status     string `json:"status"`
+	}
+	if err := json.Unmarshal([]byte(raw), &s); err != nil {
+		return nil, fmt.Errorf("erro ao decodificar resposta do envio PIX: %w", err)
+	}
+
+	endToEndID := s.EndToEndID
+	if endToEndID == "" {
+		endToEndID = s.E2EID
+	}
+	if endToEndID == "" {
+		return nil, fmt.Errorf("resposta inválida da API (endToEndId ausente)")
+	}
+
+	return &Send{IDEnvio: s.IDEnvio, EndToEndID: endToEndID, Valor: s.Valor, Status: s.Status, Raw: json.RawMessage(raw)}, nil
+}
+
+// parseWebhookBody lê o formato {"pix": [...]} usado pela API PIX do Bacen
+func parseWebhookBody(body []byte) ([]ReceivedPix, error) {
+	if len(body) == 0 {
+		return nil, nil
+	}
+	var notification struct {
+		Pix []ReceivedPix `json:"pix"`
+	}
+	if err := json.Unmarshal(body, &notification); err != nil {
+		return nil, err
+	}
+	return notification.Pix, nil
+}
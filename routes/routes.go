@@ -3,32 +3,64 @@ package routes
 import (
 	"database/sql"
 	"BACK_SORTE_GO/handlers"
+	"BACK_SORTE_GO/mail"
+	"BACK_SORTE_GO/middleware"
+	"BACK_SORTE_GO/payment"
 	"github.com/gorilla/mux"
 )
 
-func SetupRoutes(db *sql.DB) *mux.Router {
+func SetupRoutes(db *sql.DB, pixProvider payment.PixProvider, cardProviders payment.CardProviders) *mux.Router {
 	router := mux.NewRouter()
+	mailer := mail.NewSender()
 	
 	// Health Check
 	router.HandleFunc("/health", handlers.HealthCheckHandler()).Methods("GET")
 
 	// Rotas de usuário
-	router.HandleFunc("/users", handlers.CreateUserHandler(db)).Methods("POST")
+	router.HandleFunc("/users", handlers.CreateUserHandler(db, mailer)).Methods("POST")
 
 	// Muda password
-	router.HandleFunc("/users/passwordChange", handlers.UserPasswordChangeHandler(db)).Methods("POST")
+	router.HandleFunc("/users/passwordChange", middleware.RequireAuth(db, handlers.UserPasswordChangeHandler(db))).Methods("POST")
+
+	// Últimos acessos do usuário
+	router.HandleFunc("/users/logins", middleware.RequireAuth(db, handlers.UserLoginHistoryHandler(db))).Methods("GET")
+
+	// Saques das doações do usuário
+	router.HandleFunc("/users/saques", middleware.RequireAuth(db, handlers.UserSaqueListHandler(db))).Methods("GET")
+
+	// Faturas de plano do usuário (PIX com vencimento)
+	router.HandleFunc("/users/faturas", middleware.RequireAuth(db, handlers.UserFaturaListHandler(db))).Methods("GET")
+
+	// Verificação de e-mail
+	router.HandleFunc("/users/verifyEmail", handlers.VerifyEmailHandler(db)).Methods("POST")
+	router.HandleFunc("/users/verifyEmail/resend", middleware.RequireAuth(db, handlers.ResendEmailVerificationHandler(db, mailer))).Methods("POST")
+
+	// Recuperação de senha por e-mail
+	router.HandleFunc("/users/passwordReset/request", handlers.PasswordResetRequestHandler(db)).Methods("POST")
+	router.HandleFunc("/users/passwordReset/confirm", handlers.PasswordResetConfirmHandler(db)).Methods("POST")
+
+	// Autenticação em duas etapas (TOTP)
+	router.HandleFunc("/users/2fa/setup", middleware.RequireAuth(db, handlers.TotpSetupHandler(db))).Methods("POST")
+	router.HandleFunc("/users/2fa/enable", middleware.RequireAuth(db, handlers.TotpEnableHandler(db))).Methods("POST")
+	router.HandleFunc("/users/2fa/verify", middleware.RequireAuth(db, handlers.TotpVerifyHandler(db))).Methods("POST")
+	router.HandleFunc("/users/2fa/recoveryCodes", middleware.RequireAuth(db, handlers.TotpRecoveryCodesHandler(db))).Methods("POST")
+	router.HandleFunc("/users/2fa/disable", middleware.RequireAuth(db, handlers.TotpDisableHandler(db))).Methods("POST")
+
+	// LGPD: exportação dos dados e exclusão da conta
+	router.HandleFunc("/users/dataExport", middleware.RequireAuth(db, middleware.RequireStepUp(db, handlers.UserDataExportHandler(db)))).Methods("GET")
+	router.HandleFunc("/users/deleteAccount", middleware.RequireAuth(db, middleware.RequireStepUp(db, handlers.UserDeleteAccountHandler(db)))).Methods("POST")
 
 	// registra conta bancaria de recebimento 
-	router.HandleFunc("/users/bankAccount", handlers.UserBankAccountHandler(db)).Methods("POST")
+	router.HandleFunc("/users/bankAccount", middleware.RequireAuth(db, middleware.RequireStepUp(db, handlers.UserBankAccountHandler(db)))).Methods("POST")
 
 	// alterar conta bancaria de recebimento 
-	router.HandleFunc("/users/bankAccount", handlers.UserBankAccountUpdateHandler(db)).Methods("PATCH")
+	router.HandleFunc("/users/bankAccount", middleware.RequireAuth(db, middleware.RequireStepUp(db, handlers.UserBankAccountUpdateHandler(db)))).Methods("PATCH")
 
 	// Busca conta bancaria de recebimento 
-	router.HandleFunc("/users/bankAccount", handlers.UserBankAccountGetHandler(db)).Methods("GET")
+	router.HandleFunc("/users/bankAccount", middleware.RequireAuth(db, handlers.UserBankAccountGetHandler(db))).Methods("GET")
 
 	//atualiza img do perfil do usuario
-	router.HandleFunc("/users/uploadProfileImage", handlers.UploadUserProfileImageHandler(db)).Methods("POST")
+	router.HandleFunc("/users/uploadProfileImage", middleware.RequireAuth(db, handlers.UploadUserProfileImageHandler(db))).Methods("POST")
 	
 	//get img do perfil do usuario
 	router.HandleFunc("/users/ProfileImage/{id}", handlers.UserProfileImageHandler(db)).Methods("GET")
@@ -37,54 +69,135 @@ func SetupRoutes(db *sql.DB) *mux.Router {
 	router.HandleFunc("/users/show/{id}", handlers.UserShowHandler(db)).Methods("GET")
 
 	//Muda nome usuario
-	router.HandleFunc("/users/nameChange", handlers.UserNameChangeHandler(db)).Methods("POST")
+	router.HandleFunc("/users/nameChange", middleware.RequireAuth(db, handlers.UserNameChangeHandler(db))).Methods("POST")
 
 	// Rota para fazer login com usuario email e senha retorna tokemn
 	//router.Handle("/login", middleware.CorsMiddleware(handlers.LoginHandler(db))).Methods("POST")
 	router.HandleFunc("/login", handlers.LoginHandler(db)).Methods("POST")
 
+	// Encerra a sessão atual (ou todas com ?all=true)
+	router.HandleFunc("/logout", middleware.RequireAuth(db, handlers.LogoutHandler(db))).Methods("POST")
+
 	// Rota para criar donation valida tokemn
-	router.HandleFunc("/donation", handlers.DonationHandler(db)).Methods("POST")
+	router.HandleFunc("/donation", middleware.RequireAuth(db, middleware.Idempotency(db, handlers.DonationFormMaxBytes, handlers.DonationHandler(db)))).Methods("POST")
 
 	// Listar doações por usuário com paginação
 	router.HandleFunc("/donation/list", handlers.DonationListByIDUserHandler(db)).Methods("GET")
 
 	//deleta doações
-	router.HandleFunc("/donation/{id}", handlers.DonationDellHandler(db)).Methods("DELETE")
+	router.HandleFunc("/donation/{id}", middleware.RequireAuth(db, handlers.DonationDellHandler(db))).Methods("DELETE")
 
 	//Buscar doação por nome link
-	router.HandleFunc("/donation/link/{nome_link}", handlers.DonationByLinkHandler(db)).Methods("GET")
+	router.HandleFunc("/donation/link/{nome_link}", middleware.OptionalAuth(db, handlers.DonationByLinkHandler(db))).Methods("GET")
 
 	//buscar as mensagens visíveis da doação
 	router.HandleFunc("/donation/mensagem", handlers.DonationMensagesHandler(db)).Methods("GET")
 
 	//encerra envento de doação 
-	router.HandleFunc("/donation/closed/{id}", handlers.DonationClosedHandler(db)).Methods("GET")
+	router.HandleFunc("/donation/closed/{id}", middleware.RequireAuth(db, handlers.DonationClosedHandler(db))).Methods("GET")
 
 	//prepara os valores para serem enviado para o criado da doação e bloquei visualização da doação
-	router.HandleFunc("/donation/rescue/{id}", handlers.DonationRescueHandler(db)).Methods("GET")
+	router.HandleFunc("/donation/rescue/{id}", middleware.RequireAuth(db, middleware.RequireVerifiedEmail(db, middleware.RequireStepUp(db, handlers.DonationRescueHandler(db))))).Methods("GET")
+
+	// QR Code estático da campanha para cartazes: emissão pelo dono e imagem pública (json, png ou svg)
+	router.HandleFunc("/donation/{id}/qrcode", middleware.RequireAuth(db, handlers.DonationQRCodeEstaticoHandler(db))).Methods("POST")
+	router.HandleFunc("/donation/{id}/qrcode", handlers.DonationQRCodeHandler(db)).Methods("GET")
 
 	// registra lod de atividades na doação 
 	router.HandleFunc("/donation/visualization", handlers.DonationVisualization(db)).Methods("POST")
 
 	//rota para crear usuario e doação ao mesmo tempo 
-	router.HandleFunc("/donation/createUserAndDonation", handlers.DonationCreateSimpleHandler(db)).Methods("POST")
+	router.HandleFunc("/donation/createUserAndDonation", middleware.Idempotency(db, handlers.DonationFormMaxBytes, handlers.DonationCreateSimpleHandler(db, mailer))).Methods("POST")
 
 	// Rota testa token e gerado pelo certificado e valido
 	//router.HandleFunc("/testToken", handlers.TestTokenHandler()).Methods("GET")
 
-	//rota para crair pix teste 
-	router.HandleFunc("/pix/create", handlers.CreatePixTokenHandler(db)).Methods("POST")
+	//rota para crair pix teste (com Idempotency-Key, repetições devolvem a mesma cobrança)
+	router.HandleFunc("/pix/create", middleware.Idempotency(db, middleware.IdempotencyJSONMaxBytes, handlers.CreatePixTokenHandler(db, pixProvider))).Methods("POST")
 
-	router.HandleFunc("/pix/status/{txid}", handlers.PixChargeStatusHandler()).Methods("GET")
+	// Doação mensal: cadastro público, confirmação e gerenciamento pelo link assinado enviado ao doador (?token=)
+	router.HandleFunc("/recorrencia", handlers.DoacaoRecorrenteCreateHandler(db, mailer)).Methods("POST")
+	router.HandleFunc("/recorrencia/{id}", handlers.DoacaoRecorrenteHandler(db)).Methods("GET")
+	router.HandleFunc("/recorrencia/{id}/confirmar", handlers.DoacaoRecorrenteConfirmarHandler(db, pixProvider, mailer)).Methods("POST")
+	router.HandleFunc("/recorrencia/{id}/pausar", handlers.DoacaoRecorrentePausarHandler(db)).Methods("POST")
+	router.HandleFunc("/recorrencia/{id}/retomar", handlers.DoacaoRecorrenteRetomarHandler(db)).Methods("POST")
+	router.HandleFunc("/recorrencia/{id}/cancelar", handlers.DoacaoRecorrenteCancelarHandler(db)).Methods("POST")
+
+	router.HandleFunc("/pix/status/{txid}", handlers.PixChargeStatusHandler(db, pixProvider)).Methods("GET")
 	
 	router.HandleFunc("/pix/monitora/{txid}", handlers.MonitorarStatusPagamentoHandler(db)).Methods("POST")
 
+	// Notificações de PIX recebido enviadas pela EfiPay (autenticadas por mTLS e/ou HMAC na URL)
+	router.HandleFunc("/pix/webhook", handlers.PixWebhookHandler(db, pixProvider)).Methods("POST")
+
+	// Registro e consulta do webhook na EfiPay
+	router.HandleFunc("/pix/webhook/config", middleware.RequireAuth(db, middleware.RequireRole(middleware.RoleAdmin)(handlers.PixWebhookConfigHandler(pixProvider)))).Methods("POST")
+	router.HandleFunc("/pix/webhook/config", middleware.RequireAuth(db, middleware.RequireRole(middleware.RoleAdmin)(handlers.PixWebhookDetailHandler(pixProvider)))).Methods("GET")
+
+	// Simula o pagamento de uma cobrança no provedor fake (apenas desenvolvimento/testes)
+	if fake, ok := pixProvider.(*payment.FakeProvider); ok {
+		router.HandleFunc("/pix/fake/pay/{txid}", handlers.PixFakePayHandler(db, fake)).Methods("POST")
+	}
+
+	// Doação com cartão ou carteira digital (payment_token gerado no navegador pelo provedor)
+	router.HandleFunc("/pagamento/cartao", middleware.Idempotency(db, middleware.IdempotencyJSONMaxBytes, handlers.CreatePagamentoCartaoHandler(db, cardProviders))).Methods("POST")
+	router.HandleFunc("/pagamento/cartao/{id}", handlers.PagamentoCartaoStatusHandler(db)).Methods("GET")
+
+	// Notificações de mudança de status (captura, contestação, estorno) enviadas pelo provedor de cartão
+	router.HandleFunc("/pagamento/notificacao/{provedor}", handlers.PagamentoNotificacaoHandler(db, cardProviders)).Methods("POST")
+
+	// Simula mudanças de status do cartão no provedor fake (apenas desenvolvimento/testes)
+	if fake, ok := cardProviders[payment.MetodoCartao].(*payment.FakeCardProvider); ok {
+		router.HandleFunc("/pagamento/fake/{id}/{status}", handlers.PagamentoFakeStatusHandler(db, fake)).Methods("POST")
+	}
+
+	// Devolução (total ou parcial) de um pagamento ao doador, pelo dono da campanha ou por um operador
+	router.HandleFunc("/pix/devolucao/{txid}", middleware.RequireAuth(db, middleware.RequireStepUp(db, handlers.PixDevolucaoHandler(db)))).Methods("POST")
+	router.HandleFunc("/pix/devolucao/{txid}", middleware.RequireAuth(db, handlers.PixDevolucaoListHandler(db))).Methods("GET")
+
 	// valor total da doação e total de doadores 
 	router.HandleFunc("/pix/total/{id}", handlers.DonationSummaryByIDHandler(db)).Methods("GET")
 
 	// inicializar busca de todo os pagamento com status em andamento não finalizado ainda com prazo de venciamnete ativos pendeentes de verificação 
-	router.HandleFunc("/pix/monitora/all", handlers.MonitorarStatusAllPagamentosHandler(db)).Methods("GET")
+	router.HandleFunc("/pix/monitora/all", middleware.RequireAuth(db, middleware.RequireRole(middleware.RoleOperator)(handlers.MonitorarStatusAllPagamentosHandler(db)))).Methods("GET")
+
+	// Emissão de fatura de plano (conta_nivel) como cobrança PIX com vencimento, multa, juros e desconto
+	router.HandleFunc("/admin/conta-nivel/faturas", middleware.RequireAuth(db, middleware.RequireRole(middleware.RoleOperator)(handlers.ContaNivelFaturaHandler(db, pixProvider)))).Methods("POST")
+
+	// Conciliação das cobranças com a listagem do provedor
+	router.HandleFunc("/admin/pix/conciliacao", middleware.RequireAuth(db, middleware.RequireRole(middleware.RoleOperator)(handlers.PixConciliacaoHandler(db, pixProvider)))).Methods("POST")
+	router.HandleFunc("/admin/pix/conciliacao", middleware.RequireAuth(db, middleware.RequireRole(middleware.RoleOperator)(handlers.PixConciliacaoListHandler(db)))).Methods("GET")
+	router.HandleFunc("/admin/pix/conciliacao/{id}", middleware.RequireAuth(db, middleware.RequireRole(middleware.RoleOperator)(handlers.PixConciliacaoDetailHandler(db)))).Methods("GET")
+
+	// Administração de roles
+	router.HandleFunc("/admin/roles", middleware.RequireAuth(db, middleware.RequireRole(middleware.RoleAdmin)(handlers.RoleListHandler(db)))).Methods("GET")
+	router.HandleFunc("/admin/users/{id}/roles", middleware.RequireAuth(db, middleware.RequireRole(middleware.RoleAdmin)(handlers.UserRolesHandler(db)))).Methods("GET")
+	router.HandleFunc("/admin/users/{id}/roles", middleware.RequireAuth(db, middleware.RequireRole(middleware.RoleAdmin)(handlers.UserRoleGrantHandler(db)))).Methods("POST")
+	router.HandleFunc("/admin/users/{id}/roles/{role}", middleware.RequireAuth(db, middleware.RequireRole(middleware.RoleAdmin)(handlers.UserRoleRevokeHandler(db)))).Methods("DELETE")
+
+	// Clientes da API autorizados no /login
+	router.HandleFunc("/admin/apiClients", middleware.RequireAuth(db, middleware.RequireRole(middleware.RoleAdmin)(handlers.ApiClientListHandler(db)))).Methods("GET")
+	router.HandleFunc("/admin/apiClients", middleware.RequireAuth(db, middleware.RequireRole(middleware.RoleAdmin)(handlers.ApiClientCreateHandler(db)))).Methods("POST")
+	router.HandleFunc("/admin/apiClients/{id}", middleware.RequireAuth(db, middleware.RequireRole(middleware.RoleAdmin)(handlers.ApiClientUpdateHandler(db)))).Methods("PATCH")
+	router.HandleFunc("/admin/apiClients/{id}/rotateSecret", middleware.RequireAuth(db, middleware.RequireRole(middleware.RoleAdmin)(handlers.ApiClientRotateSecretHandler(db)))).Methods("POST")
+
+	// Regras de taxa da plataforma
+	router.HandleFunc("/admin/taxas", middleware.RequireAuth(db, middleware.RequireRole(middleware.RoleAdmin)(handlers.TaxaRegraListHandler(db)))).Methods("GET")
+	router.HandleFunc("/admin/taxas", middleware.RequireAuth(db, middleware.RequireRole(middleware.RoleAdmin)(handlers.TaxaRegraCreateHandler(db)))).Methods("POST")
+	router.HandleFunc("/admin/taxas/simular", middleware.RequireAuth(db, middleware.RequireRole(middleware.RoleAdmin)(handlers.TaxaSimularHandler(db)))).Methods("GET")
+	router.HandleFunc("/admin/taxas/{id}", middleware.RequireAuth(db, middleware.RequireRole(middleware.RoleAdmin)(handlers.TaxaRegraUpdateHandler(db)))).Methods("PATCH")
+
+	// Saques: fila de aprovação do operador e comprovantes
+	router.HandleFunc("/admin/saques", middleware.RequireAuth(db, middleware.RequireRole(middleware.RoleOperator)(handlers.SaqueListHandler(db)))).Methods("GET")
+	router.HandleFunc("/admin/saques/{id}/aprovar", middleware.RequireAuth(db, middleware.RequireRole(middleware.RoleOperator)(middleware.RequireTOTPStepUp(db, handlers.SaqueAprovarHandler(db))))).Methods("POST")
+	router.HandleFunc("/admin/saques/{id}/recusar", middleware.RequireAuth(db, middleware.RequireRole(middleware.RoleOperator)(handlers.SaqueRecusarHandler(db)))).Methods("POST")
+	router.HandleFunc("/admin/saques/{id}/comprovante", middleware.RequireAuth(db, middleware.RequireRole(middleware.RoleOperator)(handlers.SaqueComprovanteHandler(db)))).Methods("POST")
+
+	// Devolve aos doadores os pagamentos de uma campanha removida
+	router.HandleFunc("/admin/donation/{id}/devolucoes", middleware.RequireAuth(db, middleware.RequireRole(middleware.RoleOperator)(handlers.DonationDevolverTudoHandler(db)))).Methods("POST")
+
+	// Tentativas de login suspeitas
+	router.HandleFunc("/admin/logins/suspicious", middleware.RequireAuth(db, middleware.RequireRole(middleware.RoleAdmin)(handlers.SuspiciousLoginsHandler(db)))).Methods("GET")
 
 	//mensagem de fale conosco // open 
 	router.HandleFunc("/contact/mensagem", handlers.ContactMensagemHandler(db)).Methods("POST")
@@ -0,0 +1,131 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// Segredo dos vetores de teste da RFC 6238 (SHA-1): "12345678901234567890" em base32
+const segredoRFC = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// Vetores da RFC 6238, apêndice B, com os 6 últimos dígitos dos códigos de 8 dígitos
+var vetoresRFC = []struct {
+	unix   int64
+	codigo string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+	{20000000000, "353130"},
+}
+
+func TestCodeRFC6238(t *testing.T) {
+	for _, v := range vetoresRFC {
+		codigo, err := Code(segredoRFC, time.Unix(v.unix, 0))
+		if err != nil {
+			t.Fatalf("Code(%d): %v", v.unix, err)
+		}
+		if codigo != v.codigo {
+			t.Errorf("Code(%d) = %s, esperado %s", v.unix, codigo, v.codigo)
+		}
+	}
+}
+
+func TestValidateRFC6238(t *testing.T) {
+	for _, v := range vetoresRFC {
+		agora := time.Unix(v.unix, 0)
+		step, ok := Validate(segredoRFC, v.codigo, agora)
+		if !ok || step != Step(agora) {
+			t.Errorf("Validate(%d) = %d, %v; esperado %d, true", v.unix, step, ok, Step(agora))
+		}
+	}
+}
+
+func TestValidateSegredoFormatado(t *testing.T) {
+	// Aplicativos exibem o segredo em minúsculas e em grupos; os dois formatos são aceitos
+	segredo := strings.ToLower(segredoRFC[:16]) + " " + segredoRFC[16:] + "=="
+	if _, ok := Validate(segredo, "287082", time.Unix(59, 0)); !ok {
+		t.Error("segredo com espaços, minúsculas e padding não foi aceito")
+	}
+}
+
+func TestValidateRelogio(t *testing.T) {
+	gerado := time.Unix(1111111111, 0)
+	codigo, err := Code(segredoRFC, gerado)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	casos := []struct {
+		nome   string
+		agora  time.Time
+		valido bool
+	}{
+		{"mesmo passo", gerado, true},
+		{"um passo depois", gerado.Add(Period * time.Second), true},
+		{"um passo antes", gerado.Add(-Period * time.Second), true},
+		{"dois passos depois", gerado.Add(2 * Period * time.Second), false},
+		{"dois passos antes", gerado.Add(-2 * Period * time.Second), false},
+	}
+	for _, c := range casos {
+		step, ok := Validate(segredoRFC, codigo, c.agora)
+		if ok != c.valido {
+			t.Errorf("%s: Validate = %v, esperado %v", c.nome, ok, c.valido)
+		}
+		// O passo devolvido é sempre o do código, não o do relógio atual
+		if ok && step != Step(gerado) {
+			t.Errorf("%s: passo %d, esperado %d", c.nome, step, Step(gerado))
+		}
+	}
+}
+
+func TestValidateReuso(t *testing.T) {
+	// O reuso é barrado pelo chamador com o último passo aceito (last_step): o mesmo código, validado de novo
+	// no passo seguinte, devolve o mesmo passo e não pode avançar last_step
+	gerado := time.Unix(1234567890, 0)
+	codigo, _ := Code(segredoRFC, gerado)
+
+	primeiro, ok := Validate(segredoRFC, codigo, gerado)
+	if !ok {
+		t.Fatal("código não aceito no primeiro uso")
+	}
+	segundo, ok := Validate(segredoRFC, codigo, gerado.Add(Period*time.Second))
+	if !ok || segundo > primeiro {
+		t.Errorf("reuso devolveu passo %d (ok=%v), deveria ser <= %d", segundo, ok, primeiro)
+	}
+}
+
+func TestValidateInvalidos(t *testing.T) {
+	agora := time.Unix(59, 0)
+	casos := []struct {
+		nome, segredo, codigo string
+	}{
+		{"código errado", segredoRFC, "287083"},
+		{"código curto", segredoRFC, "28708"},
+		{"código longo", segredoRFC, "2870820"},
+		{"código vazio", segredoRFC, ""},
+		{"segredo inválido", "!!!", "287082"},
+	}
+	for _, c := range casos {
+		if _, ok := Validate(c.segredo, c.codigo, agora); ok {
+			t.Errorf("%s: Validate aceitou", c.nome)
+		}
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	a, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := GenerateSecret()
+	if len(a) != 32 || a == b {
+		t.Errorf("segredos %q e %q: esperados 32 caracteres e diferentes", a, b)
+	}
+	if _, err := decodeSecret(a); err != nil {
+		t.Errorf("segredo gerado não decodifica: %v", err)
+	}
+}
@@ -2,12 +2,19 @@ package utils
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"mime/multipart"
+	"net"
+	"net/http"
 	"os"
-	"strconv"
 	"strings"
 
+	appconfig "BACK_SORTE_GO/config"
+
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
@@ -44,7 +51,80 @@ func UploadToS3(file multipart.File, filename, bucket string) (string, error) {
 	return result.Location, nil
 }
 
-func StringToFloat(str string) (float64, error) {
-	str = strings.ReplaceAll(str, ",", ".")
-	return strconv.ParseFloat(str, 64)
+// GenerateRandomToken gera um token aleatório seguro codificado em base64 url
+func GenerateRandomToken(size int) (string, error) {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("erro ao gerar token aleatório: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// HashToken retorna o sha256 em hexadecimal do token, usado para persistir tokens sem guardar o valor original
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// ClientIP retorna o IP de origem da requisição. Por padrão vale o IP da conexão (RemoteAddr); X-Forwarded-For
+// só é lido quando a conexão vem de um proxy listado em TRUSTED_PROXIES, e então vale o último salto (da direita
+// para a esquerda) que não é um proxy confiável. Os saltos mais à esquerda são enviados pelo cliente e não valem.
+func ClientIP(r *http.Request) string {
+	ip := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		ip = host
+	}
+
+	confiaveis := trustedProxies()
+	if len(confiaveis) == 0 || !proxyConfiavel(confiaveis, ip) {
+		return ip
+	}
+
+	saltos := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(saltos) - 1; i >= 0; i-- {
+		salto := strings.TrimSpace(saltos[i])
+		if net.ParseIP(salto) == nil {
+			break
+		}
+		ip = salto
+		if !proxyConfiavel(confiaveis, salto) {
+			break
+		}
+	}
+	return ip
+}
+
+// trustedProxies converte TRUSTED_PROXIES em redes; IPs sem máscara viram redes de um único endereço
+func trustedProxies() []*net.IPNet {
+	var redes []*net.IPNet
+	for _, item := range strings.Split(appconfig.GetTrustedProxies(), ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			if ip := net.ParseIP(item); ip != nil && ip.To4() != nil {
+				item += "/32"
+			} else {
+				item += "/128"
+			}
+		}
+		if _, rede, err := net.ParseCIDR(item); err == nil {
+			redes = append(redes, rede)
+		}
+	}
+	return redes
+}
+
+func proxyConfiavel(redes []*net.IPNet, ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, rede := range redes {
+		if rede.Contains(parsed) {
+			return true
+		}
+	}
+	return false
 }